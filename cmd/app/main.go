@@ -1,9 +1,16 @@
 package main
 
 import (
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -16,16 +23,20 @@ import (
 	"github.com/pkg/errors"
 	"github.com/qredo/signing-agent/internal/action"
 	"github.com/qredo/signing-agent/internal/api"
+	"github.com/qredo/signing-agent/internal/audit"
 	"github.com/qredo/signing-agent/internal/auth"
 	"github.com/qredo/signing-agent/internal/autoapprover"
+	"github.com/qredo/signing-agent/internal/backup"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub"
 	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/journal"
 	"github.com/qredo/signing-agent/internal/rest"
 	"github.com/qredo/signing-agent/internal/service"
 	"github.com/qredo/signing-agent/internal/store"
 	"github.com/qredo/signing-agent/internal/util"
+	"github.com/qredo/signing-agent/internal/webhook"
 )
 
 var (
@@ -43,6 +54,16 @@ func main() {
 	_, _ = parser.AddCommand("start", "start service", "", &startCmd{})
 	_, _ = parser.AddCommand("version", "print version", "print service version and quit", &versionCmd{})
 	_, _ = parser.AddCommand("gen-keys", "generate keys", "generates keys and quit", &genKeysCmd{})
+	_, _ = parser.AddCommand("gen-store-key", "generate file store key", "generates a key for the file store encryption, prints it base64 encoded and quit", &genStoreKeyCmd{})
+	_, _ = parser.AddCommand("migrate-store", "migrate the store", "copies the registered agent to the store of another config, verifies the copy and quit", &migrateStoreCmd{})
+	_, _ = parser.AddCommand("backup", "back up the agent", "writes the registered agent to an encrypted backup bundle and quit", &backupCmd{})
+	_, _ = parser.AddCommand("restore", "restore the agent", "restores the agent from an encrypted backup bundle into the configured store and quit", &restoreCmd{})
+	_, _ = parser.AddCommand("split-key", "split the agent key", "splits the agent BLS key into shares, any threshold of them rebuild the key, and quit", &splitKeyCmd{})
+	_, _ = parser.AddCommand("recombine-key", "recombine the agent key", "rebuilds the agent BLS key from the shares into the configured store and quit. "+
+		"The key is checked against the stored agent key or, without one, against the key registered on Qredo. "+
+		"An agent not in the store is saved without its EC private key, which the shares don't hold", &recombineKeyCmd{})
+	_, _ = parser.AddCommand("deregister", "deregister the agent", "removes the registered agent from the configured store and quit, the service must be stopped", &deregisterCmd{})
+	_, _ = parser.AddCommand("gen-api-key", "generate local API key", "generates an API key for the local REST API, prints it with its hash and quit", &genAPIKeyCmd{})
 
 	_, err := parser.Parse()
 	if err != nil {
@@ -73,6 +94,486 @@ func (g *genKeysCmd) Execute([]string) error {
 	return nil
 }
 
+type genAPIKeyCmd struct {
+}
+
+func (g *genAPIKeyCmd) Execute([]string) error {
+	key, err := util.RandomBytes(32)
+	if err != nil {
+		return err
+	}
+
+	apiKey := base64.RawURLEncoding.EncodeToString(key)
+	fmt.Printf("APIKey: %s\nHash: %s\n", apiKey, auth.HashAPIKey(apiKey))
+	return nil
+}
+
+type genStoreKeyCmd struct {
+}
+
+func (g *genStoreKeyCmd) Execute([]string) error {
+	key, err := util.RandomBytes(util.AESKeySize)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("StoreKey: %s\n", base64.StdEncoding.EncodeToString(key))
+	return nil
+}
+
+type migrateStoreCmd struct {
+	From       string `long:"from" description:"configuration file of the source store" required:"true"`
+	To         string `long:"to" description:"configuration file of the destination store" required:"true"`
+	WipeSource bool   `long:"wipe-source" description:"delete the agent from the source store once the copy is verified"`
+}
+
+func (m *migrateStoreCmd) Execute([]string) error {
+	from, err := loadStoreConfig(m.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := loadStoreConfig(m.To)
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(from.Store, to.Store) {
+		return errors.New("the source and the destination stores are the same")
+	}
+
+	fromStore, err := initStore(from)
+	if err != nil {
+		return errors.Wrap(err, "source store")
+	}
+
+	toStore, err := initStore(to)
+	if err != nil {
+		return errors.Wrap(err, "destination store")
+	}
+
+	agentID, err := store.Migrate(fromStore, toStore, m.WipeSource)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Agent %s migrated from the %s store to the %s store\n", agentID, from.Store.Type, to.Store.Type)
+	if m.WipeSource {
+		fmt.Printf("Agent %s deleted from the %s store\n", agentID, from.Store.Type)
+	}
+	return nil
+}
+
+type backupCmd struct {
+	ConfigFile     string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Out            string `short:"o" long:"out" description:"file the backup bundle is written to" required:"true"`
+	PassphraseFile string `long:"passphrase-file" description:"file with the passphrase the bundle is encrypted with"`
+	PublicKey      string `long:"public-key" description:"hex or base64 encoded secp256k1 public key the bundle is encrypted to"`
+}
+
+func (b *backupCmd) Execute([]string) error {
+	key := backup.Key{PublicKey: b.PublicKey}
+	if len(b.PassphraseFile) > 0 {
+		passphrase, err := readSecretFile(b.PassphraseFile)
+		if err != nil {
+			return err
+		}
+		key.Passphrase = passphrase
+	}
+
+	cfg, err := loadStoreConfig(b.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Signer.Type == "remote" {
+		return errors.New("the agent key is held by the remote signer, back it up there")
+	}
+
+	kv, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	agentStore := store.NewAgentStore(kv)
+	agentInfo, err := agentStore.GetAgentInfo()
+	if err != nil {
+		return err
+	}
+	if agentInfo == nil {
+		return errors.New("no agent registered in the store")
+	}
+
+	pending, err := agentStore.GetPendingRotation(agentInfo.APIKeyID)
+	if err != nil {
+		return err
+	}
+
+	bundle, err := backup.NewBundle(agentInfo.APIKeyID, agentInfo, pending, key)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(b.Out, data, 0600); err != nil {
+		return errors.Wrap(err, "write backup bundle")
+	}
+
+	fmt.Printf("Agent %s backed up to %s, method: %s\n", bundle.AgentID, b.Out, bundle.Method)
+	return nil
+}
+
+type restoreCmd struct {
+	ConfigFile     string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	In             string `short:"i" long:"in" description:"file the backup bundle is read from" required:"true"`
+	PassphraseFile string `long:"passphrase-file" description:"file with the passphrase the bundle is encrypted with"`
+	PrivateKeyFile string `long:"private-key-file" description:"file with the hex or base64 encoded secp256k1 private key the bundle is encrypted to"`
+}
+
+func (r *restoreCmd) Execute([]string) error {
+	key := backup.Key{}
+	var err error
+	if len(r.PassphraseFile) > 0 {
+		if key.Passphrase, err = readSecretFile(r.PassphraseFile); err != nil {
+			return err
+		}
+	}
+	if len(r.PrivateKeyFile) > 0 {
+		if key.PrivateKey, err = readSecretFile(r.PrivateKeyFile); err != nil {
+			return err
+		}
+	}
+
+	data, err := os.ReadFile(r.In)
+	if err != nil {
+		return errors.Wrap(err, "read backup bundle")
+	}
+
+	bundle := &backup.Bundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return errors.Wrap(err, "parse backup bundle")
+	}
+
+	agentID, agentInfo, err := bundle.Open(key)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadStoreConfig(r.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	kv, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	agentStore := store.NewAgentStore(kv)
+	existing, err := agentStore.GetAgentInfo()
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return errors.Errorf("the %s store already holds the agent %s", cfg.Store.Type, existing.APIKeyID)
+	}
+
+	if err := agentStore.SaveAgentInfo(agentID, agentInfo); err != nil {
+		return err
+	}
+
+	fmt.Printf("Agent %s restored into the %s store\n", agentID, cfg.Store.Type)
+	return nil
+}
+
+type splitKeyCmd struct {
+	ConfigFile    string   `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Threshold     int      `short:"k" long:"threshold" description:"number of shares needed to rebuild the key" required:"true"`
+	Shares        int      `short:"n" long:"shares" description:"number of shares" required:"true"`
+	OutDir        string   `short:"o" long:"out-dir" description:"directory the shares are written to" default:"."`
+	CustodianKeys []string `long:"custodian-key" description:"hex or base64 encoded secp256k1 public key the share is encrypted to, once per share in order, empty for a share in clear"`
+}
+
+func (s *splitKeyCmd) Execute([]string) error {
+	cfg, err := loadStoreConfig(s.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.Signer.Type == "remote" {
+		return errors.New("the agent key is held by the remote signer, split it there")
+	}
+
+	kv, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	agentStore := store.NewAgentStore(kv)
+	agentInfo, err := agentStore.GetAgentInfo()
+	if err != nil {
+		return err
+	}
+	if agentInfo == nil {
+		return errors.New("no agent registered in the store")
+	}
+
+	pending, err := agentStore.GetPendingRotation(agentInfo.APIKeyID)
+	if err != nil {
+		return err
+	}
+
+	shares, err := backup.SplitKey(agentInfo.APIKeyID, agentInfo, pending, s.Threshold, s.Shares, s.CustodianKeys)
+	if err != nil {
+		return err
+	}
+
+	for _, share := range shares {
+		data, err := json.MarshalIndent(share, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		fileName := filepath.Join(s.OutDir, fmt.Sprintf("share-%s-%d.json", share.SetID, share.Index))
+		if err := os.WriteFile(fileName, data, 0600); err != nil {
+			return errors.Wrap(err, "write share")
+		}
+
+		fmt.Printf("Share %d of %d written to %s, encrypted: %v\n", share.Index, share.Count, fileName, share.IsEncrypted())
+	}
+
+	fmt.Printf("Key of agent %s split, %d of %d shares rebuild it\n", agentInfo.APIKeyID, s.Threshold, s.Shares)
+	return nil
+}
+
+type recombineKeyCmd struct {
+	ConfigFile       string   `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+	Shares           []string `short:"s" long:"share" description:"share file, at least the threshold of shares are needed" required:"true"`
+	PrivateKeyFiles  []string `long:"private-key-file" description:"file with the hex or base64 encoded secp256k1 private key of a custodian, needed for the encrypted shares"`
+	APIKeySecretFile string   `long:"api-key-secret-file" description:"file with the API key secret, needed only if the agent is not in the store"`
+}
+
+func (r *recombineKeyCmd) Execute([]string) error {
+	privateKeys := make([]string, 0, len(r.PrivateKeyFiles))
+	for _, fileName := range r.PrivateKeyFiles {
+		privateKey, err := readSecretFile(fileName)
+		if err != nil {
+			return err
+		}
+		privateKeys = append(privateKeys, privateKey)
+	}
+
+	shares := make([]*backup.Share, 0, len(r.Shares))
+	for _, fileName := range r.Shares {
+		share, err := readShare(fileName, privateKeys)
+		if err != nil {
+			return errors.Wrap(err, fileName)
+		}
+		shares = append(shares, share)
+	}
+
+	recovered, err := backup.CombineKey(shares)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := loadStoreConfig(r.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	kv, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	agentStore := store.NewAgentStore(kv)
+	agentInfo, err := agentStore.GetAgentInfo()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case agentInfo != nil && agentInfo.APIKeyID != recovered.AgentID:
+		return errors.Errorf("the %s store already holds the agent %s", cfg.Store.Type, agentInfo.APIKeyID)
+	case agentInfo == nil && len(r.APIKeySecretFile) == 0:
+		return errors.Errorf("the agent %s is not in the %s store, the API key secret file is needed", recovered.AgentID, cfg.Store.Type)
+	case agentInfo == nil:
+		secret, err := readSecretFile(r.APIKeySecretFile)
+		if err != nil {
+			return err
+		}
+
+		agentInfo = &store.AgentInfo{
+			WorkspaceID:  recovered.WorkspaceID,
+			APIKeyID:     recovered.AgentID,
+			APIKeySecret: secret,
+		}
+	}
+
+	// the shares carry the public key they are checked against, so the rebuilt key is checked against a trusted one
+	blsPublicKey, err := trustedBLSPublicKey(cfg, agentInfo)
+	if err != nil {
+		return err
+	}
+
+	if err := recovered.Verify(blsPublicKey); err != nil {
+		return err
+	}
+
+	agentInfo.BLSPrivateKey = recovered.BLSPrivateKey
+	if err := agentStore.SaveAgentInfo(recovered.AgentID, agentInfo); err != nil {
+		return err
+	}
+
+	fmt.Printf("Key of agent %s rebuilt from %d shares into the %s store\n", recovered.AgentID, len(shares), cfg.Store.Type)
+	if len(agentInfo.ECPrivateKey) == 0 {
+		fmt.Println("The agent is saved without its EC private key")
+	}
+	return nil
+}
+
+// trustedBLSPublicKey returns the public key of the stored agent key, or the public key registered on Qredo
+// when the store holds no key
+func trustedBLSPublicKey(cfg config.Config, agentInfo *store.AgentInfo) (string, error) {
+	if len(agentInfo.BLSPrivateKey) > 0 {
+		return backup.BLSPublicKey(agentInfo.BLSPrivateKey)
+	}
+
+	provider := auth.NewHeaderProvider(cfg.Base.QredoAPI, util.NewLogger(&cfg.Logging))
+	if err := provider.Initiate(agentInfo.WorkspaceID, agentInfo.APIKeySecret, agentInfo.APIKeyID); err != nil {
+		return defs.EmptyString, errors.Wrap(err, "authenticate to Qredo")
+	}
+	defer provider.Stop()
+
+	resp := &struct {
+		BlsPublicKey string `json:"blsPublicKey"`
+	}{}
+	url := defs.URLAPIKey(cfg.Base.QredoAPI, agentInfo.WorkspaceID, agentInfo.APIKeyID)
+	if err := util.NewHTTPClient().Request(http.MethodGet, url, nil, resp, provider.GetAuthHeader()); err != nil {
+		return defs.EmptyString, errors.Wrap(err, "read the API key registered on Qredo")
+	}
+
+	if len(resp.BlsPublicKey) == 0 {
+		return defs.EmptyString, errors.New("no BLS public key registered on Qredo")
+	}
+
+	return resp.BlsPublicKey, nil
+}
+
+// readShare reads the share file and decrypts the share with the matching custodian private key
+func readShare(fileName string, privateKeys []string) (*backup.Share, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "read share")
+	}
+
+	share := &backup.Share{}
+	if err := json.Unmarshal(data, share); err != nil {
+		return nil, errors.Wrap(err, "parse share")
+	}
+
+	for _, privateKey := range privateKeys {
+		if !share.IsEncrypted() {
+			break
+		}
+
+		// the private keys not matching the custodian key are skipped
+		if err := share.Decrypt(privateKey); err != nil && err != backup.ErrNotCustodianKey {
+			return nil, err
+		}
+	}
+
+	if share.IsEncrypted() {
+		return nil, errors.Errorf("no private key for the custodian of share %d", share.Index)
+	}
+
+	return share, nil
+}
+
+// readSecretFile returns the content of the file without the trailing new line
+func readSecretFile(fileName string) (string, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return defs.EmptyString, errors.Wrapf(err, "read %s", fileName)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+type deregisterCmd struct {
+	ConfigFile string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
+}
+
+func (d *deregisterCmd) Execute([]string) error {
+	cfg, err := loadStoreConfig(d.ConfigFile)
+	if err != nil {
+		return err
+	}
+
+	kv, err := initStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	agentStore := store.NewAgentStore(kv)
+	agentInfo, err := agentStore.GetAgentInfo()
+	if err != nil {
+		return err
+	}
+	if agentInfo == nil {
+		return errors.New("no agent registered in the store")
+	}
+
+	if err := agentStore.DeletePendingRotation(agentInfo.APIKeyID); err != nil {
+		return err
+	}
+
+	if err := agentStore.DeleteAgentInfo(agentInfo.APIKeyID); err != nil {
+		return err
+	}
+
+	fmt.Printf("Agent %s deregistered from the %s store\n", agentInfo.APIKeyID, cfg.Store.Type)
+	return nil
+}
+
+// loadStoreConfig loads the config file with the environment overrides and validates it
+func loadStoreConfig(fileName string) (config.Config, error) {
+	var cfg config.Config
+	cfg.Default()
+
+	if err := cfg.Load(fileName); err != nil {
+		return cfg, errors.Wrapf(err, "load %s", fileName)
+	}
+
+	if err := cfg.LoadEnv(); err != nil {
+		return cfg, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return cfg, errors.Wrapf(err, "invalid %s", fileName)
+	}
+
+	return cfg, nil
+}
+
+func initStore(cfg config.Config) (util.KVStore, error) {
+	kv := util.CreateStore(cfg)
+	if kv == nil {
+		return nil, errors.Errorf("unsupported store type: %s", cfg.Store.Type)
+	}
+
+	if err := kv.Init(); err != nil {
+		return nil, err
+	}
+
+	return kv, nil
+}
+
 type startCmd struct {
 	ConfigFile string `short:"c" long:"config" description:"path to configuration file" default:"cc.yaml"`
 }
@@ -87,9 +588,24 @@ func (c *startCmd) Execute([]string) error {
 		os.Exit(1)
 	}
 
-	log := util.NewLogger(&cfg.Logging)
+	if err = cfg.LoadEnv(); err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
+	if err = cfg.Validate(); err != nil {
+		fmt.Printf("invalid config file %s: %v\n", c.ConfigFile, err)
+		os.Exit(1)
+	}
+
+	log, logLevel := util.NewLeveledLogger(&cfg.Logging)
 	log.Info("Loaded config file from " + c.ConfigFile)
 
+	reloader := config.NewReloader(c.ConfigFile, cfg)
+	reloader.OnReload(func(cfg config.Config) {
+		logLevel.SetLevel(util.LogLevel(cfg.Logging.Level))
+	})
+
 	ver := &api.Version{
 		BuildType: "dev",
 	}
@@ -104,7 +620,7 @@ func (c *startCmd) Execute([]string) error {
 		ver.BuildDate = buildDate
 	}
 
-	router, err := initRouter(log, cfg, *ver)
+	router, err := initRouter(log, cfg, *ver, reloader)
 	if err != nil {
 		log.Errorf("Failed to start the router, err: %v", err)
 		log.Warn("exiting")
@@ -112,6 +628,7 @@ func (c *startCmd) Execute([]string) error {
 	}
 
 	setCtrlC(router)
+	setReloadOnHangup(log, reloader)
 
 	if err = router.Start(); err != nil {
 		log.Errorf("HTTP Listener error: %v", err)
@@ -133,7 +650,24 @@ func setCtrlC(r *rest.Router) {
 	}()
 }
 
-func initRouter(log *zap.SugaredLogger, config config.Config, version api.Version) (*rest.Router, error) {
+// setReloadOnHangup reloads the config file on SIGHUP
+func setReloadOnHangup(log *zap.SugaredLogger, reloader *config.Reloader) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	go func() {
+		for range sigChan {
+			result, err := reloader.Reload()
+			if err != nil {
+				log.Errorf("Config reload failed, running config kept, err: %v", err)
+				continue
+			}
+
+			log.Infof("Config reloaded, applied: %v, restart required: %v", result.Applied, result.RestartRequired)
+		}
+	}()
+}
+
+func initRouter(log *zap.SugaredLogger, config config.Config, version api.Version, reloader *config.Reloader) (*rest.Router, error) {
 	agentStore, err := genAgentStore(config, log)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to initialise the store")
@@ -156,12 +690,19 @@ func initRouter(log *zap.SugaredLogger, config config.Config, version api.Versio
 	})
 
 	var messageCache message.Cacher
-	if !config.AutoApprove.Enabled {
+	if !config.AutoApprove.Enabled || len(config.AutoApprove.PolicyFile) > 0 {
+		// with an approval policy some actions are left for manual approval, so they are cached as well
 		messageCache = message.NewCacher(config.LoadBalancing.Enable, log, rds)
 	}
 
-	feedHub := hub.NewFeedHub(hub.NewWebsocketSource(hub.NewDefaultDialer(), config.Websocket.QredoWebsocket, log, config.Websocket, headerProvider), log, messageCache)
-	signer, err := action.NewSigner(config.Base.QredoAPI, headerProvider, log, agentKey)
+	source := hub.NewWebsocketSource(hub.NewDefaultDialer(), config.Websocket.QredoWebsocket, log, config.Websocket, headerProvider)
+	feedHub := hub.NewFeedHub(source, log, messageCache, config.FeedHub)
+	keySigner, agentKey, err := genKeySigner(config, agentStore, agentInfo, agentKey, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialise the key signer")
+	}
+
+	signer, err := action.NewSigner(config.Base.QredoAPI, headerProvider, log, keySigner, agentKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to initialise the signer")
 	}
@@ -170,13 +711,58 @@ func initRouter(log *zap.SugaredLogger, config config.Config, version api.Versio
 	rs := redsync.New(pool)
 	syncronizer := action.NewSyncronizer(&config.LoadBalancing, rds, rs)
 
-	autoApprover := genAutoApprover(config, log, signer, syncronizer)
+	actionJournal, err := genJournal(config, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialise the approval journal")
+	}
+
+	auditLog, err := genAuditLog(config, log, signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialise the audit log")
+	}
+	if auditLog != nil {
+		actionJournal = audit.NewJournal(actionJournal, auditLog)
+		auditLog.Start()
+	}
+
+	autoApprover, err := genAutoApprover(config, log, signer, syncronizer, messageCache, actionJournal, feedHub)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialise the auto approver")
+	}
+
+	webhooks, err := genWebhooks(config, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to initialise the webhooks")
+	}
+
 	upgrader := hub.NewDefaultUpgrader(config.Websocket.ReadBufferSize, config.Websocket.WriteBufferSize)
 
-	agentService := service.NewAgentService(config, headerProvider, agentStore, signer, feedHub, autoApprover, log, upgrader, agentInfo)
-	actionService := service.NewActionService(syncronizer, log, config.LoadBalancing.Enable, messageCache, signer)
+	agentService := service.NewAgentService(config, headerProvider, agentStore, signer, feedHub, autoApprover, webhooks, messageCache, log,
+		upgrader, agentInfo)
+	actionService := service.NewActionService(syncronizer, log, config.LoadBalancing.Enable, messageCache, signer, actionJournal, auditLog)
+
+	setReloadHooks(reloader, source, feedHub, agentService, autoApprover)
 
-	return rest.NewRouter(log, config, version, agentService, actionService), nil
+	var authenticator auth.RequestAuthenticator
+	if config.HTTP.Auth.Enabled {
+		if authenticator, err = auth.NewRequestAuthenticator(config.HTTP.Auth); err != nil {
+			return nil, errors.Wrap(err, "Failed to initialise the request authenticator")
+		}
+	}
+
+	return rest.NewRouter(log, config, version, agentService, actionService, authenticator, reloader), nil
+}
+
+// setReloadHooks applies the reloaded websocket, feed hub and auto-approval settings to the running components
+func setReloadHooks(reloader *config.Reloader, source hub.Source, feedHub hub.FeedHub, agentService service.AgentService, autoApprover autoapprover.AutoApprover) {
+	reloader.OnReload(func(cfg config.Config) {
+		source.SetReconnectConfig(cfg.Websocket)
+		feedHub.SetConfig(cfg.FeedHub)
+		agentService.SetWebsocketConfig(cfg.Websocket)
+		if autoApprover != nil {
+			autoApprover.SetRetryConfig(cfg.AutoApprove)
+		}
+	})
 }
 
 func genAgentStore(config config.Config, log *zap.SugaredLogger) (store.AgentStore, error) {
@@ -185,6 +771,7 @@ func genAgentStore(config config.Config, log *zap.SugaredLogger) (store.AgentSto
 	if kv == nil {
 		log.Panicf("Unsupported store type: %s", config.Store.Type)
 	}
+	kv = util.NewInstrumentedStore(kv, config.Store.Type)
 
 	if err := kv.Init(); err != nil {
 		return nil, err
@@ -193,14 +780,113 @@ func genAgentStore(config config.Config, log *zap.SugaredLogger) (store.AgentSto
 	return store.NewAgentStore(kv), nil
 }
 
-func genAutoApprover(config config.Config, log *zap.SugaredLogger, signer action.Signer, syncronizer action.ActionSync) autoapprover.AutoApprover {
+// genKeySigner returns the key signer and the key to set. The remote signer holds the key of its key ID, so the stored
+// key isn't set. A key stored before the remote signer was used is imported once, if the remote signer has no key,
+// and removed from the store
+func genKeySigner(config config.Config, agentStore store.AgentStore, agentInfo *store.AgentInfo, agentKey string, log *zap.SugaredLogger) (action.KeySigner, string, error) {
+	switch config.Signer.Type {
+	case "", "local":
+		return action.NewLocalKeySigner(), agentKey, nil
+	case "remote":
+		log.Infof("Using the remote signer on %s, key ID `%s`", config.Signer.Remote.Socket, config.Signer.Remote.KeyID)
+		keySigner := action.NewRemoteKeySigner(config.Signer.Remote)
+		if agentInfo == nil || len(agentInfo.BLSPrivateKey) == 0 {
+			return keySigner, defs.EmptyString, nil
+		}
+
+		if err := importStoredKey(keySigner, agentInfo.BLSPrivateKey, log); err != nil {
+			return nil, defs.EmptyString, err
+		}
+
+		// the stored key is erased only once the remote signer holds the same key
+		agentInfo.BLSPrivateKey = defs.EmptyString
+		if err := agentStore.SaveAgentInfo(agentInfo.APIKeyID, agentInfo); err != nil {
+			return nil, defs.EmptyString, errors.Wrap(err, "failed to remove the stored agent key")
+		}
+
+		return keySigner, defs.EmptyString, nil
+	default:
+		return nil, defs.EmptyString, errors.Errorf("unsupported signer type `%s`", config.Signer.Type)
+	}
+}
+
+// importStoredKey imports the stored agent key into the remote signer if it has no key for the key ID, then makes sure
+// the remote signer holds the same key. A different key means a reused or misconfigured key ID, the agent doesn't start
+func importStoredKey(keySigner action.KeySigner, blsPrivateKey string, log *zap.SugaredLogger) error {
+	storedPublicKey, err := defs.BLSPublicKey(blsPrivateKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to read the stored agent key")
+	}
+
+	remotePublicKey, err := keySigner.PublicKey()
+	if errors.Is(err, action.ErrNoKey) {
+		log.Info("Importing the stored agent key into the remote signer")
+		if err := keySigner.SetKey(blsPrivateKey); err != nil {
+			return errors.Wrap(err, "failed to import the stored agent key")
+		}
+		remotePublicKey, err = keySigner.PublicKey()
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to read the key of the remote signer")
+	}
+
+	if remotePublicKey != storedPublicKey {
+		return errors.Errorf("the remote signer key `%s` doesn't match the stored agent key `%s`, check the key ID",
+			remotePublicKey, storedPublicKey)
+	}
+
+	return nil
+}
+
+func genJournal(config config.Config, log *zap.SugaredLogger) (journal.Journal, error) {
+	if !config.Journal.Enabled {
+		log.Debug("Approval journal not enabled in config")
+		return nil, nil
+	}
+
+	log.Infof("Recording the decisions in the approval journal %s", config.Journal.File)
+	return journal.NewFileJournal(config.Journal.File, log)
+}
+
+func genAuditLog(config config.Config, log *zap.SugaredLogger, signer action.Signer) (audit.Log, error) {
+	if !config.Audit.Enabled {
+		log.Debug("Audit log not enabled in config")
+		return nil, nil
+	}
+
+	log.Infof("Sealing the signatures in the audit log %s", config.Audit.File)
+	return audit.NewFileLog(config.Audit.File, time.Duration(config.Audit.BatchInterval)*time.Second, config.Audit.MaxBatchSize, signer, log)
+}
+
+func genAutoApprover(config config.Config, log *zap.SugaredLogger, signer action.Signer, syncronizer action.ActionSync,
+	messageCache message.CacheRemover, actionJournal journal.Journal, events hub.EventPublisher) (autoapprover.AutoApprover, error) {
 	if !config.AutoApprove.Enabled {
 		log.Debug("Auto-approval feature not enabled in config")
-		return nil
+		return nil, nil
 	}
 
 	log.Debug("Auto-approval feature enabled")
-	return autoapprover.NewAutoApprover(log, config, syncronizer, signer)
+
+	var policy *autoapprover.Policy
+	if len(config.AutoApprove.PolicyFile) > 0 {
+		var err error
+		if policy, err = autoapprover.LoadPolicy(config.AutoApprove.PolicyFile); err != nil {
+			return nil, err
+		}
+		log.Infof("Auto-approval policy loaded from %s, %d rules", config.AutoApprove.PolicyFile, len(policy.Rules))
+	}
+
+	return autoapprover.NewAutoApprover(log, config, syncronizer, signer, policy, messageCache, actionJournal, events), nil
+}
+
+func genWebhooks(config config.Config, log *zap.SugaredLogger) (webhook.Dispatcher, error) {
+	if !config.Webhooks.Enabled {
+		log.Debug("Webhooks not enabled in config")
+		return nil, nil
+	}
+
+	log.Infof("Posting the actions to %d webhook endpoints, delivery queue in %s", len(config.Webhooks.Endpoints), config.Webhooks.QueueFile)
+	return webhook.NewDispatcher(config.Webhooks, log)
 }
 
 func genHeaderProvider(config config.Config, agentInfo *store.AgentInfo, log *zap.SugaredLogger) (auth.HeaderProvider, string, error) {
@@ -0,0 +1,104 @@
+package action
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/qredo/signing-agent/crypto"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+// ErrNoKey is returned when the key signer holds no key
+var ErrNoKey = errors.New("no bls key")
+
+// KeySigner holds the agent BLS key and signs the messages with it
+type KeySigner interface {
+	// GenerateKey generates a new key and returns its base64 encoded public key, along with the private key to set.
+	// A key signer holding its keys returns an empty private key, the new key is used once an empty key is set
+	GenerateKey() (string, string, error)
+	// SetKey sets the base64 encoded BLS private key
+	SetKey(blsPrivateKey string) error
+	// HasKey returns true if a key is set, it's not checked before signing, Sign returns ErrNoKey without a key
+	HasKey() bool
+	// PublicKey returns the base64 encoded public key of the key, ErrNoKey if no key is set
+	PublicKey() (string, error)
+	// ClearKey forgets the key, the signer can't sign until a key is set again
+	ClearKey()
+	// Sign returns the BLS signature of the message
+	Sign(message []byte) ([]byte, error)
+}
+
+// localKeySigner holds the key in process memory, it's the default key signer
+type localKeySigner struct {
+	blsPrivateKey []byte
+	lock          sync.RWMutex // the key is set and cleared while the actions are signed
+}
+
+func NewLocalKeySigner() KeySigner {
+	return &localKeySigner{}
+}
+
+func (l *localKeySigner) GenerateKey() (string, string, error) {
+	return defs.GenerateBLSKey()
+}
+
+func (l *localKeySigner) SetKey(blsPrivateKey string) error {
+	data, err := base64.StdEncoding.DecodeString(blsPrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid bls key")
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.zeroKey()
+	l.blsPrivateKey = data
+	return nil
+}
+
+func (l *localKeySigner) HasKey() bool {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return len(l.blsPrivateKey) > 0
+}
+
+func (l *localKeySigner) PublicKey() (string, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if len(l.blsPrivateKey) == 0 {
+		return defs.EmptyString, ErrNoKey
+	}
+
+	return defs.BLSPublicKey(base64.StdEncoding.EncodeToString(l.blsPrivateKey))
+}
+
+// ClearKey zeroes the key in memory before dropping it
+func (l *localKeySigner) ClearKey() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.zeroKey()
+	l.blsPrivateKey = nil
+}
+
+func (l *localKeySigner) Sign(message []byte) ([]byte, error) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if len(l.blsPrivateKey) == 0 {
+		return nil, ErrNoKey
+	}
+
+	return crypto.BLSSign(message, l.blsPrivateKey)
+}
+
+// zeroKey overwrites the key in memory, the caller holds the lock
+func (l *localKeySigner) zeroKey() {
+	for i := range l.blsPrivateKey {
+		l.blsPrivateKey[i] = 0
+	}
+}
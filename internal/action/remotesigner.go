@@ -0,0 +1,230 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+// remoteSignerHost is the host of the remote signer requests, the connections go to the Unix socket
+const remoteSignerHost = "http://remote-signer"
+
+type remoteImportRequest struct {
+	PrivateKey string `json:"privateKey"`
+}
+
+type remoteKeyResponse struct {
+	PublicKey string `json:"publicKey"`
+}
+
+type remoteSignRequest struct {
+	Message string `json:"message"`
+}
+
+type remoteSignResponse struct {
+	Signature string `json:"signature"`
+}
+
+type remoteErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// remoteKeySigner is the client of a remote signer, a separate process holding the key and signing with it by key ID.
+// The remote signer serves JSON over HTTP on a Unix socket:
+//
+//	GET  /v1/keys/{keyID}           returns {"publicKey": base64}, 404 if there is no key
+//	PUT  /v1/keys/{keyID}           {"privateKey": base64}  imports the key
+//	POST /v1/keys/{keyID}/next      generates the next key, returns {"publicKey": base64}
+//	POST /v1/keys/{keyID}/activate  makes the next key the key of the key ID, if any
+//	POST /v1/keys/{keyID}/sign      {"message": base64}     returns {"signature": base64}
+//
+// The agent signs with the key until the next one is activated, so the action submitting the next key can be
+// approved with it. It can refuse to sign after its own policy checks, with 403 and {"error": reason}
+type remoteKeySigner struct {
+	keyID      string
+	httpClient *http.Client
+	lock       sync.Mutex
+	keyKnown   bool // the key state is known from the last answer of the remote signer
+	hasKey     bool
+}
+
+func NewRemoteKeySigner(cfg config.RemoteSignerConfig) KeySigner {
+	dialer := &net.Dialer{}
+	return &remoteKeySigner{
+		keyID: cfg.KeyID,
+		httpClient: &http.Client{
+			Timeout: time.Duration(cfg.TimeoutSec) * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", cfg.Socket)
+				},
+			},
+		},
+	}
+}
+
+// GenerateKey generates the next key in the remote signer, the private key never leaves it and is returned empty
+func (r *remoteKeySigner) GenerateKey() (string, string, error) {
+	resp := &remoteKeyResponse{}
+	if err := r.send(http.MethodPost, r.keyURL()+"/next", struct{}{}, resp); err != nil {
+		return defs.EmptyString, defs.EmptyString, err
+	}
+
+	if len(resp.PublicKey) == 0 {
+		return defs.EmptyString, defs.EmptyString, errors.New("invalid public key from the remote signer")
+	}
+
+	return resp.PublicKey, defs.EmptyString, nil
+}
+
+// SetKey imports the key into the remote signer, an empty key activates the key generated last
+func (r *remoteKeySigner) SetKey(blsPrivateKey string) error {
+	if len(blsPrivateKey) == 0 {
+		return r.send(http.MethodPost, r.keyURL()+"/activate", struct{}{}, nil)
+	}
+
+	if _, err := base64.StdEncoding.DecodeString(blsPrivateKey); err != nil {
+		return fmt.Errorf("invalid bls key")
+	}
+
+	err := r.send(http.MethodPut, r.keyURL(), remoteImportRequest{PrivateKey: blsPrivateKey}, nil)
+	r.updateKeyState(err)
+	return err
+}
+
+// HasKey returns true if the remote signer holds a key for the key ID. The key state is kept from the last answer
+// of the remote signer, it's asked only while the state is unknown and a transport error reports no key
+func (r *remoteKeySigner) HasKey() bool {
+	if len(r.keyID) == 0 {
+		return false
+	}
+
+	r.lock.Lock()
+	known, hasKey := r.keyKnown, r.hasKey
+	r.lock.Unlock()
+	if known {
+		return hasKey
+	}
+
+	_, err := r.PublicKey()
+	return err == nil
+}
+
+// PublicKey returns the public key of the key the remote signer holds for the key ID
+func (r *remoteKeySigner) PublicKey() (string, error) {
+	if len(r.keyID) == 0 {
+		return defs.EmptyString, ErrNoKey
+	}
+
+	resp := &remoteKeyResponse{}
+	err := r.send(http.MethodGet, r.keyURL(), nil, resp)
+	r.updateKeyState(err)
+	if err != nil {
+		return defs.EmptyString, err
+	}
+
+	if len(resp.PublicKey) == 0 {
+		return defs.EmptyString, errors.New("invalid public key from the remote signer")
+	}
+
+	return resp.PublicKey, nil
+}
+
+// ClearKey does nothing, the key stays in the remote signer which manages its lifecycle
+func (r *remoteKeySigner) ClearKey() {}
+
+func (r *remoteKeySigner) Sign(message []byte) ([]byte, error) {
+	resp := &remoteSignResponse{}
+	req := remoteSignRequest{Message: base64.StdEncoding.EncodeToString(message)}
+	err := r.send(http.MethodPost, r.keyURL()+"/sign", req, resp)
+	r.updateKeyState(err)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(resp.Signature)
+	if err != nil || len(signature) == 0 {
+		return nil, errors.New("invalid signature from the remote signer")
+	}
+
+	return signature, nil
+}
+
+// updateKeyState keeps the key state from the answer of the remote signer, the other errors leave it as is
+func (r *remoteKeySigner) updateKeyState(err error) {
+	if err != nil && !errors.Is(err, ErrNoKey) {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.keyKnown = true
+	r.hasKey = err == nil
+}
+
+func (r *remoteKeySigner) keyURL() string {
+	return fmt.Sprintf("%s/v1/keys/%s", remoteSignerHost, url.PathEscape(r.keyID))
+}
+
+func (r *remoteKeySigner) send(method, url string, reqData, respData interface{}) error {
+	var body io.Reader
+	if reqData != nil {
+		b, err := json.Marshal(reqData)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "remote signer")
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errors.Wrap(err, "remote signer")
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		remoteErr := remoteErrorResponse{}
+		_ = json.Unmarshal(respBody, &remoteErr)
+
+		switch resp.StatusCode {
+		case http.StatusForbidden:
+			return defs.ErrForbidden().WithDetail(fmt.Sprintf("the remote signer refused: %s", remoteErr.Error))
+		case http.StatusNotFound:
+			return errors.Wrapf(ErrNoKey, "remote signer responded %d: %s", resp.StatusCode, remoteErr.Error)
+		}
+		return errors.Errorf("remote signer responded %d: %s", resp.StatusCode, remoteErr.Error)
+	}
+
+	if respData != nil {
+		if err := json.Unmarshal(respBody, respData); err != nil {
+			return errors.Wrap(err, "decode remote signer response")
+		}
+	}
+
+	return nil
+}
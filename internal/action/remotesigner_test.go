@@ -0,0 +1,267 @@
+package action
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/test-go/testify/assert"
+
+	"github.com/qredo/signing-agent/internal/auth"
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+// fakeRemoteSigner holds the imported keys, it signs by returning the key and the message
+type fakeRemoteSigner struct {
+	keys     map[string]string
+	nextKeys map[string]string
+	refuse   string
+	keyReads int
+}
+
+func (f *fakeRemoteSigner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/keys/some-key":
+		f.keyReads++
+		key, ok := f.keys["some-key"]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(remoteErrorResponse{Error: "unknown key"})
+			return
+		}
+
+		_ = json.NewEncoder(w).Encode(remoteKeyResponse{PublicKey: "pub-" + key})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/keys/some-key/next":
+		f.nextKeys["some-key"] = base64.StdEncoding.EncodeToString([]byte("next key"))
+		_ = json.NewEncoder(w).Encode(remoteKeyResponse{PublicKey: "pub-" + f.nextKeys["some-key"]})
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/keys/some-key/activate":
+		if key, ok := f.nextKeys["some-key"]; ok {
+			f.keys["some-key"] = key
+			delete(f.nextKeys, "some-key")
+		}
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/keys/some-key":
+		req := remoteImportRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.keys["some-key"] = req.PrivateKey
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/keys/some-key/sign":
+		if len(f.refuse) > 0 {
+			w.WriteHeader(http.StatusForbidden)
+			_ = json.NewEncoder(w).Encode(remoteErrorResponse{Error: f.refuse})
+			return
+		}
+
+		key, ok := f.keys["some-key"]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			_ = json.NewEncoder(w).Encode(remoteErrorResponse{Error: "unknown key"})
+			return
+		}
+
+		req := remoteSignRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		signature := base64.StdEncoding.EncodeToString([]byte(key + "|" + req.Message))
+		_ = json.NewEncoder(w).Encode(remoteSignResponse{Signature: signature})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestRemoteSigner(t *testing.T) (*fakeRemoteSigner, KeySigner) {
+	dir, err := os.MkdirTemp("", "rs")
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+
+	socket := filepath.Join(dir, "signer.sock")
+	listener, err := net.Listen("unix", socket)
+	assert.Nil(t, err)
+
+	fake := &fakeRemoteSigner{keys: map[string]string{}, nextKeys: map[string]string{}}
+	server := httptest.NewUnstartedServer(fake)
+	server.Listener = listener
+	server.Start()
+	t.Cleanup(server.Close)
+
+	return fake, NewRemoteKeySigner(config.RemoteSignerConfig{
+		Socket:     socket,
+		KeyID:      "some-key",
+		TimeoutSec: 5,
+	})
+}
+
+func TestRemoteKeySigner_imports_key_and_signs(t *testing.T) {
+	//Arrange
+	fake, sut := newTestRemoteSigner(t)
+	blsKey := base64.StdEncoding.EncodeToString([]byte("some key"))
+
+	//Act
+	err := sut.SetKey(blsKey)
+	assert.Nil(t, err)
+	signature, err := sut.Sign([]byte("some message"))
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, blsKey, fake.keys["some-key"])
+	assert.Equal(t, blsKey+"|"+base64.StdEncoding.EncodeToString([]byte("some message")), string(signature))
+	assert.True(t, sut.HasKey())
+}
+
+func TestRemoteKeySigner_generates_and_activates_next_key(t *testing.T) {
+	//Arrange
+	fake, sut := newTestRemoteSigner(t)
+	blsKey := base64.StdEncoding.EncodeToString([]byte("some key"))
+	err := sut.SetKey(blsKey)
+	assert.Nil(t, err)
+
+	//Act
+	publicKey, privateKey, err := sut.GenerateKey()
+	assert.Nil(t, err)
+	signature, err := sut.Sign([]byte("some message"))
+	assert.Nil(t, err)
+	err = sut.SetKey("")
+
+	//Assert
+	assert.Nil(t, err)
+	nextKey := base64.StdEncoding.EncodeToString([]byte("next key"))
+	assert.Equal(t, "pub-"+nextKey, publicKey)
+	assert.Empty(t, privateKey)
+	assert.Equal(t, blsKey+"|"+base64.StdEncoding.EncodeToString([]byte("some message")), string(signature))
+	assert.Equal(t, nextKey, fake.keys["some-key"])
+}
+
+func TestRemoteKeySigner_HasKey_no_key_in_remote_signer(t *testing.T) {
+	//Arrange
+	_, sut := newTestRemoteSigner(t)
+
+	//Act
+	res := sut.HasKey()
+
+	//Assert
+	assert.False(t, res)
+}
+
+func TestRemoteKeySigner_HasKey_keeps_the_key_state(t *testing.T) {
+	//Arrange
+	fake, sut := newTestRemoteSigner(t)
+	blsKey := base64.StdEncoding.EncodeToString([]byte("some key"))
+
+	//Act
+	before := sut.HasKey()
+	cached := sut.HasKey()
+	err := sut.SetKey(blsKey)
+	after := sut.HasKey()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.False(t, before)
+	assert.False(t, cached)
+	assert.True(t, after)
+	assert.Equal(t, 1, fake.keyReads)
+}
+
+func TestRemoteKeySigner_HasKey_signer_unreachable(t *testing.T) {
+	//Arrange
+	sut := NewRemoteKeySigner(config.RemoteSignerConfig{
+		Socket:     filepath.Join(t.TempDir(), "missing.sock"),
+		KeyID:      "some-key",
+		TimeoutSec: 1,
+	})
+
+	//Act
+	res := sut.HasKey()
+
+	//Assert
+	assert.False(t, res)
+	assert.False(t, sut.(*remoteKeySigner).keyKnown)
+}
+
+func TestRemoteKeySigner_Sign_refused_by_policy(t *testing.T) {
+	//Arrange
+	fake, sut := newTestRemoteSigner(t)
+	fake.refuse = "amount above the limit"
+
+	//Act
+	_, err := sut.Sign([]byte("some message"))
+
+	//Assert
+	apiErr, ok := err.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "the remote signer refused: amount above the limit", detail)
+}
+
+func TestRemoteKeySigner_Sign_unknown_key(t *testing.T) {
+	//Arrange
+	_, sut := newTestRemoteSigner(t)
+
+	//Act
+	_, err := sut.Sign([]byte("some message"))
+
+	//Assert
+	assert.True(t, errors.Is(err, ErrNoKey))
+	assert.Equal(t, "remote signer responded 404: unknown key: no bls key", err.Error())
+}
+
+func TestRemoteKeySigner_PublicKey(t *testing.T) {
+	//Arrange
+	fake, sut := newTestRemoteSigner(t)
+
+	//Act
+	_, errNoKey := sut.PublicKey()
+	fake.keys["some-key"] = "some key"
+	publicKey, err := sut.PublicKey()
+
+	//Assert
+	assert.True(t, errors.Is(errNoKey, ErrNoKey))
+	assert.Nil(t, err)
+	assert.Equal(t, "pub-some key", publicKey)
+}
+
+func TestRemoteKeySigner_Sign_signer_unreachable(t *testing.T) {
+	//Arrange
+	sut := NewRemoteKeySigner(config.RemoteSignerConfig{
+		Socket:     filepath.Join(t.TempDir(), "missing.sock"),
+		KeyID:      "some-key",
+		TimeoutSec: 1,
+	})
+
+	//Act
+	_, err := sut.Sign([]byte("some message"))
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "remote signer")
+}
+
+func TestSigner_ApproveActionMessages_refused_by_remote_signer(t *testing.T) {
+	//Arrange
+	fake, keySigner := newTestRemoteSigner(t)
+	fake.keys["some-key"] = "some key"
+	fake.refuse = "outside the signing hours"
+	mockGetAction([]byte("some message"))
+
+	sut := actionSigner{
+		htc:          util.NewHTTPMockClient(),
+		authProvider: &auth.MockHeaderProvider{},
+		keySigner:    keySigner,
+		log:          util.NewTestLogger(),
+	}
+
+	//Act
+	err := sut.ApproveActionMessages("test_id", [][]byte{[]byte("some message")})
+
+	//Assert
+	apiErr, ok := err.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "the remote signer refused: outside the signing hours", detail)
+}
@@ -1,14 +1,16 @@
 package action
 
 import (
-	"encoding/base64"
+	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/qredo/signing-agent/crypto"
 	"github.com/qredo/signing-agent/internal/auth"
 	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/metrics"
 	"github.com/qredo/signing-agent/internal/util"
 	"go.uber.org/zap"
 )
@@ -29,27 +31,43 @@ const (
 	reject  int = 4
 )
 
+// Signer signs the actions with the agent BLS key and submits the signatures to Qredo.
+// Every message of the action is signed and the signatures are submitted in the order of the messages.
+// ActionApprove and ActionReject return the signed messages, so the decision can be recorded
 type Signer interface {
+	// GenerateKey returns the public key of a new agent key and the private key to set, empty if the key signer holds it
+	GenerateKey() (string, string, error)
 	SetKey(blsPrivateKey string) error
-	ActionApprove(actionID string) error
-	ActionReject(actionID string) error
-	ApproveActionMessage(actionID string, message []byte) error
+	// ClearKey forgets the agent key, once the agent is deregistered
+	ClearKey()
+	// Sign returns the BLS signature of the message with the agent key
+	Sign(message []byte) ([]byte, error)
+	ActionApprove(actionID string) ([][]byte, error)
+	ActionReject(actionID string) ([][]byte, error)
+	ApproveActionMessages(actionID string, messages [][]byte) error
+	RejectActionMessages(actionID string, messages [][]byte) error
 }
 
 type actionSigner struct {
-	baseURL       string
-	blsPrivateKey []byte
+	baseURL   string
+	keySigner KeySigner
 
 	htc          *util.Client
 	authProvider auth.HeaderProvider
 	log          *zap.SugaredLogger
 }
 
-func NewSigner(baseURL string, authProvide auth.HeaderProvider, log *zap.SugaredLogger, blsPrivateKey string) (Signer, error) {
+// NewSigner returns the Signer with the key operations done by the key signer, the local key signer by default
+func NewSigner(baseURL string, authProvide auth.HeaderProvider, log *zap.SugaredLogger, keySigner KeySigner, blsPrivateKey string) (Signer, error) {
+	if keySigner == nil {
+		keySigner = NewLocalKeySigner()
+	}
+
 	s := &actionSigner{
 		htc:          util.NewHTTPClient(),
 		authProvider: authProvide,
 		baseURL:      baseURL,
+		keySigner:    keySigner,
 		log:          log,
 	}
 
@@ -62,40 +80,98 @@ func NewSigner(baseURL string, authProvide auth.HeaderProvider, log *zap.Sugared
 	return s, nil
 }
 
+func (s *actionSigner) GenerateKey() (string, string, error) {
+	return s.keySigner.GenerateKey()
+}
+
 func (s *actionSigner) SetKey(blsPrivateKey string) error {
-	data, err := base64.StdEncoding.DecodeString(blsPrivateKey)
-	if err != nil {
-		s.log.Errorf("failed to decode the agent blsPrivateKey, err: %v", err)
-		return fmt.Errorf("invalid bls key")
+	if err := s.keySigner.SetKey(blsPrivateKey); err != nil {
+		s.log.Errorf("failed to set the agent blsPrivateKey, err: %v", err)
+		return err
 	}
 
-	s.blsPrivateKey = data
 	return nil
 }
 
-func (s actionSigner) ActionApprove(actionID string) error {
-	message, err := s.getActionMessage(actionID)
+func (s *actionSigner) ClearKey() {
+	s.keySigner.ClearKey()
+}
+
+func (s actionSigner) Sign(message []byte) ([]byte, error) {
+	if s.keySigner == nil {
+		return nil, defs.ErrInternal().WithDetail("failed to generate signature, invalid blsKey")
+	}
+
+	signature, err := s.keySigner.Sign(message)
+	if errors.Is(err, ErrNoKey) {
+		return nil, defs.ErrInternal().WithDetail("failed to generate signature, invalid blsKey")
+	}
+
+	return signature, err
+}
+
+func (s actionSigner) ActionApprove(actionID string) ([][]byte, error) {
+	messages, err := s.getActionMessages(actionID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return s.signAction(actionID, message, approve)
+	return messages, s.signAction(actionID, messages, approve)
 }
 
-func (s actionSigner) ActionReject(actionID string) error {
-	message, err := s.getActionMessage(actionID)
+func (s actionSigner) ActionReject(actionID string) ([][]byte, error) {
+	messages, err := s.getActionMessages(actionID)
 	if err != nil {
+		return nil, err
+	}
+
+	return messages, s.signAction(actionID, messages, reject)
+}
+
+func (s actionSigner) ApproveActionMessages(actionID string, messages [][]byte) error {
+	if err := s.verifyActionMessages(actionID, messages); err != nil {
 		return err
 	}
 
-	return s.signAction(actionID, message, reject)
+	return s.signAction(actionID, messages, approve)
 }
 
-func (s actionSigner) ApproveActionMessage(actionID string, message []byte) error {
-	return s.signAction(actionID, message, approve)
+func (s actionSigner) RejectActionMessages(actionID string, messages [][]byte) error {
+	if err := s.verifyActionMessages(actionID, messages); err != nil {
+		return err
+	}
+
+	return s.signAction(actionID, messages, reject)
 }
 
-func (s actionSigner) getActionMessage(actionID string) ([]byte, error) {
+// verifyActionMessages makes sure the messages to sign are the messages of the action on Qredo,
+// as the messages given come from the feed or the cache
+func (s actionSigner) verifyActionMessages(actionID string, messages [][]byte) error {
+	if len(messages) == 0 {
+		return defs.ErrBadRequest().WithDetail("action can't be signed, no messages")
+	}
+
+	actionMessages, err := s.getActionMessages(actionID)
+	if err != nil {
+		return err
+	}
+
+	if len(actionMessages) != len(messages) {
+		s.log.Errorf("the action `%s` has %d messages, %d given", actionID, len(actionMessages), len(messages))
+		return defs.ErrBadRequest().WithDetail("action can't be signed, the messages don't match the action")
+	}
+
+	for i, message := range messages {
+		if !bytes.Equal(message, actionMessages[i]) {
+			s.log.Errorf("the message %d doesn't match the message of the action `%s`", i, actionID)
+			return defs.ErrBadRequest().WithDetail("action can't be signed, the messages don't match the action")
+		}
+	}
+
+	return nil
+}
+
+func (s actionSigner) getActionMessages(actionID string) ([][]byte, error) {
 	resp := &getActionResponse{}
 
 	header := s.authProvider.GetAuthHeader()
@@ -107,25 +183,55 @@ func (s actionSigner) getActionMessage(actionID string) ([]byte, error) {
 		return nil, defs.ErrBadRequest().WithDetail("action can't be signed, status not pending")
 	}
 
-	message, err := hex.DecodeString(resp.Messages[0])
-	if err != nil {
-		s.log.Errorf("failed to decode the action message, err: %v", err)
-		return nil, defs.ErrInternal().WithDetail("failed to decode the action message")
+	if len(resp.Messages) == 0 {
+		return nil, defs.ErrBadRequest().WithDetail("action can't be signed, no messages")
 	}
-	return message, nil
+
+	messages := make([][]byte, 0, len(resp.Messages))
+	for i, encoded := range resp.Messages {
+		message, err := hex.DecodeString(encoded)
+		if err != nil {
+			s.log.Errorf("failed to decode the action message %d, err: %v", i, err)
+			return nil, defs.ErrInternal().WithDetail("failed to decode the action message")
+		}
+		messages = append(messages, message)
+	}
+
+	return messages, nil
 }
 
-func (s actionSigner) signAction(actionID string, message []byte, status int) error {
-	if len(s.blsPrivateKey) == 0 {
+func (s actionSigner) signAction(actionID string, messages [][]byte, status int) (err error) {
+	defer func(start time.Time) {
+		metrics.SignDuration.WithLabelValues(statusLabel(status), metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+	}(time.Now())
+
+	if s.keySigner == nil {
 		return defs.ErrInternal().WithDetail("failed to generate signature, invalid blsKey")
 	}
 
-	blsSig, _ := crypto.BLSSign(message, s.blsPrivateKey)
-	signature := hex.EncodeToString(blsSig)
+	if len(messages) == 0 {
+		return defs.ErrBadRequest().WithDetail("action can't be signed, no messages")
+	}
 
 	req := signRequest{
 		Status:     status,
-		Signatures: []string{signature},
+		Signatures: make([]string, 0, len(messages)),
+	}
+	for i, message := range messages {
+		blsSig, err := s.keySigner.Sign(message)
+		if err != nil {
+			s.log.Errorf("failed to sign the message %d of the action `%s`, err: %v", i, actionID, err)
+			if errors.Is(err, ErrNoKey) {
+				return defs.ErrInternal().WithDetail("failed to generate signature, invalid blsKey")
+			}
+			var apiErr *defs.APIError
+			if errors.As(err, &apiErr) {
+				// the refusal of the remote signer
+				return apiErr
+			}
+			return defs.ErrInternal().WithDetail("failed to generate signature")
+		}
+		req.Signatures = append(req.Signatures, hex.EncodeToString(blsSig))
 	}
 
 	header := s.authProvider.GetAuthHeader()
@@ -136,3 +242,11 @@ func (s actionSigner) signAction(actionID string, message []byte, status int) er
 
 	return nil
 }
+
+func statusLabel(status int) string {
+	if status == approve {
+		return "approve"
+	}
+
+	return "reject"
+}
@@ -1,39 +1,69 @@
 package action
 
 type MockSigner struct {
-	ActionApproveCalled        bool
-	ActionRejectCalled         bool
-	ApproveActionMessageCalled bool
-	SetKeyCalled               bool
+	GenerateKeyCalled           bool
+	ActionApproveCalled         bool
+	ActionRejectCalled          bool
+	ApproveActionMessagesCalled bool
+	RejectActionMessagesCalled  bool
+	SetKeyCalled                bool
+	ClearKeyCalled              bool
+	SignCalled                  bool
 
 	LastBlsPrivateKey string
 	LastActionId      string
 	LastMessage       []byte
+	LastMessages      [][]byte
+	NextMessages      [][]byte
 	NextError         error
 	NextSetKeyError   error
+	NextSignature     []byte
+	NextPublicKey     string
+	NextPrivateKey    string
 
 	Counter int
 }
 
+func (m *MockSigner) GenerateKey() (string, string, error) {
+	m.GenerateKeyCalled = true
+	return m.NextPublicKey, m.NextPrivateKey, m.NextError
+}
+
 func (m *MockSigner) SetKey(blsPrivateKey string) error {
 	m.SetKeyCalled = true
 	m.LastBlsPrivateKey = blsPrivateKey
 	return m.NextSetKeyError
 }
-func (m *MockSigner) ActionApprove(actionID string) error {
+func (m *MockSigner) ClearKey() {
+	m.ClearKeyCalled = true
+}
+func (m *MockSigner) Sign(message []byte) ([]byte, error) {
+	m.SignCalled = true
+	m.LastMessage = message
+	return m.NextSignature, m.NextError
+}
+func (m *MockSigner) ActionApprove(actionID string) ([][]byte, error) {
 	m.ActionApproveCalled = true
 	m.LastActionId = actionID
-	return m.NextError
+	return m.NextMessages, m.NextError
 }
-func (m *MockSigner) ActionReject(actionID string) error {
+func (m *MockSigner) ActionReject(actionID string) ([][]byte, error) {
 	m.ActionRejectCalled = true
 	m.LastActionId = actionID
+	return m.NextMessages, m.NextError
+}
+func (m *MockSigner) ApproveActionMessages(actionID string, messages [][]byte) error {
+	m.ApproveActionMessagesCalled = true
+	m.LastActionId = actionID
+	m.LastMessages = messages
+	m.Counter++
 	return m.NextError
 }
-func (m *MockSigner) ApproveActionMessage(actionID string, message []byte) error {
-	m.ApproveActionMessageCalled = true
+
+func (m *MockSigner) RejectActionMessages(actionID string, messages [][]byte) error {
+	m.RejectActionMessagesCalled = true
 	m.LastActionId = actionID
-	m.LastMessage = message
+	m.LastMessages = messages
 	m.Counter++
 	return m.NextError
 }
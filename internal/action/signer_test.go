@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/qredo/signing-agent/internal/auth"
@@ -18,7 +19,7 @@ import (
 
 func TestSigner_NewSigner_invalid_key(t *testing.T) {
 	//Arrange//Act
-	sut, err := NewSigner("", nil, util.NewTestLogger(), "invalid")
+	sut, err := NewSigner("", nil, util.NewTestLogger(), nil, "invalid")
 
 	//Assert
 	assert.Nil(t, sut)
@@ -31,17 +32,17 @@ func TestSigner_NewSigner_setsKey(t *testing.T) {
 	blsKey := "AAAAAAAAAAAAAAAAAAAAABDDv4z4cTfnlPDDVe/BiMibwqyitjYevyAVXLOf6vOt"
 
 	//Act
-	sut, err := NewSigner("", nil, util.NewTestLogger(), blsKey)
+	sut, err := NewSigner("", nil, util.NewTestLogger(), nil, blsKey)
 
 	//Assert
 	assert.Nil(t, err)
 	assert.NotNil(t, sut)
-	assert.NotEmpty(t, sut.(*actionSigner).blsPrivateKey)
+	assert.NotEmpty(t, sut.(*actionSigner).keySigner.(*localKeySigner).blsPrivateKey)
 }
 
 func TestSigner_SetKey_invalid_key(t *testing.T) {
 	//Arrange
-	sut, _ := NewSigner("", nil, util.NewTestLogger(), defs.EmptyString)
+	sut, _ := NewSigner("", nil, util.NewTestLogger(), nil, defs.EmptyString)
 
 	//Act
 	err := sut.SetKey("new key")
@@ -54,14 +55,57 @@ func TestSigner_SetKey_invalid_key(t *testing.T) {
 func TestSigner_SetKey_sets_key(t *testing.T) {
 	//Arrange
 	blsKey := "AAAAAAAAAAAAAAAAAAAAABDDv4z4cTfnlPDDVe/BiMibwqyitjYevyAVXLOf6vOt"
-	sut, _ := NewSigner("", nil, util.NewTestLogger(), "")
+	sut, _ := NewSigner("", nil, util.NewTestLogger(), nil, "")
 
 	//Act
 	err := sut.SetKey(blsKey)
 
 	//Assert
 	assert.Nil(t, err)
-	assert.NotEmpty(t, sut.(*actionSigner).blsPrivateKey)
+	assert.NotEmpty(t, sut.(*actionSigner).keySigner.(*localKeySigner).blsPrivateKey)
+}
+
+func TestSigner_ClearKey_clears_key(t *testing.T) {
+	//Arrange
+	blsKey := "AAAAAAAAAAAAAAAAAAAAABDDv4z4cTfnlPDDVe/BiMibwqyitjYevyAVXLOf6vOt"
+	sut, _ := NewSigner("", nil, util.NewTestLogger(), nil, blsKey)
+	key := sut.(*actionSigner).keySigner.(*localKeySigner).blsPrivateKey
+
+	//Act
+	sut.ClearKey()
+	_, err := sut.Sign([]byte("message"))
+
+	//Assert
+	assert.Empty(t, sut.(*actionSigner).keySigner.(*localKeySigner).blsPrivateKey)
+	assert.Equal(t, make([]byte, len(key)), key)
+	assert.NotNil(t, err)
+}
+
+func TestSigner_SetKey_ClearKey_while_signing(t *testing.T) {
+	//Arrange
+	blsKey := "AAAAAAAAAAAAAAAAAAAAABDDv4z4cTfnlPDDVe/BiMibwqyitjYevyAVXLOf6vOt"
+	sut, _ := NewSigner("", nil, util.NewTestLogger(), nil, blsKey)
+
+	//Act
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_ = sut.SetKey(blsKey)
+			sut.ClearKey()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			_, _ = sut.Sign([]byte("message"))
+		}
+	}()
+	wg.Wait()
+
+	//Assert
+	assert.Empty(t, sut.(*actionSigner).keySigner.(*localKeySigner).blsPrivateKey)
 }
 
 func TestSigner_ActionApprove_getActionMessage_req_call_error(t *testing.T) {
@@ -96,7 +140,7 @@ func TestSigner_ActionApprove_getActionMessage_req_call_error(t *testing.T) {
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	_, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -130,7 +174,7 @@ func TestSigner_ActionApprove_getActionMessage_status_not_pending(t *testing.T)
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	_, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -163,7 +207,7 @@ func TestSigner_ActionApprove_getActionMessage_cant_decode_message(t *testing.T)
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	_, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -197,7 +241,7 @@ func TestSigner_ActionApprove_signAction_invalidKey(t *testing.T) {
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	_, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -244,15 +288,15 @@ func TestSigner_ActionApprove_signAction_request_error(t *testing.T) {
 	}
 
 	sut := actionSigner{
-		htc:           htcMock,
-		authProvider:  authMock,
-		baseURL:       "apiURL",
-		log:           util.NewTestLogger(),
-		blsPrivateKey: []byte("data"),
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	_, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -287,18 +331,19 @@ func TestSigner_ActionApprove_signAction_request_success(t *testing.T) {
 	}
 
 	sut := actionSigner{
-		htc:           htcMock,
-		authProvider:  authMock,
-		baseURL:       "apiURL",
-		log:           util.NewTestLogger(),
-		blsPrivateKey: []byte("data"),
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
 	}
 
 	//Act
-	err := sut.ActionApprove("test_id")
+	signed, err := sut.ActionApprove("test_id")
 
 	//Assert
 	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("some data")}, signed)
 }
 
 func TestSigner_ActionReject_signAction_request_success(t *testing.T) {
@@ -342,15 +387,15 @@ func TestSigner_ActionReject_signAction_request_success(t *testing.T) {
 	}
 
 	sut := actionSigner{
-		htc:           htcMock,
-		authProvider:  authMock,
-		baseURL:       "apiURL",
-		log:           util.NewTestLogger(),
-		blsPrivateKey: []byte("data"),
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
 	}
 
 	//Act
-	err := sut.ActionReject("test_id")
+	_, err := sut.ActionReject("test_id")
 
 	//Assert
 	assert.Nil(t, err)
@@ -362,7 +407,7 @@ func TestSigner_ActionReject_signAction_request_success(t *testing.T) {
 	assert.NotEmpty(t, lastSignRequest.Signatures)
 }
 
-func TestSigner_ApproveActionMessage_signAction_request_success(t *testing.T) {
+func TestSigner_ApproveActionMessages_signAction_request_success(t *testing.T) {
 	//Arrange
 	authMock := &auth.MockHeaderProvider{
 		NextHeader: http.Header{},
@@ -376,6 +421,10 @@ func TestSigner_ApproveActionMessage_signAction_request_success(t *testing.T) {
 	)
 
 	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodGet {
+			return getActionHTTPResponse([]byte("some data")), nil
+		}
+
 		jd := json.NewDecoder(r.Body)
 
 		defer r.Body.Close()
@@ -391,15 +440,15 @@ func TestSigner_ApproveActionMessage_signAction_request_success(t *testing.T) {
 	}
 
 	sut := actionSigner{
-		htc:           htcMock,
-		authProvider:  authMock,
-		baseURL:       "apiURL",
-		log:           util.NewTestLogger(),
-		blsPrivateKey: []byte("data"),
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
 	}
 
 	//Act
-	err := sut.ApproveActionMessage("test_id", []byte("some data"))
+	err := sut.ApproveActionMessages("test_id", [][]byte{[]byte("some data")})
 
 	//Assert
 	assert.Nil(t, err)
@@ -410,3 +459,281 @@ func TestSigner_ApproveActionMessage_signAction_request_success(t *testing.T) {
 	assert.Equal(t, approve, lastSignRequest.Status)
 	assert.NotEmpty(t, lastSignRequest.Signatures)
 }
+
+func TestSigner_ActionApprove_signs_every_message_in_order(t *testing.T) {
+	//Arrange
+	authMock := &auth.MockHeaderProvider{
+		NextHeader: http.Header{},
+	}
+
+	messages := fmt.Sprintf(`{"messages":["%s","%s"], "status":1, "id":"someID"}`,
+		hex.EncodeToString([]byte("first")), hex.EncodeToString([]byte("second")))
+	htcMock := util.NewHTTPMockClient()
+
+	var lastSignRequest signRequest
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodPost {
+			defer r.Body.Close()
+			_ = json.NewDecoder(r.Body).Decode(&lastSignRequest)
+		}
+
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(messages))),
+		}, nil
+	}
+
+	sut := actionSigner{
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
+	}
+
+	//Act
+	signed, err := sut.ActionApprove("test_id")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("first"), []byte("second")}, signed)
+	assert.Len(t, lastSignRequest.Signatures, 2)
+
+	first, _ := sut.Sign([]byte("first"))
+	second, _ := sut.Sign([]byte("second"))
+	assert.Equal(t, []string{hex.EncodeToString(first), hex.EncodeToString(second)}, lastSignRequest.Signatures)
+}
+
+func TestSigner_ActionApprove_action_without_messages(t *testing.T) {
+	//Arrange
+	authMock := &auth.MockHeaderProvider{
+		NextHeader: http.Header{},
+	}
+
+	htcMock := util.NewHTTPMockClient()
+	postCalled := false
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		postCalled = postCalled || r.Method == http.MethodPost
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"messages":[], "status":1, "id":"someID"}`))),
+		}, nil
+	}
+
+	sut := actionSigner{
+		htc:          htcMock,
+		authProvider: authMock,
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
+	}
+
+	//Act
+	_, err := sut.ActionApprove("test_id")
+
+	//Assert
+	assert.NotNil(t, err)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "action can't be signed, no messages", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.False(t, postCalled)
+}
+
+func TestSigner_RejectActionMessages_no_messages(t *testing.T) {
+	//Arrange
+	sut := actionSigner{
+		log:       util.NewTestLogger(),
+		keySigner: &localKeySigner{blsPrivateKey: []byte("data")},
+	}
+
+	//Act
+	err := sut.RejectActionMessages("test_id", nil)
+
+	//Assert
+	assert.NotNil(t, err)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "action can't be signed, no messages", detail)
+}
+
+// getActionHTTPResponse returns the response of Qredo to the GET of a pending action with the messages
+func getActionHTTPResponse(messages ...[]byte) *http.Response {
+	resp := getActionResponse{
+		ID:     "test_id",
+		Status: defs.StatusPending,
+	}
+	for _, message := range messages {
+		resp.Messages = append(resp.Messages, hex.EncodeToString(message))
+	}
+	data, _ := json.Marshal(resp)
+
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: 200,
+		Body:       io.NopCloser(bytes.NewReader(data)),
+	}
+}
+
+// mockGetAction makes Qredo return the pending action with the messages
+// mockKeySigner fails to sign with NextSignError, the other KeySigner methods are not expected to be called
+type mockKeySigner struct {
+	KeySigner
+	SignCalled    bool
+	NextSignError error
+}
+
+func (m *mockKeySigner) Sign(message []byte) ([]byte, error) {
+	m.SignCalled = true
+	return nil, m.NextSignError
+}
+
+func TestSigner_ApproveActionMessages_wrapped_sign_refusal(t *testing.T) {
+	//Arrange
+	mockGetAction([]byte("some message"))
+	refusal := defs.ErrForbidden().WithDetail("the remote signer refused: outside the signing hours")
+	sut := actionSigner{
+		htc:          util.NewHTTPMockClient(),
+		authProvider: &auth.MockHeaderProvider{},
+		keySigner:    &mockKeySigner{NextSignError: fmt.Errorf("sign message: %w", refusal)},
+		log:          util.NewTestLogger(),
+	}
+
+	//Act
+	err := sut.ApproveActionMessages("test_id", [][]byte{[]byte("some message")})
+
+	//Assert
+	apiErr, ok := err.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "the remote signer refused: outside the signing hours", detail)
+}
+
+func TestSigner_ApproveActionMessages_no_key(t *testing.T) {
+	//Arrange
+	mockGetAction([]byte("some message"))
+	keySigner := &mockKeySigner{NextSignError: fmt.Errorf("remote signer responded 404: %w", ErrNoKey)}
+	sut := actionSigner{
+		htc:          util.NewHTTPMockClient(),
+		authProvider: &auth.MockHeaderProvider{},
+		keySigner:    keySigner,
+		log:          util.NewTestLogger(),
+	}
+
+	//Act
+	err := sut.ApproveActionMessages("test_id", [][]byte{[]byte("some message")})
+
+	//Assert
+	assert.True(t, keySigner.SignCalled)
+	apiErr, ok := err.(*defs.APIError)
+	assert.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "failed to generate signature, invalid blsKey", detail)
+}
+
+func TestSigner_Sign_returns_the_key_signer_error(t *testing.T) {
+	//Arrange
+	sut := actionSigner{
+		keySigner: &mockKeySigner{NextSignError: errors.New("remote signer: connection refused")},
+		log:       util.NewTestLogger(),
+	}
+
+	//Act
+	_, err := sut.Sign([]byte("some message"))
+
+	//Assert
+	assert.EqualError(t, err, "remote signer: connection refused")
+}
+
+func mockGetAction(messages ...[]byte) {
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return getActionHTTPResponse(messages...), nil
+	}
+}
+
+func TestSigner_ApproveActionMessages_messages_dont_match_the_action(t *testing.T) {
+	testCases := []struct {
+		name     string
+		messages [][]byte
+	}{
+		{"different count", [][]byte{[]byte("some data"), []byte("other data")}},
+		{"different bytes", [][]byte{[]byte("some dat4")}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			postCalled := false
+			util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+				if r.Method == http.MethodPost {
+					postCalled = true
+				}
+				return getActionHTTPResponse([]byte("some data")), nil
+			}
+			keySigner := &localKeySigner{blsPrivateKey: []byte("data")}
+			sut := actionSigner{
+				htc:          util.NewHTTPMockClient(),
+				authProvider: &auth.MockHeaderProvider{},
+				baseURL:      "apiURL",
+				log:          util.NewTestLogger(),
+				keySigner:    keySigner,
+			}
+
+			//Act
+			err := sut.ApproveActionMessages("test_id", tc.messages)
+
+			//Assert
+			assert.NotNil(t, err)
+			code, detail := err.(*defs.APIError).APIError()
+			assert.Equal(t, http.StatusBadRequest, code)
+			assert.Equal(t, "action can't be signed, the messages don't match the action", detail)
+			assert.False(t, postCalled)
+		})
+	}
+}
+
+func TestSigner_RejectActionMessages_action_not_pending(t *testing.T) {
+	//Arrange
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"id":"test_id","status":3,"messages":["00"]}`))),
+		}, nil
+	}
+	sut := actionSigner{
+		htc:          util.NewHTTPMockClient(),
+		authProvider: &auth.MockHeaderProvider{},
+		baseURL:      "apiURL",
+		log:          util.NewTestLogger(),
+		keySigner:    &localKeySigner{blsPrivateKey: []byte("data")},
+	}
+
+	//Act
+	err := sut.RejectActionMessages("test_id", [][]byte{{0}})
+
+	//Assert
+	assert.NotNil(t, err)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "action can't be signed, status not pending", detail)
+}
+
+func TestLocalKeySigner_PublicKey(t *testing.T) {
+	//Arrange
+	publicKey, privateKey, err := defs.GenerateBLSKey()
+	assert.Nil(t, err)
+	sut := NewLocalKeySigner()
+
+	//Act
+	_, errNoKey := sut.PublicKey()
+	assert.Nil(t, sut.SetKey(privateKey))
+	res, err := sut.PublicKey()
+
+	//Assert
+	assert.Equal(t, ErrNoKey, errNoKey)
+	assert.Nil(t, err)
+	assert.Equal(t, publicKey, res)
+}
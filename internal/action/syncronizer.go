@@ -7,6 +7,7 @@ import (
 	"github.com/go-redsync/redsync/v4"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/metrics"
 )
 
 var ctxBackground = context.Background()
@@ -46,6 +47,7 @@ func NewSyncronizer(conf *config.LoadBalancing, cache message.KVStore, sync sync
 // ShouldHandleAction returns true if the action wasn't already picked up by another agent
 func (a *syncronize) ShouldHandleAction(actionID string) bool {
 	if err := a.cache.Get(ctxBackground, a.getKey(actionID)).Err(); err == nil {
+		metrics.ActionsAlreadyHandled.Inc()
 		return false
 	}
 
@@ -56,7 +58,11 @@ func (a *syncronize) ShouldHandleAction(actionID string) bool {
 
 // AcquireLock locks the mutex set for the action to be handled
 func (a *syncronize) AcquireLock() error {
-	if err := a.mutex.Lock(); err != nil {
+	start := time.Now()
+	err := a.mutex.Lock()
+	metrics.LockWaitDuration.WithLabelValues(metrics.Outcome(err)).Observe(time.Since(start).Seconds())
+
+	if err != nil {
 		time.Sleep(time.Duration(a.cfgLoadBalancing.OnLockErrorTimeOutMs) * time.Millisecond)
 		return err
 	}
@@ -1,5 +1,15 @@
 package api
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/qredo/signing-agent/internal/audit"
+	"github.com/qredo/signing-agent/internal/backup"
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/journal"
+)
+
 type AgentRegisterRequest struct {
 	APIKeyID    string `json:"APIKeyID" validate:"required"`
 	Secret      string `json:"secret" validate:"required"`
@@ -16,11 +26,98 @@ type GetAgentDetailsResponse struct {
 	FeedURL string `json:"feedURL"`
 }
 
+// BackupRequest sets either the passphrase or the hex or base64 encoded secp256k1 public key the backup is encrypted with
+type BackupRequest struct {
+	Passphrase string `json:"passphrase"`
+	PublicKey  string `json:"publicKey"`
+}
+
+// RestoreRequest is the backup bundle with either its passphrase or the hex or base64 encoded secp256k1 private key
+type RestoreRequest struct {
+	Bundle     *backup.Bundle `json:"bundle" validate:"required"`
+	Passphrase string         `json:"passphrase"`
+	PrivateKey string         `json:"privateKey"`
+}
+
+type RestoreResponse struct {
+	AgentID string `json:"agentID"`
+	FeedURL string `json:"feedURL"`
+}
+
+// RotateKeysResponse is the agent with rotated keys and the approved action of the rotation
+type RotateKeysResponse struct {
+	AgentID  string `json:"agentID"`
+	ActionID string `json:"actionID"`
+}
+
+// DeregisterResponse is the agent removed from the signing agent
+type DeregisterResponse struct {
+	AgentID string `json:"agentID"`
+}
+
+// WebhookDeadLettersResponse is the webhook deliveries given up after the last attempt
+type WebhookDeadLettersResponse struct {
+	DeadLetters []WebhookDeadLetter `json:"deadLetters"`
+}
+
+// WebhookDeadLetterResponse is the outcome of the request on the dead letter
+type WebhookDeadLetterResponse struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+}
+
+type WebhookDeadLetter struct {
+	ID        string          `json:"id"`
+	Endpoint  string          `json:"endpoint"`
+	ActionID  string          `json:"actionID"`
+	Body      json.RawMessage `json:"body"`
+	Attempts  int             `json:"attempts"`
+	CreatedAt time.Time       `json:"createdAt"`
+	FailedAt  time.Time       `json:"failedAt"`
+	LastError string          `json:"lastError"`
+}
+
+// ConfigResponse is the redacted running config with the fingerprint of its settings
+type ConfigResponse struct {
+	config.Config `yaml:",inline"`
+	Fingerprint   string `json:"fingerprint" yaml:"fingerprint"`
+}
+
 type ActionResponse struct {
 	ActionID string `json:"actionID"`
 	Status   string `json:"status"`
 }
 
+// ActionsHistoryResponse is a page of the recorded decisions. Before is set when there may be earlier decisions,
+// it's the value of the before param to get the previous page
+type ActionsHistoryResponse struct {
+	Actions []journal.Entry `json:"actions"`
+	Before  int             `json:"before,omitempty"`
+}
+
+type ActionProofResponse struct {
+	ActionID string        `json:"actionID"`
+	Proofs   []audit.Proof `json:"proofs"`
+}
+
+type PendingAction struct {
+	ID          string `json:"id"`
+	Status      int    `json:"status"`
+	ExpireTime  int64  `json:"expireTime"`
+	Type        string `json:"type,omitempty"`
+	WorkspaceID string `json:"workspaceID,omitempty"`
+	Asset       string `json:"asset,omitempty"`
+	Amount      int64  `json:"amount,omitempty"`
+	Destination string `json:"destination,omitempty"`
+}
+
+type PendingActionsResponse struct {
+	Total   int             `json:"total"`
+	Offset  int             `json:"offset"`
+	Limit   int             `json:"limit"`
+	Actions []PendingAction `json:"actions"`
+}
+
 type WebsocketStatus struct {
 	ReadyState       string `json:"readyState"`
 	RemoteFeedUrl    string `json:"remoteFeedURL"`
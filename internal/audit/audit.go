@@ -0,0 +1,408 @@
+// Package audit keeps a tamper-evident trail of the signatures of the agent.
+// The decisions recorded in the journal are appended as leaves to the audit file and periodically sealed
+// into batches. The leaves of a batch are hashed into a Merkle tree, together with the root of the previous batch,
+// and the root is signed with the agent BLS key. An inclusion proof can be returned for any action in a sealed batch
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/crypto"
+)
+
+const (
+	recordLeaf  = "leaf"
+	recordBatch = "batch"
+
+	// maxRecordSize is the maximum size of a line in the audit file
+	maxRecordSize = 1024 * 1024
+)
+
+// genesisRoot is the previous root of the first batch
+var genesisRoot = make([]byte, crypto.HashSize)
+
+// RootSigner signs the batch roots with the agent BLS key
+type RootSigner interface {
+	Sign(message []byte) ([]byte, error)
+}
+
+// Log is the audit trail of the agent signatures
+type Log interface {
+	// Append adds the leaf of the action to the next batch
+	Append(actionID string, leaf []byte) error
+	// Proofs returns the inclusion proofs of the leaves of the action in the sealed batches
+	Proofs(actionID string) ([]Proof, error)
+	// Start starts sealing the pending leaves periodically
+	Start()
+	// Stop stops sealing and seals the pending leaves
+	Stop()
+}
+
+// Batch is a sealed set of consecutive leaves
+type Batch struct {
+	Sequence     int       `json:"sequence"`
+	FirstLeaf    int       `json:"firstLeaf"`
+	LeafCount    int       `json:"leafCount"`
+	PreviousRoot string    `json:"previousRoot"`
+	Root         string    `json:"root"`
+	Signature    string    `json:"signature"`
+	SealedAt     time.Time `json:"sealedAt"`
+}
+
+// Proof proves the leaf is part of the batch. Path is the proof generated by crypto.GenerateProofFromTree,
+// hex encoded, and can be checked with crypto.Verify against the root. The first leaf of every batch tree
+// is the previous root, so the leaf index in the tree is LeafIndex+1
+type Proof struct {
+	ActionID     string    `json:"actionID"`
+	Leaf         string    `json:"leaf"`
+	LeafIndex    int       `json:"leafIndex"`
+	Batch        int       `json:"batch"`
+	Path         []string  `json:"path"`
+	PreviousRoot string    `json:"previousRoot"`
+	Root         string    `json:"root"`
+	Signature    string    `json:"signature"`
+	SealedAt     time.Time `json:"sealedAt"`
+}
+
+type record struct {
+	Type     string `json:"type"`
+	ActionID string `json:"actionID,omitempty"`
+	Leaf     string `json:"leaf,omitempty"`
+	Batch    *Batch `json:"batch,omitempty"`
+}
+
+type fileLog struct {
+	lock   sync.Mutex
+	file   *os.File
+	log    *zap.SugaredLogger
+	signer RootSigner
+
+	interval     time.Duration
+	maxBatchSize int
+
+	leaves      []string
+	leafActions []string
+	actions     map[string][]int
+	batches     []Batch
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewFileLog opens the audit file, creating it if it doesn't exist, and loads the leaves and batches.
+// The pending leaves are sealed every interval or as soon as maxBatchSize leaves are pending
+func NewFileLog(fileName string, interval time.Duration, maxBatchSize int, signer RootSigner, log *zap.SugaredLogger) (Log, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open audit file")
+	}
+
+	l := &fileLog{
+		file:         file,
+		log:          log,
+		signer:       signer,
+		interval:     interval,
+		maxBatchSize: maxBatchSize,
+		actions:      make(map[string][]int),
+	}
+
+	if err := l.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return l, nil
+}
+
+func (l *fileLog) Start() {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(l.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.sealPending()
+			case <-l.stop:
+				l.sealPending()
+				return
+			}
+		}
+	}()
+}
+
+func (l *fileLog) Stop() {
+	if l.stop == nil {
+		return
+	}
+
+	close(l.stop)
+	<-l.done
+	l.stop = nil
+}
+
+func (l *fileLog) Append(actionID string, leaf []byte) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	rec := record{
+		Type:     recordLeaf,
+		ActionID: actionID,
+		Leaf:     string(leaf),
+	}
+	if err := l.write(rec); err != nil {
+		return err
+	}
+	l.addLeaf(rec)
+
+	if l.maxBatchSize > 0 && l.pendingCount() >= l.maxBatchSize {
+		if err := l.seal(); err != nil {
+			l.log.Errorf("Audit: failed to seal the batch, err: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (l *fileLog) Proofs(actionID string) ([]Proof, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	proofs := []Proof{}
+	for _, index := range l.actions[actionID] {
+		batch := l.batchOf(index)
+		if batch == nil {
+			continue
+		}
+
+		proof, err := l.proof(index, batch)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, *proof)
+	}
+
+	return proofs, nil
+}
+
+func (l *fileLog) sealPending() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := l.seal(); err != nil {
+		l.log.Errorf("Audit: failed to seal the batch, err: %v", err)
+	}
+}
+
+// seal builds the Merkle tree of the pending leaves, signs its root and writes the batch.
+// The caller must hold the lock
+func (l *fileLog) seal() error {
+	count := l.pendingCount()
+	if count == 0 {
+		return nil
+	}
+
+	previousRoot := l.lastRoot()
+	first := len(l.leaves) - count
+	tree, err := buildTree(previousRoot, l.leaves[first:])
+	if err != nil {
+		return err
+	}
+
+	root := *tree[len(tree)-1]
+	signature, err := l.signer.Sign(root)
+	if err != nil {
+		return errors.Wrap(err, "sign batch root")
+	}
+
+	batch := &Batch{
+		Sequence:     len(l.batches),
+		FirstLeaf:    first,
+		LeafCount:    count,
+		PreviousRoot: hex.EncodeToString(previousRoot),
+		Root:         hex.EncodeToString(root),
+		Signature:    hex.EncodeToString(signature),
+		SealedAt:     time.Now().UTC(),
+	}
+	if err := l.write(record{Type: recordBatch, Batch: batch}); err != nil {
+		return err
+	}
+	l.batches = append(l.batches, *batch)
+
+	l.log.Infof("Audit: sealed batch %d with %d leaves, root %s", batch.Sequence, batch.LeafCount, batch.Root)
+	return nil
+}
+
+func (l *fileLog) proof(index int, batch *Batch) (*Proof, error) {
+	previousRoot, err := hex.DecodeString(batch.PreviousRoot)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode previous root")
+	}
+
+	tree, err := buildTree(previousRoot, l.leaves[batch.FirstLeaf:batch.FirstLeaf+batch.LeafCount])
+	if err != nil {
+		return nil, err
+	}
+
+	if hex.EncodeToString(*tree[len(tree)-1]) != batch.Root {
+		return nil, errors.Errorf("root mismatch for batch %d", batch.Sequence)
+	}
+
+	pos := index - batch.FirstLeaf + 1
+	path, err := crypto.GenerateProofFromTree(tree[pos], pos, tree)
+	if err != nil {
+		return nil, errors.Wrap(err, "generate proof")
+	}
+
+	proof := &Proof{
+		ActionID:     l.leafActions[index],
+		Leaf:         l.leaves[index],
+		LeafIndex:    index - batch.FirstLeaf,
+		Batch:        batch.Sequence,
+		PreviousRoot: batch.PreviousRoot,
+		Root:         batch.Root,
+		Signature:    batch.Signature,
+		SealedAt:     batch.SealedAt,
+	}
+	for _, node := range path {
+		proof.Path = append(proof.Path, hex.EncodeToString(*node))
+	}
+
+	return proof, nil
+}
+
+// buildTree returns the Merkle tree of the previous root followed by the leaves
+func buildTree(previousRoot []byte, leaves []string) ([]*[]byte, error) {
+	assets := make([][]byte, 0, len(leaves)+1)
+	assets = append(assets, previousRoot)
+	for _, leaf := range leaves {
+		assets = append(assets, []byte(leaf))
+	}
+
+	tree, err := crypto.BuildMerkleTreeStore(assets)
+	if err != nil {
+		return nil, errors.Wrap(err, "build merkle tree")
+	}
+
+	return tree, nil
+}
+
+func (l *fileLog) load() error {
+	scanner := bufio.NewScanner(l.file)
+	scanner.Buffer(make([]byte, 0, 4096), maxRecordSize)
+	for scanner.Scan() {
+		rec := record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return errors.Wrap(err, "parse audit record")
+		}
+
+		switch rec.Type {
+		case recordLeaf:
+			l.addLeaf(rec)
+		case recordBatch:
+			if rec.Batch == nil || rec.Batch.FirstLeaf+rec.Batch.LeafCount > len(l.leaves) {
+				return errors.Errorf("invalid audit batch after leaf %d", len(l.leaves))
+			}
+			l.batches = append(l.batches, *rec.Batch)
+		default:
+			return errors.Errorf("unknown audit record type `%s`", rec.Type)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.Wrap(err, "read audit file")
+	}
+
+	return nil
+}
+
+// write appends the record to the audit file. The caller must hold the lock
+func (l *fileLog) write(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit record")
+	}
+	data = append(data, '\n')
+
+	if _, err := l.file.Write(data); err != nil {
+		return errors.Wrap(err, "write audit record")
+	}
+
+	return l.file.Sync()
+}
+
+func (l *fileLog) addLeaf(rec record) {
+	l.actions[rec.ActionID] = append(l.actions[rec.ActionID], len(l.leaves))
+	l.leaves = append(l.leaves, rec.Leaf)
+	l.leafActions = append(l.leafActions, rec.ActionID)
+}
+
+func (l *fileLog) pendingCount() int {
+	if len(l.batches) == 0 {
+		return len(l.leaves)
+	}
+
+	last := l.batches[len(l.batches)-1]
+	return len(l.leaves) - last.FirstLeaf - last.LeafCount
+}
+
+func (l *fileLog) lastRoot() []byte {
+	if len(l.batches) == 0 {
+		return genesisRoot
+	}
+
+	root, err := hex.DecodeString(l.batches[len(l.batches)-1].Root)
+	if err != nil {
+		return genesisRoot
+	}
+
+	return root
+}
+
+func (l *fileLog) batchOf(index int) *Batch {
+	for i := range l.batches {
+		if index >= l.batches[i].FirstLeaf && index < l.batches[i].FirstLeaf+l.batches[i].LeafCount {
+			return &l.batches[i]
+		}
+	}
+
+	return nil
+}
+
+// VerifyProof checks the proof path against its root. The signature of the root must be verified
+// separately, with the agent BLS public key
+func VerifyProof(proof Proof) error {
+	root, err := hex.DecodeString(proof.Root)
+	if err != nil {
+		return errors.Wrap(err, "decode root")
+	}
+
+	path := make([][]byte, 0, len(proof.Path))
+	for _, node := range proof.Path {
+		decoded, err := hex.DecodeString(node)
+		if err != nil {
+			return errors.Wrap(err, "decode proof path")
+		}
+		path = append(path, decoded)
+	}
+
+	if len(path) < 2 || !bytes.Equal(crypto.HashB([]byte(proof.Leaf)), path[0]) {
+		return errors.New("proof doesn't match the leaf")
+	}
+
+	return crypto.Verify(root, path)
+}
@@ -0,0 +1,35 @@
+package audit
+
+type MockLog struct {
+	AppendCalled bool
+	ProofsCalled bool
+	StartCalled  bool
+	StopCalled   bool
+
+	LastActionID string
+	LastLeaf     []byte
+
+	NextProofs []Proof
+	NextError  error
+}
+
+func (m *MockLog) Append(actionID string, leaf []byte) error {
+	m.AppendCalled = true
+	m.LastActionID = actionID
+	m.LastLeaf = leaf
+	return m.NextError
+}
+
+func (m *MockLog) Proofs(actionID string) ([]Proof, error) {
+	m.ProofsCalled = true
+	m.LastActionID = actionID
+	return m.NextProofs, m.NextError
+}
+
+func (m *MockLog) Start() {
+	m.StartCalled = true
+}
+
+func (m *MockLog) Stop() {
+	m.StopCalled = true
+}
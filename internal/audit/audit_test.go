@@ -0,0 +1,199 @@
+package audit
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/internal/journal"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+type mockRootSigner struct {
+	SignCalled bool
+	LastRoot   []byte
+	NextError  error
+}
+
+func (m *mockRootSigner) Sign(message []byte) ([]byte, error) {
+	m.SignCalled = true
+	m.LastRoot = message
+	return []byte("signature"), m.NextError
+}
+
+func newTestLog(t *testing.T, fileName string, maxBatchSize int, signer RootSigner) *fileLog {
+	l, err := NewFileLog(fileName, time.Hour, maxBatchSize, signer, util.NewTestLogger())
+	require.Nil(t, err)
+	return l.(*fileLog)
+}
+
+func TestFileLog_Proofs_verify_for_every_leaf(t *testing.T) {
+	for _, count := range []int{1, 2, 3, 5, 8} {
+		t.Run(fmt.Sprintf("%d leaves", count), func(t *testing.T) {
+			// Arrange
+			signer := &mockRootSigner{}
+			sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 0, signer)
+			for i := 0; i < count; i++ {
+				require.Nil(t, sut.Append(fmt.Sprintf("action%d", i), []byte(fmt.Sprintf(`{"leaf":%d}`, i))))
+			}
+
+			// Act
+			sut.sealPending()
+
+			// Assert
+			assert.True(t, signer.SignCalled)
+			for i := 0; i < count; i++ {
+				proofs, err := sut.Proofs(fmt.Sprintf("action%d", i))
+				require.Nil(t, err)
+				require.Len(t, proofs, 1)
+				assert.Equal(t, i, proofs[0].LeafIndex)
+				assert.Equal(t, "7369676e6174757265", proofs[0].Signature)
+				assert.Nil(t, VerifyProof(proofs[0]))
+			}
+		})
+	}
+}
+
+func TestFileLog_Proofs_pending_leaf_has_no_proof(t *testing.T) {
+	// Arrange
+	sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 0, &mockRootSigner{})
+	require.Nil(t, sut.Append("action", []byte("leaf")))
+
+	// Act
+	proofs, err := sut.Proofs("action")
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Empty(t, proofs)
+}
+
+func TestFileLog_Append_seals_full_batch_and_chains_roots(t *testing.T) {
+	// Arrange
+	sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 2, &mockRootSigner{})
+
+	// Act
+	for i := 0; i < 4; i++ {
+		require.Nil(t, sut.Append(fmt.Sprintf("action%d", i), []byte(fmt.Sprintf("leaf%d", i))))
+	}
+
+	// Assert
+	require.Len(t, sut.batches, 2)
+	assert.Equal(t, 0, sut.pendingCount())
+	assert.Equal(t, sut.batches[0].Root, sut.batches[1].PreviousRoot)
+
+	proofs, err := sut.Proofs("action3")
+	require.Nil(t, err)
+	require.Len(t, proofs, 1)
+	assert.Equal(t, 1, proofs[0].Batch)
+	assert.Equal(t, sut.batches[0].Root, proofs[0].PreviousRoot)
+}
+
+func TestFileLog_seal_keeps_leaves_pending_when_signing_fails(t *testing.T) {
+	// Arrange
+	signer := &mockRootSigner{NextError: errors.New("no key")}
+	sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 0, signer)
+	require.Nil(t, sut.Append("action", []byte("leaf")))
+
+	// Act
+	sut.sealPending()
+
+	// Assert
+	assert.True(t, signer.SignCalled)
+	assert.Empty(t, sut.batches)
+	assert.Equal(t, 1, sut.pendingCount())
+}
+
+func TestNewFileLog_loads_leaves_and_batches(t *testing.T) {
+	// Arrange
+	fileName := filepath.Join(t.TempDir(), "audit.jsonl")
+	first := newTestLog(t, fileName, 0, &mockRootSigner{})
+	require.Nil(t, first.Append("sealed", []byte("leaf1")))
+	first.sealPending()
+	require.Nil(t, first.Append("pending", []byte("leaf2")))
+
+	// Act
+	sut := newTestLog(t, fileName, 0, &mockRootSigner{})
+
+	// Assert
+	require.Len(t, sut.batches, 1)
+	assert.Equal(t, first.batches[0], sut.batches[0])
+	assert.Equal(t, 1, sut.pendingCount())
+
+	proofs, err := sut.Proofs("sealed")
+	require.Nil(t, err)
+	require.Len(t, proofs, 1)
+	assert.Nil(t, VerifyProof(proofs[0]))
+}
+
+func TestVerifyProof_detects_altered_leaf(t *testing.T) {
+	// Arrange
+	sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 0, &mockRootSigner{})
+	require.Nil(t, sut.Append("action1", []byte(`{"status":"approved"}`)))
+	require.Nil(t, sut.Append("action2", []byte(`{"status":"rejected"}`)))
+	sut.sealPending()
+	proofs, err := sut.Proofs("action1")
+	require.Nil(t, err)
+	require.Len(t, proofs, 1)
+
+	// Act
+	proof := proofs[0]
+	proof.Leaf = `{"status":"rejected"}`
+	err = VerifyProof(proof)
+
+	// Assert
+	assert.NotNil(t, err)
+}
+
+func TestFileLog_Stop_seals_pending_leaves(t *testing.T) {
+	// Arrange
+	defer goleak.VerifyNone(t, goleak.IgnoreTopFunction("go.opencensus.io/stats/view.(*worker).start"))
+	sut := newTestLog(t, filepath.Join(t.TempDir(), "audit.jsonl"), 0, &mockRootSigner{})
+	sut.Start()
+	require.Nil(t, sut.Append("action", []byte("leaf")))
+
+	// Act
+	sut.Stop()
+
+	// Assert
+	assert.Len(t, sut.batches, 1)
+}
+
+func TestAuditedJournal_Record_appends_signed_decisions_only(t *testing.T) {
+	// Arrange
+	journalMock := &journal.MockJournal{}
+	logMock := &MockLog{}
+	sut := NewJournal(journalMock, logMock)
+
+	// Act
+	errFailed := sut.Record(journal.Entry{ActionID: "failed", Status: journal.StatusFailed})
+	failedAppended := logMock.AppendCalled
+	errApproved := sut.Record(journal.Entry{ActionID: "approved", Status: journal.StatusApproved})
+
+	// Assert
+	assert.Nil(t, errFailed)
+	assert.Nil(t, errApproved)
+	assert.False(t, failedAppended)
+	assert.True(t, journalMock.RecordCalled)
+	assert.True(t, logMock.AppendCalled)
+	assert.Equal(t, "approved", logMock.LastActionID)
+	assert.Contains(t, string(logMock.LastLeaf), `"actionID":"approved"`)
+}
+
+func TestAuditedJournal_Query_without_journal(t *testing.T) {
+	// Arrange
+	sut := NewJournal(nil, &MockLog{})
+
+	// Act
+	entries, err := sut.Query(journal.Filter{})
+
+	// Assert
+	assert.Nil(t, entries)
+	assert.NotNil(t, err)
+	assert.Equal(t, "Not Found", err.Error())
+}
@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/journal"
+)
+
+type auditedJournal struct {
+	journal  journal.Journal
+	auditLog Log
+}
+
+// NewJournal returns a journal.Journal appending the signed decisions to the auditLog.
+// The entries are recorded in the wrapped journal as well, when provided
+func NewJournal(wrapped journal.Journal, auditLog Log) journal.Journal {
+	return &auditedJournal{
+		journal:  wrapped,
+		auditLog: auditLog,
+	}
+}
+
+func (j *auditedJournal) Record(entry journal.Entry) error {
+	if j.journal != nil {
+		if err := j.journal.Record(entry); err != nil {
+			return err
+		}
+	}
+
+	// only the decisions signed and submitted to Qredo are part of the audit trail
+	if entry.Status == journal.StatusFailed {
+		return nil
+	}
+
+	leaf, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "marshal audit leaf")
+	}
+
+	return j.auditLog.Append(entry.ActionID, leaf)
+}
+
+func (j *auditedJournal) Query(filter journal.Filter) ([]journal.Entry, error) {
+	if j.journal == nil {
+		return nil, defs.ErrNotFound().WithDetail("the approval journal is not enabled")
+	}
+
+	return j.journal.Query(filter)
+}
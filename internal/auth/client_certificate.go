@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+// ClientCertificateIdentity returns the caller identity for the verified client certificate of the request, if any.
+// The certificate subject is looked up in identities, falling back to the subject common name
+func ClientCertificateIdentity(r *http.Request, identities map[string]string) string {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return defs.EmptyString
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	if identity, ok := identities[subject.String()]; ok {
+		return identity
+	}
+
+	return subject.CommonName
+}
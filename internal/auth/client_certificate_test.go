@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newRequestWithClientCert(subject pkix.Name) *http.Request {
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client", nil)
+	req.TLS = &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}},
+	}
+	return req
+}
+
+func TestClientCertificateIdentity_no_verified_certificate(t *testing.T) {
+	//Arrange
+	plain, _ := http.NewRequest(http.MethodGet, "/api/v2/client", nil)
+	unverified, _ := http.NewRequest(http.MethodGet, "/api/v2/client", nil)
+	unverified.TLS = &tls.ConnectionState{}
+
+	//Act & Assert
+	assert.Empty(t, ClientCertificateIdentity(plain, nil))
+	assert.Empty(t, ClientCertificateIdentity(unverified, nil))
+}
+
+func TestClientCertificateIdentity_maps_subject(t *testing.T) {
+	//Arrange
+	req := newRequestWithClientCert(pkix.Name{CommonName: "approver", Organization: []string{"Qredo"}})
+	identities := map[string]string{"CN=approver,O=Qredo": "approval-service"}
+
+	//Act
+	identity := ClientCertificateIdentity(req, identities)
+
+	//Assert
+	assert.Equal(t, "approval-service", identity)
+}
+
+func TestClientCertificateIdentity_defaults_to_common_name(t *testing.T) {
+	//Arrange
+	req := newRequestWithClientCert(pkix.Name{CommonName: "reporting", Organization: []string{"Qredo"}})
+
+	//Act
+	identity := ClientCertificateIdentity(req, map[string]string{"CN=approver,O=Qredo": "approval-service"})
+
+	//Assert
+	assert.Equal(t, "reporting", identity)
+}
@@ -70,6 +70,10 @@ func (p *apiTokenProvider) Initiate(workspaceID, apiKeySecret, apiKeyID string)
 	// set a ticker to half the token's validity time
 	ticker := time.NewTicker(p.tokenTTL / 2)
 
+	p.lock.RLock()
+	stop := p.stop
+	p.lock.RUnlock()
+
 	go func() {
 		defer func() {
 			ticker.Stop()
@@ -91,7 +95,7 @@ func (p *apiTokenProvider) Initiate(workspaceID, apiKeySecret, apiKeyID string)
 
 				ticker.Reset(p.tokenTTL / 2)
 
-			case <-p.stop:
+			case <-stop:
 				return
 			}
 		}
@@ -100,8 +104,13 @@ func (p *apiTokenProvider) Initiate(workspaceID, apiKeySecret, apiKeyID string)
 	return nil
 }
 
+// Stop stops refreshing the token. The provider can be initiated again, by ex: when the agent registers again
 func (p *apiTokenProvider) Stop() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
 	close(p.stop)
+	p.stop = make(chan bool)
 }
 
 func (p *apiTokenProvider) GetAuthHeader() http.Header {
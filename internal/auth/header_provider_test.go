@@ -89,6 +89,42 @@ func TestHeaderProvider_Initiate_setsToken(t *testing.T) {
 	sut.Stop()
 }
 
+func TestHeaderProvider_Initiate_again_after_Stop(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	htcMock := util.NewHTTPMockClient()
+
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte("{\"token\":\"testToken\"}"))),
+		}, nil
+	}
+
+	sut := apiTokenProvider{
+		baseURL: "baseURL",
+		htc:     htcMock,
+		getTokenDurationFunc: func(value string) time.Duration {
+			return 5 * time.Minute
+		},
+		log:  util.NewTestLogger(),
+		stop: make(chan bool),
+		lock: sync.RWMutex{},
+	}
+	_ = sut.Initiate("wkspID", "test secret", "test key id")
+	sut.Stop()
+
+	//Act
+	err := sut.Initiate("other wkspID", "other secret", "other key id")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "other key id", sut.apiKeyID)
+	sut.Stop()
+	sut.Stop()
+}
+
 func TestHeaderProvider_refreshToken(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t, ignoreOpenCensus)
@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+const (
+	bearerPrefix = "Bearer "
+
+	// headers of the HMAC signed requests, in the same style as the Qredo API ones
+	saKeyIDHeader     = "sa-api-key"
+	saSignatureHeader = "sa-api-signature"
+	saTimestampHeader = "sa-api-timestamp"
+
+	// maxBodySize is the size of the body read to check the signature of a request
+	maxBodySize = 1 << 20
+)
+
+// RequestAuthenticator authenticates the requests received by the local REST API
+type RequestAuthenticator interface {
+	// Authenticate returns the identity of the caller or an unauthorized APIError
+	Authenticate(r *http.Request) (string, error)
+}
+
+type apiKeyHash struct {
+	name string
+	hash []byte
+}
+
+type requestAuthenticator struct {
+	apiKeys  []apiKeyHash
+	hmacKeys map[string][]byte
+	maxSkew  time.Duration
+	nowFunc  func() time.Time
+}
+
+// NewRequestAuthenticator returns a RequestAuthenticator for the schemes configured in cfg.
+// Bearer API keys are stored as hex encoded SHA-256 hashes, HMAC secrets as base64 url encoded strings
+func NewRequestAuthenticator(cfg config.HTTPAuth) (RequestAuthenticator, error) {
+	a := &requestAuthenticator{
+		hmacKeys: make(map[string][]byte, len(cfg.HMACKeys)),
+		maxSkew:  time.Duration(cfg.HMACMaxSkew) * time.Second,
+		nowFunc:  time.Now,
+	}
+
+	for _, key := range cfg.APIKeys {
+		hash, err := hex.DecodeString(key.Hash)
+		if err != nil || len(hash) != sha256.Size {
+			return nil, fmt.Errorf("invalid hash for api key `%s`", key.Name)
+		}
+		a.apiKeys = append(a.apiKeys, apiKeyHash{name: key.Name, hash: hash})
+	}
+
+	for _, key := range cfg.HMACKeys {
+		secret, err := base64.RawURLEncoding.DecodeString(key.Secret)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid secret for hmac key `%s`", key.ID)
+		}
+		a.hmacKeys[key.ID] = secret
+	}
+
+	if len(a.apiKeys) == 0 && len(a.hmacKeys) == 0 {
+		return nil, fmt.Errorf("authentication enabled but no api or hmac keys configured")
+	}
+
+	return a, nil
+}
+
+// HashAPIKey returns the hex encoded hash of the API key, as expected in the config file
+func HashAPIKey(apiKey string) string {
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])
+}
+
+// Authenticate checks the bearer API key if present, otherwise the HMAC signature of the request
+func (a *requestAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if authorization := r.Header.Get("Authorization"); len(authorization) > 0 {
+		return a.authenticateAPIKey(authorization)
+	}
+
+	if len(r.Header.Get(saSignatureHeader)) > 0 {
+		return a.authenticateHMAC(r)
+	}
+
+	return defs.EmptyString, defs.ErrUnauthorized().WithDetail("missing credentials")
+}
+
+func (a *requestAuthenticator) authenticateAPIKey(authorization string) (string, error) {
+	if !strings.HasPrefix(authorization, bearerPrefix) || len(a.apiKeys) == 0 {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("unsupported authorization scheme")
+	}
+
+	hash := sha256.Sum256([]byte(strings.TrimPrefix(authorization, bearerPrefix)))
+	for _, key := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], key.hash) == 1 {
+			return key.name, nil
+		}
+	}
+
+	return defs.EmptyString, defs.ErrUnauthorized().WithDetail("invalid api key")
+}
+
+func (a *requestAuthenticator) authenticateHMAC(r *http.Request) (string, error) {
+	keyID := r.Header.Get(saKeyIDHeader)
+	secret, ok := a.hmacKeys[keyID]
+	if !ok {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("invalid hmac key")
+	}
+
+	timestamp := r.Header.Get(saTimestampHeader)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("invalid timestamp")
+	}
+
+	if skew := a.nowFunc().Sub(time.Unix(seconds, 0)); skew > a.maxSkew || skew < -a.maxSkew {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("timestamp out of the accepted window")
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(r.Header.Get(saSignatureHeader))
+	if err != nil {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("invalid signature")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return defs.EmptyString, defs.ErrBadRequest().WithDetail(fmt.Sprintf("the request body is larger than %d bytes", maxBodySize))
+		}
+		return defs.EmptyString, defs.ErrBadRequest().WithDetail("failed to read the request body")
+	}
+
+	expected, err := defs.HmacSum(timestamp, r.Method, r.URL.RequestURI(), secret, body)
+	if err != nil {
+		return defs.EmptyString, defs.ErrInternal().Wrap(err)
+	}
+
+	if !hmac.Equal(expected, signature) {
+		return defs.EmptyString, defs.ErrUnauthorized().WithDetail("invalid signature")
+	}
+
+	return keyID, nil
+}
+
+// readBody reads the request body, up to maxBodySize, and replaces it, so it can be decoded by the handlers
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return []byte{}, nil
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, nil
+}
@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+var testHMACSecret = []byte("some hmac secret")
+
+func newTestAuthenticator(t *testing.T) *requestAuthenticator {
+	sut, err := NewRequestAuthenticator(config.HTTPAuth{
+		Enabled:     true,
+		APIKeys:     []config.APIKey{{Name: "approval-service", Hash: HashAPIKey("some api key")}},
+		HMACKeys:    []config.HMACKey{{ID: "reporting-service", Secret: base64.RawURLEncoding.EncodeToString(testHMACSecret)}},
+		HMACMaxSkew: 30,
+	})
+	require.Nil(t, err)
+
+	return sut.(*requestAuthenticator)
+}
+
+func newSignedRequest(t *testing.T, keyID string, timestamp time.Time, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPut, "http://localhost:8007/api/v2/client/action/some_id?x=1", bytes.NewBufferString(body))
+	ts := fmt.Sprintf("%d", timestamp.Unix())
+	sig, err := defs.HmacSum(ts, http.MethodPut, "/api/v2/client/action/some_id?x=1", testHMACSecret, []byte(body))
+	require.Nil(t, err)
+
+	req.Header.Set(saKeyIDHeader, keyID)
+	req.Header.Set(saTimestampHeader, ts)
+	req.Header.Set(saSignatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+	return req
+}
+
+func assertUnauthorized(t *testing.T, err error, detail string) {
+	require.NotNil(t, err)
+	apiErr, ok := err.(*defs.APIError)
+	require.True(t, ok)
+	code, errDetail := apiErr.APIError()
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, detail, errDetail)
+}
+
+func TestRequestAuthenticator_NewRequestAuthenticator_invalid_config(t *testing.T) {
+	tests := map[string]struct {
+		cfg config.HTTPAuth
+		err string
+	}{
+		"no keys": {
+			cfg: config.HTTPAuth{Enabled: true},
+			err: "authentication enabled but no api or hmac keys configured",
+		},
+		"invalid api key hash": {
+			cfg: config.HTTPAuth{APIKeys: []config.APIKey{{Name: "key1", Hash: "not hex"}}},
+			err: "invalid hash for api key `key1`",
+		},
+		"invalid hmac secret": {
+			cfg: config.HTTPAuth{HMACKeys: []config.HMACKey{{ID: "key1", Secret: "$$$"}}},
+			err: "invalid secret for hmac key `key1`",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//Act
+			sut, err := NewRequestAuthenticator(tc.cfg)
+
+			//Assert
+			assert.Nil(t, sut)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+}
+
+func TestRequestAuthenticator_Authenticate_missing_credentials(t *testing.T) {
+	//Arrange
+	sut := newTestAuthenticator(t)
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client", nil)
+
+	//Act
+	identity, err := sut.Authenticate(req)
+
+	//Assert
+	assert.Empty(t, identity)
+	assertUnauthorized(t, err, "missing credentials")
+}
+
+func TestRequestAuthenticator_Authenticate_api_key(t *testing.T) {
+	tests := map[string]struct {
+		authorization string
+		identity      string
+		detail        string
+	}{
+		"valid key":      {authorization: "Bearer some api key", identity: "approval-service"},
+		"invalid key":    {authorization: "Bearer some other key", detail: "invalid api key"},
+		"invalid scheme": {authorization: "Basic some api key", detail: "unsupported authorization scheme"},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//Arrange
+			sut := newTestAuthenticator(t)
+			req, _ := http.NewRequest(http.MethodGet, "/api/v2/client", nil)
+			req.Header.Set("Authorization", tc.authorization)
+
+			//Act
+			identity, err := sut.Authenticate(req)
+
+			//Assert
+			assert.Equal(t, tc.identity, identity)
+			if tc.detail == defs.EmptyString {
+				assert.Nil(t, err)
+			} else {
+				assertUnauthorized(t, err, tc.detail)
+			}
+		})
+	}
+}
+
+func TestRequestAuthenticator_Authenticate_hmac_signature(t *testing.T) {
+	//Arrange
+	sut := newTestAuthenticator(t)
+	req := newSignedRequest(t, "reporting-service", time.Now(), `{"some":"body"}`)
+
+	//Act
+	identity, err := sut.Authenticate(req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "reporting-service", identity)
+
+	body, _ := io.ReadAll(req.Body)
+	assert.Equal(t, `{"some":"body"}`, string(body))
+}
+
+func TestRequestAuthenticator_Authenticate_hmac_body_too_large(t *testing.T) {
+	//Arrange
+	sut := newTestAuthenticator(t)
+	req := newSignedRequest(t, "reporting-service", time.Now(), strings.Repeat("a", maxBodySize+1))
+
+	//Act
+	identity, err := sut.Authenticate(req)
+
+	//Assert
+	assert.Empty(t, identity)
+	require.NotNil(t, err)
+	apiErr, ok := err.(*defs.APIError)
+	require.True(t, ok)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "the request body is larger than 1048576 bytes", detail)
+}
+
+func TestRequestAuthenticator_Authenticate_hmac_failures(t *testing.T) {
+	//Arrange
+	sut := newTestAuthenticator(t)
+
+	unknownKey := newSignedRequest(t, "unknown-service", time.Now(), "")
+	expired := newSignedRequest(t, "reporting-service", time.Now().Add(-time.Minute), "")
+	tampered := newSignedRequest(t, "reporting-service", time.Now(), "some body")
+	tampered.Body = io.NopCloser(bytes.NewBufferString("some other body"))
+
+	tests := map[*http.Request]string{
+		unknownKey: "invalid hmac key",
+		expired:    "timestamp out of the accepted window",
+		tampered:   "invalid signature",
+	}
+
+	for req, detail := range tests {
+		//Act
+		identity, err := sut.Authenticate(req)
+
+		//Assert
+		assert.Empty(t, identity)
+		assertUnauthorized(t, err, detail)
+	}
+}
@@ -1,5 +1,6 @@
 // Package autoapprover provides a mechanism to receive action information as bytes.
 // The action data is analyzed and if it meets the requirements, the action is approved.
+// When an approval policy is configured, the action is approved, rejected or left for manual approval as the policy decides.
 // It supports approval retrying based on defined intervals
 
 package autoapprover
@@ -7,6 +8,7 @@ package autoapprover
 import (
 	"encoding/json"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
@@ -14,29 +16,48 @@ import (
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/journal"
+	"github.com/qredo/signing-agent/internal/metrics"
 )
 
+// journalCaller is the caller recorded in the journal for the automatic decisions
+const journalCaller = "auto-approver"
+
 type AutoApprover interface {
 	Listen(wg *sync.WaitGroup)
 	GetFeedClient() *hub.HubFeedClient
 	Stop()
+	// SetRetryConfig changes the retry intervals of the next approvals
+	SetRetryConfig(cfg config.AutoApprove)
 }
 
 type autoActionApprover struct {
 	hub.HubFeedClient
+	feedLock        sync.Mutex
+	listened        bool
 	log             *zap.SugaredLogger
 	cfgAutoApproval config.AutoApprove
+	cfgLock         sync.RWMutex
 
 	syncronizer          action.ActionSync
 	lastError            error
 	loadBalancingEnabled bool
 	signer               action.Signer
+	policy               *Policy
+	messageCache         message.CacheRemover
+	journal              journal.Journal
+	events               hub.EventPublisher
 }
 
 // NewAutoApprover returns a new *AutoApprover instance initialized with the provided parameters
 // The AutoApprover has an internal FeedClient which means it will be stopped when the service stops
 // or the Feed channel is closed on the sender side
-func NewAutoApprover(log *zap.SugaredLogger, config config.Config, syncronizer action.ActionSync, signer action.Signer) AutoApprover {
+// If the policy is nil, every pending action is approved. Otherwise the policy decides and the actions
+// handled automatically are removed from the messageCache, when provided
+// The automatic decisions are recorded in the actionJournal and published to the v2 feed clients through events, if provided
+func NewAutoApprover(log *zap.SugaredLogger, config config.Config, syncronizer action.ActionSync, signer action.Signer,
+	policy *Policy, messageCache message.CacheRemover, actionJournal journal.Journal, events hub.EventPublisher) AutoApprover {
 	return &autoActionApprover{
 		HubFeedClient:        hub.NewHubFeedClient(true),
 		log:                  log,
@@ -44,17 +65,23 @@ func NewAutoApprover(log *zap.SugaredLogger, config config.Config, syncronizer a
 		syncronizer:          syncronizer,
 		loadBalancingEnabled: config.LoadBalancing.Enable,
 		signer:               signer,
+		policy:               policy,
+		messageCache:         messageCache,
+		journal:              actionJournal,
+		events:               events,
 	}
 }
 
 // Listen is constantly listening for messages on the Feed channel.
-// The Feed channel is always closed by the sender. When this happens, the AutoApprover stops
+// The Feed channel is always closed by the sender. When this happens, the AutoApprover stops.
+// Listening again after a stop is done on a new Feed channel, that must be registered to the hub again
 func (a *autoActionApprover) Listen(wg *sync.WaitGroup) {
+	feed := a.renewFeed()
 	a.log.Debug("AutoApprover: listening")
 	wg.Done()
 
 	for {
-		if message, ok := <-a.Feed; !ok {
+		if message, ok := <-feed; !ok {
 			//channel was closed by the sender
 			a.log.Info("AutoApprover: stopped")
 			return
@@ -64,11 +91,34 @@ func (a *autoActionApprover) Listen(wg *sync.WaitGroup) {
 	}
 }
 
+// renewFeed returns the channel to listen on, a new one if the AutoApprover listened before,
+// as the previous channel was closed when it stopped
+func (a *autoActionApprover) renewFeed() chan []byte {
+	a.feedLock.Lock()
+	defer a.feedLock.Unlock()
+
+	if a.listened {
+		a.HubFeedClient = hub.NewHubFeedClient(true)
+	}
+	a.listened = true
+
+	return a.Feed
+}
+
 func (a *autoActionApprover) Stop() {
 	a.log.Debug("AutoApprover: stopping")
 	close(a.Feed)
 }
 
+func (a *autoActionApprover) SetRetryConfig(cfg config.AutoApprove) {
+	a.cfgLock.Lock()
+	defer a.cfgLock.Unlock()
+
+	a.cfgAutoApproval.RetryInterval = cfg.RetryInterval
+	a.cfgAutoApproval.RetryIntervalMax = cfg.RetryIntervalMax
+	a.log.Infof("AutoApprover: retry interval set to %ds, max %ds", cfg.RetryInterval, cfg.RetryIntervalMax)
+}
+
 func (a *autoActionApprover) GetFeedClient() *hub.HubFeedClient {
 	return &a.HubFeedClient
 }
@@ -77,12 +127,15 @@ func (a *autoActionApprover) handleMessage(message []byte) {
 	action := defs.ActionInfo{}
 	if err := json.Unmarshal(message, &action); err == nil {
 		if !action.IsExpired() {
-			if action.Status == defs.StatusPending {
-				if a.shouldHandleAction(action.ID) {
-					a.handleAction(action)
-				}
-			} else {
+			if action.Status != defs.StatusPending {
 				a.log.Infof("AutoApprover: action `%s` status not pending", action.ID)
+			} else if len(action.Messages) == 0 {
+				a.log.Errorf("AutoApprover: action `%s` has no messages to sign", action.ID)
+			} else {
+				decision := a.decide(action)
+				if decision != DecisionManual && a.shouldHandleAction(action.ID) {
+					a.handleAction(action, decision)
+				}
 			}
 		} else {
 			a.log.Infof("AutoApprover: action `%s` has expired", action.ID)
@@ -93,6 +146,22 @@ func (a *autoActionApprover) handleMessage(message []byte) {
 	}
 }
 
+// decide evaluates the action against the policy, if any, and logs the decision
+func (a *autoActionApprover) decide(action defs.ActionInfo) Decision {
+	if a.policy == nil {
+		return DecisionApprove
+	}
+
+	decision, rule := a.policy.Evaluate(action)
+	a.log.Infow("AutoApprover: policy decision",
+		"actionID", action.ID,
+		"type", action.Type,
+		"decision", decision,
+		"rule", rule)
+
+	return decision
+}
+
 func (a *autoActionApprover) shouldHandleAction(actionId string) bool {
 	if a.loadBalancingEnabled {
 		//check if the action was already picked up by another signing agent
@@ -105,7 +174,7 @@ func (a *autoActionApprover) shouldHandleAction(actionId string) bool {
 	return true
 }
 
-func (a *autoActionApprover) handleAction(action defs.ActionInfo) {
+func (a *autoActionApprover) handleAction(action defs.ActionInfo, decision Decision) {
 	if a.loadBalancingEnabled {
 		if err := a.syncronizer.AcquireLock(); err != nil {
 			a.log.Debugf("AutoApprover, mutex lock err: %v, action `%s`", err, action.ID)
@@ -118,25 +187,68 @@ func (a *autoActionApprover) handleAction(action defs.ActionInfo) {
 		}()
 	}
 
-	a.approveAction(action.ID, action.Messages[0])
+	if decision == DecisionReject {
+		a.rejectAction(action.ID, action.Messages)
+	} else {
+		a.approveAction(action.ID, action.Messages)
+	}
+}
+
+func (a *autoActionApprover) approveAction(actionId string, messages [][]byte) {
+	a.signWithRetry(actionId, messages, journal.DecisionApprove, "approval", "approved", func() error {
+		return a.signer.ApproveActionMessages(actionId, messages)
+	})
+}
+
+func (a *autoActionApprover) rejectAction(actionId string, messages [][]byte) {
+	a.signWithRetry(actionId, messages, journal.DecisionReject, "rejection", "rejected", func() error {
+		return a.signer.RejectActionMessages(actionId, messages)
+	})
 }
 
-func (a *autoActionApprover) approveAction(actionId string, message []byte) {
+// signWithRetry calls sign until it succeeds or the retry timer times out, then records and publishes the outcome
+func (a *autoActionApprover) signWithRetry(actionId string, messages [][]byte, decision, operation, outcome string, sign func() error) {
+	startedAt := time.Now()
+	a.cfgLock.RLock()
 	timer := newRetryTimer(a.cfgAutoApproval.RetryInterval, a.cfgAutoApproval.RetryIntervalMax)
-	for {
-		if err := a.signer.ApproveActionMessage(actionId, message); err == nil {
-			a.log.Infof("AutoApprover: action `%s` approved automatically", actionId)
+	a.cfgLock.RUnlock()
+
+	for retries := 0; ; retries++ {
+		if err := sign(); err == nil {
+			a.log.Infof("AutoApprover: action `%s` %s automatically", actionId, outcome)
+			a.record(journal.NewEntry(actionId, decision, journal.ModeAutomatic, journalCaller, messages, startedAt, retries, nil))
+			a.removeFromCache(actionId)
 			return
 		} else {
-			a.log.Errorf("AutoApprover: approval failed for action `%s`, err: %v", actionId, err)
-
+			a.log.Errorf("AutoApprover: %s failed for action `%s`, err: %v", operation, actionId, err)
 			if timer.isTimeOut() {
-				a.log.Warnf("AutoApprover: auto action approve timed out for action `%s`", actionId)
+				a.log.Warnf("AutoApprover: auto action %s timed out for action `%s`", operation, actionId)
+				a.record(journal.NewEntry(actionId, decision, journal.ModeAutomatic, journalCaller, messages, startedAt, retries, err))
 				return
 			}
-
-			a.log.Warnf("AutoApprover: auto approve action is repeated for action `%s` ", actionId)
+			a.log.Warnf("AutoApprover: auto action %s is repeated for action `%s` ", operation, actionId)
+			metrics.AutoApprovalRetries.WithLabelValues(operation).Inc()
 			timer.retry()
 		}
 	}
 }
+
+func (a *autoActionApprover) record(entry journal.Entry) {
+	if a.events != nil {
+		a.events.PublishEvent(hub.EventAutoApproval, entry)
+	}
+
+	if a.journal == nil {
+		return
+	}
+
+	if err := a.journal.Record(entry); err != nil {
+		a.log.Errorf("AutoApprover: failed to record the decision for action `%s` in the journal, err: %v", entry.ActionID, err)
+	}
+}
+
+func (a *autoActionApprover) removeFromCache(actionId string) {
+	if a.messageCache != nil {
+		a.messageCache.RemoveMessage(actionId)
+	}
+}
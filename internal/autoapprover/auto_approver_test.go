@@ -11,6 +11,8 @@ import (
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/journal"
 	"github.com/qredo/signing-agent/internal/util"
 	"github.com/test-go/testify/assert"
 	"go.uber.org/goleak"
@@ -40,7 +42,35 @@ func TestAutoApprover_Listen_fails_to_unmarshal(t *testing.T) {
 	//Assert
 	assert.NotNil(t, sut.lastError)
 	assert.Equal(t, "unexpected end of JSON input", sut.lastError.Error())
-	assert.False(t, signerMock.ApproveActionMessageCalled)
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
+}
+
+func TestAutoApprover_Listen_after_Stop_listens_on_new_feed(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	sut := &autoActionApprover{
+		signer:        &action.MockSigner{},
+		HubFeedClient: hub.NewHubFeedClient(true),
+		log:           util.NewTestLogger(),
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go sut.Listen(&wg)
+	wg.Wait()
+	previousFeed := sut.GetFeedClient().Feed
+	sut.Stop()
+
+	//Act
+	wg.Add(1)
+	go sut.Listen(&wg)
+	wg.Wait()
+	sut.Feed <- []byte("")
+	<-time.After(time.Second) //give it time to finish
+
+	//Assert
+	assert.NotEqual(t, previousFeed, sut.GetFeedClient().Feed)
+	assert.NotNil(t, sut.lastError)
+	sut.Stop()
 }
 
 func TestAutoApprover_handleMessage_action_expired(t *testing.T) {
@@ -60,7 +90,7 @@ func TestAutoApprover_handleMessage_action_expired(t *testing.T) {
 
 	//Assert
 	assert.Nil(t, sut.lastError)
-	assert.False(t, signerMock.ApproveActionMessageCalled)
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
 }
 
 func TestAutoApprover_handleMessage_action_notInPending(t *testing.T) {
@@ -82,7 +112,7 @@ func TestAutoApprover_handleMessage_action_notInPending(t *testing.T) {
 
 	//Assert
 	assert.Nil(t, sut.lastError)
-	assert.False(t, signerMock.ApproveActionMessageCalled)
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
 }
 
 func TestAutoApprover_handleMessage_shouldnt_handle_action(t *testing.T) {
@@ -98,6 +128,7 @@ func TestAutoApprover_handleMessage_shouldnt_handle_action(t *testing.T) {
 		ID:         "actionid",
 		ExpireTime: time.Now().Add(time.Minute).Unix(),
 		Status:     defs.StatusPending,
+		Messages:   [][]byte{[]byte("some message")},
 	})
 
 	//Act
@@ -106,7 +137,7 @@ func TestAutoApprover_handleMessage_shouldnt_handle_action(t *testing.T) {
 	//Assert
 	assert.True(t, syncronizerMock.ShouldHandleActionCalled)
 	assert.Equal(t, "actionid", syncronizerMock.LastActionId)
-	assert.False(t, signerMock.ApproveActionMessageCalled)
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
 }
 
 func TestAutoApprover_handleMessage_fails_to_lock(t *testing.T) {
@@ -126,6 +157,7 @@ func TestAutoApprover_handleMessage_fails_to_lock(t *testing.T) {
 		ID:         "actionid",
 		ExpireTime: time.Now().Add(time.Minute).Unix(),
 		Status:     defs.StatusPending,
+		Messages:   [][]byte{[]byte("some message")},
 	})
 
 	//Act
@@ -158,14 +190,14 @@ func TestAutoApprover_handleAction_acquires_lock_and_approves(t *testing.T) {
 	}
 
 	//Act
-	sut.handleAction(action)
+	sut.handleAction(action, DecisionApprove)
 
 	//Assert
 	assert.True(t, syncronizerMock.AcquireLockCalled)
 	assert.True(t, syncronizerMock.ReleaseCalled)
-	assert.True(t, signerMock.ApproveActionMessageCalled)
+	assert.True(t, signerMock.ApproveActionMessagesCalled)
 	assert.Equal(t, "actionid", signerMock.LastActionId)
-	assert.Equal(t, []byte("some message"), signerMock.LastMessage)
+	assert.Equal(t, [][]byte{[]byte("some message")}, signerMock.LastMessages)
 }
 
 func TestAutoApprover_approveAction_retries_to_approve(t *testing.T) {
@@ -183,10 +215,176 @@ func TestAutoApprover_approveAction_retries_to_approve(t *testing.T) {
 	}
 
 	//Act
-	sut.approveAction("some action id", []byte("some message"))
+	sut.approveAction("some action id", [][]byte{[]byte("some message")})
 
 	//Assert
-	assert.True(t, signerMock.ApproveActionMessageCalled)
+	assert.True(t, signerMock.ApproveActionMessagesCalled)
 	assert.Equal(t, "some action id", signerMock.LastActionId)
 	assert.True(t, signerMock.Counter > 1)
 }
+
+func TestAutoApprover_SetRetryConfig_changes_the_retry_intervals(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some error"),
+	}
+	sut := &autoActionApprover{
+		signer: signerMock,
+		cfgAutoApproval: config.AutoApprove{
+			Enabled:          true,
+			RetryIntervalMax: 300,
+			RetryInterval:    5,
+		},
+		log: util.NewTestLogger(),
+	}
+
+	//Act
+	sut.SetRetryConfig(config.AutoApprove{
+		RetryIntervalMax: 2,
+		RetryInterval:    1,
+	})
+	sut.approveAction("some action id", [][]byte{[]byte("some message")})
+
+	//Assert
+	assert.True(t, sut.cfgAutoApproval.Enabled)
+	assert.Equal(t, 3, signerMock.Counter)
+}
+
+func TestAutoApprover_approveAction_records_retries_in_journal(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some error"),
+	}
+	journalMock := &journal.MockJournal{}
+	sut := &autoActionApprover{
+		signer: signerMock,
+		cfgAutoApproval: config.AutoApprove{
+			RetryIntervalMax: 3,
+			RetryInterval:    1,
+		},
+		log:     util.NewTestLogger(),
+		journal: journalMock,
+	}
+
+	//Act
+	sut.approveAction("some action id", [][]byte{[]byte("some message")})
+
+	//Assert
+	assert.True(t, journalMock.RecordCalled)
+	assert.Equal(t, "some action id", journalMock.LastEntry.ActionID)
+	assert.Equal(t, journal.ModeAutomatic, journalMock.LastEntry.Mode)
+	assert.Equal(t, journal.StatusFailed, journalMock.LastEntry.Status)
+	assert.Equal(t, "some error", journalMock.LastEntry.Error)
+	assert.Equal(t, signerMock.Counter-1, journalMock.LastEntry.Retries)
+	assert.Equal(t, []string{journal.HashMessage([]byte("some message"))}, journalMock.LastEntry.MessageHashes)
+}
+
+func TestAutoApprover_approveAction_publishes_the_result(t *testing.T) {
+	//Arrange
+	eventsMock := &hub.MockEventPublisher{}
+	sut := &autoActionApprover{
+		signer: &action.MockSigner{},
+		cfgAutoApproval: config.AutoApprove{
+			RetryIntervalMax: 3,
+			RetryInterval:    1,
+		},
+		log:    util.NewTestLogger(),
+		events: eventsMock,
+	}
+
+	//Act
+	sut.approveAction("some action id", [][]byte{[]byte("some message")})
+
+	//Assert
+	assert.True(t, eventsMock.PublishEventCalled)
+	assert.Equal(t, hub.EventAutoApproval, eventsMock.LastEventType)
+	entry := eventsMock.LastPayload.(journal.Entry)
+	assert.Equal(t, "some action id", entry.ActionID)
+	assert.Equal(t, journal.DecisionApprove, entry.Decision)
+	assert.Equal(t, journal.StatusApproved, entry.Status)
+}
+
+func TestAutoApprover_handleMessage_policy_leaves_action_for_manual_approval(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	signerMock := &action.MockSigner{}
+	cacheMock := &message.MockCache{}
+	sut := &autoActionApprover{
+		log:          util.NewTestLogger(),
+		signer:       signerMock,
+		policy:       &Policy{},
+		messageCache: cacheMock,
+	}
+
+	bytes, _ := json.Marshal(defs.ActionInfo{
+		ID:         "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Status:     defs.StatusPending,
+		Messages:   [][]byte{[]byte("some message")},
+	})
+
+	//Act
+	sut.handleMessage(bytes)
+
+	//Assert
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
+	assert.False(t, signerMock.RejectActionMessagesCalled)
+	assert.False(t, cacheMock.RemoveMessageCalled)
+}
+
+func TestAutoApprover_handleMessage_policy_rejects_action(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	signerMock := &action.MockSigner{}
+	cacheMock := &message.MockCache{}
+	sut := &autoActionApprover{
+		log:    util.NewTestLogger(),
+		signer: signerMock,
+		policy: &Policy{
+			Rules: []Rule{{Name: "reject-eth", Decision: DecisionReject, Assets: []string{"ETH"}}},
+		},
+		messageCache: cacheMock,
+	}
+
+	bytes, _ := json.Marshal(defs.ActionInfo{
+		ID:         "actionid",
+		Asset:      "ETH",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Status:     defs.StatusPending,
+		Messages:   [][]byte{[]byte("some message")},
+	})
+
+	//Act
+	sut.handleMessage(bytes)
+
+	//Assert
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
+	assert.True(t, signerMock.RejectActionMessagesCalled)
+	assert.Equal(t, "actionid", signerMock.LastActionId)
+	assert.Equal(t, [][]byte{[]byte("some message")}, signerMock.LastMessages)
+	assert.True(t, cacheMock.RemoveMessageCalled)
+	assert.Equal(t, "actionid", cacheMock.LastID)
+}
+
+func TestAutoApprover_handleMessage_action_without_messages(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	signerMock := &action.MockSigner{}
+	sut := &autoActionApprover{
+		log:    util.NewTestLogger(),
+		signer: signerMock,
+	}
+
+	bytes, _ := json.Marshal(defs.ActionInfo{
+		ID:         "actionid",
+		ExpireTime: time.Now().Add(time.Minute).Unix(),
+		Status:     defs.StatusPending,
+	})
+
+	//Act
+	sut.handleMessage(bytes)
+
+	//Assert
+	assert.False(t, signerMock.ApproveActionMessagesCalled)
+	assert.False(t, signerMock.RejectActionMessagesCalled)
+}
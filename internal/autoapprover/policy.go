@@ -0,0 +1,131 @@
+package autoapprover
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+// Decision is the outcome of evaluating an action against the approval policy
+type Decision string
+
+const (
+	DecisionApprove Decision = "approve"
+	DecisionReject  Decision = "reject"
+	DecisionManual  Decision = "manual"
+)
+
+// noRuleMatched is reported as the rule name when an action didn't match any rule
+const noRuleMatched = "<none>"
+
+// Rule matches actions on their fields. An empty list or an unset amount bound matches any value.
+// All the set conditions must hold for the rule to match
+type Rule struct {
+	Name         string   `yaml:"name"`
+	Decision     Decision `yaml:"decision"`
+	Types        []string `yaml:"types"`
+	Assets       []string `yaml:"assets"`
+	Workspaces   []string `yaml:"workspaces"`
+	Destinations []string `yaml:"destinations"`
+	MinAmount    *int64   `yaml:"minAmount"`
+	MaxAmount    *int64   `yaml:"maxAmount"`
+}
+
+// Policy is an ordered list of rules, the first rule matching the action decides its outcome.
+// Actions not matched by any rule are left for manual approval
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// LoadPolicy reads and validates the policy file
+func LoadPolicy(fileName string) (*Policy, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, errors.Wrap(err, "read policy file")
+	}
+
+	policy := &Policy{}
+	if err := yaml.UnmarshalStrict(data, policy); err != nil {
+		return nil, errors.Wrap(err, "parse policy file")
+	}
+
+	if err := policy.validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid policy")
+	}
+
+	return policy, nil
+}
+
+// Evaluate returns the decision for the action and the name of the rule that took it
+func (p *Policy) Evaluate(action defs.ActionInfo) (Decision, string) {
+	for _, rule := range p.Rules {
+		if rule.matches(action) {
+			return rule.Decision, rule.Name
+		}
+	}
+
+	return DecisionManual, noRuleMatched
+}
+
+func (p *Policy) validate() error {
+	names := make(map[string]bool, len(p.Rules))
+	for i, rule := range p.Rules {
+		if rule.Name == defs.EmptyString {
+			return fmt.Errorf("rule %d has no name", i)
+		}
+
+		if names[rule.Name] {
+			return fmt.Errorf("duplicate rule name `%s`", rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Decision {
+		case DecisionApprove, DecisionReject, DecisionManual:
+		default:
+			return fmt.Errorf("rule `%s` has invalid decision `%s`", rule.Name, rule.Decision)
+		}
+
+		if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount > *rule.MaxAmount {
+			return fmt.Errorf("rule `%s` has minAmount greater than maxAmount", rule.Name)
+		}
+	}
+
+	return nil
+}
+
+func (r Rule) matches(action defs.ActionInfo) bool {
+	if !matchesAny(r.Types, action.Type) ||
+		!matchesAny(r.Assets, action.Asset) ||
+		!matchesAny(r.Workspaces, action.WorkspaceID) ||
+		!matchesAny(r.Destinations, action.Destination) {
+		return false
+	}
+
+	if r.MinAmount != nil && action.Amount < *r.MinAmount {
+		return false
+	}
+
+	if r.MaxAmount != nil && action.Amount > *r.MaxAmount {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(values []string, value string) bool {
+	if len(values) == 0 {
+		return true
+	}
+
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,126 @@
+package autoapprover
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+func writePolicyFile(t *testing.T, content string) string {
+	fileName := filepath.Join(t.TempDir(), "policy.yaml")
+	require.Nil(t, os.WriteFile(fileName, []byte(content), 0600))
+	return fileName
+}
+
+func TestPolicy_LoadPolicy_file_not_found(t *testing.T) {
+	//Act
+	policy, err := LoadPolicy(filepath.Join(t.TempDir(), "missing.yaml"))
+
+	//Assert
+	assert.Nil(t, policy)
+	assert.ErrorContains(t, err, "read policy file")
+}
+
+func TestPolicy_LoadPolicy_invalid_policies(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		err     string
+	}{
+		"unknown field": {
+			content: "rules:\n  - name: r1\n    decision: approve\n    currency: [BTC]\n",
+			err:     "parse policy file",
+		},
+		"missing name": {
+			content: "rules:\n  - decision: approve\n",
+			err:     "rule 0 has no name",
+		},
+		"duplicate name": {
+			content: "rules:\n  - name: r1\n    decision: approve\n  - name: r1\n    decision: reject\n",
+			err:     "duplicate rule name `r1`",
+		},
+		"invalid decision": {
+			content: "rules:\n  - name: r1\n    decision: sign\n",
+			err:     "rule `r1` has invalid decision `sign`",
+		},
+		"invalid amounts": {
+			content: "rules:\n  - name: r1\n    decision: approve\n    minAmount: 10\n    maxAmount: 5\n",
+			err:     "rule `r1` has minAmount greater than maxAmount",
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//Act
+			policy, err := LoadPolicy(writePolicyFile(t, tc.content))
+
+			//Assert
+			assert.Nil(t, policy)
+			assert.ErrorContains(t, err, tc.err)
+		})
+	}
+}
+
+func TestPolicy_Evaluate(t *testing.T) {
+	//Arrange
+	policy, err := LoadPolicy(writePolicyFile(t, `
+rules:
+  - name: reject-unknown-destination
+    decision: reject
+    types: [ApproveTransaction]
+    destinations: [blocked-address]
+  - name: approve-small-btc
+    decision: approve
+    types: [ApproveTransaction]
+    assets: [BTC]
+    workspaces: [workspace1]
+    maxAmount: 1000
+  - name: large-btc-manual
+    decision: manual
+    assets: [BTC]
+    minAmount: 1001
+`))
+	require.Nil(t, err)
+
+	tests := map[string]struct {
+		action   defs.ActionInfo
+		decision Decision
+		rule     string
+	}{
+		"first matching rule wins": {
+			action:   defs.ActionInfo{Type: "ApproveTransaction", Asset: "BTC", WorkspaceID: "workspace1", Destination: "blocked-address", Amount: 5},
+			decision: DecisionReject,
+			rule:     "reject-unknown-destination",
+		},
+		"all conditions match": {
+			action:   defs.ActionInfo{Type: "ApproveTransaction", Asset: "BTC", WorkspaceID: "workspace1", Destination: "some address", Amount: 1000},
+			decision: DecisionApprove,
+			rule:     "approve-small-btc",
+		},
+		"amount over the limit": {
+			action:   defs.ActionInfo{Type: "ApproveTransaction", Asset: "BTC", WorkspaceID: "workspace1", Amount: 5000},
+			decision: DecisionManual,
+			rule:     "large-btc-manual",
+		},
+		"no rule matches": {
+			action:   defs.ActionInfo{Type: "ApproveTransaction", Asset: "ETH", WorkspaceID: "workspace1", Amount: 5},
+			decision: DecisionManual,
+			rule:     noRuleMatched,
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			//Act
+			decision, rule := policy.Evaluate(tc.action)
+
+			//Assert
+			assert.Equal(t, tc.decision, decision)
+			assert.Equal(t, tc.rule, rule)
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/crypto"
+	"github.com/qredo/signing-agent/internal/store"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+const (
+	// MethodPassphrase encrypts the bundle with AES-256-GCM, the key is derived from a passphrase with argon2id
+	MethodPassphrase = "passphrase"
+	// MethodECIES encrypts the bundle to the operator's secp256k1 public key
+	MethodECIES = "ecies"
+
+	// bundleVersion is the version of the bundle format
+	bundleVersion = 1
+)
+
+// ErrWrongKey is returned when the bundle can't be decrypted with the given passphrase or private key
+var ErrWrongKey = errors.New("wrong passphrase or private key for the backup bundle, or the bundle is corrupted")
+
+// Bundle is the encrypted backup of the registered agent. Only the agent ID is readable without the key
+type Bundle struct {
+	Version    int              `json:"version"`
+	Method     string           `json:"method"`
+	AgentID    string           `json:"agentID"`
+	CreatedAt  int64            `json:"createdAt"`
+	KDF        *util.KDFParams  `json:"kdf,omitempty"`
+	Ciphertext []byte           `json:"ciphertext,omitempty"`
+	ECIES      *ECIESCiphertext `json:"ecies,omitempty"`
+}
+
+// ECIESCiphertext is the hex encoded output of crypto.Secp256k1Encrypt
+type ECIESCiphertext struct {
+	C string `json:"c"`
+	V string `json:"v"`
+	T string `json:"t"`
+}
+
+// Key is the passphrase, or the secp256k1 key, the bundle is encrypted with.
+// The keys are hex or base64 encoded, the private key is needed only to open the bundle
+type Key struct {
+	Passphrase string `json:"passphrase,omitempty"`
+	PublicKey  string `json:"publicKey,omitempty"`
+	PrivateKey string `json:"privateKey,omitempty"`
+}
+
+type payload struct {
+	AgentID   string          `json:"agentID"`
+	AgentInfo store.AgentInfo `json:"agentInfo"`
+}
+
+// ErrPendingRotation is returned when the agent has a key rotation not yet resolved, its keys may be replaced
+var ErrPendingRotation = errors.New("the agent has a pending key rotation, it's resolved on the next start")
+
+// NewBundle encrypts the agent info with the passphrase if set, otherwise to the public key.
+// The agent is not backed up while a key rotation is pending, the bundle could hold keys about to be replaced
+func NewBundle(agentID string, agentInfo *store.AgentInfo, pending *store.PendingRotation, key Key) (*Bundle, error) {
+	if len(agentID) == 0 || agentInfo == nil {
+		return nil, errors.New("no agent to back up")
+	}
+
+	if pending != nil {
+		return nil, ErrPendingRotation
+	}
+
+	if len(agentInfo.BLSPrivateKey) == 0 {
+		return nil, errors.New("the agent has no BLS key to back up")
+	}
+
+	plain, err := json.Marshal(payload{
+		AgentID:   agentID,
+		AgentInfo: *agentInfo,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Version:   bundleVersion,
+		AgentID:   agentID,
+		CreatedAt: time.Now().Unix(),
+	}
+
+	switch {
+	case len(key.Passphrase) > 0 && len(key.PublicKey) > 0:
+		return nil, errors.New("either a passphrase or a public key must be set, not both")
+	case len(key.Passphrase) > 0:
+		bundle.Method = MethodPassphrase
+		if bundle.KDF, err = util.NewKDFParams(); err != nil {
+			return nil, err
+		}
+
+		aesKey, err := bundle.KDF.DeriveKey(key.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		if bundle.Ciphertext, err = util.EncryptGCM(aesKey, plain, bundle.aad()); err != nil {
+			return nil, errors.Wrap(err, "encrypt backup bundle")
+		}
+	case len(key.PublicKey) > 0:
+		bundle.Method = MethodECIES
+		publicKey, err := parsePublicKey(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+
+		c, v, t, err := crypto.Secp256k1Encrypt(string(plain), publicKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "encrypt backup bundle")
+		}
+		bundle.ECIES = &ECIESCiphertext{C: c, V: v, T: t}
+	default:
+		return nil, errors.New("a passphrase or a public key must be set")
+	}
+
+	return bundle, nil
+}
+
+// Open decrypts the bundle with the passphrase or the private key, depending on the bundle method
+func (b *Bundle) Open(key Key) (string, *store.AgentInfo, error) {
+	if b.Version != bundleVersion {
+		return "", nil, errors.Errorf("unsupported backup bundle version %d", b.Version)
+	}
+
+	var plain []byte
+	switch b.Method {
+	case MethodPassphrase:
+		if len(key.Passphrase) == 0 || b.KDF == nil {
+			return "", nil, errors.New("the backup bundle is encrypted with a passphrase, no passphrase set")
+		}
+
+		aesKey, err := b.KDF.DeriveKey(key.Passphrase)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if plain, err = util.DecryptGCM(aesKey, b.Ciphertext, b.aad()); err != nil {
+			return "", nil, ErrWrongKey
+		}
+	case MethodECIES:
+		if len(key.PrivateKey) == 0 || b.ECIES == nil {
+			return "", nil, errors.New("the backup bundle is encrypted to a public key, no private key set")
+		}
+
+		privateKey, err := decodeKey(key.PrivateKey)
+		if err != nil {
+			return "", nil, errors.Wrap(err, "decode private key")
+		}
+
+		message, err := crypto.Secp256k1Decrypt(b.ECIES.C, b.ECIES.V, b.ECIES.T, hex.EncodeToString(privateKey))
+		if err != nil {
+			return "", nil, ErrWrongKey
+		}
+		plain = []byte(message)
+	default:
+		return "", nil, errors.Errorf("unsupported backup bundle method `%s`", b.Method)
+	}
+
+	p := payload{}
+	if err := json.Unmarshal(plain, &p); err != nil {
+		return "", nil, errors.Wrap(err, "parse backup bundle")
+	}
+
+	if p.AgentID != b.AgentID {
+		return "", nil, errors.New("the backup bundle agent ID doesn't match its content")
+	}
+
+	if len(p.AgentInfo.BLSPrivateKey) == 0 {
+		return "", nil, errors.New("the backup bundle holds no BLS key")
+	}
+
+	return p.AgentID, &p.AgentInfo, nil
+}
+
+// aad binds the ciphertext to the bundle format and the agent
+func (b *Bundle) aad() []byte {
+	return []byte("signing-agent/backup/v1/" + b.AgentID)
+}
+
+// parsePublicKey returns the hex encoded uncompressed public key
+func parsePublicKey(encoded string) (string, error) {
+	b, err := decodeKey(encoded)
+	if err != nil {
+		return "", errors.Wrap(err, "decode public key")
+	}
+
+	publicKey, err := btcec.ParsePubKey(b, btcec.S256())
+	if err != nil {
+		return "", errors.Wrap(err, "invalid secp256k1 public key")
+	}
+
+	return hex.EncodeToString(publicKey.SerializeUncompressed()), nil
+}
+
+// decodeKey decodes the hex or base64 encoded key
+func decodeKey(encoded string) ([]byte, error) {
+	encoded = strings.TrimSpace(encoded)
+	if b, err := hex.DecodeString(encoded); err == nil {
+		return b, nil
+	}
+
+	return base64.StdEncoding.DecodeString(encoded)
+}
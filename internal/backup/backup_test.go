@@ -0,0 +1,226 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/store"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+const testAgentID = "5zPWqLZaPqAaNenjyzWy5rcaGm4PuT1bfP74GgrzFUJn"
+
+func init() {
+	// keep the key derivation cheap in the tests
+	util.DefaultKDFParams.Time = 1
+	util.DefaultKDFParams.Memory = 1024
+	util.DefaultKDFParams.Threads = 1
+}
+
+func newTestAgentInfo() *store.AgentInfo {
+	return &store.AgentInfo{
+		BLSPrivateKey: "some bls key",
+		ECPrivateKey:  "some ec key",
+		WorkspaceID:   "some workspace",
+		APIKeyID:      "some api key",
+		APIKeySecret:  "some secret",
+	}
+}
+
+func TestBundle_passphrase_round_trip(t *testing.T) {
+	//Arrange
+	agentInfo := newTestAgentInfo()
+	bundle, err := NewBundle(testAgentID, agentInfo, nil, Key{Passphrase: "some passphrase"})
+	require.NoError(t, err)
+
+	//Act
+	agentID, restored, err := bundle.Open(Key{Passphrase: "some passphrase"})
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, testAgentID, agentID)
+	assert.Equal(t, agentInfo, restored)
+	assert.Equal(t, MethodPassphrase, bundle.Method)
+	assert.NotContains(t, string(bundle.Ciphertext), "some bls key")
+}
+
+func TestBundle_ecies_round_trip(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		encode func(b []byte) string
+	}{
+		{name: "hex", encode: hex.EncodeToString},
+		{name: "base64", encode: base64.StdEncoding.EncodeToString},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			privateKey, err := btcec.NewPrivateKey(btcec.S256())
+			require.NoError(t, err)
+			agentInfo := newTestAgentInfo()
+
+			bundle, err := NewBundle(testAgentID, agentInfo, nil, Key{PublicKey: tc.encode(privateKey.PubKey().SerializeCompressed())})
+			require.NoError(t, err)
+
+			//Act
+			agentID, restored, err := bundle.Open(Key{PrivateKey: tc.encode(privateKey.Serialize())})
+
+			//Assert
+			require.NoError(t, err)
+			assert.Equal(t, testAgentID, agentID)
+			assert.Equal(t, agentInfo, restored)
+			assert.Equal(t, MethodECIES, bundle.Method)
+		})
+	}
+}
+
+func TestBundle_Open_wrong_passphrase(t *testing.T) {
+	//Arrange
+	bundle, err := NewBundle(testAgentID, newTestAgentInfo(), nil, Key{Passphrase: "some passphrase"})
+	require.NoError(t, err)
+
+	//Act
+	_, _, err = bundle.Open(Key{Passphrase: "other passphrase"})
+
+	//Assert
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestBundle_Open_tampered_agent_id(t *testing.T) {
+	//Arrange
+	bundle, err := NewBundle(testAgentID, newTestAgentInfo(), nil, Key{Passphrase: "some passphrase"})
+	require.NoError(t, err)
+	bundle.AgentID = "other agent"
+
+	//Act
+	_, _, err = bundle.Open(Key{Passphrase: "some passphrase"})
+
+	//Assert
+	assert.ErrorIs(t, err, ErrWrongKey)
+}
+
+func TestNewBundle_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		agentID   string
+		agentInfo *store.AgentInfo
+		pending   *store.PendingRotation
+		key       Key
+		errMsg    string
+	}{
+		{
+			name:      "no agent",
+			agentInfo: newTestAgentInfo(),
+			key:       Key{Passphrase: "some passphrase"},
+			errMsg:    "no agent to back up",
+		},
+		{
+			name:      "no key",
+			agentID:   testAgentID,
+			agentInfo: newTestAgentInfo(),
+			errMsg:    "a passphrase or a public key must be set",
+		},
+		{
+			name:      "pending rotation",
+			agentID:   testAgentID,
+			agentInfo: newTestAgentInfo(),
+			pending:   &store.PendingRotation{},
+			key:       Key{Passphrase: "some passphrase"},
+			errMsg:    "the agent has a pending key rotation, it's resolved on the next start",
+		},
+		{
+			name:      "no BLS key",
+			agentID:   testAgentID,
+			agentInfo: &store.AgentInfo{APIKeyID: "some api key"},
+			key:       Key{Passphrase: "some passphrase"},
+			errMsg:    "the agent has no BLS key to back up",
+		},
+		{
+			name:      "passphrase and public key",
+			agentID:   testAgentID,
+			agentInfo: newTestAgentInfo(),
+			key:       Key{Passphrase: "some passphrase", PublicKey: "some key"},
+			errMsg:    "either a passphrase or a public key must be set, not both",
+		},
+		{
+			name:      "invalid public key",
+			agentID:   testAgentID,
+			agentInfo: newTestAgentInfo(),
+			key:       Key{PublicKey: hex.EncodeToString([]byte("not a key"))},
+			errMsg:    "invalid secp256k1 public key",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			_, err := NewBundle(tc.agentID, tc.agentInfo, tc.pending, tc.key)
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestBundle_Open_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		bundle Bundle
+		key    Key
+		errMsg string
+	}{
+		{
+			name:   "unsupported version",
+			bundle: Bundle{Version: 2, Method: MethodPassphrase},
+			key:    Key{Passphrase: "some passphrase"},
+			errMsg: "unsupported backup bundle version 2",
+		},
+		{
+			name:   "unsupported method",
+			bundle: Bundle{Version: bundleVersion, Method: "some method"},
+			errMsg: "unsupported backup bundle method `some method`",
+		},
+		{
+			name:   "no passphrase",
+			bundle: Bundle{Version: bundleVersion, Method: MethodPassphrase, KDF: &util.KDFParams{}},
+			key:    Key{PrivateKey: "some key"},
+			errMsg: "the backup bundle is encrypted with a passphrase, no passphrase set",
+		},
+		{
+			name:   "no private key",
+			bundle: Bundle{Version: bundleVersion, Method: MethodECIES, ECIES: &ECIESCiphertext{}},
+			key:    Key{Passphrase: "some passphrase"},
+			errMsg: "the backup bundle is encrypted to a public key, no private key set",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			_, _, err := tc.bundle.Open(tc.key)
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestBundle_Open_no_bls_key(t *testing.T) {
+	//Arrange
+	plain := []byte(`{"agentID":"` + testAgentID + `","agentInfo":{"APIKeyID":"some api key"}}`)
+	bundle := &Bundle{Version: bundleVersion, Method: MethodPassphrase, AgentID: testAgentID}
+	var err error
+	bundle.KDF, err = util.NewKDFParams()
+	require.Nil(t, err)
+	aesKey, err := bundle.KDF.DeriveKey("some passphrase")
+	require.Nil(t, err)
+	bundle.Ciphertext, err = util.EncryptGCM(aesKey, plain, bundle.aad())
+	require.Nil(t, err)
+
+	//Act
+	_, agentInfo, err := bundle.Open(Key{Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, agentInfo)
+	assert.EqualError(t, err, "the backup bundle holds no BLS key")
+}
@@ -0,0 +1,122 @@
+package backup
+
+import (
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxShares is the number of the non-zero x coordinates in GF(256)
+	maxShares = 255
+)
+
+// the exp and log tables of GF(256) with the AES polynomial x^8 + x^4 + x^3 + x + 1, generator 3
+var (
+	gfExp [255]byte
+	gfLog [256]byte
+)
+
+func init() {
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		gfExp[i] = x
+		gfLog[x] = byte(i)
+
+		// x *= 3
+		xtime := x << 1
+		if x&0x80 != 0 {
+			xtime ^= 0x1b
+		}
+		x ^= xtime
+	}
+}
+
+func gfMul(a, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+
+	return gfExp[(int(gfLog[a])+int(gfLog[b]))%255]
+}
+
+func gfDiv(a, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+
+	return gfExp[(int(gfLog[a])-int(gfLog[b])+255)%255]
+}
+
+// splitSecret splits the secret into count shares, any threshold of them rebuild the secret.
+// The share i is the value at x = i+1 of a random polynomial of degree threshold-1 per secret byte
+func splitSecret(secret []byte, threshold, count int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty secret")
+	}
+
+	if threshold < 2 || threshold > count || count > maxShares {
+		return nil, errors.Errorf("invalid threshold %d of %d shares, expected 2 <= threshold <= shares <= %d", threshold, count, maxShares)
+	}
+
+	shares := make([][]byte, count)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+
+	coefficients := make([]byte, threshold-1)
+	for b, s := range secret {
+		if _, err := rand.Read(coefficients); err != nil {
+			return nil, errors.Wrap(err, "generate polynomial")
+		}
+
+		for i := range shares {
+			x := byte(i + 1)
+
+			// Horner's method, the constant term is the secret byte
+			y := byte(0)
+			for c := len(coefficients) - 1; c >= 0; c-- {
+				y = gfMul(y, x) ^ coefficients[c]
+			}
+			shares[i][b] = gfMul(y, x) ^ s
+		}
+	}
+
+	return shares, nil
+}
+
+// combineSecret rebuilds the secret from the shares by their x coordinate, with Lagrange interpolation at x = 0
+func combineSecret(shares map[byte][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least 2 shares are needed")
+	}
+
+	size := -1
+	for x, y := range shares {
+		if x == 0 {
+			return nil, errors.New("invalid share index 0")
+		}
+
+		if size >= 0 && len(y) != size {
+			return nil, errors.New("the shares have different lengths")
+		}
+		size = len(y)
+	}
+
+	secret := make([]byte, size)
+	for xi, yi := range shares {
+		// the Lagrange basis polynomial of xi at 0
+		basis := byte(1)
+		for xj := range shares {
+			if xj != xi {
+				basis = gfMul(basis, gfDiv(xj, xj^xi))
+			}
+		}
+
+		for b := range secret {
+			secret[b] ^= gfMul(yi[b], basis)
+		}
+	}
+
+	return secret, nil
+}
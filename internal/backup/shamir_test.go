@@ -0,0 +1,80 @@
+package backup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGF256_div_is_inverse_of_mul(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			require.Equal(t, byte(a), gfDiv(gfMul(byte(a), byte(b)), byte(b)))
+		}
+	}
+}
+
+func TestSplitSecret_any_threshold_of_shares_rebuild_the_secret(t *testing.T) {
+	//Arrange
+	secret := []byte("some secret of the agent")
+	shares, err := splitSecret(secret, 3, 5)
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		for j := i + 1; j < 5; j++ {
+			for k := j + 1; k < 5; k++ {
+				//Act
+				combined, err := combineSecret(map[byte][]byte{
+					byte(i + 1): shares[i],
+					byte(j + 1): shares[j],
+					byte(k + 1): shares[k],
+				})
+
+				//Assert
+				require.NoError(t, err)
+				assert.Equal(t, secret, combined)
+			}
+		}
+	}
+}
+
+func TestSplitSecret_fewer_shares_than_threshold(t *testing.T) {
+	//Arrange
+	secret := []byte("some secret of the agent")
+	shares, err := splitSecret(secret, 3, 5)
+	require.NoError(t, err)
+
+	//Act
+	combined, err := combineSecret(map[byte][]byte{1: shares[0], 2: shares[1]})
+
+	//Assert
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, combined)
+	for _, share := range shares {
+		assert.NotEqual(t, secret, share)
+	}
+}
+
+func TestSplitSecret_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		secret    []byte
+		threshold int
+		count     int
+		errMsg    string
+	}{
+		{name: "empty secret", threshold: 2, count: 3, errMsg: "empty secret"},
+		{name: "threshold of 1", secret: []byte("some secret"), threshold: 1, count: 3, errMsg: "invalid threshold 1 of 3 shares"},
+		{name: "threshold above shares", secret: []byte("some secret"), threshold: 4, count: 3, errMsg: "invalid threshold 4 of 3 shares"},
+		{name: "too many shares", secret: []byte("some secret"), threshold: 2, count: 256, errMsg: "invalid threshold 2 of 256 shares"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			_, err := splitSecret(tc.secret, tc.threshold, tc.count)
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
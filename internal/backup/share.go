@@ -0,0 +1,259 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/crypto"
+	"github.com/qredo/signing-agent/internal/store"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+const (
+	// shareVersion is the version of the share format
+	shareVersion = 1
+)
+
+// ErrKeyMismatch is returned when the rebuilt BLS key doesn't match the public key of the split key
+var ErrKeyMismatch = errors.New("the rebuilt BLS key doesn't match the registered public key")
+
+// ErrNotCustodianKey is returned when the private key doesn't match the custodian key the share is encrypted to
+var ErrNotCustodianKey = errors.New("the private key is not the custodian key of the share")
+
+// Share is one of the shares of the agent BLS private key. Any threshold of the shares of a set rebuild the key.
+// The share value is either in clear or encrypted to the custodian's secp256k1 public key
+type Share struct {
+	Version      int              `json:"version"`
+	SetID        string           `json:"setID"`
+	AgentID      string           `json:"agentID"`
+	WorkspaceID  string           `json:"workspaceID"`
+	BLSPublicKey string           `json:"blsPublicKey"`
+	Threshold    int              `json:"threshold"`
+	Count        int              `json:"count"`
+	Index        int              `json:"index"`
+	CustodianKey string           `json:"custodianKey,omitempty"`
+	Value        []byte           `json:"value,omitempty"`
+	ECIES        *ECIESCiphertext `json:"ecies,omitempty"`
+}
+
+// RecoveredKey is the BLS private key rebuilt from the shares, with the agent it belongs to
+type RecoveredKey struct {
+	AgentID       string
+	WorkspaceID   string
+	BLSPrivateKey string
+}
+
+// SplitKey splits the agent BLS private key into count shares, any threshold of them rebuild the key.
+// The custodian keys are either empty, or one hex or base64 encoded secp256k1 public key per share,
+// an empty custodian key leaves the share in clear. The key is not split while a key rotation is pending
+func SplitKey(agentID string, agentInfo *store.AgentInfo, pending *store.PendingRotation, threshold, count int, custodianKeys []string) ([]*Share, error) {
+	if len(agentID) == 0 || agentInfo == nil {
+		return nil, errors.New("no agent to split the key of")
+	}
+
+	if pending != nil {
+		return nil, ErrPendingRotation
+	}
+
+	if len(agentInfo.BLSPrivateKey) == 0 {
+		return nil, errors.New("the agent has no BLS key to split")
+	}
+
+	if len(custodianKeys) > 0 && len(custodianKeys) != count {
+		return nil, errors.Errorf("expected %d custodian keys, one per share, got %d", count, len(custodianKeys))
+	}
+
+	blsKey, err := base64.StdEncoding.DecodeString(agentInfo.BLSPrivateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "decode BLS private key")
+	}
+
+	blsPublicKey, err := blsPublicKeyOf(blsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := splitSecret(blsKey, threshold, count)
+	if err != nil {
+		return nil, err
+	}
+
+	setID, err := util.RandomBytes(8)
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make([]*Share, count)
+	for i, value := range values {
+		share := &Share{
+			Version:      shareVersion,
+			SetID:        hex.EncodeToString(setID),
+			AgentID:      agentID,
+			WorkspaceID:  agentInfo.WorkspaceID,
+			BLSPublicKey: blsPublicKey,
+			Threshold:    threshold,
+			Count:        count,
+			Index:        i + 1,
+			Value:        value,
+		}
+
+		if len(custodianKeys) > 0 && len(custodianKeys[i]) > 0 {
+			if err := share.encrypt(custodianKeys[i]); err != nil {
+				return nil, errors.Wrapf(err, "share %d", share.Index)
+			}
+		}
+
+		shares[i] = share
+	}
+
+	return shares, nil
+}
+
+// IsEncrypted returns true if the share value is encrypted to the custodian key
+func (s *Share) IsEncrypted() bool {
+	return s.ECIES != nil
+}
+
+// Decrypt decrypts the share value with the custodian's private key, the private key must match the custodian key
+func (s *Share) Decrypt(privateKey string) error {
+	if !s.IsEncrypted() {
+		return nil
+	}
+
+	b, err := decodeKey(privateKey)
+	if err != nil {
+		return errors.Wrap(err, "decode private key")
+	}
+
+	_, publicKey := btcec.PrivKeyFromBytes(btcec.S256(), b)
+	if hex.EncodeToString(publicKey.SerializeUncompressed()) != s.CustodianKey {
+		return ErrNotCustodianKey
+	}
+
+	message, err := crypto.Secp256k1Decrypt(s.ECIES.C, s.ECIES.V, s.ECIES.T, hex.EncodeToString(b))
+	if err != nil {
+		return errors.Wrapf(err, "decrypt share %d", s.Index)
+	}
+
+	if s.Value, err = base64.StdEncoding.DecodeString(message); err != nil {
+		return errors.Wrapf(err, "decode share %d", s.Index)
+	}
+
+	s.ECIES = nil
+	return nil
+}
+
+// CombineKey rebuilds the BLS private key from the decrypted shares of a set,
+// and checks it matches the public key of the split key. The shares carry that public key,
+// so the rebuilt key must be verified against a trusted public key before it's used, see RecoveredKey.Verify
+func CombineKey(shares []*Share) (*RecoveredKey, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares")
+	}
+
+	first := shares[0]
+	if first.Version != shareVersion {
+		return nil, errors.Errorf("unsupported share version %d", first.Version)
+	}
+
+	values := map[byte][]byte{}
+	for _, share := range shares {
+		if share.Version != first.Version || share.SetID != first.SetID || share.AgentID != first.AgentID ||
+			share.BLSPublicKey != first.BLSPublicKey || share.Threshold != first.Threshold {
+			return nil, errors.Errorf("share %d is not from the same split as share %d", share.Index, first.Index)
+		}
+
+		if share.IsEncrypted() {
+			return nil, errors.Errorf("share %d is encrypted", share.Index)
+		}
+
+		if share.Index < 1 || share.Index > maxShares {
+			return nil, errors.Errorf("invalid share index %d", share.Index)
+		}
+
+		if _, ok := values[byte(share.Index)]; ok {
+			return nil, errors.Errorf("duplicate share %d", share.Index)
+		}
+		values[byte(share.Index)] = share.Value
+	}
+
+	if len(values) < first.Threshold {
+		return nil, errors.Errorf("%d shares are needed, got %d", first.Threshold, len(values))
+	}
+
+	blsKey, err := combineSecret(values)
+	if err != nil {
+		return nil, err
+	}
+
+	blsPublicKey, err := blsPublicKeyOf(blsKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if blsPublicKey != first.BLSPublicKey {
+		return nil, ErrKeyMismatch
+	}
+
+	return &RecoveredKey{
+		AgentID:       first.AgentID,
+		WorkspaceID:   first.WorkspaceID,
+		BLSPrivateKey: base64.StdEncoding.EncodeToString(blsKey),
+	}, nil
+}
+
+// encrypt encrypts the share value to the custodian's public key
+func (s *Share) encrypt(custodianKey string) error {
+	publicKey, err := parsePublicKey(custodianKey)
+	if err != nil {
+		return err
+	}
+
+	c, v, t, err := crypto.Secp256k1Encrypt(base64.StdEncoding.EncodeToString(s.Value), publicKey)
+	if err != nil {
+		return errors.Wrap(err, "encrypt share")
+	}
+
+	s.CustodianKey = publicKey
+	s.ECIES = &ECIESCiphertext{C: c, V: v, T: t}
+	s.Value = nil
+	return nil
+}
+
+// Verify checks the rebuilt key matches the trusted BLS public key, the one of the stored agent key
+// or the one registered on Qredo
+func (k *RecoveredKey) Verify(blsPublicKey string) error {
+	publicKey, err := BLSPublicKey(k.BLSPrivateKey)
+	if err != nil {
+		return err
+	}
+
+	if publicKey != blsPublicKey {
+		return ErrKeyMismatch
+	}
+
+	return nil
+}
+
+// BLSPublicKey returns the base64 encoded BLS public key of the base64 encoded private key
+func BLSPublicKey(blsPrivateKey string) (string, error) {
+	blsKey, err := base64.StdEncoding.DecodeString(blsPrivateKey)
+	if err != nil {
+		return "", errors.Wrap(err, "decode BLS private key")
+	}
+
+	return blsPublicKeyOf(blsKey)
+}
+
+// blsPublicKeyOf returns the base64 encoded BLS public key of the private key
+func blsPublicKeyOf(blsKey []byte) (string, error) {
+	publicKey, _, err := crypto.BLSKeys(nil, blsKey)
+	if err != nil {
+		return "", errors.Wrap(err, "derive BLS public key")
+	}
+
+	return base64.StdEncoding.EncodeToString(publicKey), nil
+}
@@ -0,0 +1,208 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/store"
+)
+
+func newTestShareAgentInfo() *store.AgentInfo {
+	agentInfo := newTestAgentInfo()
+	agentInfo.BLSPrivateKey = base64.StdEncoding.EncodeToString([]byte("some 32 bytes long bls key ....."))
+	return agentInfo
+}
+
+func TestSplitKey_round_trip(t *testing.T) {
+	//Arrange
+	agentInfo := newTestShareAgentInfo()
+	shares, err := SplitKey(testAgentID, agentInfo, nil, 2, 3, nil)
+	require.NoError(t, err)
+
+	//Act
+	recovered, err := CombineKey([]*Share{shares[2], shares[0]})
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, &RecoveredKey{
+		AgentID:       testAgentID,
+		WorkspaceID:   agentInfo.WorkspaceID,
+		BLSPrivateKey: agentInfo.BLSPrivateKey,
+	}, recovered)
+	for _, share := range shares {
+		assert.Equal(t, shares[0].SetID, share.SetID)
+		assert.False(t, share.IsEncrypted())
+	}
+}
+
+func TestSplitKey_encrypted_to_custodians(t *testing.T) {
+	//Arrange
+	custodians := make([]*btcec.PrivateKey, 3)
+	custodianKeys := make([]string, 3)
+	for i := range custodians {
+		var err error
+		custodians[i], err = btcec.NewPrivateKey(btcec.S256())
+		require.NoError(t, err)
+		custodianKeys[i] = hex.EncodeToString(custodians[i].PubKey().SerializeCompressed())
+	}
+	custodianKeys[2] = ""
+
+	agentInfo := newTestShareAgentInfo()
+	shares, err := SplitKey(testAgentID, agentInfo, nil, 2, 3, custodianKeys)
+	require.NoError(t, err)
+
+	//Act
+	err = shares[1].Decrypt(hex.EncodeToString(custodians[1].Serialize()))
+	require.NoError(t, err)
+	recovered, err := CombineKey([]*Share{shares[1], shares[2]})
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, agentInfo.BLSPrivateKey, recovered.BLSPrivateKey)
+	assert.True(t, shares[0].IsEncrypted())
+	assert.Nil(t, shares[0].Value)
+	assert.False(t, shares[2].IsEncrypted())
+}
+
+func TestShare_Decrypt_wrong_custodian(t *testing.T) {
+	//Arrange
+	custodian, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	other, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	key := hex.EncodeToString(custodian.PubKey().SerializeCompressed())
+	shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 2, []string{key, key})
+	require.NoError(t, err)
+
+	//Act
+	err = shares[0].Decrypt(hex.EncodeToString(other.Serialize()))
+
+	//Assert
+	assert.ErrorIs(t, err, ErrNotCustodianKey)
+	assert.True(t, shares[0].IsEncrypted())
+}
+
+func TestCombineKey_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		shares func(t *testing.T) []*Share
+		err    error
+		errMsg string
+	}{
+		{
+			name: "not enough shares",
+			shares: func(t *testing.T) []*Share {
+				shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 3, 5, nil)
+				require.NoError(t, err)
+				return shares[:2]
+			},
+			errMsg: "3 shares are needed, got 2",
+		},
+		{
+			name: "duplicate share",
+			shares: func(t *testing.T) []*Share {
+				shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 3, nil)
+				require.NoError(t, err)
+				return []*Share{shares[0], shares[0]}
+			},
+			errMsg: "duplicate share 1",
+		},
+		{
+			name: "shares of different splits",
+			shares: func(t *testing.T) []*Share {
+				shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 3, nil)
+				require.NoError(t, err)
+				other, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 3, nil)
+				require.NoError(t, err)
+				return []*Share{shares[0], other[1]}
+			},
+			errMsg: "share 2 is not from the same split as share 1",
+		},
+		{
+			name: "encrypted share",
+			shares: func(t *testing.T) []*Share {
+				custodian, err := btcec.NewPrivateKey(btcec.S256())
+				require.NoError(t, err)
+				key := hex.EncodeToString(custodian.PubKey().SerializeCompressed())
+				shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 2, []string{key, ""})
+				require.NoError(t, err)
+				return shares
+			},
+			errMsg: "share 1 is encrypted",
+		},
+		{
+			name: "corrupted share",
+			shares: func(t *testing.T) []*Share {
+				shares, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 3, nil)
+				require.NoError(t, err)
+				shares[1].Value[0] ^= 0xff
+				return shares[:2]
+			},
+			err: ErrKeyMismatch,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Act
+			_, err := CombineKey(tc.shares(t))
+
+			//Assert
+			if tc.err != nil {
+				assert.ErrorIs(t, err, tc.err)
+			} else {
+				assert.ErrorContains(t, err, tc.errMsg)
+			}
+		})
+	}
+}
+
+func TestRecoveredKey_Verify(t *testing.T) {
+	//Arrange
+	agentInfo := newTestShareAgentInfo()
+	shares, err := SplitKey(testAgentID, agentInfo, nil, 2, 2, nil)
+	require.NoError(t, err)
+	recovered, err := CombineKey(shares)
+	require.NoError(t, err)
+
+	blsPublicKey, err := BLSPublicKey(agentInfo.BLSPrivateKey)
+	require.NoError(t, err)
+	otherPublicKey, err := BLSPublicKey(base64.StdEncoding.EncodeToString([]byte("other 32 bytes long bls key ....")))
+	require.NoError(t, err)
+
+	//Act
+	err = recovered.Verify(blsPublicKey)
+	mismatchErr := recovered.Verify(otherPublicKey)
+
+	//Assert
+	assert.NoError(t, err)
+	assert.Equal(t, ErrKeyMismatch, mismatchErr)
+}
+
+func TestSplitKey_fails(t *testing.T) {
+	//Act
+	_, err := SplitKey(testAgentID, newTestShareAgentInfo(), nil, 2, 3, []string{"some key"})
+
+	//Assert
+	assert.ErrorContains(t, err, "expected 3 custodian keys, one per share, got 1")
+}
+
+func TestSplitKey_pending_rotation(t *testing.T) {
+	//Act
+	_, err := SplitKey(testAgentID, newTestShareAgentInfo(), &store.PendingRotation{}, 2, 3, nil)
+
+	//Assert
+	assert.Equal(t, ErrPendingRotation, err)
+}
+
+func TestSplitKey_no_bls_key(t *testing.T) {
+	//Act
+	_, err := SplitKey(testAgentID, &store.AgentInfo{APIKeyID: testAgentID}, nil, 2, 3, nil)
+
+	//Assert
+	assert.EqualError(t, err, "the agent has no BLS key to split")
+}
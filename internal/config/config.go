@@ -15,6 +15,35 @@ type Config struct {
 	Store         Store           `yaml:"store" json:"store"`
 	AutoApprove   AutoApprove     `yaml:"autoApproval" json:"autoApproval"`
 	Websocket     WebSocketConfig `yaml:"websocket" json:"websocket"`
+	FeedHub       FeedHubConfig   `yaml:"feedHub" json:"feedHub"`
+	Journal       Journal         `yaml:"journal" json:"journal"`
+	Audit         Audit           `yaml:"audit" json:"audit"`
+	Signer        SignerConfig    `yaml:"signer" json:"signer"`
+	Webhooks      Webhooks        `yaml:"webhooks" json:"webhooks"`
+	Admin         Admin           `yaml:"admin" json:"admin"`
+}
+
+// Admin enables the administration endpoints. The backup and restore endpoints hand out and replace the agent keys,
+// they are served only when enabled here and with the HTTP authentication enabled.
+// The fingerprint key, shared by the instances of a deployment, keys the digests of the sensitive settings in the
+// config fingerprint, so the instances with different secrets have different fingerprints
+type Admin struct {
+	BackupEnabled  bool   `yaml:"backupEnabled" json:"backupEnabled"`
+	FingerprintKey string `yaml:"fingerprintKey" json:"fingerprintKey" sensitive:"true"`
+}
+
+// SignerConfig is where the agent BLS key is held. The local signer holds the key in process memory,
+// the remote signer is a separate process holding the key and signing with it by key ID
+type SignerConfig struct {
+	Type   string             `yaml:"type" json:"type"`
+	Remote RemoteSignerConfig `yaml:"remote" json:"remote"`
+}
+
+// RemoteSignerConfig is the remote signer reached over the Unix socket
+type RemoteSignerConfig struct {
+	Socket     string `yaml:"socket" json:"socket"`
+	KeyID      string `yaml:"keyID" json:"keyID"`
+	TimeoutSec int    `yaml:"timeoutSec" json:"timeoutSec"`
 }
 
 type Base struct {
@@ -22,15 +51,31 @@ type Base struct {
 }
 
 type TLSConfig struct {
-	Enabled  bool   `yaml:"enabled" json:"enabled"`
-	CertFile string `yaml:"certFile" json:"certFile"`
-	KeyFile  string `yaml:"keyFile" json:"keyFile"`
+	Enabled           bool              `yaml:"enabled" json:"enabled"`
+	CertFile          string            `yaml:"certFile" json:"certFile"`
+	KeyFile           string            `yaml:"keyFile" json:"keyFile"`
+	ClientCAFile      string            `yaml:"clientCAFile" json:"clientCAFile"`
+	RequireClientCert bool              `yaml:"requireClientCert" json:"requireClientCert"`
+	ClientIdentities  map[string]string `yaml:"clientIdentities" json:"clientIdentities"`
 }
 
 type AutoApprove struct {
-	Enabled          bool `yaml:"enabled" json:"enabled"`
-	RetryIntervalMax int  `yaml:"retryIntervalMaxSec" json:"retryIntervalMaxSec"`
-	RetryInterval    int  `yaml:"retryIntervalSec" json:"retryIntervalSec"`
+	Enabled          bool   `yaml:"enabled" json:"enabled"`
+	RetryIntervalMax int    `yaml:"retryIntervalMaxSec" json:"retryIntervalMaxSec"`
+	RetryInterval    int    `yaml:"retryIntervalSec" json:"retryIntervalSec"`
+	PolicyFile       string `yaml:"policyFile" json:"policyFile"`
+}
+
+type Journal struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	File    string `yaml:"file" json:"file"`
+}
+
+type Audit struct {
+	Enabled       bool   `yaml:"enabled" json:"enabled"`
+	File          string `yaml:"file" json:"file"`
+	BatchInterval int    `yaml:"batchIntervalSec" json:"batchIntervalSec"`
+	MaxBatchSize  int    `yaml:"maxBatchSize" json:"maxBatchSize"`
 }
 
 type WebSocketConfig struct {
@@ -44,29 +89,97 @@ type WebSocketConfig struct {
 	WriteBufferSize   int    `yaml:"writeBufferSize" json:"writeBufferSize"`
 }
 
+// FeedHubConfig is the delivery of the feed messages to the feed clients. Each client has a bounded queue,
+// the overflow policy applies when the queue of a slow client is full: dropOldest, dropNewest or disconnect.
+// The external clients with a full queue for evictAfterSec are disconnected, 0 never evicts them
+type FeedHubConfig struct {
+	QueueSize      int    `yaml:"queueSize" json:"queueSize"`
+	OverflowPolicy string `yaml:"overflowPolicy" json:"overflowPolicy"`
+	EvictAfter     int    `yaml:"evictAfterSec" json:"evictAfterSec"`
+}
+
+// Webhooks are the HTTP endpoints the feed actions are posted to. The failed deliveries are retried with exponential
+// backoff, from retryIntervalSec up to retryIntervalMaxSec, and moved to the dead letters after maxAttempts.
+// At most maxDeadLetters are kept, the oldest evicted first, and at most maxConcurrent deliveries are posted
+// at once to each endpoint. The pending deliveries and the dead letters are kept in the queue file
+type Webhooks struct {
+	Enabled          bool              `yaml:"enabled" json:"enabled"`
+	Endpoints        []WebhookEndpoint `yaml:"endpoints" json:"endpoints"`
+	QueueFile        string            `yaml:"queueFile" json:"queueFile"`
+	TimeoutSec       int               `yaml:"timeoutSec" json:"timeoutSec"`
+	RetryInterval    int               `yaml:"retryIntervalSec" json:"retryIntervalSec"`
+	RetryIntervalMax int               `yaml:"retryIntervalMaxSec" json:"retryIntervalMaxSec"`
+	MaxAttempts      int               `yaml:"maxAttempts" json:"maxAttempts"`
+	MaxDeadLetters   int               `yaml:"maxDeadLetters" json:"maxDeadLetters"`
+	MaxConcurrent    int               `yaml:"maxConcurrent" json:"maxConcurrent"`
+}
+
+// WebhookEndpoint is an endpoint the actions are posted to, the bodies are signed with the base64 url encoded secret.
+// Only the actions of the listed types and statuses are posted, an empty list matches any value
+type WebhookEndpoint struct {
+	Name     string   `yaml:"name" json:"name"`
+	URL      string   `yaml:"url" json:"url"`
+	Secret   string   `yaml:"secret" json:"secret" sensitive:"true"`
+	Types    []string `yaml:"types" json:"types"`
+	Statuses []int    `yaml:"statuses" json:"statuses"`
+}
+
 type Store struct {
-	Type       string    `default:"file" yaml:"type" json:"type"`
-	FileConfig string    `yaml:"file" json:"file"`
-	OciConfig  OciConfig `yaml:"oci" json:"oci"`
-	AwsConfig  AWSConfig `yaml:"aws" json:"aws"`
-	GcpConfig  GCPConfig `yaml:"gcp" json:"gcp"`
+	Type           string         `default:"file" yaml:"type" json:"type"`
+	FileConfig     string         `yaml:"file" json:"file"`
+	FileEncryption FileEncryption `yaml:"fileEncryption" json:"fileEncryption"`
+	OciConfig      OciConfig      `yaml:"oci" json:"oci"`
+	AwsConfig      AWSConfig      `yaml:"aws" json:"aws"`
+	GcpConfig      GCPConfig      `yaml:"gcp" json:"gcp"`
+	VaultConfig    VaultConfig    `yaml:"vault" json:"vault"`
+	K8sConfig      K8sConfig      `yaml:"k8s" json:"k8s"`
+}
+
+// FileEncryption is the encryption at rest of the file store. The key encryption key is derived from the passphrase,
+// or given base64 encoded in the key or in the key file. Exactly one of them must be set
+type FileEncryption struct {
+	Enabled    bool   `yaml:"enabled" json:"enabled"`
+	Passphrase string `yaml:"passphrase" json:"passphrase" sensitive:"true"`
+	Key        string `yaml:"key" json:"key" sensitive:"true"`
+	KeyFile    string `yaml:"keyFile" json:"keyFile"`
 }
 
 type OciConfig struct {
 	Compartment         string `yaml:"compartment" json:"compartment"`
 	Vault               string `yaml:"vault" json:"vault"`
-	SecretEncryptionKey string `yaml:"secretEncryptionKey" json:"secretEncryptionKey"`
-	ConfigSecret        string `yaml:"configSecret" json:"configSecret"`
+	SecretEncryptionKey string `yaml:"secretEncryptionKey" json:"secretEncryptionKey" sensitive:"true"`
+	ConfigSecret        string `yaml:"configSecret" json:"configSecret" sensitive:"true"`
 }
 
 type AWSConfig struct {
 	Region     string `yaml:"region" json:"region"`
-	SecretName string `yaml:"configSecret" json:"configSecret"`
+	SecretName string `yaml:"configSecret" json:"configSecret" sensitive:"true"`
 }
 
 type GCPConfig struct {
 	ProjectID  string `yaml:"projectID" json:"projectID"`
-	SecretName string `yaml:"configSecret" json:"configSecret"`
+	SecretName string `yaml:"configSecret" json:"configSecret" sensitive:"true"`
+}
+
+// VaultConfig is the HashiCorp Vault configuration when the store type is set to vault. The store is kept
+// in a single secret of a KV v2 secrets engine, the authentication is either with a token or with AppRole
+type VaultConfig struct {
+	Address     string `yaml:"address" json:"address"`
+	Namespace   string `yaml:"namespace" json:"namespace"`
+	MountPath   string `yaml:"mountPath" json:"mountPath"`
+	SecretPath  string `yaml:"secretPath" json:"secretPath"`
+	AuthMethod  string `yaml:"authMethod" json:"authMethod"`
+	Token       string `yaml:"token" json:"token" sensitive:"true"`
+	AppRolePath string `yaml:"appRolePath" json:"appRolePath"`
+	RoleID      string `yaml:"roleID" json:"roleID"`
+	SecretID    string `yaml:"secretID" json:"secretID" sensitive:"true"`
+}
+
+// K8sConfig is the Kubernetes configuration when the store type is set to k8s.
+// The namespace defaults to the namespace of the pod's service account
+type K8sConfig struct {
+	Namespace  string `yaml:"namespace" json:"namespace"`
+	SecretName string `yaml:"secretName" json:"secretName"`
 }
 
 type HttpSettings struct {
@@ -74,6 +187,25 @@ type HttpSettings struct {
 	CORSAllowOrigins []string  `yaml:"CORSAllowOrigins" json:"CORSAllowOrigins"`
 	LogAllRequests   bool      `yaml:"logAllRequests" json:"logAllRequests"`
 	TLS              TLSConfig `yaml:"TLS" json:"TLS"`
+	Auth             HTTPAuth  `yaml:"auth" json:"auth"`
+}
+
+type HTTPAuth struct {
+	Enabled     bool      `yaml:"enabled" json:"enabled"`
+	APIKeys     []APIKey  `yaml:"apiKeys" json:"apiKeys"`
+	HMACKeys    []HMACKey `yaml:"hmacKeys" json:"hmacKeys" sensitive:"true"`
+	HMACMaxSkew int       `yaml:"hmacMaxSkewSec" json:"hmacMaxSkewSec"`
+	OpenRoutes  []string  `yaml:"openRoutes" json:"openRoutes"`
+}
+
+type APIKey struct {
+	Name string `yaml:"name" json:"name"`
+	Hash string `yaml:"hash" json:"hash" sensitive:"true"`
+}
+
+type HMACKey struct {
+	ID     string `yaml:"id" json:"id"`
+	Secret string `yaml:"secret" json:"secret" sensitive:"true"`
 }
 
 type Logging struct {
@@ -91,7 +223,7 @@ type LoadBalancing struct {
 type RedisConfig struct {
 	Host     string `yaml:"host" json:"host"`
 	Port     int    `yaml:"port" json:"port"`
-	Password string `yaml:"password" json:"password"`
+	Password string `yaml:"password" json:"password" sensitive:"true"`
 	DB       int    `yaml:"db" json:"db"`
 }
 
@@ -102,6 +234,11 @@ func (c *Config) Default() {
 		TLS: TLSConfig{
 			Enabled: false,
 		},
+		Auth: HTTPAuth{
+			Enabled:     false,
+			HMACMaxSkew: 30,
+			OpenRoutes:  []string{"/healthcheck/version", "/healthcheck/status"},
+		},
 	}
 
 	c.Base.QredoAPI = "https://api-v2.qredo.network/api/v2"
@@ -120,10 +257,49 @@ func (c *Config) Default() {
 		ReadBufferSize:    512,
 		WriteBufferSize:   1024,
 	}
+	c.FeedHub = FeedHubConfig{
+		QueueSize:      64,
+		OverflowPolicy: "dropOldest",
+		EvictAfter:     30,
+	}
+	c.Journal = Journal{
+		Enabled: false,
+		File:    "journal.jsonl",
+	}
+	c.Audit = Audit{
+		Enabled:       false,
+		File:          "audit.jsonl",
+		BatchInterval: 300,
+		MaxBatchSize:  256,
+	}
+	c.Webhooks = Webhooks{
+		Enabled:          false,
+		QueueFile:        "webhooks.json",
+		TimeoutSec:       10,
+		RetryInterval:    5,
+		RetryIntervalMax: 600,
+		MaxAttempts:      10,
+		MaxDeadLetters:   1000,
+		MaxConcurrent:    4,
+	}
 	c.Logging.Level = "info"
 	c.Logging.Format = "json"
 	c.Store.Type = "file"
 	c.Store.FileConfig = "ccstore.db"
+	c.Store.VaultConfig = VaultConfig{
+		MountPath:   "secret",
+		SecretPath:  "signing-agent",
+		AuthMethod:  "token",
+		AppRolePath: "approle",
+	}
+	c.Store.K8sConfig.SecretName = "signing-agent"
+	c.Signer = SignerConfig{
+		Type: "local",
+		Remote: RemoteSignerConfig{
+			KeyID:      "signing-agent",
+			TimeoutSec: 10,
+		},
+	}
 	c.LoadBalancing = LoadBalancing{
 		Enable:                false,
 		OnLockErrorTimeOutMs:  300,
@@ -152,6 +328,49 @@ func (c *Config) Load(fileName string) error {
 	return nil
 }
 
+// Validate checks the settings that can be applied without a restart
+func (c *Config) Validate() error {
+	switch c.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return errors.Errorf("unsupported logging level `%s`", c.Logging.Level)
+	}
+
+	if c.AutoApprove.RetryInterval <= 0 || c.AutoApprove.RetryIntervalMax < c.AutoApprove.RetryInterval {
+		return errors.New("autoApproval retry intervals must be positive, with retryIntervalMaxSec not lower than retryIntervalSec")
+	}
+
+	if c.Websocket.ReconnectInterval <= 0 || c.Websocket.ReconnectTimeOut < c.Websocket.ReconnectInterval {
+		return errors.New("websocket reconnect intervals must be positive, with reconnectTimeoutSec not lower than reconnectIntervalSec")
+	}
+
+	if c.Websocket.PingPeriod <= 0 || c.Websocket.WriteWait <= 0 || c.Websocket.PongWait <= c.Websocket.PingPeriod {
+		return errors.New("websocket timings must be positive, with pongWaitSec greater than pingPeriodSec")
+	}
+
+	if c.FeedHub.QueueSize <= 0 || c.FeedHub.EvictAfter < 0 {
+		return errors.New("feedHub queueSize must be positive, with evictAfterSec not negative")
+	}
+
+	switch c.FeedHub.OverflowPolicy {
+	case "dropOldest", "dropNewest", "disconnect":
+	default:
+		return errors.Errorf("unsupported feedHub overflow policy `%s`", c.FeedHub.OverflowPolicy)
+	}
+
+	switch c.Signer.Type {
+	case "local":
+	case "remote":
+		if len(c.Signer.Remote.Socket) == 0 || len(c.Signer.Remote.KeyID) == 0 || c.Signer.Remote.TimeoutSec <= 0 {
+			return errors.New("the remote signer needs the socket, the key ID and a positive timeoutSec")
+		}
+	default:
+		return errors.Errorf("unsupported signer type `%s`", c.Signer.Type)
+	}
+
+	return nil
+}
+
 // Save saves yaml config.
 func (c *Config) Save(fileName string) error {
 	b, err := yaml.Marshal(c)
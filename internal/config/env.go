@@ -0,0 +1,120 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// EnvPrefix is the prefix of the environment variables overriding the config
+	EnvPrefix = "SA"
+	// envFileSuffix is appended to the variable name of a sensitive setting to read it from a file
+	envFileSuffix = "_FILE"
+)
+
+// LoadEnv overrides the settings with the environment variables named after their yaml path,
+// e.g. SA_LOADBALANCING_REDIS_PASSWORD for loadBalancing.redis.password.
+// It's called after Load, so the precedence is defaults, then config file, then environment.
+//
+// Lists of strings are comma separated, the other lists and the maps are given in yaml flow style,
+// e.g. SA_HTTP_AUTH_APIKEYS='[{name: approval-service, hash: 3b1c...}]'.
+// The settings tagged as sensitive can be read from a mounted secret file instead,
+// e.g. SA_LOADBALANCING_REDIS_PASSWORD_FILE=/run/secrets/redis-password
+func (c *Config) LoadEnv() error {
+	return loadEnv(reflect.ValueOf(c).Elem(), EnvPrefix)
+}
+
+func loadEnv(v reflect.Value, name string) error {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fieldName := name + "_" + strings.ToUpper(yamlName(field))
+
+		if field.Type.Kind() == reflect.Struct {
+			if err := loadEnv(v.Field(i), fieldName); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok, err := lookupEnv(fieldName, isSensitive(field))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		if err := setField(v.Field(i), value); err != nil {
+			return errors.Wrapf(err, "invalid value of %s", fieldName)
+		}
+	}
+
+	return nil
+}
+
+// lookupEnv returns the value of the variable, or the content of the file named by the _FILE variable for sensitive settings
+func lookupEnv(name string, sensitive bool) (string, bool, error) {
+	value, ok := os.LookupEnv(name)
+	if !sensitive {
+		return value, ok, nil
+	}
+
+	fileName, fileOk := os.LookupEnv(name + envFileSuffix)
+	if !fileOk {
+		return value, ok, nil
+	}
+
+	if ok {
+		return "", false, errors.Errorf("both %s and %s%s are set", name, name, envFileSuffix)
+	}
+
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "read %s%s", name, envFileSuffix)
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), true, nil
+}
+
+func setField(field reflect.Value, value string) error {
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String:
+		list := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+		return nil
+	}
+
+	parsed := reflect.New(field.Type())
+	if err := yaml.UnmarshalStrict([]byte(value), parsed.Interface()); err != nil {
+		return err
+	}
+	field.Set(parsed.Elem())
+
+	return nil
+}
+
+// yamlName returns the name of the setting in the config file
+func yamlName(field reflect.StructField) string {
+	if name := strings.Split(field.Tag.Get("yaml"), ",")[0]; name != "" {
+		return name
+	}
+
+	return field.Name
+}
+
+// isSensitive returns true for the settings holding secrets
+func isSensitive(field reflect.StructField) bool {
+	return field.Tag.Get("sensitive") == "true"
+}
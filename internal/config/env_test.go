@@ -0,0 +1,142 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_LoadEnv_precedence(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+	require.NoError(t, os.WriteFile(fileName, []byte(`
+logging:
+  level: warn
+loadBalancing:
+  redis:
+    host: redis.local
+    port: 6380
+`), 0600))
+	t.Setenv("SA_LOADBALANCING_REDIS_PORT", "6381")
+	t.Setenv("SA_LOGGING_FORMAT", "text")
+
+	sut := Config{}
+	sut.Default()
+	require.NoError(t, sut.Load(fileName))
+
+	//Act
+	err := sut.LoadEnv()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, "warn", sut.Logging.Level)
+	assert.Equal(t, "text", sut.Logging.Format)
+	assert.Equal(t, "redis.local", sut.LoadBalancing.RedisConfig.Host)
+	assert.Equal(t, 6381, sut.LoadBalancing.RedisConfig.Port)
+	assert.Equal(t, 6, sut.LoadBalancing.ActionIDExpirationSec)
+}
+
+func TestConfig_LoadEnv_field_types(t *testing.T) {
+	//Arrange
+	t.Setenv("SA_AUTOAPPROVAL_ENABLED", "true")
+	t.Setenv("SA_HTTP_CORSALLOWORIGINS", "https://a.example.com, https://b.example.com")
+	t.Setenv("SA_HTTP_TLS_CLIENTIDENTITIES", "{CN=approver: approval-service}")
+	t.Setenv("SA_HTTP_AUTH_APIKEYS", "[{name: approval-service, hash: some hash}]")
+
+	sut := Config{}
+	sut.Default()
+
+	//Act
+	err := sut.LoadEnv()
+
+	//Assert
+	require.NoError(t, err)
+	assert.True(t, sut.AutoApprove.Enabled)
+	assert.Equal(t, []string{"https://a.example.com", "https://b.example.com"}, sut.HTTP.CORSAllowOrigins)
+	assert.Equal(t, map[string]string{"CN=approver": "approval-service"}, sut.HTTP.TLS.ClientIdentities)
+	assert.Equal(t, []APIKey{{Name: "approval-service", Hash: "some hash"}}, sut.HTTP.Auth.APIKeys)
+}
+
+func TestConfig_LoadEnv_sensitive_field_from_file(t *testing.T) {
+	//Arrange
+	secretFile := filepath.Join(t.TempDir(), "redis-password")
+	require.NoError(t, os.WriteFile(secretFile, []byte("some password\n"), 0600))
+	t.Setenv("SA_LOADBALANCING_REDIS_PASSWORD_FILE", secretFile)
+
+	sut := Config{}
+	sut.Default()
+
+	//Act
+	err := sut.LoadEnv()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, "some password", sut.LoadBalancing.RedisConfig.Password)
+}
+
+func TestConfig_LoadEnv_file_not_accepted_for_other_fields(t *testing.T) {
+	//Arrange
+	secretFile := filepath.Join(t.TempDir(), "host")
+	require.NoError(t, os.WriteFile(secretFile, []byte("redis.local"), 0600))
+	t.Setenv("SA_LOADBALANCING_REDIS_HOST_FILE", secretFile)
+
+	sut := Config{}
+	sut.Default()
+
+	//Act
+	err := sut.LoadEnv()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, "redis", sut.LoadBalancing.RedisConfig.Host)
+}
+
+func TestConfig_LoadEnv_fails(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "redis-password")
+	require.NoError(t, os.WriteFile(secretFile, []byte("some password"), 0600))
+
+	for _, tc := range []struct {
+		name   string
+		env    map[string]string
+		errMsg string
+	}{
+		{
+			name:   "invalid int",
+			env:    map[string]string{"SA_WEBSOCKET_PINGPERIODSEC": "five"},
+			errMsg: "invalid value of SA_WEBSOCKET_PINGPERIODSEC",
+		},
+		{
+			name:   "unknown field in list",
+			env:    map[string]string{"SA_HTTP_AUTH_HMACKEYS": "[{id: some id, key: some key}]"},
+			errMsg: "invalid value of SA_HTTP_AUTH_HMACKEYS",
+		},
+		{
+			name:   "both value and file",
+			env:    map[string]string{"SA_LOADBALANCING_REDIS_PASSWORD": "other password", "SA_LOADBALANCING_REDIS_PASSWORD_FILE": secretFile},
+			errMsg: "both SA_LOADBALANCING_REDIS_PASSWORD and SA_LOADBALANCING_REDIS_PASSWORD_FILE are set",
+		},
+		{
+			name:   "missing file",
+			env:    map[string]string{"SA_LOADBALANCING_REDIS_PASSWORD_FILE": filepath.Join(t.TempDir(), "missing")},
+			errMsg: "read SA_LOADBALANCING_REDIS_PASSWORD_FILE",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			for name, value := range tc.env {
+				t.Setenv(name, value)
+			}
+			sut := Config{}
+			sut.Default()
+
+			//Act
+			err := sut.LoadEnv()
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+)
+
+// RedactedValue replaces the value of the sensitive settings
+const RedactedValue = "********"
+
+// Redacted returns a copy of the config with the sensitive settings masked, safe to be rendered in any format.
+// The settings are tagged as sensitive with `sensitive:"true"`, empty ones are left empty
+func (c Config) Redacted() Config {
+	return redact(reflect.ValueOf(c), false, maskValue).Interface().(Config)
+}
+
+// Fingerprint returns the hex encoded SHA-256 of the config, so the instances can be compared. The sensitive settings
+// are replaced by their HMAC-SHA256 with the admin fingerprint key, so they can't be guessed from the fingerprint
+// without the key. Without a fingerprint key they are masked, and the secrets aren't compared
+func (c Config) Fingerprint() string {
+	mask := maskValue
+	if len(c.Admin.FingerprintKey) > 0 {
+		key := []byte(c.Admin.FingerprintKey)
+		mask = func(value string) string {
+			mac := hmac.New(sha256.New, key)
+			mac.Write([]byte(value))
+			return hex.EncodeToString(mac.Sum(nil))
+		}
+	}
+
+	b, _ := json.Marshal(redact(reflect.ValueOf(c), false, mask).Interface())
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:])
+}
+
+func maskValue(string) string {
+	return RedactedValue
+}
+
+// redact returns a deep copy of the value, with the sensitive strings replaced by their mask
+func redact(v reflect.Value, sensitive bool, mask func(string) string) reflect.Value {
+	copied := reflect.New(v.Type()).Elem()
+
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			copied.Field(i).Set(redact(v.Field(i), isSensitive(v.Type().Field(i)), mask))
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			return copied
+		}
+		copied.Set(reflect.MakeSlice(v.Type(), v.Len(), v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			copied.Index(i).Set(redact(v.Index(i), sensitive, mask))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			return copied
+		}
+		copied.Set(reflect.MakeMapWithSize(v.Type(), v.Len()))
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), redact(iter.Value(), sensitive, mask))
+		}
+	case reflect.String:
+		if sensitive && v.Len() > 0 {
+			copied.SetString(mask(v.String()))
+		} else {
+			copied.Set(v)
+		}
+	default:
+		copied.Set(v)
+	}
+
+	return copied
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func newSecretConfig() Config {
+	cfg := Config{}
+	cfg.Default()
+	cfg.LoadBalancing.RedisConfig.Password = "redis password"
+	cfg.HTTP.Auth.APIKeys = []APIKey{{Name: "approval-service", Hash: "api key hash"}}
+	cfg.HTTP.Auth.HMACKeys = []HMACKey{{ID: "reporting-service", Secret: "hmac secret"}}
+	cfg.Store.OciConfig.SecretEncryptionKey = "oci encryption key"
+	cfg.Store.OciConfig.ConfigSecret = "oci config secret"
+	cfg.Store.AwsConfig.SecretName = "aws secret name"
+	cfg.Store.GcpConfig.SecretName = "gcp secret name"
+	return cfg
+}
+
+func TestConfig_Redacted_masks_sensitive_settings(t *testing.T) {
+	//Arrange
+	sut := newSecretConfig()
+
+	//Act
+	redacted := sut.Redacted()
+
+	//Assert
+	assert.Equal(t, RedactedValue, redacted.LoadBalancing.RedisConfig.Password)
+	assert.Equal(t, []APIKey{{Name: "approval-service", Hash: RedactedValue}}, redacted.HTTP.Auth.APIKeys)
+	assert.Equal(t, []HMACKey{{ID: "reporting-service", Secret: RedactedValue}}, redacted.HTTP.Auth.HMACKeys)
+	assert.Equal(t, RedactedValue, redacted.Store.OciConfig.SecretEncryptionKey)
+	assert.Equal(t, RedactedValue, redacted.Store.OciConfig.ConfigSecret)
+	assert.Equal(t, RedactedValue, redacted.Store.AwsConfig.SecretName)
+	assert.Equal(t, RedactedValue, redacted.Store.GcpConfig.SecretName)
+	assert.Equal(t, sut.LoadBalancing.RedisConfig.Host, redacted.LoadBalancing.RedisConfig.Host)
+	assert.Equal(t, sut.HTTP.CORSAllowOrigins, redacted.HTTP.CORSAllowOrigins)
+}
+
+func TestConfig_Redacted_leaves_original_untouched(t *testing.T) {
+	//Arrange
+	sut := newSecretConfig()
+
+	//Act
+	redacted := sut.Redacted()
+	redacted.HTTP.CORSAllowOrigins[0] = "https://changed.example.com"
+
+	//Assert
+	assert.Equal(t, newSecretConfig(), sut)
+}
+
+func TestConfig_Redacted_renders_no_secrets(t *testing.T) {
+	//Arrange
+	sut := newSecretConfig()
+	secrets := []string{"redis password", "api key hash", "hmac secret", "oci encryption key", "oci config secret", "aws secret name", "gcp secret name"}
+
+	//Act
+	jsonRendered, jsonErr := json.Marshal(sut.Redacted())
+	yamlRendered, yamlErr := yaml.Marshal(sut.Redacted())
+
+	//Assert
+	require.NoError(t, jsonErr)
+	require.NoError(t, yamlErr)
+	for _, secret := range secrets {
+		assert.NotContains(t, string(jsonRendered), secret)
+		assert.NotContains(t, string(yamlRendered), secret)
+	}
+}
+
+func TestConfig_Redacted_keeps_empty_settings_empty(t *testing.T) {
+	//Arrange
+	sut := Config{}
+	sut.Default()
+
+	//Act
+	redacted := sut.Redacted()
+
+	//Assert
+	assert.Equal(t, sut, redacted)
+}
+
+func TestConfig_Fingerprint(t *testing.T) {
+	//Arrange
+	sut := newSecretConfig()
+	otherSecrets := newSecretConfig()
+	otherSecrets.LoadBalancing.RedisConfig.Password = "other password"
+	otherSettings := newSecretConfig()
+	otherSettings.Logging.Level = "debug"
+
+	//Act
+	fingerprint := sut.Fingerprint()
+
+	//Assert
+	assert.Len(t, fingerprint, 64)
+	assert.Equal(t, fingerprint, newSecretConfig().Fingerprint())
+	assert.Equal(t, fingerprint, otherSecrets.Fingerprint())
+	assert.NotEqual(t, fingerprint, otherSettings.Fingerprint())
+}
+
+func TestConfig_Fingerprint_with_fingerprint_key(t *testing.T) {
+	//Arrange
+	sut := newSecretConfig()
+	sut.Admin.FingerprintKey = "some fingerprint key"
+	sameSecrets := newSecretConfig()
+	sameSecrets.Admin.FingerprintKey = "some fingerprint key"
+	otherSecrets := newSecretConfig()
+	otherSecrets.Admin.FingerprintKey = "some fingerprint key"
+	otherSecrets.LoadBalancing.RedisConfig.Password = "other password"
+	otherKey := newSecretConfig()
+	otherKey.Admin.FingerprintKey = "other fingerprint key"
+
+	//Act
+	fingerprint := sut.Fingerprint()
+
+	//Assert
+	assert.Len(t, fingerprint, 64)
+	assert.Equal(t, fingerprint, sameSecrets.Fingerprint())
+	assert.NotEqual(t, fingerprint, otherSecrets.Fingerprint())
+	assert.NotEqual(t, fingerprint, otherKey.Fingerprint())
+	assert.NotEqual(t, fingerprint, newSecretConfig().Fingerprint())
+	assert.Equal(t, RedactedValue, sut.Redacted().Admin.FingerprintKey)
+}
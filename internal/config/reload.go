@@ -0,0 +1,143 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// liveFields are the settings applied without a restart, by yaml path
+var liveFields = map[string]func(dst *Config, src Config){
+	"logging.level":                    func(dst *Config, src Config) { dst.Logging.Level = src.Logging.Level },
+	"autoApproval.retryIntervalMaxSec": func(dst *Config, src Config) { dst.AutoApprove.RetryIntervalMax = src.AutoApprove.RetryIntervalMax },
+	"autoApproval.retryIntervalSec":    func(dst *Config, src Config) { dst.AutoApprove.RetryInterval = src.AutoApprove.RetryInterval },
+	"websocket.reconnectTimeoutSec":    func(dst *Config, src Config) { dst.Websocket.ReconnectTimeOut = src.Websocket.ReconnectTimeOut },
+	"websocket.reconnectIntervalSec":   func(dst *Config, src Config) { dst.Websocket.ReconnectInterval = src.Websocket.ReconnectInterval },
+	"websocket.pingPeriodSec":          func(dst *Config, src Config) { dst.Websocket.PingPeriod = src.Websocket.PingPeriod },
+	"websocket.pongWaitSec":            func(dst *Config, src Config) { dst.Websocket.PongWait = src.Websocket.PongWait },
+	"websocket.writeWaitSec":           func(dst *Config, src Config) { dst.Websocket.WriteWait = src.Websocket.WriteWait },
+	"feedHub.queueSize":                func(dst *Config, src Config) { dst.FeedHub.QueueSize = src.FeedHub.QueueSize },
+	"feedHub.overflowPolicy":           func(dst *Config, src Config) { dst.FeedHub.OverflowPolicy = src.FeedHub.OverflowPolicy },
+	"feedHub.evictAfterSec":            func(dst *Config, src Config) { dst.FeedHub.EvictAfter = src.FeedHub.EvictAfter },
+	"http.CORSAllowOrigins":            func(dst *Config, src Config) { dst.HTTP.CORSAllowOrigins = src.HTTP.CORSAllowOrigins },
+}
+
+// ReloadResult lists the settings changed in the config file, by yaml path
+type ReloadResult struct {
+	Applied         []string `json:"applied"`
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reloader re-reads the config file and applies the live settings through the registered functions.
+// The changes of the other settings are reported as requiring a restart
+type Reloader struct {
+	lock     sync.Mutex
+	fileName string
+	running  Config
+	appliers []func(cfg Config)
+}
+
+// NewReloader returns a Reloader for the config file the running config was loaded from
+func NewReloader(fileName string, running Config) *Reloader {
+	return &Reloader{
+		fileName: fileName,
+		running:  running,
+	}
+}
+
+// OnReload registers a function called with the running config after live settings were applied
+func (r *Reloader) OnReload(apply func(cfg Config)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.appliers = append(r.appliers, apply)
+}
+
+// Running returns the running config, including the live settings applied so far
+func (r *Reloader) Running() Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.running
+}
+
+// Reload loads the config file with the environment overrides and validates it, then applies the changed live settings.
+// The running config is left untouched if the file can't be loaded or is invalid
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	loaded := Config{}
+	loaded.Default()
+	if err := loaded.Load(r.fileName); err != nil {
+		return nil, err
+	}
+
+	if err := loaded.LoadEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := loaded.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid config")
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	result := &ReloadResult{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+
+	updated := r.running
+	for _, path := range changedFields(r.running, loaded) {
+		if apply, ok := liveFields[path]; ok {
+			apply(&updated, loaded)
+			result.Applied = append(result.Applied, path)
+		} else {
+			result.RestartRequired = append(result.RestartRequired, path)
+		}
+	}
+
+	if len(result.Applied) > 0 {
+		r.running = updated
+		for _, apply := range r.appliers {
+			apply(updated)
+		}
+	}
+
+	return result, nil
+}
+
+// changedFields returns the sorted yaml paths of the settings that differ
+func changedFields(old, new Config) []string {
+	changed := []string{}
+	diff(reflect.ValueOf(old), reflect.ValueOf(new), "", &changed)
+	sort.Strings(changed)
+
+	return changed
+}
+
+func diff(old, new reflect.Value, path string, changed *[]string) {
+	switch old.Kind() {
+	case reflect.Struct:
+	case reflect.Slice, reflect.Map:
+		// a list missing from the file and an empty one are the same setting
+		if old.Len() == 0 && new.Len() == 0 {
+			return
+		}
+		fallthrough
+	default:
+		if !reflect.DeepEqual(old.Interface(), new.Interface()) {
+			*changed = append(*changed, path)
+		}
+		return
+	}
+
+	for i := 0; i < old.NumField(); i++ {
+		fieldPath := yamlName(old.Type().Field(i))
+		if path != "" {
+			fieldPath = path + "." + fieldPath
+		}
+		diff(old.Field(i), new.Field(i), fieldPath, changed)
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig() Config {
+	cfg := Config{}
+	cfg.Default()
+	return cfg
+}
+
+func TestReloader_Reload_applies_live_settings(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.Logging.Level = "debug"
+	reloaded.AutoApprove.RetryInterval = 10
+	reloaded.HTTP.Addr = "127.0.0.1:9000"
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+	var applied []Config
+	sut.OnReload(func(cfg Config) {
+		applied = append(applied, cfg)
+	})
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"autoApproval.retryIntervalSec", "logging.level"}, res.Applied)
+	assert.Equal(t, []string{"http.addr"}, res.RestartRequired)
+
+	require.Len(t, applied, 1)
+	assert.Equal(t, "debug", applied[0].Logging.Level)
+	assert.Equal(t, 10, applied[0].AutoApprove.RetryInterval)
+	assert.Equal(t, running.HTTP.Addr, applied[0].HTTP.Addr)
+	assert.Equal(t, applied[0], sut.Running())
+}
+
+func TestReloader_Reload_unchanged_file(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+	require.NoError(t, running.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+	called := false
+	sut.OnReload(func(cfg Config) {
+		called = true
+	})
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Empty(t, res.Applied)
+	assert.Empty(t, res.RestartRequired)
+	assert.False(t, called)
+}
+
+func TestReloader_Reload_invalid_config_keeps_running_config(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.Logging.Level = "debug"
+	reloaded.Websocket.PongWait = reloaded.Websocket.PingPeriod
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "pongWaitSec greater than pingPeriodSec")
+	assert.Equal(t, running, sut.Running())
+}
+
+func TestReloader_Reload_unreadable_file(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+	require.NoError(t, os.WriteFile(fileName, []byte("logging: ["), 0600))
+
+	sut := NewReloader(fileName, newTestConfig())
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "parse config file")
+}
+
+func TestReloader_Reload_remote_signer_without_socket(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.Signer.Type = "remote"
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "the remote signer needs the socket")
+}
+
+func TestReloader_Reload_signer_change_requires_restart(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.Signer.Type = "remote"
+	reloaded.Signer.Remote.Socket = "/run/signer/signer.sock"
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"signer.remote.socket", "signer.type"}, res.RestartRequired)
+}
+
+func TestReloader_Reload_applies_feed_hub_settings(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.FeedHub.OverflowPolicy = "disconnect"
+	reloaded.FeedHub.EvictAfter = 0
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, []string{"feedHub.evictAfterSec", "feedHub.overflowPolicy"}, res.Applied)
+	assert.Equal(t, reloaded.FeedHub, sut.Running().FeedHub)
+}
+
+func TestReloader_Reload_unsupported_overflow_policy(t *testing.T) {
+	//Arrange
+	running := newTestConfig()
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+
+	reloaded := newTestConfig()
+	reloaded.FeedHub.OverflowPolicy = "block"
+	require.NoError(t, reloaded.Save(fileName))
+
+	sut := NewReloader(fileName, running)
+
+	//Act
+	res, err := sut.Reload()
+
+	//Assert
+	assert.Nil(t, res)
+	assert.ErrorContains(t, err, "unsupported feedHub overflow policy `block`")
+}
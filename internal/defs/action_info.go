@@ -5,10 +5,15 @@ import (
 )
 
 type ActionInfo struct {
-	ID         string   `json:"id"`
-	Status     int      `json:"status"`
-	Messages   [][]byte `json:"messages"`
-	ExpireTime int64    `json:"expireTime"`
+	ID          string   `json:"id"`
+	Status      int      `json:"status"`
+	Messages    [][]byte `json:"messages"`
+	ExpireTime  int64    `json:"expireTime"`
+	Type        string   `json:"type,omitempty"`
+	WorkspaceID string   `json:"workspaceID,omitempty"`
+	Asset       string   `json:"asset,omitempty"`
+	Amount      int64    `json:"amount,omitempty"`
+	Destination string   `json:"destination,omitempty"`
 }
 
 func (a ActionInfo) IsExpired() bool {
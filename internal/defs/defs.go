@@ -1,7 +1,8 @@
 package defs
 
 type RequestContext struct {
-	TraceID string
+	TraceID  string
+	Identity string
 }
 
 const (
@@ -9,10 +9,11 @@ import (
 
 var ErrKVNotFound = errors.New("not found")
 
-func ErrNotFound() *APIError   { return &APIError{code: http.StatusNotFound} }
-func ErrBadRequest() *APIError { return &APIError{code: http.StatusBadRequest} }
-func ErrForbidden() *APIError  { return &APIError{code: http.StatusForbidden} }
-func ErrInternal() *APIError   { return &APIError{code: http.StatusInternalServerError} }
+func ErrNotFound() *APIError     { return &APIError{code: http.StatusNotFound} }
+func ErrBadRequest() *APIError   { return &APIError{code: http.StatusBadRequest} }
+func ErrUnauthorized() *APIError { return &APIError{code: http.StatusUnauthorized} }
+func ErrForbidden() *APIError    { return &APIError{code: http.StatusForbidden} }
+func ErrInternal() *APIError     { return &APIError{code: http.StatusInternalServerError} }
 
 type APIError struct {
 	wrapped error
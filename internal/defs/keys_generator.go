@@ -32,23 +32,19 @@ func HmacSum(timestamp, method, url string, secret, body []byte) ([]byte, error)
 	return mac.Sum(nil), nil
 }
 
+// GenerateKeys returns the base64 encoded public and private keys of a new BLS key and a new EC key
 func GenerateKeys() (string, string, string, string, error) {
-	seed, err := randomBytes(48)
+	blsPublic, blsPriv, err := GenerateBLSKey()
 	if err != nil {
-		return EmptyString, EmptyString, EmptyString, EmptyString, fmt.Errorf("failed to generate seed, err: %v", err)
+		return EmptyString, EmptyString, EmptyString, EmptyString, err
 	}
 
-	blsPublic, blsPriv, err := crypto.BLSKeys(crypto.NewRand(seed), nil)
+	ecPublic, ecPriv, err := GenerateECKey()
 	if err != nil {
-		return EmptyString, EmptyString, EmptyString, EmptyString, fmt.Errorf("failed to generate bls key, err: %v", err)
+		return EmptyString, EmptyString, EmptyString, EmptyString, err
 	}
 
-	hashedSeed := sha256.Sum256(seed)
-	ecPriv, ecPub := btcec.PrivKeyFromBytes(btcec.S256(), hashedSeed[:])
-	ecPubS := ecPub.SerializeUncompressed()
-	ecPrivS := ecPriv.Serialize()
-
-	return encode(blsPublic), encode(blsPriv), encode(ecPubS), encode(ecPrivS), nil
+	return blsPublic, blsPriv, ecPublic, ecPriv, nil
 }
 
 func randomBytes(size int) ([]byte, error) {
@@ -61,3 +57,46 @@ func randomBytes(size int) ([]byte, error) {
 func encode(b []byte) string {
 	return base64.StdEncoding.EncodeToString(b)
 }
+
+// GenerateBLSKey returns the base64 encoded public and private keys of a new BLS key
+func GenerateBLSKey() (string, string, error) {
+	seed, err := randomBytes(48)
+	if err != nil {
+		return EmptyString, EmptyString, fmt.Errorf("failed to generate seed, err: %v", err)
+	}
+
+	blsPublic, blsPriv, err := crypto.BLSKeys(crypto.NewRand(seed), nil)
+	if err != nil {
+		return EmptyString, EmptyString, fmt.Errorf("failed to generate bls key, err: %v", err)
+	}
+
+	return encode(blsPublic), encode(blsPriv), nil
+}
+
+// GenerateECKey returns the base64 encoded public and private keys of a new EC key
+func GenerateECKey() (string, string, error) {
+	seed, err := randomBytes(48)
+	if err != nil {
+		return EmptyString, EmptyString, fmt.Errorf("failed to generate seed, err: %v", err)
+	}
+
+	hashedSeed := sha256.Sum256(seed)
+	ecPriv, ecPub := btcec.PrivKeyFromBytes(btcec.S256(), hashedSeed[:])
+
+	return encode(ecPub.SerializeUncompressed()), encode(ecPriv.Serialize()), nil
+}
+
+// BLSPublicKey returns the base64 encoded public key of the base64 encoded BLS private key
+func BLSPublicKey(blsPrivateKey string) (string, error) {
+	blsPriv, err := base64.StdEncoding.DecodeString(blsPrivateKey)
+	if err != nil || len(blsPriv) == 0 {
+		return EmptyString, fmt.Errorf("invalid bls key")
+	}
+
+	blsPublic, _, err := crypto.BLSKeys(nil, blsPriv)
+	if err != nil {
+		return EmptyString, fmt.Errorf("failed to derive the bls public key, err: %v", err)
+	}
+
+	return encode(blsPublic), nil
+}
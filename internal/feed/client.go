@@ -1,9 +1,14 @@
 // Package clientfeed provides functionality to register to a feed hub to receive bytes data as TextMessage
 // The received data is being then written also as a TextMessage to an open websocket connection.
+// The client can send approve and reject commands on the connection, each one is acknowledged on it once executed.
 
 package feed
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
 	"sync"
 	"time"
 
@@ -18,6 +23,45 @@ import (
 // UnregisterFunc is used by the ClientFeed to unregister itself from the feed hub. Upon its request, the Feed channel will be closed and no data will be received
 type UnregisterFunc func(client *hub.HubFeedClient)
 
+// The ops of the commands sent by the clients on the feed connection
+const (
+	OpApprove = "approve"
+	OpReject  = "reject"
+)
+
+// The statuses of the acknowledgements
+const (
+	AckApproved = "approved"
+	AckRejected = "rejected"
+	AckFailed   = "failed"
+)
+
+// Command is sent by the client on the feed connection to approve or reject an action.
+// The ID is chosen by the client to correlate the acknowledgement, it's echoed back
+type Command struct {
+	ID       string `json:"id,omitempty"`
+	Op       string `json:"op"`
+	ActionID string `json:"actionID"`
+}
+
+// Ack is the acknowledgement of a command, written on the feed connection once the command is executed.
+// The Ack field is the op of the command, the code and the error are set when it failed
+type Ack struct {
+	Ack      string `json:"ack"`
+	ID       string `json:"id,omitempty"`
+	ActionID string `json:"actionID"`
+	Status   string `json:"status"`
+	Code     int    `json:"code,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// maxCommands is how many commands of a connection are executed at once, the commands received meanwhile
+// are acknowledged as failed with the 429 code
+const maxCommands = 8
+
+// CommandHandler executes the command received on the feed connection
+type CommandHandler func(cmd Command) error
+
 // ClientFeed is a client recieving messages from a feeb hub it's registered to
 type ClientFeed interface {
 	Start(wg *sync.WaitGroup)
@@ -35,11 +79,18 @@ type clientFeedImpl struct {
 	pingPeriod time.Duration
 	readyState string
 	unregister UnregisterFunc
+
+	writeLock     sync.Mutex
+	handleCommand CommandHandler
+	commandSlots  chan struct{}
+	readDone      chan struct{}
 }
 
 // NewClientFeed returns a new ClientFeed which is an instance of ClientFeedImpl initialized with the provided parameters
 // ClientFeed has an external FeedClient which means it can unregister itself from the feed hub to stop receiving data
-func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister UnregisterFunc, config config.WebSocketConfig) ClientFeed {
+// If handleCommand is set, the commands sent by the client on the connection are executed and acknowledged
+func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister UnregisterFunc, config config.WebSocketConfig,
+	handleCommand CommandHandler) ClientFeed {
 	return &clientFeedImpl{
 		HubFeedClient: hub.NewHubFeedClient(false),
 		conn:          conn,
@@ -50,6 +101,9 @@ func NewClientFeed(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregis
 		pingPeriod:    time.Duration(config.PingPeriod) * time.Second,
 		readyState:    defs.ConnectionState.Open,
 		unregister:    unregister,
+		handleCommand: handleCommand,
+		commandSlots:  make(chan struct{}, maxCommands),
+		readDone:      make(chan struct{}),
 	}
 }
 
@@ -72,6 +126,9 @@ func (c *clientFeedImpl) Start(wg *sync.WaitGroup) {
 	}()
 
 	c.setHandlers()
+	if c.handleCommand != nil {
+		go c.readCommands()
+	}
 	wg.Done() //notify the caller everything is properly set up and ready to receive/send messages
 
 	for {
@@ -86,6 +143,13 @@ func (c *clientFeedImpl) Start(wg *sync.WaitGroup) {
 				c.unregister(&c.HubFeedClient)
 				return
 			}
+		case <-c.readDone:
+			c.log.Debug("ClientFeed - websocket connection closed by the client")
+			c.readyState = defs.ConnectionState.Closed
+
+			//must also unregister from the feed hub to stop receiving messages
+			c.unregister(&c.HubFeedClient)
+			return
 		case <-c.closeConn:
 			c.log.Debug("ClientFeed - closing websocket connection")
 			if err := c.conn.WriteControl(websocket.CloseMessage, []byte{}, time.Now().Add(c.writeWait)); err != nil {
@@ -108,7 +172,7 @@ func (c *clientFeedImpl) Listen(wg *sync.WaitGroup) {
 			}
 			return
 		} else {
-			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+			if err := c.write(message); err != nil {
 				c.log.Errorf("ClientFeed: error while writing data to websocket conn:%v", err)
 			}
 		}
@@ -117,11 +181,7 @@ func (c *clientFeedImpl) Listen(wg *sync.WaitGroup) {
 
 func (c *clientFeedImpl) setHandlers() {
 	c.conn.SetPongHandler(func(message string) error {
-		if err := c.conn.SetReadDeadline(time.Now().Add(c.pongWait)); err != nil {
-			return err
-		}
-
-		return c.conn.WriteControl(websocket.PingMessage, []byte(message), time.Now().Add(c.writeWait))
+		return c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
 	})
 
 	c.conn.SetPingHandler(func(message string) error {
@@ -132,3 +192,114 @@ func (c *clientFeedImpl) setHandlers() {
 		return c.conn.WriteControl(websocket.PongMessage, []byte(message), time.Now().Add(c.writeWait))
 	})
 }
+
+// write writes the text message, the feed messages and the acknowledgements are written concurrently
+func (c *clientFeedImpl) write(message []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.conn.WriteMessage(websocket.TextMessage, message)
+}
+
+// readCommands reads the commands sent by the client and executes them concurrently, up to maxCommands, so the control
+// messages are still read meanwhile. It stops when the connection is closed, once the commands in progress are acknowledged
+func (c *clientFeedImpl) readCommands() {
+	var commands sync.WaitGroup
+	defer func() {
+		commands.Wait()
+		close(c.readDone)
+	}()
+
+	_ = c.conn.SetReadDeadline(time.Now().Add(c.pongWait)) // result is always nil
+	for {
+		_, message, err := c.conn.ReadMessage()
+		if err != nil {
+			c.log.Debugf("ClientFeed: stopped reading the websocket conn, err: %v", err)
+			return
+		}
+
+		select {
+		case c.commandSlots <- struct{}{}:
+		default:
+			c.acknowledge(tooManyCommands(message))
+			continue
+		}
+
+		commands.Add(1)
+		go func() {
+			defer func() {
+				<-c.commandSlots
+				commands.Done()
+			}()
+			c.acknowledge(c.execute(message))
+		}()
+	}
+}
+
+// execute parses and executes the command, it returns the acknowledgement
+func (c *clientFeedImpl) execute(message []byte) Ack {
+	cmd := Command{}
+	if err := json.Unmarshal(message, &cmd); err != nil {
+		return Ack{Status: AckFailed, Code: http.StatusBadRequest, Error: "invalid command"}
+	}
+
+	ack := Ack{
+		Ack:      cmd.Op,
+		ID:       cmd.ID,
+		ActionID: cmd.ActionID,
+	}
+
+	switch {
+	case cmd.Op != OpApprove && cmd.Op != OpReject:
+		ack.Status, ack.Code, ack.Error = AckFailed, http.StatusBadRequest, fmt.Sprintf("unsupported op `%s`", cmd.Op)
+	case cmd.ActionID == defs.EmptyString:
+		ack.Status, ack.Code, ack.Error = AckFailed, http.StatusBadRequest, "empty actionID"
+	default:
+		if err := c.handleCommand(cmd); err != nil {
+			ack.Status = AckFailed
+			var apiErr *defs.APIError
+			if errors.As(err, &apiErr) {
+				ack.Code, ack.Error = apiErr.APIError()
+			} else {
+				ack.Code, ack.Error = http.StatusInternalServerError, err.Error()
+			}
+		} else if cmd.Op == OpReject {
+			ack.Status = AckRejected
+		} else {
+			ack.Status = AckApproved
+		}
+	}
+
+	return ack
+}
+
+// tooManyCommands returns the failed acknowledgement of the command received while maxCommands are in progress
+func tooManyCommands(message []byte) Ack {
+	cmd := Command{}
+	_ = json.Unmarshal(message, &cmd) // the fields of an invalid command are left empty
+
+	return Ack{
+		Ack:      cmd.Op,
+		ID:       cmd.ID,
+		ActionID: cmd.ActionID,
+		Status:   AckFailed,
+		Code:     http.StatusTooManyRequests,
+		Error:    "too many commands in progress",
+	}
+}
+
+// acknowledge writes the acknowledgement, in a command.ack Envelope for the v2 clients
+func (c *clientFeedImpl) acknowledge(ack Ack) {
+	data, _ := json.Marshal(ack)
+	if c.Version == hub.FeedVersion2 {
+		var err error
+		if data, err = hub.NewEnvelope(hub.EventCommandAck, data); err != nil {
+			c.log.Errorf("ClientFeed: failed to wrap the acknowledgement of action `%s`, err: %v", ack.ActionID, err)
+			return
+		}
+	}
+
+	if err := c.write(data); err != nil {
+		c.log.Errorf("ClientFeed: error while writing the acknowledgement of action `%s` to websocket conn:%v", ack.ActionID, err)
+	}
+}
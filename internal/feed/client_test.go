@@ -1,7 +1,9 @@
 package feed
 
 import (
+	"encoding/json"
 	"errors"
+	"net/http"
 	"sync"
 	"testing"
 	"time"
@@ -18,9 +20,48 @@ import (
 
 var ignoreOpenCensus = goleak.IgnoreTopFunction("go.opencensus.io/stats/view.(*worker).start")
 
+// commandConn is a websocket connection the client sends the commands on, it records the written messages.
+// Closing the commands channel closes the connection
+type commandConn struct {
+	hub.MockWebsocketConnection
+	commands chan []byte
+	lock     sync.Mutex
+	written  [][]byte
+}
+
+func (c *commandConn) ReadMessage() (int, []byte, error) {
+	if command, ok := <-c.commands; ok {
+		return websocket.TextMessage, command, nil
+	}
+
+	return 0, nil, errors.New("connection closed")
+}
+
+func (c *commandConn) WriteMessage(messageType int, data []byte) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.written = append(c.written, data)
+	return nil
+}
+
+func (c *commandConn) acks(t *testing.T) []Ack {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	acks := []Ack{}
+	for _, data := range c.written {
+		ack := Ack{}
+		assert.Nil(t, json.Unmarshal(data, &ack))
+		acks = append(acks, ack)
+	}
+
+	return acks
+}
+
 func TestClientFeedImpl_GetFeedClient(t *testing.T) {
 	//Arrange
-	sut := NewClientFeed(nil, nil, nil, config.WebSocketConfig{})
+	sut := NewClientFeed(nil, nil, nil, config.WebSocketConfig{}, nil)
 
 	//Act
 	res := sut.GetFeedClient()
@@ -46,7 +87,7 @@ func TestClientFeedImpl_Start_unregisters_the_client(t *testing.T) {
 		PingPeriod: 2,
 		PongWait:   2,
 		WriteWait:  2,
-	})
+	}, nil)
 	var wg sync.WaitGroup
 	wg.Add(1)
 
@@ -126,3 +167,136 @@ func TestClientFeedImpl_Listen_closes_connection(t *testing.T) {
 	assert.Equal(t, websocket.CloseMessage, mockConn.LastMessageType)
 	assert.Equal(t, defs.ConnectionState.Closed, sut.readyState)
 }
+
+func TestClientFeedImpl_acknowledges_the_commands(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	conn := &commandConn{
+		commands: make(chan []byte, 5),
+	}
+	var lastUnregisteredClient *hub.HubFeedClient
+	unregister := func(client *hub.HubFeedClient) {
+		lastUnregisteredClient = client
+	}
+	var lock sync.Mutex
+	executed := []Command{}
+	handleCommand := func(cmd Command) error {
+		lock.Lock()
+		defer lock.Unlock()
+
+		executed = append(executed, cmd)
+		if cmd.ActionID == "unknown" {
+			return defs.ErrNotFound().WithDetail("action not found")
+		}
+		return nil
+	}
+	sut := NewClientFeed(conn, util.NewTestLogger(), unregister, config.WebSocketConfig{
+		PingPeriod: 60,
+		PongWait:   70,
+		WriteWait:  2,
+	}, handleCommand)
+
+	conn.commands <- []byte(`{"id":"c1","op":"approve","actionID":"action1"}`)
+	conn.commands <- []byte(`{"id":"c2","op":"reject","actionID":"unknown"}`)
+	conn.commands <- []byte(`{"id":"c3","op":"sign","actionID":"action1"}`)
+	conn.commands <- []byte(`{"id":"c4","op":"reject"}`)
+	conn.commands <- []byte(`not a command`)
+	close(conn.commands)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	//Act
+	sut.Start(&wg)
+
+	//Assert
+	assert.ElementsMatch(t, []Ack{
+		{Ack: OpApprove, ID: "c1", ActionID: "action1", Status: AckApproved},
+		{Ack: OpReject, ID: "c2", ActionID: "unknown", Status: AckFailed, Code: http.StatusNotFound, Error: "action not found"},
+		{Ack: "sign", ID: "c3", ActionID: "action1", Status: AckFailed, Code: http.StatusBadRequest, Error: "unsupported op `sign`"},
+		{Ack: OpReject, ID: "c4", Status: AckFailed, Code: http.StatusBadRequest, Error: "empty actionID"},
+		{Status: AckFailed, Code: http.StatusBadRequest, Error: "invalid command"},
+	}, conn.acks(t))
+	assert.ElementsMatch(t, []Command{
+		{ID: "c1", Op: OpApprove, ActionID: "action1"},
+		{ID: "c2", Op: OpReject, ActionID: "unknown"},
+	}, executed)
+	assert.True(t, conn.SetReadDeadlineCalled)
+	assert.True(t, conn.CloseCalled)
+	assert.Equal(t, sut.GetFeedClient(), lastUnregisteredClient)
+}
+
+func TestClientFeedImpl_wraps_the_acknowledgements_for_v2_clients(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	conn := &commandConn{
+		commands: make(chan []byte, 1),
+	}
+	sut := NewClientFeed(conn, util.NewTestLogger(), func(client *hub.HubFeedClient) {}, config.WebSocketConfig{
+		PingPeriod: 60,
+		PongWait:   70,
+		WriteWait:  2,
+	}, func(cmd Command) error { return nil })
+	sut.GetFeedClient().Version = hub.FeedVersion2
+
+	conn.commands <- []byte(`{"id":"c1","op":"approve","actionID":"action1"}`)
+	close(conn.commands)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	//Act
+	sut.Start(&wg)
+
+	//Assert
+	assert.Len(t, conn.written, 1)
+	envelope := hub.Envelope{}
+	assert.Nil(t, json.Unmarshal(conn.written[0], &envelope))
+	assert.Equal(t, hub.EventCommandAck, envelope.Type)
+	assert.Equal(t, hub.FeedVersion2, envelope.Version)
+	assert.JSONEq(t, `{"ack":"approve","id":"c1","actionID":"action1","status":"approved"}`, string(envelope.Payload))
+}
+
+func TestClientFeedImpl_refuses_the_commands_past_the_max_in_progress(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	conn := &commandConn{
+		commands: make(chan []byte, maxCommands+1),
+	}
+	release := make(chan struct{})
+	sut := NewClientFeed(conn, util.NewTestLogger(), func(client *hub.HubFeedClient) {}, config.WebSocketConfig{
+		PingPeriod: 60,
+		PongWait:   70,
+		WriteWait:  2,
+	}, func(cmd Command) error {
+		<-release
+		return nil
+	})
+
+	for i := 0; i < maxCommands; i++ {
+		conn.commands <- []byte(`{"id":"c1","op":"approve","actionID":"action1"}`)
+	}
+	conn.commands <- []byte(`{"id":"c2","op":"reject","actionID":"action2"}`)
+	close(conn.commands)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	done := make(chan struct{})
+
+	//Act
+	go func() {
+		sut.Start(&wg)
+		close(done)
+	}()
+
+	//Assert
+	assert.Eventually(t, func() bool {
+		return len(conn.acks(t)) == 1
+	}, 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, Ack{Ack: OpReject, ID: "c2", ActionID: "action2", Status: AckFailed, Code: http.StatusTooManyRequests,
+		Error: "too many commands in progress"}, conn.acks(t)[0])
+
+	close(release)
+	<-done
+	assert.Len(t, conn.acks(t), maxCommands+1)
+}
@@ -0,0 +1,162 @@
+package feed
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/hub"
+)
+
+// sseEventAction is the type of the events carrying the action messages
+const sseEventAction = "action"
+
+type sseClientFeedImpl struct {
+	hub.HubFeedClient
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	writeLock  sync.Mutex
+	closed     <-chan struct{}
+	listenDone chan struct{}
+	log        *zap.SugaredLogger
+	keepAlive  time.Duration
+	unregister UnregisterFunc
+}
+
+// NewSSEClientFeed returns a new ClientFeed streaming the received messages as Server-Sent Events on the response of the request.
+// The response headers are written, the stream stays open until the client disconnects or the Feed channel is closed.
+// Unlike the websocket ClientFeed, Start blocks the request handler for as long as the stream is open
+func NewSSEClientFeed(w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger, unregister UnregisterFunc, config config.WebSocketConfig) (ClientFeed, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("streaming not supported")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // no proxy buffering of the stream
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return &sseClientFeedImpl{
+		HubFeedClient: hub.NewHubFeedClient(false),
+		w:             w,
+		flusher:       flusher,
+		closed:        r.Context().Done(),
+		listenDone:    make(chan struct{}),
+		log:           log,
+		keepAlive:     time.Duration(config.PingPeriod) * time.Second,
+		unregister:    unregister,
+	}, nil
+}
+
+// GetFeedClient returns the internal FeedClient structure used to register itself to the feed hub in order to start receiving data on the Feed channel
+func (c *sseClientFeedImpl) GetFeedClient() *hub.HubFeedClient {
+	return &c.HubFeedClient
+}
+
+// Start is sending the keep-alive comments until the client disconnects or the Feed channel is closed.
+// When the client disconnects, it unregisters from the feed hub and waits for Listen to return, so nothing is written once it returns
+func (c *sseClientFeedImpl) Start(wg *sync.WaitGroup) {
+	c.log.Debug("SSEClientFeed - starting the client feed")
+
+	ticker := time.NewTicker(c.keepAlive)
+	defer ticker.Stop()
+
+	wg.Done()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.write([]byte(": keep-alive\n\n")); err != nil {
+				c.log.Errorf("SSEClientFeed - failed to send keep-alive, err: %v", err)
+			}
+		case <-c.closed:
+			c.log.Debug("SSEClientFeed - client disconnected")
+			c.unregister(&c.HubFeedClient)
+			<-c.listenDone
+			return
+		case <-c.listenDone:
+			return
+		}
+	}
+}
+
+// Listen is receiving data on the Feed channel and writes them as events to the stream
+func (c *sseClientFeedImpl) Listen(wg *sync.WaitGroup) {
+	defer close(c.listenDone)
+
+	wg.Done()
+	for {
+		if message, ok := <-c.Feed; !ok {
+			c.log.Debug("SSEClientFeed: client feed channel was closed")
+			return
+		} else {
+			event := formatEvent(message)
+			if c.Version == hub.FeedVersion2 {
+				event = formatEnvelope(message)
+			}
+
+			if err := c.write(event); err != nil {
+				c.log.Errorf("SSEClientFeed: error while writing data to the stream: %v", err)
+			}
+		}
+	}
+}
+
+func (c *sseClientFeedImpl) write(data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if _, err := c.w.Write(data); err != nil {
+		return err
+	}
+
+	c.flusher.Flush()
+	return nil
+}
+
+// formatEvent returns the action event of the message, the event ID is the action ID
+func formatEvent(message []byte) []byte {
+	action := defs.ActionInfo{}
+	if err := json.Unmarshal(message, &action); err != nil {
+		action.ID = ""
+	}
+
+	return writeEvent(action.ID, sseEventAction, message)
+}
+
+// formatEnvelope returns the event of the v2 Envelope, named after its type, the event ID is the envelope ID
+func formatEnvelope(message []byte) []byte {
+	envelope := hub.Envelope{}
+	if err := json.Unmarshal(message, &envelope); err != nil || len(envelope.Type) == 0 {
+		return formatEvent(message)
+	}
+
+	return writeEvent(envelope.ID, envelope.Type, message)
+}
+
+func writeEvent(id, eventType string, message []byte) []byte {
+	var event bytes.Buffer
+
+	if len(id) > 0 {
+		fmt.Fprintf(&event, "id: %s\n", id)
+	}
+
+	fmt.Fprintf(&event, "event: %s\n", eventType)
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		fmt.Fprintf(&event, "data: %s\n", line)
+	}
+	event.WriteString("\n")
+
+	return event.Bytes()
+}
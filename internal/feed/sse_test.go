@@ -0,0 +1,103 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/goleak"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+type notFlushingWriter struct {
+	http.ResponseWriter
+}
+
+func TestNewSSEClientFeed_streaming_not_supported(t *testing.T) {
+	//Arrange
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/client/feed/sse", nil)
+
+	//Act
+	res, err := NewSSEClientFeed(notFlushingWriter{httptest.NewRecorder()}, req, util.NewTestLogger(), nil, config.WebSocketConfig{})
+
+	//Assert
+	assert.Nil(t, res)
+	assert.EqualError(t, err, "streaming not supported")
+}
+
+func TestSSEClientFeed_streams_the_messages_until_the_feed_is_closed(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/client/feed/sse", nil)
+	sut, _ := NewSSEClientFeed(rr, req, util.NewTestLogger(), nil, config.WebSocketConfig{PingPeriod: 5})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go sut.Listen(&wg)
+	go sut.Start(&wg)
+	wg.Wait()
+
+	//Act
+	sut.GetFeedClient().Feed <- []byte(`{"id":"action1","status":1}`)
+	sut.GetFeedClient().Feed <- []byte("not an action")
+	close(sut.GetFeedClient().Feed)
+	<-sut.(*sseClientFeedImpl).listenDone
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "text/event-stream", rr.Header().Get("Content-Type"))
+	assert.Equal(t, "no-cache", rr.Header().Get("Cache-Control"))
+	assert.Equal(t, "id: action1\nevent: action\ndata: {\"id\":\"action1\",\"status\":1}\n\n"+
+		"event: action\ndata: not an action\n\n", rr.Body.String())
+}
+
+func TestSSEClientFeed_Start_unregisters_the_disconnected_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	ctx, cancel := context.WithCancel(context.Background())
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/client/feed/sse", nil).WithContext(ctx)
+	var lastUnregisteredClient *hub.HubFeedClient
+	unregister := func(client *hub.HubFeedClient) {
+		lastUnregisteredClient = client
+		close(client.Feed)
+	}
+	sut, _ := NewSSEClientFeed(httptest.NewRecorder(), req, util.NewTestLogger(), unregister, config.WebSocketConfig{PingPeriod: 5})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go sut.Listen(&wg)
+
+	//Act
+	cancel()
+	sut.Start(&wg)
+
+	//Assert
+	assert.Equal(t, sut.GetFeedClient(), lastUnregisteredClient)
+	assert.False(t, sut.GetFeedClient().IsInternal)
+}
+
+func TestFormatEvent_multiline_message(t *testing.T) {
+	//Act
+	res := formatEvent([]byte("line 1\nline 2"))
+
+	//Assert
+	assert.Equal(t, "event: action\ndata: line 1\ndata: line 2\n\n", string(res))
+}
+
+func TestFormatEnvelope(t *testing.T) {
+	//Arrange
+	envelope := []byte(`{"type":"connection.state","version":2,"id":"event1","payload":{"readyState":"OPEN"}}`)
+
+	//Act
+	res := formatEnvelope(envelope)
+
+	//Assert
+	assert.Equal(t, "id: event1\nevent: connection.state\ndata: "+string(envelope)+"\n\n", string(res))
+}
@@ -0,0 +1,62 @@
+package feed
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/qredo/signing-agent/internal/hub"
+)
+
+// The websocket subprotocols selecting the version of the feed protocol
+const (
+	SubprotocolV1 = "signing-agent.v1"
+	SubprotocolV2 = "signing-agent.v2"
+)
+
+// VersionParam is the query parameter selecting the version of the feed protocol, it takes precedence over the subprotocol
+const VersionParam = "version"
+
+// RequestedVersion returns the version of the feed protocol requested by the client, hub.FeedVersion1 if none is requested.
+// The version is selected by the query parameter or, for the websocket feed, by the first known subprotocol requested
+func RequestedVersion(r *http.Request) (int, error) {
+	if value := r.URL.Query().Get(VersionParam); len(value) > 0 {
+		switch value {
+		case "1":
+			return hub.FeedVersion1, nil
+		case "2":
+			return hub.FeedVersion2, nil
+		default:
+			return 0, fmt.Errorf("unsupported feed version `%s`", value)
+		}
+	}
+
+	for _, protocol := range websocket.Subprotocols(r) {
+		switch protocol {
+		case SubprotocolV1:
+			return hub.FeedVersion1, nil
+		case SubprotocolV2:
+			return hub.FeedVersion2, nil
+		}
+	}
+
+	return hub.FeedVersion1, nil
+}
+
+// Subprotocol returns the subprotocol of the version to answer the websocket handshake with,
+// empty if the client didn't request it
+func Subprotocol(r *http.Request, version int) string {
+	protocol := SubprotocolV1
+	if version == hub.FeedVersion2 {
+		protocol = SubprotocolV2
+	}
+
+	for _, requested := range websocket.Subprotocols(r) {
+		if requested == protocol {
+			return protocol
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,57 @@
+package feed
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/internal/hub"
+)
+
+func TestRequestedVersion(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		subprotocols string
+		wantVersion  int
+		wantError    string
+	}{
+		{"default", "/client/feed", "", hub.FeedVersion1, ""},
+		{"query v2", "/client/feed?version=2", "", hub.FeedVersion2, ""},
+		{"subprotocol v2", "/client/feed", "chat, " + SubprotocolV2, hub.FeedVersion2, ""},
+		{"query over subprotocol", "/client/feed?version=1", SubprotocolV2, hub.FeedVersion1, ""},
+		{"unsupported", "/client/feed?version=3", "", 0, "unsupported feed version `3`"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//Arrange
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if len(tt.subprotocols) > 0 {
+				req.Header.Set("Sec-Websocket-Protocol", tt.subprotocols)
+			}
+
+			//Act
+			res, err := RequestedVersion(req)
+
+			//Assert
+			assert.Equal(t, tt.wantVersion, res)
+			if len(tt.wantError) > 0 {
+				assert.EqualError(t, err, tt.wantError)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSubprotocol(t *testing.T) {
+	//Arrange
+	req := httptest.NewRequest("GET", "/client/feed?version=1", nil)
+	req.Header.Set("Sec-Websocket-Protocol", SubprotocolV2)
+
+	//Act & Assert
+	assert.Equal(t, SubprotocolV2, Subprotocol(req, hub.FeedVersion2))
+	assert.Empty(t, Subprotocol(req, hub.FeedVersion1))
+}
@@ -0,0 +1,99 @@
+package hub
+
+import (
+	"time"
+)
+
+// the policies applied when the queue of a feed client is full
+const (
+	OverflowDropOldest = "dropOldest"
+	OverflowDropNewest = "dropNewest"
+	OverflowDisconnect = "disconnect"
+)
+
+// clientQueue is the bounded queue of the messages to a feed client. The hub queues the messages without blocking,
+// they are forwarded to the client Feed channel at the pace of the client
+type clientQueue struct {
+	feed      chan []byte
+	messages  chan []byte
+	done      chan struct{}
+	stopped   chan struct{}
+	fullSince time.Time
+}
+
+// newClientQueue returns the queue of size messages to the feed channel and starts forwarding them
+func newClientQueue(feed chan []byte, size int) *clientQueue {
+	if size < 1 {
+		size = 1
+	}
+
+	q := &clientQueue{
+		feed:     feed,
+		messages: make(chan []byte, size),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+
+	go q.forward()
+	return q
+}
+
+// push queues the message, it returns false if the queue is full
+func (q *clientQueue) push(message []byte) bool {
+	select {
+	case q.messages <- message:
+		q.fullSince = time.Time{}
+		return true
+	default:
+		if q.fullSince.IsZero() {
+			q.fullSince = time.Now()
+		}
+		return false
+	}
+}
+
+// replaceOldest drops the oldest queued message to queue the message.
+// Only the hub queues messages, so there is room once the oldest is dropped
+func (q *clientQueue) replaceOldest(message []byte) {
+	select {
+	case <-q.messages:
+	default:
+	}
+
+	q.messages <- message
+}
+
+// fullFor returns how long the queue has been full, 0 if it's not
+func (q *clientQueue) fullFor() time.Duration {
+	if q.fullSince.IsZero() {
+		return 0
+	}
+
+	return time.Since(q.fullSince)
+}
+
+// close stops forwarding, the queued messages are dropped. The Feed channel is closed once it returns
+func (q *clientQueue) close() {
+	close(q.done)
+	<-q.stopped
+}
+
+func (q *clientQueue) forward() {
+	defer func() {
+		close(q.feed)
+		close(q.stopped)
+	}()
+
+	for {
+		select {
+		case message := <-q.messages:
+			select {
+			case q.feed <- message:
+			case <-q.done:
+				return
+			}
+		case <-q.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package hub
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The versions of the feed protocol. Version 1 forwards the actions as received from upstream,
+// version 2 wraps them in an Envelope and adds the events originated by the agent
+const (
+	FeedVersion1 = 1
+	FeedVersion2 = 2
+)
+
+// The types of the v2 events
+const (
+	EventAction          = "action"              // an action received from upstream
+	EventActionReplay    = "action.replay"       // a cached action replayed when the client registers
+	EventConnectionState = "connection.state"    // the ready state of the upstream connection changed
+	EventAutoApproval    = "autoapproval.result" // the outcome of an automatic decision
+	EventCommandAck      = "command.ack"         // the acknowledgement of a command sent on the websocket feed
+)
+
+// Envelope is the typed event sent to the v2 feed clients
+type Envelope struct {
+	Type      string          `json:"type"`
+	Version   int             `json:"version"`
+	ID        string          `json:"id"`
+	Timestamp time.Time       `json:"timestamp"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// ConnectionStateEvent is the payload of the connection.state events
+type ConnectionStateEvent struct {
+	ReadyState    string `json:"readyState"`
+	RemoteFeedUrl string `json:"remoteFeedURL"`
+}
+
+// EventPublisher sends the events originated by the agent to the v2 feed clients
+type EventPublisher interface {
+	// PublishEvent sends the payload, marshalled to JSON, in an Envelope of the eventType
+	PublishEvent(eventType string, payload any)
+}
+
+// NewEnvelope returns the marshalled Envelope of the payload with a new ID.
+// A payload that isn't valid JSON, as an upstream message might be, is sent as a JSON string
+func NewEnvelope(eventType string, payload []byte) ([]byte, error) {
+	if !json.Valid(payload) {
+		var err error
+		if payload, err = json.Marshal(string(payload)); err != nil {
+			return nil, err
+		}
+	}
+
+	return json.Marshal(Envelope{
+		Type:      eventType,
+		Version:   FeedVersion2,
+		ID:        uuid.NewString(),
+		Timestamp: time.Now().UTC(),
+		Payload:   payload,
+	})
+}
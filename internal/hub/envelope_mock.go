@@ -0,0 +1,13 @@
+package hub
+
+type MockEventPublisher struct {
+	PublishEventCalled bool
+	LastEventType      string
+	LastPayload        any
+}
+
+func (m *MockEventPublisher) PublishEvent(eventType string, payload any) {
+	m.PublishEventCalled = true
+	m.LastEventType = eventType
+	m.LastPayload = payload
+}
@@ -1,18 +1,25 @@
 package hub
 
 import (
+	"encoding/json"
 	"sync"
+	"time"
 
 	"go.uber.org/zap"
 
 	"github.com/qredo/signing-agent/internal/api"
+	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/metrics"
 )
 
 type HubFeedClient struct {
 	Feed       chan []byte
 	IsInternal bool
+	// Version is the feed protocol of the client, the FeedVersion2 clients receive the Envelope of the actions and the agent events.
+	// It must be set before the client registers, the zero value is FeedVersion1
+	Version int
 }
 
 func NewHubFeedClient(isInternal bool) HubFeedClient {
@@ -24,41 +31,51 @@ func NewHubFeedClient(isInternal bool) HubFeedClient {
 
 // FeedHub maintains the set of active clients
 // It provides ways to register and unregister clients
-// Broadcasts messages from the source to all active clients
+// Broadcasts messages from the source to all active clients, through a bounded queue per client
+// The events published are sent to the v2 clients only, along with the changes of the source ready state
 type FeedHub interface {
+	EventPublisher
 	Run() bool
 	Stop()
 	RegisterClient(client *HubFeedClient)
 	UnregisterClient(client *HubFeedClient)
 	IsRunning() bool
 	GetWebsocketStatus() api.WebsocketStatus
+	// SetConfig changes the overflow policy and the eviction delay, the queue size applies to the clients registered afterwards
+	SetConfig(cfg config.FeedHubConfig)
 }
 
 type feedHubImpl struct {
 	source    Source
 	broadcast chan []byte
-	clients   map[*HubFeedClient]bool
+	clients   map[*HubFeedClient]*clientQueue
+	cfg       config.FeedHubConfig
 
 	register   chan *HubFeedClient
 	unregister chan *HubFeedClient
 	log        *zap.SugaredLogger
 	lock       sync.RWMutex
 	isRunning  bool
+	done       chan struct{} // closed when the hub stopped and closed the clients
 
 	messageCache message.Cache
 }
 
 // NewFeedHub returns a FeedHub object that's an instance of FeedHubImpl
-func NewFeedHub(source Source, log *zap.SugaredLogger, messageCache message.Cache) FeedHub {
-	return &feedHubImpl{
+func NewFeedHub(source Source, log *zap.SugaredLogger, messageCache message.Cache, cfg config.FeedHubConfig) FeedHub {
+	feedHub := &feedHubImpl{
 		source:       source,
 		log:          log,
-		clients:      make(map[*HubFeedClient]bool),
+		cfg:          cfg,
+		clients:      make(map[*HubFeedClient]*clientQueue),
 		register:     make(chan *HubFeedClient),
 		unregister:   make(chan *HubFeedClient),
 		lock:         sync.RWMutex{},
 		messageCache: messageCache,
 	}
+
+	source.OnReadyStateChange(feedHub.publishReadyState)
+	return feedHub
 }
 
 // IsRunning returns true only if the underlying source connection is open
@@ -71,6 +88,13 @@ func (w *feedHubImpl) Run() bool {
 	if !w.source.Connect() {
 		return false
 	}
+
+	//the previous run must clean up its clients before the new clients register
+	if w.done != nil {
+		<-w.done
+	}
+	w.done = make(chan struct{})
+
 	var wg sync.WaitGroup
 	wg.Add(2)
 
@@ -98,15 +122,32 @@ func (w *feedHubImpl) RegisterClient(client *HubFeedClient) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	//send all previously received pending messages
+	if _, ok := w.clients[client]; ok {
+		return
+	}
+
+	queue := newClientQueue(client.Feed, w.cfg.QueueSize)
+
+	//queue all previously received pending messages, the oldest are dropped if they don't fit
 	if w.messageCache != nil {
 		messages := w.messageCache.GetMessages()
 		for _, message := range messages {
-			client.Feed <- message
+			if client.Version == FeedVersion2 {
+				if message = w.envelope(EventActionReplay, message); message == nil {
+					continue
+				}
+			}
+
+			if !queue.push(message) {
+				queue.replaceOldest(message)
+				metrics.FeedDroppedMessages.WithLabelValues(internalLabel(client), OverflowDropOldest).Inc()
+			}
 		}
+		queue.fullSince = time.Time{}
 	}
 
-	w.clients[client] = true
+	w.clients[client] = queue
+	metrics.FeedClients.WithLabelValues(internalLabel(client)).Inc()
 	w.log.Info("FeedHub: new feed client registered")
 }
 
@@ -115,14 +156,68 @@ func (w *feedHubImpl) UnregisterClient(client *HubFeedClient) {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	registered := w.clients[client]
+	queue, registered := w.clients[client]
 	if registered {
-		close(client.Feed)
+		queue.close()
 		delete(w.clients, client)
+		metrics.FeedClients.WithLabelValues(internalLabel(client)).Dec()
 		w.log.Info("FeedHub: feed client unregistered")
 	}
 }
 
+// PublishEvent queues the Envelope of the payload to the v2 clients, the events are not cached
+func (w *feedHubImpl) PublishEvent(eventType string, payload any) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		w.log.Errorf("FeedHub: failed to marshal the %s event, err: %v", eventType, err)
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	var event []byte
+	for client, queue := range w.clients {
+		if client.Version != FeedVersion2 {
+			continue
+		}
+
+		if event == nil {
+			if event = w.envelope(eventType, data); event == nil {
+				return
+			}
+		}
+		w.deliver(client, queue, event)
+	}
+}
+
+// publishReadyState is called by the source when its ready state changes
+func (w *feedHubImpl) publishReadyState(state string) {
+	w.PublishEvent(EventConnectionState, ConnectionStateEvent{
+		ReadyState:    state,
+		RemoteFeedUrl: w.source.GetFeedUrl(),
+	})
+}
+
+// envelope returns the Envelope of the message, nil if it can't be marshalled
+func (w *feedHubImpl) envelope(eventType string, message []byte) []byte {
+	data, err := NewEnvelope(eventType, message)
+	if err != nil {
+		w.log.Errorf("FeedHub: failed to wrap the %s event, err: %v", eventType, err)
+		return nil
+	}
+
+	return data
+}
+
+func (w *feedHubImpl) SetConfig(cfg config.FeedHubConfig) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.cfg = cfg
+	w.log.Infof("FeedHub: queue size set to %d, overflow policy %s, eviction after %ds", cfg.QueueSize, cfg.OverflowPolicy, cfg.EvictAfter)
+}
+
 func (w *feedHubImpl) GetWebsocketStatus() api.WebsocketStatus {
 	readyState := w.source.GetReadyState()
 	sourceFeedUrl := w.source.GetFeedUrl()
@@ -153,17 +248,20 @@ func (w *feedHubImpl) cleanUp() {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
-	for client := range w.clients {
+	for client, queue := range w.clients {
 		w.log.Info("FeedHub: closing feed clients")
-		close(client.Feed)
+		queue.close()
 		delete(w.clients, client)
+		metrics.FeedClients.WithLabelValues(internalLabel(client)).Dec()
 	}
 }
 
 func (w *feedHubImpl) startHub(wg *sync.WaitGroup) {
+	done := w.done
 	defer func() {
 		w.isRunning = false
 		w.cleanUp()
+		close(done)
 	}()
 
 	w.isRunning = true
@@ -181,12 +279,71 @@ func (w *feedHubImpl) startHub(wg *sync.WaitGroup) {
 				w.messageCache.AddMessage(message)
 			}
 
-			//send the message to all connected clients
-			for client := range w.clients {
-				client.Feed <- message
+			metrics.FeedBroadcasts.Inc()
+
+			//queue the message to all connected clients, the v2 clients share the same envelope
+			var event []byte
+			for client, queue := range w.clients {
+				if client.Version != FeedVersion2 {
+					w.deliver(client, queue, message)
+					continue
+				}
+
+				if event == nil {
+					if event = w.envelope(EventAction, message); event == nil {
+						continue
+					}
+				}
+				w.deliver(client, queue, event)
 			}
 
 			w.lock.Unlock()
 		}
 	}
 }
+
+// deliver queues the message to the client and applies the overflow policy if its queue is full.
+// The internal clients are never disconnected, they drop the oldest message instead
+func (w *feedHubImpl) deliver(client *HubFeedClient, queue *clientQueue, message []byte) {
+	if queue.push(message) {
+		return
+	}
+
+	policy := w.cfg.OverflowPolicy
+	if !client.IsInternal {
+		if policy == OverflowDisconnect {
+			w.evict(client, queue, "overflow")
+			return
+		}
+
+		if w.cfg.EvictAfter > 0 && queue.fullFor() >= time.Duration(w.cfg.EvictAfter)*time.Second {
+			w.evict(client, queue, "slow")
+			return
+		}
+	}
+
+	if policy != OverflowDropNewest {
+		policy = OverflowDropOldest
+		queue.replaceOldest(message)
+	}
+
+	metrics.FeedDroppedMessages.WithLabelValues(internalLabel(client), policy).Inc()
+	w.log.Debugf("FeedHub: feed client queue full, message dropped, policy: %s", policy)
+}
+
+// evict disconnects the slow client, its Feed channel is closed
+func (w *feedHubImpl) evict(client *HubFeedClient, queue *clientQueue, reason string) {
+	queue.close()
+	delete(w.clients, client)
+	metrics.FeedClients.WithLabelValues(internalLabel(client)).Dec()
+	metrics.FeedEvictions.WithLabelValues(reason).Inc()
+	w.log.Warnf("FeedHub: slow feed client disconnected, reason: %s", reason)
+}
+
+func internalLabel(client *HubFeedClient) string {
+	if client.IsInternal {
+		return "true"
+	}
+
+	return "false"
+}
@@ -1,6 +1,7 @@
 package hub
 
 import (
+	"encoding/json"
 	"sync"
 	"testing"
 	"time"
@@ -8,20 +9,23 @@ import (
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 
+	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub/message"
 	"github.com/qredo/signing-agent/internal/util"
 )
 
 type mockSourceConnection struct {
-	ConnectCalled       bool
-	ListenCalled        bool
-	DisconnectCalled    bool
-	GetReadyStateCalled bool
-	NextConnect         bool
-	NextReadyState      string
-	RxMessages          chan []byte
-	NextFeedURL         string
+	ConnectCalled            bool
+	ListenCalled             bool
+	DisconnectCalled         bool
+	GetReadyStateCalled      bool
+	SetReconnectConfigCalled bool
+	NextConnect              bool
+	NextReadyState           string
+	RxMessages               chan []byte
+	NextFeedURL              string
+	LastReadyStateListener   func(state string)
 }
 
 func (m *mockSourceConnection) Connect() bool {
@@ -48,17 +52,30 @@ func (m *mockSourceConnection) GetReadyState() string {
 	return m.NextReadyState
 }
 
+func (m *mockSourceConnection) SetReconnectConfig(config config.WebSocketConfig) {
+	m.SetReconnectConfigCalled = true
+}
+
+func (m *mockSourceConnection) OnReadyStateChange(listener func(state string)) {
+	m.LastReadyStateListener = listener
+}
+
 func (m *mockSourceConnection) GetSendChannel() chan []byte {
 	return m.RxMessages
 }
 
 var ignoreOpenCensus = goleak.IgnoreTopFunction("go.opencensus.io/stats/view.(*worker).start")
 
+var testFeedHubConfig = config.FeedHubConfig{
+	QueueSize:      8,
+	OverflowPolicy: OverflowDropOldest,
+}
+
 func TestFeedHub_Run_fails_to_connect(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t, ignoreOpenCensus)
 	mockSourceConn := &mockSourceConnection{}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil)
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
 
 	//Act
 	res := feedHub.Run()
@@ -77,7 +94,7 @@ func TestFeedHub_Run_connects_and_listens(t *testing.T) {
 		RxMessages:  make(chan []byte, 1),
 	}
 	mockCache := &message.MockCache{}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), mockCache)
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), mockCache, testFeedHubConfig)
 	client := &HubFeedClient{
 		Feed: make(chan []byte),
 	}
@@ -120,12 +137,46 @@ func TestFeedHub_Run_connects_and_listens(t *testing.T) {
 	close(mockSourceConn.RxMessages)
 }
 
+func TestFeedHub_Run_again_after_the_source_closed(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockSourceConn := &mockSourceConnection{
+		NextConnect: true,
+		RxMessages:  make(chan []byte, 1),
+	}
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
+	previousClient := &HubFeedClient{
+		Feed: make(chan []byte),
+	}
+	feedHub.Run()
+	feedHub.RegisterClient(previousClient)
+	close(mockSourceConn.RxMessages)
+	mockSourceConn.RxMessages = make(chan []byte, 1)
+	client := &HubFeedClient{
+		Feed: make(chan []byte),
+	}
+
+	//Act
+	res := feedHub.Run()
+	feedHub.RegisterClient(client)
+	mockSourceConn.RxMessages <- []byte("test")
+	msg, ok := <-client.Feed
+	_, previousOk := <-previousClient.Feed
+
+	//Assert
+	assert.True(t, res)
+	assert.True(t, ok)
+	assert.Equal(t, "test", string(msg))
+	assert.False(t, previousOk)
+	close(mockSourceConn.RxMessages)
+}
+
 func TestFeedHub_Stop_not_connected(t *testing.T) {
 	//Arrange
 	mockSourceConn := &mockSourceConnection{
 		NextConnect: true,
 	}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil)
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
 
 	//Act
 	feedHub.Stop()
@@ -141,7 +192,7 @@ func TestFeedHub_Stop_connected(t *testing.T) {
 		NextConnect:    true,
 		NextReadyState: defs.ConnectionState.Open,
 	}
-	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil)
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
 
 	//Act
 	feedHub.Stop()
@@ -161,7 +212,8 @@ func TestFeedHub_Register_sends_cached_messages_to_client(t *testing.T) {
 		},
 	}
 	feedHub := &feedHubImpl{
-		clients:      make(map[*HubFeedClient]bool),
+		clients:      make(map[*HubFeedClient]*clientQueue),
+		cfg:          testFeedHubConfig,
 		log:          util.NewTestLogger(),
 		messageCache: mockCache,
 	}
@@ -191,14 +243,15 @@ func TestFeedHub_Register_sends_cached_messages_to_client(t *testing.T) {
 	assert.Contains(t, receivedMessages, []byte("message 1"))
 	assert.Contains(t, receivedMessages, []byte("message 2"))
 	assert.True(t, mockCache.GetMessagesCalled)
-	close(client.Feed)
+	feedHub.UnregisterClient(client)
 }
 
 func TestFeedHub_Register_Unregister_client(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t, ignoreOpenCensus)
 	feedHub := &feedHubImpl{
-		clients: make(map[*HubFeedClient]bool),
+		clients: make(map[*HubFeedClient]*clientQueue),
+		cfg:     testFeedHubConfig,
 		log:     util.NewTestLogger(),
 	}
 	client := &HubFeedClient{
@@ -219,7 +272,8 @@ func TestFeedHub_GetWebsocketStatus(t *testing.T) {
 
 	feedHub := &feedHubImpl{
 		log:       util.NewTestLogger(),
-		clients:   make(map[*HubFeedClient]bool),
+		clients:   make(map[*HubFeedClient]*clientQueue),
+		cfg:       testFeedHubConfig,
 		broadcast: make(chan []byte),
 		source: &mockSourceConnection{
 			NextReadyState: "open",
@@ -246,4 +300,299 @@ func TestFeedHub_GetWebsocketStatus(t *testing.T) {
 	wg.Wait()
 
 	assert.Equal(t, uint32(4), feedHub.GetWebsocketStatus().ConnectedClients)
+	feedHub.cleanUp()
+}
+
+// newStalledClient returns a client that never reads its Feed, the queue forwarding is blocked on a first message
+func newStalledClient(t *testing.T, isInternal bool, size int) (*HubFeedClient, *clientQueue) {
+	client := &HubFeedClient{
+		Feed:       make(chan []byte),
+		IsInternal: isInternal,
+	}
+	queue := newClientQueue(client.Feed, size)
+	queue.push([]byte("in flight"))
+	assert.Eventually(t, func() bool { return len(queue.messages) == 0 }, time.Second, 10*time.Millisecond)
+
+	return client, queue
+}
+
+func TestFeedHub_slow_client_doesnt_block_the_others(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockSourceConn := &mockSourceConnection{
+		NextConnect: true,
+		RxMessages:  make(chan []byte),
+	}
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, config.FeedHubConfig{
+		QueueSize:      2,
+		OverflowPolicy: OverflowDropNewest,
+	})
+	feedHub.Run()
+	stalledClient := &HubFeedClient{
+		Feed: make(chan []byte),
+	}
+	client := &HubFeedClient{
+		Feed: make(chan []byte),
+	}
+	feedHub.RegisterClient(stalledClient)
+	feedHub.RegisterClient(client)
+	received := []string{}
+
+	//Act
+	for i := 0; i < 10; i++ {
+		mockSourceConn.RxMessages <- []byte{byte('0' + i)}
+		received = append(received, string(<-client.Feed))
+	}
+
+	//Assert
+	assert.Equal(t, []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}, received)
+	for _, expected := range []string{"0", "1", "2"} {
+		assert.Equal(t, expected, string(<-stalledClient.Feed))
+	}
+	close(mockSourceConn.RxMessages)
+}
+
+func TestFeedHub_deliver_drop_oldest(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	client, queue := newStalledClient(t, false, 2)
+	feedHub := &feedHubImpl{
+		clients: map[*HubFeedClient]*clientQueue{client: queue},
+		cfg:     config.FeedHubConfig{QueueSize: 2, OverflowPolicy: OverflowDropOldest},
+		log:     util.NewTestLogger(),
+	}
+
+	//Act
+	for _, message := range []string{"1", "2", "3"} {
+		feedHub.deliver(client, queue, []byte(message))
+	}
+
+	//Assert
+	assert.Equal(t, "in flight", string(<-client.Feed))
+	assert.Equal(t, "2", string(<-client.Feed))
+	assert.Equal(t, "3", string(<-client.Feed))
+	assert.Equal(t, 1, len(feedHub.clients))
+	feedHub.UnregisterClient(client)
+}
+
+func TestFeedHub_deliver_drop_newest(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	client, queue := newStalledClient(t, false, 2)
+	feedHub := &feedHubImpl{
+		clients: map[*HubFeedClient]*clientQueue{client: queue},
+		cfg:     config.FeedHubConfig{QueueSize: 2, OverflowPolicy: OverflowDropNewest},
+		log:     util.NewTestLogger(),
+	}
+
+	//Act
+	for _, message := range []string{"1", "2", "3"} {
+		feedHub.deliver(client, queue, []byte(message))
+	}
+
+	//Assert
+	assert.Equal(t, "in flight", string(<-client.Feed))
+	assert.Equal(t, "1", string(<-client.Feed))
+	assert.Equal(t, "2", string(<-client.Feed))
+	assert.Equal(t, 1, len(feedHub.clients))
+	feedHub.UnregisterClient(client)
+}
+
+func TestFeedHub_deliver_disconnects_external_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	client, queue := newStalledClient(t, false, 2)
+	feedHub := &feedHubImpl{
+		clients: map[*HubFeedClient]*clientQueue{client: queue},
+		cfg:     config.FeedHubConfig{QueueSize: 2, OverflowPolicy: OverflowDisconnect},
+		log:     util.NewTestLogger(),
+	}
+
+	//Act
+	for _, message := range []string{"1", "2", "3"} {
+		feedHub.deliver(client, queue, []byte(message))
+	}
+
+	//Assert
+	_, ok := <-client.Feed
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(feedHub.clients))
+}
+
+func TestFeedHub_deliver_doesnt_disconnect_internal_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	client, queue := newStalledClient(t, true, 2)
+	feedHub := &feedHubImpl{
+		clients: map[*HubFeedClient]*clientQueue{client: queue},
+		cfg:     config.FeedHubConfig{QueueSize: 2, OverflowPolicy: OverflowDisconnect, EvictAfter: 1},
+		log:     util.NewTestLogger(),
+	}
+
+	//Act
+	for _, message := range []string{"1", "2", "3"} {
+		feedHub.deliver(client, queue, []byte(message))
+	}
+	queue.fullSince = time.Now().Add(-2 * time.Second)
+	feedHub.deliver(client, queue, []byte("4"))
+
+	//Assert
+	assert.Equal(t, "in flight", string(<-client.Feed))
+	assert.Equal(t, "3", string(<-client.Feed))
+	assert.Equal(t, "4", string(<-client.Feed))
+	assert.Equal(t, 1, len(feedHub.clients))
+	feedHub.UnregisterClient(client)
+}
+
+func TestFeedHub_deliver_evicts_client_staying_slow(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	client, queue := newStalledClient(t, false, 2)
+	feedHub := &feedHubImpl{
+		clients: map[*HubFeedClient]*clientQueue{client: queue},
+		cfg:     config.FeedHubConfig{QueueSize: 2, OverflowPolicy: OverflowDropNewest, EvictAfter: 1},
+		log:     util.NewTestLogger(),
+	}
+	for _, message := range []string{"1", "2", "3"} {
+		feedHub.deliver(client, queue, []byte(message))
+	}
+	assert.Equal(t, 1, len(feedHub.clients))
+	queue.fullSince = time.Now().Add(-2 * time.Second)
+
+	//Act
+	feedHub.deliver(client, queue, []byte("4"))
+
+	//Assert
+	_, ok := <-client.Feed
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(feedHub.clients))
+}
+
+func TestFeedHub_Register_drops_oldest_cached_messages_not_fitting(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockCache := &message.MockCache{
+		NextMessages: [][]byte{
+			[]byte("message 1"),
+			[]byte("message 2"),
+			[]byte("message 3"),
+		},
+	}
+	feedHub := &feedHubImpl{
+		clients:      make(map[*HubFeedClient]*clientQueue),
+		cfg:          config.FeedHubConfig{QueueSize: 1, OverflowPolicy: OverflowDisconnect},
+		log:          util.NewTestLogger(),
+		messageCache: mockCache,
+	}
+	client := &HubFeedClient{
+		Feed: make(chan []byte),
+	}
+
+	//Act
+	feedHub.RegisterClient(client)
+	received := string(<-client.Feed)
+	if received != "message 3" {
+		received = string(<-client.Feed) //the first message was forwarded before the others were queued
+	}
+
+	//Assert
+	assert.Equal(t, "message 3", received)
+	assert.Equal(t, 1, len(feedHub.clients))
+	feedHub.UnregisterClient(client)
+}
+
+func decodeEnvelope(t *testing.T, data []byte) Envelope {
+	envelope := Envelope{}
+	assert.Nil(t, json.Unmarshal(data, &envelope))
+	assert.Equal(t, FeedVersion2, envelope.Version)
+	assert.NotEmpty(t, envelope.ID)
+	assert.False(t, envelope.Timestamp.IsZero())
+
+	return envelope
+}
+
+func TestFeedHub_broadcasts_the_envelope_to_v2_clients(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockSourceConn := &mockSourceConnection{
+		NextConnect: true,
+		RxMessages:  make(chan []byte),
+	}
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
+	v1Client := &HubFeedClient{Feed: make(chan []byte)}
+	v2Client := &HubFeedClient{Feed: make(chan []byte), Version: FeedVersion2}
+	feedHub.Run()
+	feedHub.RegisterClient(v1Client)
+	feedHub.RegisterClient(v2Client)
+
+	//Act
+	mockSourceConn.RxMessages <- []byte(`{"id":"action1"}`)
+	mockSourceConn.RxMessages <- []byte("not json")
+
+	//Assert
+	assert.Equal(t, `{"id":"action1"}`, string(<-v1Client.Feed))
+	assert.Equal(t, "not json", string(<-v1Client.Feed))
+
+	envelope := decodeEnvelope(t, <-v2Client.Feed)
+	assert.Equal(t, EventAction, envelope.Type)
+	assert.JSONEq(t, `{"id":"action1"}`, string(envelope.Payload))
+
+	envelope = decodeEnvelope(t, <-v2Client.Feed)
+	assert.Equal(t, EventAction, envelope.Type)
+	assert.Equal(t, `"not json"`, string(envelope.Payload))
+	close(mockSourceConn.RxMessages)
+}
+
+func TestFeedHub_Register_replays_cached_messages_to_v2_client(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockCache := &message.MockCache{
+		NextMessages: [][]byte{[]byte(`{"id":"action1"}`)},
+	}
+	feedHub := &feedHubImpl{
+		clients:      make(map[*HubFeedClient]*clientQueue),
+		cfg:          testFeedHubConfig,
+		log:          util.NewTestLogger(),
+		messageCache: mockCache,
+	}
+	client := &HubFeedClient{Feed: make(chan []byte), Version: FeedVersion2}
+
+	//Act
+	feedHub.RegisterClient(client)
+
+	//Assert
+	envelope := decodeEnvelope(t, <-client.Feed)
+	assert.Equal(t, EventActionReplay, envelope.Type)
+	assert.JSONEq(t, `{"id":"action1"}`, string(envelope.Payload))
+	feedHub.UnregisterClient(client)
+}
+
+func TestFeedHub_publishes_the_source_ready_state_to_v2_clients_only(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockSourceConn := &mockSourceConnection{
+		NextFeedURL: "wss://feed",
+	}
+	feedHub := NewFeedHub(mockSourceConn, util.NewTestLogger(), nil, testFeedHubConfig)
+	v1Client := &HubFeedClient{Feed: make(chan []byte)}
+	v2Client := &HubFeedClient{Feed: make(chan []byte), Version: FeedVersion2}
+	feedHub.RegisterClient(v1Client)
+	feedHub.RegisterClient(v2Client)
+
+	//Act
+	mockSourceConn.LastReadyStateListener(defs.ConnectionState.Connecting)
+
+	//Assert
+	envelope := decodeEnvelope(t, <-v2Client.Feed)
+	assert.Equal(t, EventConnectionState, envelope.Type)
+	assert.JSONEq(t, `{"readyState":"CONNECTING","remoteFeedURL":"wss://feed"}`, string(envelope.Payload))
+
+	select {
+	case message := <-v1Client.Feed:
+		assert.Fail(t, "unexpected message for the v1 client", string(message))
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	feedHub.UnregisterClient(v1Client)
+	feedHub.UnregisterClient(v2Client)
 }
@@ -10,6 +10,7 @@ import (
 	"github.com/qredo/signing-agent/internal/auth"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/metrics"
 )
 
 // Source has an underlying websocket connection used to receive messages. It will send these messages to an outbound channel
@@ -18,6 +19,10 @@ type Source interface {
 	Disconnect()
 	Listen(wg *sync.WaitGroup)
 	GetSendChannel() chan []byte
+	// SetReconnectConfig changes the reconnection intervals of the next reconnection
+	SetReconnectConfig(config config.WebSocketConfig)
+	// OnReadyStateChange sets the listener called with the new ready state on every change
+	OnReadyStateChange(listener func(state string))
 	SourceStats
 }
 
@@ -33,12 +38,15 @@ type websocketSource struct {
 	log                  *zap.SugaredLogger
 	feedUrl              string
 	shouldReconnect      bool
+	stopped              bool          // disconnected on request, the next Connect starts over
+	listening            chan struct{} // closed when Listen returns
 	readyState           string
 	reconnectIntervalMax time.Duration
 	reconnectInterval    time.Duration
 	rxMessages           chan []byte
 	lock                 sync.RWMutex
 	authProvider         auth.HeaderProvider
+	readyStateListener   func(state string)
 }
 
 // NewWebsocketSource returns a Source object that's an instance of websocketSource
@@ -60,16 +68,18 @@ func NewWebsocketSource(dialer WebsocketDialer, feedUrl string, log *zap.Sugared
 // Connect is trying to establish a websocket connection which will be used as a source
 // It tries to reconnect at each interval defined in the configuration
 func (w *websocketSource) Connect() bool {
+	w.restart()
 	w.setReadyState(defs.ConnectionState.Connecting)
 
+	reconnectInterval, reconnectIntervalMax := w.getReconnectIntervals()
 	startTime := time.Now()
-	for time.Since(startTime) < w.reconnectIntervalMax {
+	for time.Since(startTime) < reconnectIntervalMax {
 		if err := w.dial(); err == nil {
 			w.log.Infof("WebsocketSource: connected to feed %v", w.feedUrl)
 			return true
 		} else {
-			w.log.Errorf("WebsocketSource: cannot connect to feed: %v, retry connection in %v", err, w.reconnectInterval)
-			time.Sleep(w.reconnectInterval)
+			w.log.Errorf("WebsocketSource: cannot connect to feed: %v, retry connection in %v", err, reconnectInterval)
+			time.Sleep(reconnectInterval)
 		}
 	}
 
@@ -80,9 +90,15 @@ func (w *websocketSource) Connect() bool {
 // Listen is receiving messages from the underlying websocket connection and sends them to the outbound channel
 // In case of a reading or connectivity issue it tries to reconnect
 func (w *websocketSource) Listen(wg *sync.WaitGroup) {
+	listening := make(chan struct{})
+	w.lock.Lock()
+	w.listening = listening
+	w.lock.Unlock()
+
 	defer func() {
 		w.conn.Close()
 		close(w.rxMessages)
+		close(listening)
 	}()
 
 	wg.Done()
@@ -96,6 +112,7 @@ func (w *websocketSource) Listen(wg *sync.WaitGroup) {
 			}
 			//either connection issue or issue reading the message
 			w.log.Errorf("WebsocketSource: unexpected connection error: %v", err)
+			metrics.SourceReconnects.Inc()
 			if !w.Connect() {
 				return
 			}
@@ -113,9 +130,31 @@ func (w *websocketSource) Disconnect() {
 		w.log.Errorf("WebsocketSource: error on send CloseMessage, error: %v", err)
 	}
 	w.shouldReconnect = false
+	w.stopped = true
 	w.setReadyState(defs.ConnectionState.Closed)
 }
 
+// restart prepares the source disconnected on request for a new connection, once the previous listener returned
+func (w *websocketSource) restart() {
+	if !w.stopped {
+		return
+	}
+
+	w.lock.RLock()
+	listening := w.listening
+	w.lock.RUnlock()
+
+	if listening != nil {
+		// unblocks the read of the previous listener
+		_ = w.conn.Close()
+		<-listening
+	}
+
+	w.stopped = false
+	w.shouldReconnect = true
+	w.rxMessages = make(chan []byte, 1)
+}
+
 // GetFeedUrl returns the websocket url
 func (w *websocketSource) GetFeedUrl() string {
 	return w.feedUrl
@@ -129,6 +168,30 @@ func (w *websocketSource) GetReadyState() string {
 	return w.readyState
 }
 
+// SetReconnectConfig changes the reconnection intervals, used from the next reconnection
+func (w *websocketSource) SetReconnectConfig(config config.WebSocketConfig) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.reconnectIntervalMax = time.Duration(config.ReconnectTimeOut) * time.Second
+	w.reconnectInterval = time.Duration(config.ReconnectInterval) * time.Second
+}
+
+// OnReadyStateChange sets the listener of the ready state changes
+func (w *websocketSource) OnReadyStateChange(listener func(state string)) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.readyStateListener = listener
+}
+
+func (w *websocketSource) getReconnectIntervals() (time.Duration, time.Duration) {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+
+	return w.reconnectInterval, w.reconnectIntervalMax
+}
+
 // GetSendChannel returns the outbound channel
 func (w *websocketSource) GetSendChannel() chan []byte {
 	return w.rxMessages
@@ -146,9 +209,18 @@ func (w *websocketSource) dial() error {
 	return err
 }
 
+// setReadyState updates the ready state, the listener is called outside the lock on a change
 func (w *websocketSource) setReadyState(state string) {
 	w.lock.Lock()
-	defer w.lock.Unlock()
-
+	changed := w.readyState != state
+	if changed {
+		metrics.SourceReadyStateTransitions.WithLabelValues(state).Inc()
+	}
 	w.readyState = state
+	listener := w.readyStateListener
+	w.lock.Unlock()
+
+	if changed && listener != nil {
+		listener(state)
+	}
 }
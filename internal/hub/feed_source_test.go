@@ -228,3 +228,58 @@ func TestWebsocketSource_Listen_sends_message(t *testing.T) {
 	sut.shouldReconnect = false
 	connMock.read <- true
 }
+
+func TestWebsocketSource_Connect_after_Disconnect_starts_over(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	previousConn := &MockWebsocketConnection{
+		NextError: errors.New("some close error"),
+		read:      make(chan bool, 1),
+	}
+	dialerMock := &mockWebsocketDialer{
+		NextConn: &MockWebsocketConnection{},
+	}
+	sut := NewWebsocketSource(dialerMock, "feed", util.NewTestLogger(), config.WebSocketConfig{ReconnectTimeOut: 6, ReconnectInterval: 2}, &auth.MockHeaderProvider{}).(*websocketSource)
+	sut.conn = previousConn
+	previousChannel := sut.GetSendChannel()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go sut.Listen(&wg)
+	wg.Wait()
+
+	sut.Disconnect()
+	previousConn.read <- true
+	_, ok := <-previousChannel //channel was closed by the previous listener
+
+	//Act
+	res := sut.Connect()
+
+	//Assert
+	assert.False(t, ok)
+	assert.True(t, res)
+	assert.True(t, previousConn.CloseCalled)
+	assert.True(t, sut.shouldReconnect)
+	assert.Equal(t, defs.ConnectionState.Open, sut.GetReadyState())
+	assert.NotEqual(t, previousChannel, sut.GetSendChannel())
+}
+
+func TestWebsocketSource_setReadyState_notifies_the_changes(t *testing.T) {
+	//Arrange
+	sut := &websocketSource{
+		readyState: defs.ConnectionState.Closed,
+		log:        util.NewTestLogger(),
+	}
+	states := make([]string, 0)
+	sut.OnReadyStateChange(func(state string) {
+		states = append(states, state)
+	})
+
+	//Act
+	sut.setReadyState(defs.ConnectionState.Connecting)
+	sut.setReadyState(defs.ConnectionState.Connecting)
+	sut.setReadyState(defs.ConnectionState.Open)
+
+	//Assert
+	assert.Equal(t, []string{defs.ConnectionState.Connecting, defs.ConnectionState.Open}, states)
+}
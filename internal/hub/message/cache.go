@@ -29,6 +29,9 @@ type Cache interface {
 type Cacher interface {
 	CacheRemover
 	Cache
+	// Clear removes every message of the local cache, the pending actions of a deregistered agent can't be signed anymore.
+	// The distributed cache is shared in Redis, its messages are left to expire
+	Clear()
 }
 
 func NewCacher(isMultiInstance bool, log *zap.SugaredLogger, kvStore *redis.Client) Cacher {
@@ -4,6 +4,7 @@ type MockCache struct {
 	AddMessageCalled    bool
 	GetMessagesCalled   bool
 	RemoveMessageCalled bool
+	ClearCalled         bool
 
 	LastMessage []byte
 	LastID      string
@@ -25,3 +26,7 @@ func (m *MockCache) RemoveMessage(ID string) {
 	m.RemoveMessageCalled = true
 	m.LastID = ID
 }
+
+func (m *MockCache) Clear() {
+	m.ClearCalled = true
+}
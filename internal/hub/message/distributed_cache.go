@@ -63,6 +63,12 @@ func (c *distributedCache) RemoveMessage(ID string) {
 	c.kvStore.Del(ctx, c.getKey(ID))
 }
 
+// Clear leaves the messages in Redis, the keys are shared with the other instances and not scoped to the agent.
+// The messages expire on their own
+func (c *distributedCache) Clear() {
+	c.log.Debug("Message Cache: the distributed cache is not cleared, its messages expire on their own")
+}
+
 func (c *distributedCache) getKey(ID string) string {
 	return keyPrefix + ID
 }
@@ -167,3 +167,19 @@ func TestDistributedCache_RemoveMessage(t *testing.T) {
 	assert.True(t, mockKVStore.DelCalled)
 	assert.Equal(t, "transaction:testID", mockKVStore.LastKey)
 }
+
+func TestDistributedCache_Clear_leaves_shared_messages(t *testing.T) {
+	//Arrange
+	mockKVStore := &KVStoreMock{}
+	sut := distributedCache{
+		log:     util.NewTestLogger(),
+		kvStore: mockKVStore,
+	}
+
+	//Act
+	sut.Clear()
+
+	//Assert
+	assert.False(t, mockKVStore.ScanCalled)
+	assert.False(t, mockKVStore.DelCalled)
+}
@@ -59,3 +59,11 @@ func (c *localCache) RemoveMessage(ID string) {
 	c.log.Debugf("Message Cache: removing message with ID `%s`", ID)
 	delete(c.messages, ID)
 }
+
+// Clear deletes every message of the cache
+func (c *localCache) Clear() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.messages = make(map[string][]byte)
+}
@@ -99,3 +99,21 @@ func TestLocalCache_RemoveMessage(t *testing.T) {
 	//Assert
 	assert.Empty(t, sut.messages)
 }
+
+func TestLocalCache_Clear(t *testing.T) {
+	//Arrange
+	sut := &localCache{
+		log:  util.NewTestLogger(),
+		lock: sync.RWMutex{},
+		messages: map[string][]byte{
+			"test":  []byte(""),
+			"other": []byte(""),
+		},
+	}
+
+	//Act
+	sut.Clear()
+
+	//Assert
+	assert.Empty(t, sut.messages)
+}
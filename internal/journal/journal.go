@@ -0,0 +1,211 @@
+// Package journal keeps an append-only record of the decisions taken by the agent on the actions.
+// Each entry is stored as a JSON line in the journal file and the entries can be queried by time range, status and action ID,
+// a page at a time. The sequence of an entry is its line in the file, the lines that can't be parsed are skipped
+package journal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+// Modes of the decisions
+const (
+	ModeManual    = "manual"
+	ModeAutomatic = "automatic"
+)
+
+// Decisions taken on the actions
+const (
+	DecisionApprove = "approve"
+	DecisionReject  = "reject"
+)
+
+// Statuses of the entries, an entry is failed when the signature couldn't be submitted
+const (
+	StatusApproved = "approved"
+	StatusRejected = "rejected"
+	StatusFailed   = "failed"
+)
+
+// maxEntrySize is the maximum size of a journal line
+const maxEntrySize = 1024 * 1024
+
+// Entry records a decision taken on an action. The sequence is set by Query, it's not recorded
+type Entry struct {
+	Seq           int       `json:"seq,omitempty"`
+	ActionID      string    `json:"actionID"`
+	Decision      string    `json:"decision"`
+	Mode          string    `json:"mode"`
+	Caller        string    `json:"caller,omitempty"`
+	Status        string    `json:"status"`
+	MessageHashes []string  `json:"messageHashes,omitempty"`
+	StartedAt     time.Time `json:"startedAt"`
+	FinishedAt    time.Time `json:"finishedAt"`
+	Retries       int       `json:"retries"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// Filter selects the entries returned by Query. Zero values match any entry.
+// The time range applies to the time the decision finished. Only the entries with a sequence lower than Before
+// are selected, and only the last Limit of them, so the previous page is queried with Before set to the
+// sequence of the first entry of the page
+type Filter struct {
+	From     time.Time
+	To       time.Time
+	Status   string
+	ActionID string
+	Before   int
+	Limit    int
+}
+
+// Journal records the decisions taken on the actions
+type Journal interface {
+	// Record appends the entry to the journal
+	Record(entry Entry) error
+	// Query returns the last entries matching the filter, in the order they were recorded
+	Query(filter Filter) ([]Entry, error)
+}
+
+type fileJournal struct {
+	lock sync.Mutex
+	file *os.File
+	log  *zap.SugaredLogger
+}
+
+// NewFileJournal opens the journal file for appending, creating it if it doesn't exist
+func NewFileJournal(fileName string, log *zap.SugaredLogger) (Journal, error) {
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "open journal file")
+	}
+
+	return &fileJournal{
+		file: file,
+		log:  log,
+	}, nil
+}
+
+// NewEntry returns an entry for the decision, with the status set from the error
+func NewEntry(actionID, decision, mode, caller string, messages [][]byte, startedAt time.Time, retries int, err error) Entry {
+	entry := Entry{
+		ActionID:   actionID,
+		Decision:   decision,
+		Mode:       mode,
+		Caller:     caller,
+		StartedAt:  startedAt.UTC(),
+		FinishedAt: time.Now().UTC(),
+		Retries:    retries,
+	}
+
+	for _, message := range messages {
+		entry.MessageHashes = append(entry.MessageHashes, HashMessage(message))
+	}
+
+	switch {
+	case err != nil:
+		entry.Status = StatusFailed
+		entry.Error = err.Error()
+	case decision == DecisionReject:
+		entry.Status = StatusRejected
+	default:
+		entry.Status = StatusApproved
+	}
+
+	return entry
+}
+
+// HashMessage returns the hex encoded SHA-256 hash of the signed message
+func HashMessage(message []byte) string {
+	hash := sha256.Sum256(message)
+	return hex.EncodeToString(hash[:])
+}
+
+func (j *fileJournal) Record(entry Entry) error {
+	entry.Seq = 0
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return errors.Wrap(err, "marshal journal entry")
+	}
+	data = append(data, '\n')
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, err := j.file.Write(data); err != nil {
+		return errors.Wrap(err, "write journal entry")
+	}
+
+	return j.file.Sync()
+}
+
+func (j *fileJournal) Query(filter Filter) ([]Entry, error) {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+
+	if _, err := j.file.Seek(0, 0); err != nil {
+		return nil, errors.Wrap(err, "read journal file")
+	}
+
+	entries := []Entry{}
+	scanner := bufio.NewScanner(j.file)
+	scanner.Buffer(make([]byte, 0, 4096), maxEntrySize)
+	for seq := 1; scanner.Scan(); seq++ {
+		if filter.Before > 0 && seq >= filter.Before {
+			break
+		}
+
+		entry := Entry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			j.log.Warnf("Journal: skipping the unparsable entry on line %d, err: %v", seq, err)
+			continue
+		}
+		entry.Seq = seq
+
+		if !filter.matches(entry) {
+			continue
+		}
+
+		// keep only the last Limit entries
+		if filter.Limit > 0 && len(entries) == filter.Limit {
+			copy(entries, entries[1:])
+			entries = entries[:filter.Limit-1]
+		}
+		entries = append(entries, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "read journal file")
+	}
+
+	return entries, nil
+}
+
+func (f Filter) matches(entry Entry) bool {
+	if f.ActionID != defs.EmptyString && f.ActionID != entry.ActionID {
+		return false
+	}
+
+	if f.Status != defs.EmptyString && f.Status != entry.Status {
+		return false
+	}
+
+	if !f.From.IsZero() && entry.FinishedAt.Before(f.From) {
+		return false
+	}
+
+	if !f.To.IsZero() && entry.FinishedAt.After(f.To) {
+		return false
+	}
+
+	return true
+}
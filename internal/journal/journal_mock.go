@@ -0,0 +1,24 @@
+package journal
+
+type MockJournal struct {
+	RecordCalled bool
+	QueryCalled  bool
+
+	LastEntry  Entry
+	LastFilter Filter
+
+	NextEntries []Entry
+	NextError   error
+}
+
+func (m *MockJournal) Record(entry Entry) error {
+	m.RecordCalled = true
+	m.LastEntry = entry
+	return m.NextError
+}
+
+func (m *MockJournal) Query(filter Filter) ([]Entry, error) {
+	m.QueryCalled = true
+	m.LastFilter = filter
+	return m.NextEntries, m.NextError
+}
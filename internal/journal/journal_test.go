@@ -0,0 +1,163 @@
+package journal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+func TestNewEntry_sets_status(t *testing.T) {
+	// Arrange
+	startedAt := time.Now()
+
+	// Act
+	approved := NewEntry("id", DecisionApprove, ModeManual, "caller", [][]byte{[]byte("first"), []byte("second")}, startedAt, 0, nil)
+	rejected := NewEntry("id", DecisionReject, ModeAutomatic, "", nil, startedAt, 2, nil)
+	failed := NewEntry("id", DecisionApprove, ModeManual, "caller", nil, startedAt, 0, errors.New("some error"))
+
+	// Assert
+	assert.Equal(t, StatusApproved, approved.Status)
+	assert.Equal(t, []string{HashMessage([]byte("first")), HashMessage([]byte("second"))}, approved.MessageHashes)
+	assert.Equal(t, StatusRejected, rejected.Status)
+	assert.Empty(t, rejected.MessageHashes)
+	assert.Equal(t, 2, rejected.Retries)
+	assert.Equal(t, StatusFailed, failed.Status)
+	assert.Equal(t, "some error", failed.Error)
+	assert.False(t, failed.FinishedAt.Before(failed.StartedAt))
+}
+
+func TestFileJournal_Record_appends_entries(t *testing.T) {
+	// Arrange
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+	sut, err := NewFileJournal(fileName, util.NewTestLogger())
+	require.Nil(t, err)
+
+	// Act
+	require.Nil(t, sut.Record(Entry{ActionID: "first", Status: StatusApproved}))
+	require.Nil(t, sut.Record(Entry{ActionID: "second", Status: StatusRejected}))
+
+	// Assert
+	data, err := os.ReadFile(fileName)
+	require.Nil(t, err)
+	assert.Contains(t, string(data), `"actionID":"first"`)
+	assert.Contains(t, string(data), `"actionID":"second"`)
+
+	reopened, err := NewFileJournal(fileName, util.NewTestLogger())
+	require.Nil(t, err)
+	entries, err := reopened.Query(Filter{})
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "first", entries[0].ActionID)
+	assert.Equal(t, "second", entries[1].ActionID)
+}
+
+func TestFileJournal_Query_filters_entries(t *testing.T) {
+	// Arrange
+	sut, err := NewFileJournal(filepath.Join(t.TempDir(), "journal.jsonl"), util.NewTestLogger())
+	require.Nil(t, err)
+
+	now := time.Now().UTC()
+	require.Nil(t, sut.Record(Entry{ActionID: "old", Status: StatusApproved, FinishedAt: now.Add(-2 * time.Hour)}))
+	require.Nil(t, sut.Record(Entry{ActionID: "approved", Status: StatusApproved, FinishedAt: now}))
+	require.Nil(t, sut.Record(Entry{ActionID: "failed", Status: StatusFailed, FinishedAt: now}))
+
+	// Act
+	byTime, err := sut.Query(Filter{From: now.Add(-time.Hour), To: now.Add(time.Hour)})
+	require.Nil(t, err)
+	byStatus, err := sut.Query(Filter{Status: StatusFailed})
+	require.Nil(t, err)
+	byID, err := sut.Query(Filter{ActionID: "old"})
+	require.Nil(t, err)
+	none, err := sut.Query(Filter{ActionID: "missing"})
+	require.Nil(t, err)
+
+	// Assert
+	require.Len(t, byTime, 2)
+	assert.Equal(t, "approved", byTime[0].ActionID)
+	assert.Equal(t, "failed", byTime[1].ActionID)
+	require.Len(t, byStatus, 1)
+	assert.Equal(t, "failed", byStatus[0].ActionID)
+	require.Len(t, byID, 1)
+	assert.Equal(t, StatusApproved, byID[0].Status)
+	assert.Empty(t, none)
+}
+
+func TestFileJournal_Query_pages_the_entries(t *testing.T) {
+	// Arrange
+	sut, err := NewFileJournal(filepath.Join(t.TempDir(), "journal.jsonl"), util.NewTestLogger())
+	require.Nil(t, err)
+
+	for _, id := range []string{"first", "second", "third", "fourth", "fifth"} {
+		require.Nil(t, sut.Record(Entry{ActionID: id, Status: StatusApproved}))
+	}
+	require.Nil(t, sut.Record(Entry{ActionID: "sixth", Status: StatusFailed}))
+
+	// Act
+	last, err := sut.Query(Filter{Status: StatusApproved, Limit: 2})
+	require.Nil(t, err)
+	previous, err := sut.Query(Filter{Status: StatusApproved, Limit: 2, Before: last[0].Seq})
+	require.Nil(t, err)
+	first, err := sut.Query(Filter{Status: StatusApproved, Limit: 2, Before: previous[0].Seq})
+	require.Nil(t, err)
+
+	// Assert
+	require.Len(t, last, 2)
+	assert.Equal(t, "fourth", last[0].ActionID)
+	assert.Equal(t, 4, last[0].Seq)
+	assert.Equal(t, "fifth", last[1].ActionID)
+	require.Len(t, previous, 2)
+	assert.Equal(t, "second", previous[0].ActionID)
+	assert.Equal(t, "third", previous[1].ActionID)
+	require.Len(t, first, 1)
+	assert.Equal(t, "first", first[0].ActionID)
+	assert.Equal(t, 1, first[0].Seq)
+}
+
+func TestFileJournal_Query_skips_the_unparsable_lines(t *testing.T) {
+	// Arrange
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+	sut, err := NewFileJournal(fileName, util.NewTestLogger())
+	require.Nil(t, err)
+
+	require.Nil(t, sut.Record(Entry{ActionID: "first", Status: StatusApproved}))
+	file, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0600)
+	require.Nil(t, err)
+	_, err = file.WriteString("{\"actionID\":\"trunc\n")
+	require.Nil(t, err)
+	require.Nil(t, file.Close())
+	require.Nil(t, sut.Record(Entry{ActionID: "second", Status: StatusApproved}))
+
+	// Act
+	entries, err := sut.Query(Filter{})
+
+	// Assert
+	require.Nil(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "first", entries[0].ActionID)
+	assert.Equal(t, 1, entries[0].Seq)
+	assert.Equal(t, "second", entries[1].ActionID)
+	assert.Equal(t, 3, entries[1].Seq)
+}
+
+func TestFileJournal_Record_doesnt_record_the_sequence(t *testing.T) {
+	// Arrange
+	fileName := filepath.Join(t.TempDir(), "journal.jsonl")
+	sut, err := NewFileJournal(fileName, util.NewTestLogger())
+	require.Nil(t, err)
+
+	// Act
+	err = sut.Record(Entry{Seq: 5, ActionID: "first"})
+
+	// Assert
+	require.Nil(t, err)
+	data, err := os.ReadFile(fileName)
+	require.Nil(t, err)
+	assert.NotContains(t, string(data), `"seq"`)
+}
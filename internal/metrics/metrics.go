@@ -0,0 +1,133 @@
+// Package metrics defines the Prometheus collectors of the Signing Agent and the handler exposing them
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "signing_agent"
+
+// Outcome label values
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+var (
+	// FeedBroadcasts counts the messages broadcast by the feed hub to its clients
+	FeedBroadcasts = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "feed_hub",
+		Name:      "broadcasts_total",
+		Help:      "Number of messages received from the source and broadcast to the feed clients.",
+	})
+
+	// FeedClients is the number of feed clients registered to the feed hub
+	FeedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "feed_hub",
+		Name:      "clients",
+		Help:      "Number of feed clients registered to the feed hub.",
+	}, []string{"internal"})
+
+	// FeedDroppedMessages counts the messages dropped for the feed clients with a full queue, by overflow policy
+	FeedDroppedMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "feed_hub",
+		Name:      "dropped_messages_total",
+		Help:      "Number of messages dropped for the feed clients with a full queue, by overflow policy.",
+	}, []string{"internal", "policy"})
+
+	// FeedEvictions counts the feed clients disconnected by the feed hub for being slow, by reason
+	FeedEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "feed_hub",
+		Name:      "evictions_total",
+		Help:      "Number of feed clients disconnected for being slow, on overflow or after staying slow.",
+	}, []string{"reason"})
+
+	// SourceReconnects counts the reconnection attempts of the upstream websocket source
+	SourceReconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket_source",
+		Name:      "reconnects_total",
+		Help:      "Number of reconnections to the upstream feed after an unexpected connection error.",
+	})
+
+	// SourceReadyStateTransitions counts the ready state changes of the upstream websocket source
+	SourceReadyStateTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "websocket_source",
+		Name:      "ready_state_transitions_total",
+		Help:      "Number of ready state transitions of the upstream feed connection, by new state.",
+	}, []string{"state"})
+
+	// SignDuration observes the latency of signing and submitting an action, by outcome
+	SignDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "signer",
+		Name:      "sign_action_duration_seconds",
+		Help:      "Latency of signing an action and submitting the signatures to Qredo.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"status", "outcome"})
+
+	// AutoApprovalRetries counts the retries of the auto approver, by operation
+	AutoApprovalRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "auto_approver",
+		Name:      "retries_total",
+		Help:      "Number of retried automatic approvals or rejections.",
+	}, []string{"operation"})
+
+	// StoreDuration observes the latency of the KVStore operations, by backend
+	StoreDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "store",
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of the store operations, by backend and operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"backend", "operation", "outcome"})
+
+	// LockWaitDuration observes the time spent acquiring the action lock when load balancing is enabled
+	LockWaitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "syncronizer",
+		Name:      "lock_wait_duration_seconds",
+		Help:      "Time spent acquiring the action lock, by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+
+	// WebhookDeliveries counts the webhook delivery attempts, by endpoint and result
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "webhooks",
+		Name:      "deliveries_total",
+		Help:      "Number of webhook delivery attempts, by endpoint and result: delivered, retry or deadLetter.",
+	}, []string{"endpoint", "result"})
+
+	// ActionsAlreadyHandled counts the actions skipped because another agent instance handled them
+	ActionsAlreadyHandled = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "syncronizer",
+		Name:      "actions_already_handled_total",
+		Help:      "Number of actions skipped because they were already handled by another instance.",
+	})
+)
+
+// Handler returns the HTTP handler exposing the registered metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Outcome returns the outcome label value for err
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailure
+	}
+
+	return OutcomeSuccess
+}
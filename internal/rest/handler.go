@@ -1,12 +1,24 @@
 package rest
 
 import (
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/qredo/signing-agent/internal/api"
 	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/feed"
+	"github.com/qredo/signing-agent/internal/journal"
+	"github.com/qredo/signing-agent/internal/metrics"
+	"github.com/qredo/signing-agent/internal/service"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
 )
 
 func (a Router) RegisterAgent(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
@@ -31,23 +43,100 @@ func (a Router) RegisterAgent(_ *defs.RequestContext, w http.ResponseWriter, r *
 	return resp, nil
 }
 
-func (a Router) ClientFeed(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
-	a.agentService.RegisterClientFeed(w, r)
+// ClientFeed upgrades the connection to the websocket feed. The approve and reject commands sent on it
+// are executed on behalf of the caller, like the requests to the action endpoints.
+// The protocol version is selected by the version query parameter or the subprotocol
+func (a Router) ClientFeed(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
+	version, err := feed.RequestedVersion(r)
+	if err != nil {
+		return nil, defs.ErrBadRequest().WithDetail(err.Error())
+	}
+
+	a.agentService.RegisterClientFeed(w, r, version, a.feedCommandHandler(callerIdentity(ctx)))
 	return nil, nil
 }
 
+func (a Router) feedCommandHandler(caller string) feed.CommandHandler {
+	return func(cmd feed.Command) error {
+		if cmd.Op == feed.OpReject {
+			return a.actionService.Reject(cmd.ActionID, caller)
+		}
+
+		return a.actionService.Approve(cmd.ActionID, caller)
+	}
+}
+
+// ClientFeedSSE streams the feed as Server-Sent Events, the response is written by the feed client.
+// The protocol version is selected by the version query parameter
+func (a Router) ClientFeedSSE(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
+	version, err := feed.RequestedVersion(r)
+	if err != nil {
+		return nil, defs.ErrBadRequest().WithDetail(err.Error())
+	}
+
+	if err := a.agentService.RegisterClientSSEFeed(w, r, version); err != nil {
+		return nil, err
+	}
+
+	return rawResponse{}, nil
+}
+
+func (a Router) WebhookDeadLetters(_ *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (any, error) {
+	return a.agentService.WebhookDeadLetters()
+}
+
+func (a Router) WebhookRequeueDeadLetter(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	id := strings.TrimSpace(mux.Vars(r)["id"])
+	if id == "" {
+		return nil, defs.ErrBadRequest().WithDetail("empty dead letter id")
+	}
+
+	return a.agentService.RequeueWebhookDeadLetter(id)
+}
+
+func (a Router) WebhookDeleteDeadLetter(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	id := strings.TrimSpace(mux.Vars(r)["id"])
+	if id == "" {
+		return nil, defs.ErrBadRequest().WithDetail("empty dead letter id")
+	}
+
+	return a.agentService.DeleteWebhookDeadLetter(id)
+}
+
 func (a Router) GetClient(_ *defs.RequestContext, w http.ResponseWriter, _ *http.Request) (any, error) {
 	return a.agentService.GetAgentDetails()
 }
 
-func (a Router) ActionApprove(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+// RotateKeys replaces the keys of the registered agent
+func (a Router) RotateKeys(ctx *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (any, error) {
+	resp, err := a.agentService.RotateKeys()
+	if err != nil {
+		return nil, err
+	}
+
+	a.log.Infof("agent keys rotated by %s", callerIdentity(ctx))
+	return resp, nil
+}
+
+// DeregisterAgent stops the service and removes the registered agent
+func (a Router) DeregisterAgent(ctx *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (any, error) {
+	resp, err := a.agentService.Deregister()
+	if err != nil {
+		return nil, err
+	}
+
+	a.log.Infof("agent deregistered by %s", callerIdentity(ctx))
+	return resp, nil
+}
+
+func (a Router) ActionApprove(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
 	actionID := mux.Vars(r)["action_id"]
 	actionID = strings.TrimSpace(actionID)
 	if actionID == "" {
 		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
 	}
 
-	if err := a.actionService.Approve(actionID); err != nil {
+	if err := a.actionService.Approve(actionID, callerIdentity(ctx)); err != nil {
 		return nil, err
 	}
 
@@ -57,14 +146,14 @@ func (a Router) ActionApprove(_ *defs.RequestContext, _ http.ResponseWriter, r *
 	}, nil
 }
 
-func (a Router) ActionReject(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+func (a Router) ActionReject(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
 	actionID := mux.Vars(r)["action_id"]
 	actionID = strings.TrimSpace(actionID)
 	if actionID == "" {
 		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
 	}
 
-	if err := a.actionService.Reject(actionID); err != nil {
+	if err := a.actionService.Reject(actionID, callerIdentity(ctx)); err != nil {
 		return nil, err
 	}
 
@@ -74,14 +163,190 @@ func (a Router) ActionReject(_ *defs.RequestContext, _ http.ResponseWriter, r *h
 	}, nil
 }
 
+func (a Router) ActionsHistory(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	query := r.URL.Query()
+	filter := journal.Filter{
+		Status:   strings.TrimSpace(query.Get("status")),
+		ActionID: strings.TrimSpace(query.Get("actionID")),
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(query.Get("from")); err != nil {
+		return nil, defs.ErrBadRequest().WithDetail("invalid from, expected RFC3339 time")
+	}
+
+	if filter.To, err = parseTimeParam(query.Get("to")); err != nil {
+		return nil, defs.ErrBadRequest().WithDetail("invalid to, expected RFC3339 time")
+	}
+
+	if filter.Before, err = parseIntParam(query.Get("before"), 0); err != nil || filter.Before < 0 {
+		return nil, defs.ErrBadRequest().WithDetail("invalid before")
+	}
+
+	if filter.Limit, err = parseIntParam(query.Get("limit"), defaultPageLimit); err != nil || filter.Limit < 1 || filter.Limit > maxPageLimit {
+		return nil, defs.ErrBadRequest().WithDetail(fmt.Sprintf("invalid limit, expected between 1 and %d", maxPageLimit))
+	}
+
+	entries, err := a.actionService.History(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := api.ActionsHistoryResponse{
+		Actions: entries,
+	}
+	if len(entries) == filter.Limit && entries[0].Seq > 1 {
+		resp.Before = entries[0].Seq
+	}
+
+	return resp, nil
+}
+
+func (a Router) ActionsPending(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	query := r.URL.Query()
+	filter := service.PendingFilter{
+		Type:        strings.TrimSpace(query.Get("type")),
+		Asset:       strings.TrimSpace(query.Get("asset")),
+		WorkspaceID: strings.TrimSpace(query.Get("workspaceID")),
+		Destination: strings.TrimSpace(query.Get("destination")),
+		Limit:       defaultPageLimit,
+	}
+
+	var err error
+	if filter.Offset, err = parseIntParam(query.Get("offset"), 0); err != nil || filter.Offset < 0 {
+		return nil, defs.ErrBadRequest().WithDetail("invalid offset")
+	}
+
+	if filter.Limit, err = parseIntParam(query.Get("limit"), defaultPageLimit); err != nil || filter.Limit < 1 || filter.Limit > maxPageLimit {
+		return nil, defs.ErrBadRequest().WithDetail(fmt.Sprintf("invalid limit, expected between 1 and %d", maxPageLimit))
+	}
+
+	return a.actionService.Pending(filter), nil
+}
+
+func (a Router) ActionProof(_ *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	actionID := mux.Vars(r)["action_id"]
+	actionID = strings.TrimSpace(actionID)
+	if actionID == "" {
+		return nil, defs.ErrBadRequest().WithDetail("empty actionID")
+	}
+
+	proofs, err := a.actionService.Proofs(actionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return api.ActionProofResponse{
+		ActionID: actionID,
+		Proofs:   proofs,
+	}, nil
+}
+
+func (a Router) Metrics(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
+	metrics.Handler().ServeHTTP(w, r)
+	return rawResponse{}, nil
+}
+
 func (a Router) HealthCheckVersion(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
 	return a.version, nil
 }
 
+// HealthCheckConfig returns the running config with the sensitive settings masked
 func (a Router) HealthCheckConfig(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
-	return a.config, nil
+	cfg := a.config
+	if a.reloader != nil {
+		cfg = a.reloader.Running()
+	}
+
+	return api.ConfigResponse{
+		Config:      cfg.Redacted(),
+		Fingerprint: cfg.Fingerprint(),
+	}, nil
+}
+
+// ConfigReload re-reads the config file and applies the settings that don't require a restart
+func (a Router) ConfigReload(ctx *defs.RequestContext, _ http.ResponseWriter, _ *http.Request) (any, error) {
+	if a.reloader == nil {
+		return nil, defs.ErrNotFound().WithDetail("config reload not available")
+	}
+
+	result, err := a.reloader.Reload()
+	if err != nil {
+		a.log.Errorf("Config reload requested by %s failed, err: %v", callerIdentity(ctx), err)
+		return nil, defs.ErrBadRequest().WithDetail(err.Error())
+	}
+
+	a.log.Infof("Config reloaded by %s, applied: %v, restart required: %v", callerIdentity(ctx), result.Applied, result.RestartRequired)
+	return result, nil
+}
+
+// Backup returns the registered agent in a bundle encrypted with the passphrase or to the public key
+func (a Router) Backup(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	data := &api.BackupRequest{}
+	if err := a.decode(data, r); err != nil {
+		a.log.Debugf("failed to decode backup request, %v", err)
+		return nil, err
+	}
+
+	bundle, err := a.agentService.Backup(data)
+	if err != nil {
+		return nil, err
+	}
+
+	a.log.Infof("agent backup requested by %s", callerIdentity(ctx))
+	return bundle, nil
+}
+
+// Restore registers the agent from the backup bundle and starts the service
+func (a Router) Restore(ctx *defs.RequestContext, _ http.ResponseWriter, r *http.Request) (any, error) {
+	data := &api.RestoreRequest{}
+	if err := a.decode(data, r); err != nil {
+		a.log.Debugf("failed to decode restore request, %v", err)
+		return nil, err
+	}
+
+	resp, err := a.agentService.Restore(data)
+	if err != nil {
+		return nil, err
+	}
+
+	a.log.Infof("agent restored by %s, starting the service", callerIdentity(ctx))
+
+	if err := a.agentService.Start(); err != nil {
+		a.log.Errorf("failed to start the agent service, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to start the agent service. Please restart")
+	}
+
+	return resp, nil
 }
 
 func (a Router) HealthCheckStatus(_ *defs.RequestContext, w http.ResponseWriter, r *http.Request) (any, error) {
 	return a.agentService.GetWebsocketStatus(), nil
 }
+
+// callerIdentity returns the identity of the authenticated caller, if any
+func callerIdentity(ctx *defs.RequestContext) string {
+	if ctx == nil {
+		return defs.EmptyString
+	}
+
+	return ctx.Identity
+}
+
+// parseTimeParam parses the RFC3339 time query parameter, an empty value is the zero time
+func parseTimeParam(value string) (time.Time, error) {
+	if value = strings.TrimSpace(value); len(value) == 0 {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseIntParam parses the integer query parameter, an empty value is the default value
+func parseIntParam(value string, defaultValue int) (int, error) {
+	if value = strings.TrimSpace(value); len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	return strconv.Atoi(value)
+}
@@ -5,13 +5,22 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/pkg/errors"
 	"github.com/qredo/signing-agent/internal/api"
+	"github.com/qredo/signing-agent/internal/audit"
+	"github.com/qredo/signing-agent/internal/backup"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/feed"
+	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/journal"
+	"github.com/qredo/signing-agent/internal/service"
 	"github.com/qredo/signing-agent/internal/util"
 	"github.com/test-go/testify/assert"
 )
@@ -19,37 +28,86 @@ import (
 type mockActionService struct {
 	ApproveCalled bool
 	RejectCalled  bool
+	HistoryCalled bool
+	ProofsCalled  bool
+	PendingCalled bool
+	StopCalled    bool
 	LastActionId  string
+	LastCaller    string
+	LastFilter    journal.Filter
+	LastPending   service.PendingFilter
+	NextEntries   []journal.Entry
+	NextProofs    []audit.Proof
+	NextPending   *api.PendingActionsResponse
 	NextError     error
 }
 
-func (m *mockActionService) Approve(actionID string) error {
+func (m *mockActionService) Approve(actionID, caller string) error {
 	m.ApproveCalled = true
 	m.LastActionId = actionID
+	m.LastCaller = caller
 	return m.NextError
 }
-func (m *mockActionService) Reject(actionID string) error {
+func (m *mockActionService) Reject(actionID, caller string) error {
 	m.RejectCalled = true
 	m.LastActionId = actionID
+	m.LastCaller = caller
 	return m.NextError
 }
+func (m *mockActionService) Pending(filter service.PendingFilter) *api.PendingActionsResponse {
+	m.PendingCalled = true
+	m.LastPending = filter
+	return m.NextPending
+}
+func (m *mockActionService) Proofs(actionID string) ([]audit.Proof, error) {
+	m.ProofsCalled = true
+	m.LastActionId = actionID
+	return m.NextProofs, m.NextError
+}
+func (m *mockActionService) History(filter journal.Filter) ([]journal.Entry, error) {
+	m.HistoryCalled = true
+	m.LastFilter = filter
+	return m.NextEntries, m.NextError
+}
+func (m *mockActionService) Stop() {
+	m.StopCalled = true
+}
 
 type mockAgentService struct {
 	RegisterAgentCalled      bool
 	RegisterClientFeedCalled bool
+	RegisterSSEFeedCalled    bool
 	StartCalled              bool
 	GetAgentDetailsCalled    bool
 	GetWebsocketStatusCalled bool
+	BackupCalled             bool
+	RestoreCalled            bool
+	RotateKeysCalled         bool
+	DeregisterCalled         bool
+	DeadLettersCalled        bool
+	StopCalled               bool
+	RequeueDeadLetterCalled  bool
+	DeleteDeadLetterCalled   bool
+	LastDeadLetterID         string
 
 	NextError                     error
 	NextStartError                error
 	NextAgentRegisterResponse     *api.AgentRegisterResponse
 	NextGetAgentDetailsResponse   *api.GetAgentDetailsResponse
 	NextHealthCheckStatusResponse *api.HealthCheckStatusResponse
+	NextBundle                    *backup.Bundle
+	NextRestoreResponse           *api.RestoreResponse
+	NextRotateKeysResponse        *api.RotateKeysResponse
+	NextDeregisterResponse        *api.DeregisterResponse
+	NextDeadLettersResponse       *api.WebhookDeadLettersResponse
 
 	LastRequest              *http.Request
+	LastVersion              int
+	LastCommandHandler       feed.CommandHandler
 	LastWriter               http.ResponseWriter
 	LastAgentRegisterRequest *api.AgentRegisterRequest
+	LastBackupRequest        *api.BackupRequest
+	LastRestoreRequest       *api.RestoreRequest
 }
 
 func (m *mockAgentService) RegisterAgent(req *api.AgentRegisterRequest) (*api.AgentRegisterResponse, error) {
@@ -63,18 +121,32 @@ func (m *mockAgentService) GetAgentDetails() (*api.GetAgentDetailsResponse, erro
 	return m.NextGetAgentDetailsResponse, m.NextError
 }
 
-func (m *mockAgentService) RegisterClientFeed(w http.ResponseWriter, r *http.Request) {
+func (m *mockAgentService) RegisterClientFeed(w http.ResponseWriter, r *http.Request, version int, handleCommand feed.CommandHandler) {
 	m.RegisterClientFeedCalled = true
 	m.LastRequest = r
+	m.LastVersion = version
+	m.LastCommandHandler = handleCommand
 	m.LastWriter = w
 }
 
+func (m *mockAgentService) RegisterClientSSEFeed(w http.ResponseWriter, r *http.Request, version int) error {
+	m.RegisterSSEFeedCalled = true
+	m.LastRequest = r
+	m.LastVersion = version
+	m.LastWriter = w
+	if m.NextError == nil {
+		_, _ = w.Write([]byte(": keep-alive\n\n"))
+	}
+	return m.NextError
+}
+
 func (m *mockAgentService) Start() error {
 	m.StartCalled = true
 	return m.NextStartError
 }
 
 func (m *mockAgentService) Stop() {
+	m.StopCalled = true
 }
 
 func (m *mockAgentService) GetWebsocketStatus() *api.HealthCheckStatusResponse {
@@ -82,6 +154,48 @@ func (m *mockAgentService) GetWebsocketStatus() *api.HealthCheckStatusResponse {
 	return m.NextHealthCheckStatusResponse
 }
 
+func (m *mockAgentService) SetWebsocketConfig(cfg config.WebSocketConfig) {
+}
+
+func (m *mockAgentService) Backup(req *api.BackupRequest) (*backup.Bundle, error) {
+	m.BackupCalled = true
+	m.LastBackupRequest = req
+	return m.NextBundle, m.NextError
+}
+
+func (m *mockAgentService) RotateKeys() (*api.RotateKeysResponse, error) {
+	m.RotateKeysCalled = true
+	return m.NextRotateKeysResponse, m.NextError
+}
+
+func (m *mockAgentService) Deregister() (*api.DeregisterResponse, error) {
+	m.DeregisterCalled = true
+	return m.NextDeregisterResponse, m.NextError
+}
+
+func (m *mockAgentService) RequeueWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error) {
+	m.RequeueDeadLetterCalled = true
+	m.LastDeadLetterID = id
+	return &api.WebhookDeadLetterResponse{ID: id, Status: "requeued"}, m.NextError
+}
+
+func (m *mockAgentService) DeleteWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error) {
+	m.DeleteDeadLetterCalled = true
+	m.LastDeadLetterID = id
+	return &api.WebhookDeadLetterResponse{ID: id, Status: "deleted"}, m.NextError
+}
+
+func (m *mockAgentService) WebhookDeadLetters() (*api.WebhookDeadLettersResponse, error) {
+	m.DeadLettersCalled = true
+	return m.NextDeadLettersResponse, m.NextError
+}
+
+func (m *mockAgentService) Restore(req *api.RestoreRequest) (*api.RestoreResponse, error) {
+	m.RestoreCalled = true
+	m.LastRestoreRequest = req
+	return m.NextRestoreResponse, m.NextError
+}
+
 var testLog = util.NewTestLogger()
 
 func NewTestRequest() *http.Request {
@@ -133,7 +247,7 @@ func TestRouter_RegisterAgent_fails_to_register(t *testing.T) {
 		NextError: fmt.Errorf("some error"),
 	}
 
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil, nil, nil)
 
 	//Act
 	response, err := sut.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -157,7 +271,7 @@ func TestRouter_RegisterAgent_fails_to_start_service(t *testing.T) {
 		NextStartError:            fmt.Errorf("some error"),
 	}
 
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil, nil, nil)
 
 	//Act
 	response, err := sut.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -184,7 +298,7 @@ func TestRouter_RegisterAgent_returns_resp(t *testing.T) {
 			},
 		}}
 
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, nil, nil, nil)
 
 	//Act
 	response, err := sut.RegisterAgent(nil, httptest.NewRecorder(), NewTestRequest())
@@ -199,6 +313,20 @@ func TestRouter_RegisterAgent_returns_resp(t *testing.T) {
 	assert.Equal(t, "test name", info.Name)
 }
 
+func TestRouter_Stop_stops_agent_and_action_services(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	actionSrvMock := &mockActionService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, actionSrvMock, nil, nil)
+
+	//Act
+	sut.Stop()
+
+	//Assert
+	assert.True(t, agentSrvMock.StopCalled)
+	assert.True(t, actionSrvMock.StopCalled)
+}
+
 func TestRouter_ClientFeed_registers_client(t *testing.T) {
 	//Arrange
 	agentSrvMock := &mockAgentService{}
@@ -218,6 +346,126 @@ func TestRouter_ClientFeed_registers_client(t *testing.T) {
 	assert.True(t, agentSrvMock.RegisterClientFeedCalled)
 	assert.Equal(t, test_req, agentSrvMock.LastRequest)
 	assert.Equal(t, w, agentSrvMock.LastWriter)
+	assert.NotNil(t, agentSrvMock.LastCommandHandler)
+	assert.Equal(t, hub.FeedVersion1, agentSrvMock.LastVersion)
+}
+
+func TestRouter_ClientFeed_version_selected_by_subprotocol(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	handler := &Router{
+		agentService: agentSrvMock,
+	}
+
+	test_req, _ := http.NewRequest("GET", "/path", nil)
+	test_req.Header.Set("Sec-Websocket-Protocol", "chat, "+feed.SubprotocolV2)
+
+	//Act
+	_, err := handler.ClientFeed(nil, httptest.NewRecorder(), test_req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, hub.FeedVersion2, agentSrvMock.LastVersion)
+}
+
+func TestRouter_ClientFeed_unsupported_version(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	handler := &Router{
+		agentService: agentSrvMock,
+	}
+
+	test_req, _ := http.NewRequest("GET", "/path?version=3", nil)
+
+	//Act
+	res, err := handler.ClientFeed(nil, httptest.NewRecorder(), test_req)
+
+	//Assert
+	assert.Nil(t, res)
+	code, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "unsupported feed version `3`", detail)
+	assert.False(t, agentSrvMock.RegisterClientFeedCalled)
+}
+
+func TestRouter_ClientFeed_commands_go_through_the_action_service(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	actionSrvMock := &mockActionService{}
+	handler := &Router{
+		agentService:  agentSrvMock,
+		actionService: actionSrvMock,
+	}
+	test_req, _ := http.NewRequest("GET", "/path", nil)
+	_, _ = handler.ClientFeed(&defs.RequestContext{Identity: "approval-service"}, httptest.NewRecorder(), test_req)
+
+	//Act
+	approveErr := agentSrvMock.LastCommandHandler(feed.Command{Op: feed.OpApprove, ActionID: "action1"})
+	approved := *actionSrvMock
+	rejectErr := agentSrvMock.LastCommandHandler(feed.Command{Op: feed.OpReject, ActionID: "action2"})
+
+	//Assert
+	assert.Nil(t, approveErr)
+	assert.True(t, approved.ApproveCalled)
+	assert.False(t, approved.RejectCalled)
+	assert.Equal(t, "action1", approved.LastActionId)
+	assert.Equal(t, "approval-service", approved.LastCaller)
+
+	assert.Nil(t, rejectErr)
+	assert.True(t, actionSrvMock.RejectCalled)
+	assert.Equal(t, "action2", actionSrvMock.LastActionId)
+	assert.Equal(t, "approval-service", actionSrvMock.LastCaller)
+}
+
+func TestRouter_ClientFeedSSE_streams_the_feed(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client/feed/sse", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, ": keep-alive\n\n", rr.Body.String())
+	assert.True(t, agentSrvMock.RegisterSSEFeedCalled)
+	_, flushes := agentSrvMock.LastWriter.(http.Flusher)
+	assert.True(t, flushes)
+	assert.Equal(t, hub.FeedVersion1, agentSrvMock.LastVersion)
+}
+
+func TestRouter_ClientFeedSSE_version_selected_by_query(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client/feed/sse?version=2", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, hub.FeedVersion2, agentSrvMock.LastVersion)
+}
+
+func TestRouter_ClientFeedSSE_hub_not_running(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextError: defs.ErrNotFound().WithDetail("feed not available, the agent is not running"),
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client/feed/sse", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "feed not available, the agent is not running")
 }
 
 func TestRouter_GetClient(t *testing.T) {
@@ -252,7 +500,7 @@ func TestRouter_ActionApprove_empty_actionId(t *testing.T) {
 	//Arrange
 	actionSrvMock := &mockActionService{}
 	req, _ := http.NewRequest("PUT", "/client/action/ ", nil)
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	rr := httptest.NewRecorder()
 	m := mux.NewRouter()
@@ -289,7 +537,7 @@ func TestRouter_ActionApprove_error_on_approve(t *testing.T) {
 		err      error
 		response interface{}
 	)
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
 		response, err = sut.ActionApprove(nil, w, r)
@@ -315,7 +563,7 @@ func TestRouter_ActionApprove_success(t *testing.T) {
 		response interface{}
 	)
 
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
 		response, err = sut.ActionApprove(nil, w, r)
@@ -344,7 +592,7 @@ func TestRouter_ActionReject_empty_actionId(t *testing.T) {
 		err      error
 		response interface{}
 	)
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
 		response, err = sut.ActionReject(nil, w, r)
@@ -374,7 +622,7 @@ func TestRouter_ActionReject_error_on_reject(t *testing.T) {
 		err      error
 		response interface{}
 	)
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
 		response, err = sut.ActionReject(nil, w, r)
@@ -400,7 +648,7 @@ func TestRouter_ActionReject_success(t *testing.T) {
 		response interface{}
 	)
 
-	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock)
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
 
 	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
 		response, err = sut.ActionReject(nil, w, r)
@@ -486,6 +734,11 @@ func TestRouter_HealthCheckConfig(t *testing.T) {
 		HTTP: config.HttpSettings{
 			Addr: "some address",
 		},
+		LoadBalancing: config.LoadBalancing{
+			RedisConfig: config.RedisConfig{
+				Password: "some password",
+			},
+		},
 	}
 	sut := Router{
 		config: testConfig,
@@ -498,8 +751,803 @@ func TestRouter_HealthCheckConfig(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, response)
 
-	data, ok := response.(config.Config)
+	data, ok := response.(api.ConfigResponse)
 	assert.True(t, ok)
 	assert.Equal(t, "some url", data.Base.QredoAPI)
 	assert.Equal(t, "some address", data.HTTP.Addr)
+	assert.Equal(t, config.RedactedValue, data.LoadBalancing.RedisConfig.Password)
+	assert.Equal(t, testConfig.Fingerprint(), data.Fingerprint)
+	assert.Equal(t, "some password", testConfig.LoadBalancing.RedisConfig.Password)
+}
+
+func TestRouter_HealthCheckConfig_renders_no_secrets(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.LoadBalancing.RedisConfig.Password = "some password"
+	cfg.HTTP.Auth.HMACKeys = []config.HMACKey{{ID: "some id", Secret: "some secret"}}
+	cfg.Store.AwsConfig.SecretName = "some secret name"
+
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/healthcheck/config", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.NotContains(t, rr.Body.String(), "some password")
+	assert.NotContains(t, rr.Body.String(), "some secret")
+	assert.Contains(t, rr.Body.String(), `"id":"some id"`)
+	assert.Contains(t, rr.Body.String(), `"fingerprint":"`+cfg.Fingerprint()+`"`)
+}
+
+func TestRouter_ActionsHistory_invalid_time(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsHistory)+"?from=yesterday", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid from, expected RFC3339 time")
+	assert.False(t, actionSrvMock.HistoryCalled)
+}
+
+func TestRouter_ActionsHistory_returns_filtered_entries(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{
+		NextEntries: []journal.Entry{{ActionID: "some action id", Status: journal.StatusApproved}},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsHistory)+
+		"?from=2023-07-01T00:00:00Z&to=2023-07-02T00:00:00Z&status=approved&actionID=some%20action%20id", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, actionSrvMock.HistoryCalled)
+	assert.Equal(t, "approved", actionSrvMock.LastFilter.Status)
+	assert.Equal(t, "some action id", actionSrvMock.LastFilter.ActionID)
+	assert.Equal(t, time.Date(2023, 7, 1, 0, 0, 0, 0, time.UTC), actionSrvMock.LastFilter.From)
+	assert.Equal(t, time.Date(2023, 7, 2, 0, 0, 0, 0, time.UTC), actionSrvMock.LastFilter.To)
+	assert.Equal(t, 0, actionSrvMock.LastFilter.Before)
+	assert.Equal(t, defaultPageLimit, actionSrvMock.LastFilter.Limit)
+	assert.Contains(t, rr.Body.String(), `"actionID":"some action id"`)
+	assert.NotContains(t, rr.Body.String(), `"before"`)
+}
+
+func TestRouter_ActionsHistory_returns_the_previous_page_cursor(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{
+		NextEntries: []journal.Entry{{Seq: 7, ActionID: "first"}, {Seq: 9, ActionID: "second"}},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsHistory)+"?before=10&limit=2", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, 10, actionSrvMock.LastFilter.Before)
+	assert.Equal(t, 2, actionSrvMock.LastFilter.Limit)
+	assert.Contains(t, rr.Body.String(), `"before":7`)
+}
+
+func TestRouter_ActionsHistory_invalid_page(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantDetail string
+	}{
+		{"?before=-1", "invalid before"},
+		{"?limit=0", "invalid limit, expected between 1 and 500"},
+		{"?limit=many", "invalid limit, expected between 1 and 500"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			//Arrange
+			actionSrvMock := &mockActionService{}
+			sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+			req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsHistory)+tt.query, nil)
+			rr := httptest.NewRecorder()
+
+			//Act
+			sut.subRouter.ServeHTTP(rr, req)
+
+			//Assert
+			assert.Equal(t, http.StatusBadRequest, rr.Code)
+			assert.Contains(t, rr.Body.String(), tt.wantDetail)
+			assert.False(t, actionSrvMock.HistoryCalled)
+		})
+	}
+}
+
+func TestRouter_ActionsPending_invalid_limit(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsPending)+"?limit=1000", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "invalid limit, expected between 1 and 500")
+	assert.False(t, actionSrvMock.PendingCalled)
+}
+
+func TestRouter_ActionsPending_returns_page(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{
+		NextPending: &api.PendingActionsResponse{
+			Total:   3,
+			Offset:  1,
+			Limit:   1,
+			Actions: []api.PendingAction{{ID: "some action id", Asset: "ETH"}},
+		},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathActionsPending)+"?asset=ETH&type=transfer&offset=1&limit=1", nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, actionSrvMock.PendingCalled)
+	assert.Equal(t, service.PendingFilter{Type: "transfer", Asset: "ETH", Offset: 1, Limit: 1}, actionSrvMock.LastPending)
+	assert.Contains(t, rr.Body.String(), `"total":3`)
+	assert.Contains(t, rr.Body.String(), `"id":"some action id"`)
+}
+
+func TestRouter_ActionProof_returns_proofs(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{
+		NextProofs: []audit.Proof{{ActionID: "some_action_id", Root: "some root"}},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix("/client/action/some_action_id/proof"), nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, actionSrvMock.ProofsCalled)
+	assert.Equal(t, "some_action_id", actionSrvMock.LastActionId)
+	assert.Contains(t, rr.Body.String(), `"root":"some root"`)
+}
+
+func TestRouter_Metrics_writes_prometheus_text(t *testing.T) {
+	//Arrange
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, nil, nil, nil)
+	req, _ := http.NewRequest(http.MethodGet, WrapPathPrefix(PathMetrics), nil)
+	rr := httptest.NewRecorder()
+
+	//Act
+	sut.subRouter.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Header().Get("Content-Type"), "text/plain")
+	assert.Contains(t, rr.Body.String(), "signing_agent_")
+	assert.NotContains(t, rr.Body.String(), `"Code":200`)
+}
+
+type mockRequestAuthenticator struct {
+	AuthenticateCalled bool
+	NextIdentity       string
+	NextError          error
+}
+
+func (m *mockRequestAuthenticator) Authenticate(r *http.Request) (string, error) {
+	m.AuthenticateCalled = true
+	return m.NextIdentity, m.NextError
+}
+
+func TestRouter_protected_routes_require_authentication(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+	}
+	actionSrvMock := &mockActionService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, actionSrvMock, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/api/v2/client/action/some_action_id", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.False(t, actionSrvMock.ApproveCalled)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.Contains(t, rr.Body.String(), "missing credentials")
+}
+
+func TestRouter_open_routes_skip_authentication(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized(),
+	}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+
+	sut := NewRouter(testLog, cfg, api.Version{BuildVersion: "some version"}, &mockAgentService{}, &mockActionService{}, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/healthcheck/version", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.False(t, authMock.AuthenticateCalled)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Contains(t, rr.Body.String(), "some version")
+}
+
+func TestRouter_backup_routes_not_served_without_authentication(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.Admin.BackupEnabled = true
+
+	sut := NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/backup", strings.NewReader(`{"passphrase":"some passphrase"}`))
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.False(t, agentSrvMock.BackupCalled)
+}
+
+func TestRouter_backup_routes_always_protected(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+	}
+	agentSrvMock := &mockAgentService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.Admin.BackupEnabled = true
+	cfg.HTTP.Auth.Enabled = true
+	cfg.HTTP.Auth.OpenRoutes = append(cfg.HTTP.Auth.OpenRoutes, PathBackup)
+
+	sut := NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/backup", strings.NewReader(`{"passphrase":"some passphrase"}`))
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.False(t, agentSrvMock.BackupCalled)
+}
+
+func TestRouter_protected_route_authenticated(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextIdentity: "approval-service",
+	}
+	actionSrvMock := &mockActionService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, actionSrvMock, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPut, "/api/v2/client/action/some_action_id", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.True(t, actionSrvMock.ApproveCalled)
+	assert.Equal(t, "some_action_id", actionSrvMock.LastActionId)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRouter_ActionApprove_passes_caller_identity(t *testing.T) {
+	//Arrange
+	actionSrvMock := &mockActionService{}
+	req, _ := http.NewRequest("PUT", "/client/action/some_action_id", nil)
+	rr := httptest.NewRecorder()
+	m := mux.NewRouter()
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, nil, actionSrvMock, nil, nil)
+
+	m.HandleFunc("/client/action/{action_id}", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = sut.ActionApprove(&defs.RequestContext{Identity: "approval-service"}, w, r)
+	})
+
+	//Act
+	m.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, actionSrvMock.ApproveCalled)
+	assert.Equal(t, "approval-service", actionSrvMock.LastCaller)
+}
+
+func TestRouter_newServerTLSConfig(t *testing.T) {
+	//Act
+	noClientCA, err := newServerTLSConfig(config.TLSConfig{Enabled: true})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Nil(t, noClientCA.ClientCAs)
+
+	//Act
+	_, err = newServerTLSConfig(config.TLSConfig{Enabled: true, RequireClientCert: true})
+
+	//Assert
+	assert.EqualError(t, err, "client certificates required but no client CA file configured")
+
+	//Act
+	_, err = newServerTLSConfig(config.TLSConfig{Enabled: true, ClientCAFile: "missing-ca.pem"})
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "read client CA file")
+}
+
+func TestRouter_ConfigReload_applies_the_CORS_policy(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.CORSAllowOrigins = []string{"https://old.example.com"}
+	cfg.HTTP.Auth.Enabled = true
+
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+	reloaded := cfg
+	reloaded.HTTP.CORSAllowOrigins = []string{"https://new.example.com"}
+	assert.Nil(t, reloaded.Save(fileName))
+
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, &mockRequestAuthenticator{NextIdentity: "operator"}, config.NewReloader(fileName, cfg))
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/config/reload", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"applied":["http.CORSAllowOrigins"],"restartRequired":[]}`, rr.Body.String())
+
+	rr = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/api/v2/healthcheck/version", nil)
+	req.Header.Set("Origin", "https://new.example.com")
+	sut.handler.ServeHTTP(rr, req)
+	assert.Equal(t, "https://new.example.com", rr.Header().Get("Access-Control-Allow-Origin"))
+}
+
+func TestRouter_ConfigReload_invalid_config(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+
+	fileName := filepath.Join(t.TempDir(), "cc.yaml")
+	reloaded := cfg
+	reloaded.Logging.Level = "verbose"
+	assert.Nil(t, reloaded.Save(fileName))
+
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, &mockRequestAuthenticator{NextIdentity: "operator"}, config.NewReloader(fileName, cfg))
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/config/reload", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), "unsupported logging level")
+}
+
+func TestRouter_ConfigReload_not_available(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.HTTP.Auth.Enabled = true
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, &mockRequestAuthenticator{NextIdentity: "operator"}, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/config/reload", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRouter_ConfigReload_not_served_without_authentication(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.Default()
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, nil, config.NewReloader("cc.yaml", cfg))
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/config/reload", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
+
+func TestRouter_ConfigReload_always_protected(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+	}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+	cfg.HTTP.Auth.OpenRoutes = append(cfg.HTTP.Auth.OpenRoutes, PathConfigReload)
+	sut := NewRouter(testLog, cfg, api.Version{}, &mockAgentService{}, &mockActionService{}, authMock, config.NewReloader("cc.yaml", cfg))
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/config/reload", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+}
+
+// newAdminRouter returns a Router serving the admin endpoints, backup and restore included, to an authenticated operator
+func newAdminRouter(agentSrvMock *mockAgentService) *Router {
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.Admin.BackupEnabled = true
+	cfg.HTTP.Auth.Enabled = true
+
+	return NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, &mockRequestAuthenticator{NextIdentity: "operator"}, nil)
+}
+
+func TestRouter_Backup_returns_bundle(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextBundle: &backup.Bundle{
+			Version: 1,
+			Method:  backup.MethodPassphrase,
+			AgentID: "some agent",
+		},
+	}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/backup", bytes.NewReader([]byte(`{"passphrase":"some passphrase"}`)))
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"version":1,"method":"passphrase","agentID":"some agent","createdAt":0}`, rr.Body.String())
+	assert.True(t, agentSrvMock.BackupCalled)
+	assert.Equal(t, "some passphrase", agentSrvMock.LastBackupRequest.Passphrase)
+}
+
+func TestRouter_Restore_missing_bundle(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/admin/restore", bytes.NewReader([]byte(`{"passphrase":"some passphrase"}`)))
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.False(t, agentSrvMock.RestoreCalled)
+}
+
+func TestRouter_Restore_starts_service(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextRestoreResponse: &api.RestoreResponse{
+			AgentID: "some agent",
+			FeedURL: "some feed",
+		},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	req, _ := http.NewRequest(http.MethodPost, "/path", bytes.NewReader([]byte(`{"bundle":{"version":1,"method":"passphrase","agentID":"some agent"},"passphrase":"some passphrase"}`)))
+
+	//Act
+	response, err := sut.Restore(nil, httptest.NewRecorder(), req)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, agentSrvMock.NextRestoreResponse, response)
+	assert.True(t, agentSrvMock.StartCalled)
+	assert.Equal(t, "some agent", agentSrvMock.LastRestoreRequest.Bundle.AgentID)
+	assert.Equal(t, "some passphrase", agentSrvMock.LastRestoreRequest.Passphrase)
+}
+
+func TestRouter_RotateKeys(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextRotateKeysResponse: &api.RotateKeysResponse{
+			AgentID:  "some agent",
+			ActionID: "some action",
+		},
+	}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/client/rotate", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"agentID":"some agent","actionID":"some action"}`, rr.Body.String())
+	assert.True(t, agentSrvMock.RotateKeysCalled)
+}
+
+func TestRouter_RotateKeys_not_served_without_authentication(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/client/rotate", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.False(t, agentSrvMock.RotateKeysCalled)
+}
+
+func TestRouter_RotateKeys_always_protected(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+	}
+	agentSrvMock := &mockAgentService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+	cfg.HTTP.Auth.OpenRoutes = append(cfg.HTTP.Auth.OpenRoutes, PathClientRotateKeys)
+	sut := NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/client/rotate", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.False(t, agentSrvMock.RotateKeysCalled)
+}
+
+func TestRouter_DeregisterAgent(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextDeregisterResponse: &api.DeregisterResponse{
+			AgentID: "some agent",
+		},
+	}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v2/client", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"agentID":"some agent"}`, rr.Body.String())
+	assert.True(t, agentSrvMock.DeregisterCalled)
+}
+
+func TestRouter_DeregisterAgent_not_served_without_authentication(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v2/client", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusMethodNotAllowed, rr.Code)
+	assert.False(t, agentSrvMock.DeregisterCalled)
+}
+
+func TestRouter_DeregisterAgent_always_protected(t *testing.T) {
+	//Arrange
+	authMock := &mockRequestAuthenticator{
+		NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+	}
+	agentSrvMock := &mockAgentService{}
+	cfg := config.Config{}
+	cfg.Default()
+	cfg.HTTP.Auth.Enabled = true
+	cfg.HTTP.Auth.OpenRoutes = append(cfg.HTTP.Auth.OpenRoutes, PathClient)
+	sut := NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, authMock, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v2/client", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.True(t, authMock.AuthenticateCalled)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+	assert.False(t, agentSrvMock.DeregisterCalled)
+}
+
+func TestRouter_WebhookDeadLetters(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextDeadLettersResponse: &api.WebhookDeadLettersResponse{
+			DeadLetters: []api.WebhookDeadLetter{{
+				ID:        "delivery",
+				Endpoint:  "approvals",
+				ActionID:  "action",
+				Body:      []byte(`{"id":"action"}`),
+				Attempts:  10,
+				LastError: "endpoint responded 503",
+			}},
+		},
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client/webhooks/deadletters", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, agentSrvMock.DeadLettersCalled)
+	assert.Contains(t, rr.Body.String(), `"body":{"id":"action"}`)
+	assert.Contains(t, rr.Body.String(), `"lastError":"endpoint responded 503"`)
+}
+
+func TestRouter_WebhookDeadLetters_not_enabled(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextError: defs.ErrNotFound().WithDetail("webhooks are not enabled"),
+	}
+	sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v2/client/webhooks/deadletters", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Contains(t, rr.Body.String(), "webhooks are not enabled")
+}
+
+func TestRouter_WebhookRequeueDeadLetter(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v2/client/webhooks/deadletters/delivery/requeue", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.True(t, agentSrvMock.RequeueDeadLetterCalled)
+	assert.Equal(t, "delivery", agentSrvMock.LastDeadLetterID)
+	assert.Contains(t, rr.Body.String(), `"status":"requeued"`)
+}
+
+func TestRouter_WebhookDeleteDeadLetter_not_found(t *testing.T) {
+	//Arrange
+	agentSrvMock := &mockAgentService{
+		NextError: defs.ErrNotFound().WithDetail("webhook dead letter not found"),
+	}
+	sut := newAdminRouter(agentSrvMock)
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodDelete, "/api/v2/client/webhooks/deadletters/delivery", nil)
+
+	//Act
+	sut.handler.ServeHTTP(rr, req)
+
+	//Assert
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.True(t, agentSrvMock.DeleteDeadLetterCalled)
+	assert.Equal(t, "delivery", agentSrvMock.LastDeadLetterID)
+	assert.Contains(t, rr.Body.String(), "webhook dead letter not found")
+}
+
+func TestRouter_WebhookDeadLetter_not_served_without_authentication(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/v2/client/webhooks/deadletters/delivery/requeue"},
+		{http.MethodDelete, "/api/v2/client/webhooks/deadletters/delivery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			//Arrange
+			agentSrvMock := &mockAgentService{}
+			sut := NewRouter(testLog, config.Config{}, api.Version{}, agentSrvMock, &mockActionService{}, nil, nil)
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+
+			//Act
+			sut.handler.ServeHTTP(rr, req)
+
+			//Assert
+			assert.Equal(t, http.StatusNotFound, rr.Code)
+			assert.False(t, agentSrvMock.RequeueDeadLetterCalled)
+			assert.False(t, agentSrvMock.DeleteDeadLetterCalled)
+		})
+	}
+}
+
+func TestRouter_WebhookDeadLetter_always_protected(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPost, "/api/v2/client/webhooks/deadletters/delivery/requeue"},
+		{http.MethodDelete, "/api/v2/client/webhooks/deadletters/delivery"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			//Arrange
+			authMock := &mockRequestAuthenticator{
+				NextError: defs.ErrUnauthorized().WithDetail("missing credentials"),
+			}
+			agentSrvMock := &mockAgentService{}
+			cfg := config.Config{}
+			cfg.Default()
+			cfg.HTTP.Auth.Enabled = true
+			cfg.HTTP.Auth.OpenRoutes = append(cfg.HTTP.Auth.OpenRoutes, PathWebhookRequeue, PathWebhookDeadLetter)
+			sut := NewRouter(testLog, cfg, api.Version{}, agentSrvMock, &mockActionService{}, authMock, nil)
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+
+			//Act
+			sut.handler.ServeHTTP(rr, req)
+
+			//Assert
+			assert.True(t, authMock.AuthenticateCalled)
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+			assert.False(t, agentSrvMock.RequeueDeadLetterCalled)
+			assert.False(t, agentSrvMock.DeleteDeadLetterCalled)
+		})
+	}
 }
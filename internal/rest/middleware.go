@@ -12,22 +12,29 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/qredo/signing-agent/internal/auth"
+	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 )
 
-func NewMiddleware(log *zap.SugaredLogger, logAllRequests bool) *Middleware {
+// NewMiddleware returns a new Middleware. The authenticator is used by the protected routes and can be nil if authentication is disabled
+func NewMiddleware(log *zap.SugaredLogger, httpConfig config.HttpSettings, authenticator auth.RequestAuthenticator) *Middleware {
 	l := log.Desugar()
 	ll := l.WithOptions(zap.AddCallerSkip(1)).Sugar()
 	mw := &Middleware{
-		log:            ll,
-		logAllRequests: logAllRequests,
+		log:              ll,
+		logAllRequests:   httpConfig.LogAllRequests,
+		authenticator:    authenticator,
+		clientIdentities: httpConfig.TLS.ClientIdentities,
 	}
 	return mw
 }
 
 type Middleware struct {
-	log            *zap.SugaredLogger
-	logAllRequests bool
+	log              *zap.SugaredLogger
+	logAllRequests   bool
+	authenticator    auth.RequestAuthenticator
+	clientIdentities map[string]string
 }
 
 func (m *Middleware) sessionMiddleware(next appHandlerFunc) appHandlerFunc {
@@ -35,6 +42,7 @@ func (m *Middleware) sessionMiddleware(next appHandlerFunc) appHandlerFunc {
 	return func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
 
 		ctx.TraceID = uuid.New().String()
+		ctx.Identity = auth.ClientCertificateIdentity(r, m.clientIdentities)
 		context.Set(r, "ctx", *ctx)
 
 		return next(ctx, w, r)
@@ -47,6 +55,26 @@ func (m *Middleware) notProtectedMiddleware(next appHandlerFunc) appHandlerFunc
 	}
 }
 
+// protectedMiddleware authenticates the request and stores the caller identity in the request context.
+// A request with a verified client certificate is already authenticated
+func (m *Middleware) protectedMiddleware(next appHandlerFunc) appHandlerFunc {
+	return func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error) {
+		if len(ctx.Identity) > 0 {
+			return next(ctx, w, r)
+		}
+
+		identity, err := m.authenticator.Authenticate(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ctx.Identity = identity
+		context.Set(r, "ctx", *ctx)
+
+		return next(ctx, w, r)
+	}
+}
+
 type loggingResponseWriter struct {
 	http.ResponseWriter
 	hijacked   bool
@@ -70,6 +98,13 @@ func (lrw *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error)
 	return h.Hijack()
 }
 
+// Flush sends the buffered data to the client, for the streamed responses
+func (lrw *loggingResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -83,12 +118,13 @@ func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 
 		next.ServeHTTP(lw, r)
 
-		var traceID string
+		var traceID, identity string
 
 		ctxI := context.Get(r, "ctx")
 		if ctxI != nil {
 			if ctx, ok := ctxI.(defs.RequestContext); ok {
 				traceID = ctx.TraceID
+				identity = ctx.Identity
 			}
 		}
 
@@ -97,6 +133,7 @@ func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 			if err, ok := errI.(error); ok {
 				m.log.Infow("REQ",
 					"trace_id", traceID,
+					"identity", identity,
 					"error", err.Error())
 			} else {
 				msg := fmt.Sprintf("REQ: %v: Unknown error type: %#v", traceID, errI)
@@ -109,7 +146,11 @@ func (m *Middleware) loggingMiddleware(next http.Handler) http.Handler {
 
 		// TODO: Make requests method logging configurable
 		if m.logAllRequests || r.Method != http.MethodGet || errI != nil {
-			m.log.Infof("REQ %s %v %v %v - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, time.Since(startTime))
+			if len(identity) > 0 {
+				m.log.Infof("REQ %s %v %v %v by `%s` - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, identity, time.Since(startTime))
+			} else {
+				m.log.Infof("REQ %s %v %v %v - [%v]", traceID, lw.statusCode, r.Method, r.RequestURI, time.Since(startTime))
+			}
 		}
 	})
 }
@@ -15,6 +15,9 @@ func WrapPathPrefix(uri string) string {
 	return strings.Join([]string{defs.PathPrefix, uri}, "")
 }
 
+// rawResponse is returned by the handlers writing the response themselves, it's not JSON encoded
+type rawResponse struct{}
+
 type appHandlerFunc func(ctx *defs.RequestContext, w http.ResponseWriter, r *http.Request) (interface{}, error)
 
 func (a appHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -26,17 +29,16 @@ func (a appHandlerFunc) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if strings.ToLower(r.Header.Get("connection")) == "upgrade" &&
 		strings.ToLower(r.Header.Get("upgrade")) == "websocket" {
 		if err != nil {
-			var apiErr *defs.APIError
-
-			if !errors.As(err, &apiErr) {
-				apiErr = defs.ErrInternal().Wrap(err)
-			}
-
-			context.Set(r, "error", apiErr)
+			// the connection is not upgraded when the request fails, the error can still be written
+			writeHTTPError(w, r, err)
 		}
 		return
 	}
 
+	if _, ok := resp.(rawResponse); ok && err == nil {
+		return
+	}
+
 	formatJSONResp(w, r, resp, err)
 }
 
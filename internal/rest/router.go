@@ -1,16 +1,21 @@
 package rest
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"net/http"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/gorilla/context"
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
 	"github.com/qredo/signing-agent/internal/api"
+	"github.com/qredo/signing-agent/internal/auth"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/service"
@@ -25,9 +30,21 @@ const (
 	PathClient             = "/client"
 	PathAction             = "/client/action/{action_id}"
 	PathClientFeed         = "/client/feed"
+	PathClientFeedSSE      = "/client/feed/sse"
+	PathWebhookDeadLetters = "/client/webhooks/deadletters"
+	PathWebhookDeadLetter  = "/client/webhooks/deadletters/{id}"
+	PathWebhookRequeue     = "/client/webhooks/deadletters/{id}/requeue"
+	PathActionsHistory     = "/client/actions/history"
+	PathActionsPending     = "/client/actions/pending"
+	PathActionProof        = "/client/action/{action_id}/proof"
 	PathApprove            = "/approve"
 	PathGetToken           = "/token"
 	PathRefreshToken       = "/refresh"
+	PathMetrics            = "/metrics"
+	PathClientRotateKeys   = "/client/rotate"
+	PathConfigReload       = "/admin/config/reload"
+	PathBackup             = "/admin/backup"
+	PathRestore            = "/admin/restore"
 )
 
 type route struct {
@@ -40,6 +57,7 @@ type Router struct {
 	log       *zap.SugaredLogger
 	config    config.Config
 	handler   http.Handler
+	cors      *atomic.Value
 	subRouter *mux.Router
 
 	middleware *Middleware
@@ -47,23 +65,32 @@ type Router struct {
 
 	agentService  service.AgentService
 	actionService service.ActionService
+	reloader      *config.Reloader
 
 	decode func(interface{}, *http.Request) error
 }
 
-func NewRouter(log *zap.SugaredLogger, config config.Config, version api.Version, service service.AgentService, actionService service.ActionService) *Router {
+// NewRouter returns a new Router. The authenticator is required only if authentication is enabled in the config,
+// the config can be reloaded only if a reloader is provided
+func NewRouter(log *zap.SugaredLogger, config config.Config, version api.Version, service service.AgentService, actionService service.ActionService,
+	authenticator auth.RequestAuthenticator, reloader *config.Reloader) *Router {
 	app := &Router{
 		log:           log,
-		middleware:    NewMiddleware(log, config.HTTP.LogAllRequests),
+		middleware:    NewMiddleware(log, config.HTTP, authenticator),
 		subRouter:     mux.NewRouter().PathPrefix(defs.PathPrefix).Subrouter(),
 		version:       version,
 		config:        config,
 		agentService:  service,
 		actionService: actionService,
+		reloader:      reloader,
+		cors:          &atomic.Value{},
 		decode:        util.DecodeRequest,
 	}
 
 	app.setRoutes()
+	if reloader != nil {
+		reloader.OnReload(app.reloadCORS)
+	}
 	return app
 }
 
@@ -77,16 +104,21 @@ func (a *Router) setRoutes() {
 		{PathClient, http.MethodGet, a.GetClient},
 		{PathAction, http.MethodPut, a.ActionApprove},
 		{PathAction, http.MethodDelete, a.ActionReject},
+		{PathActionsHistory, http.MethodGet, a.ActionsHistory},
+		{PathActionsPending, http.MethodGet, a.ActionsPending},
+		{PathActionProof, http.MethodGet, a.ActionProof},
 		{PathClientFeed, defs.MethodWebsocket, a.ClientFeed},
+		{PathClientFeedSSE, http.MethodGet, a.ClientFeedSSE},
+		{PathWebhookDeadLetters, http.MethodGet, a.WebhookDeadLetters},
+		{PathMetrics, http.MethodGet, a.Metrics},
 	}
 
 	for _, route := range routes {
-		middle := a.middleware.notProtectedMiddleware
-		if route.method == defs.MethodWebsocket {
-			a.subRouter.Handle(route.path, a.middleware.sessionMiddleware(middle(route.handler)))
-		} else {
-			a.subRouter.Handle(route.path, a.middleware.sessionMiddleware(middle(route.handler))).Methods(route.method)
-		}
+		a.handle(route, a.isProtected(route.path))
+	}
+
+	for _, route := range a.authRequiredRoutes() {
+		a.handle(route, true)
 	}
 
 	a.subRouter.Use(a.middleware.loggingMiddleware)
@@ -95,6 +127,64 @@ func (a *Router) setRoutes() {
 	a.setupCORS()
 }
 
+func (a *Router) handle(route route, protected bool) {
+	middle := a.middleware.notProtectedMiddleware
+	if protected {
+		middle = a.middleware.protectedMiddleware
+	}
+
+	if route.method == defs.MethodWebsocket {
+		a.subRouter.Handle(route.path, a.middleware.sessionMiddleware(middle(route.handler)))
+	} else {
+		a.subRouter.Handle(route.path, a.middleware.sessionMiddleware(middle(route.handler))).Methods(route.method)
+	}
+}
+
+// authRequiredRoutes returns the routes changing the config, the keys or the identity of the agent. They are served
+// only with the authentication enabled and always require it, even if listed in the open routes.
+// The backup and restore endpoints, handing out and replacing the agent keys, must be enabled in the config as well
+func (a *Router) authRequiredRoutes() []route {
+	routes := []route{
+		{PathConfigReload, http.MethodPost, a.ConfigReload},
+		{PathClient, http.MethodDelete, a.DeregisterAgent},
+		{PathClientRotateKeys, http.MethodPost, a.RotateKeys},
+		{PathWebhookRequeue, http.MethodPost, a.WebhookRequeueDeadLetter},
+		{PathWebhookDeadLetter, http.MethodDelete, a.WebhookDeleteDeadLetter},
+	}
+
+	if a.config.Admin.BackupEnabled {
+		routes = append(routes,
+			route{PathBackup, http.MethodPost, a.Backup},
+			route{PathRestore, http.MethodPost, a.Restore})
+	}
+
+	if !a.authEnabled() {
+		a.log.Warn("the admin endpoints are not served, the authentication is not enabled")
+		return nil
+	}
+
+	return routes
+}
+
+func (a *Router) authEnabled() bool {
+	return a.config.HTTP.Auth.Enabled && a.middleware.authenticator != nil
+}
+
+// isProtected returns true if authentication is enabled and the path is not in the list of open routes
+func (a *Router) isProtected(path string) bool {
+	if !a.authEnabled() {
+		return false
+	}
+
+	for _, openRoute := range a.config.HTTP.Auth.OpenRoutes {
+		if openRoute == path {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Start starts the service
 func (a *Router) Start() error {
 	errChan := make(chan error)
@@ -114,25 +204,87 @@ func (a *Router) StartHTTPListener(errChan chan error) {
 	}
 
 	if a.config.HTTP.TLS.Enabled {
+		tlsConfig, err := newServerTLSConfig(a.config.HTTP.TLS)
+		if err != nil {
+			errChan <- err
+			return
+		}
+
+		server := &http.Server{
+			Addr:      a.config.HTTP.Addr,
+			Handler:   context.ClearHandler(a.handler),
+			TLSConfig: tlsConfig,
+		}
+
 		a.log.Info("Start listening on HTTPS")
-		errChan <- http.ListenAndServeTLS(a.config.HTTP.Addr, a.config.HTTP.TLS.CertFile, a.config.HTTP.TLS.KeyFile, context.ClearHandler(a.handler))
+		errChan <- server.ListenAndServeTLS(a.config.HTTP.TLS.CertFile, a.config.HTTP.TLS.KeyFile)
 	} else {
 		a.log.Info("Start listening on HTTP")
 		errChan <- http.ListenAndServe(a.config.HTTP.Addr, context.ClearHandler(a.handler))
 	}
 }
 
-// Stop shuts down the Signing Agent service
+// Stop shuts down the Signing Agent service, then the action service once no more actions can come in
 func (a *Router) Stop() {
 	a.agentService.Stop()
+	a.actionService.Stop()
 }
 
+// newServerTLSConfig returns the TLS config verifying the client certificates against the client CA bundle, if configured
+func newServerTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if len(cfg.ClientCAFile) == 0 {
+		if cfg.RequireClientCert {
+			return nil, errors.New("client certificates required but no client CA file configured")
+		}
+		return tlsConfig, nil
+	}
+
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "read client CA file")
+	}
+
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no valid certificates in the client CA file")
+	}
+
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// setupCORS sets the handler applying the CORS policy, the allowed origins can be changed by reloading the config
 func (a *Router) setupCORS() {
+	a.cors.Store(a.newCORSHandler(a.config.HTTP.CORSAllowOrigins))
+	a.handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.cors.Load().(http.Handler).ServeHTTP(w, r)
+	})
+}
+
+// reloadCORS applies the allowed origins of the reloaded config
+func (a *Router) reloadCORS(cfg config.Config) {
+	a.cors.Store(a.newCORSHandler(cfg.HTTP.CORSAllowOrigins))
+	a.log.Infof("CORS policy: %s", strings.Join(cfg.HTTP.CORSAllowOrigins, ","))
+}
+
+func (a *Router) newCORSHandler(allowedOrigins []string) http.Handler {
 	cors := handlers.CORS(
 		handlers.AllowedHeaders([]string{
 			"Content-Type",
-			"X-Requested-With"}),
-		handlers.AllowedOrigins(a.config.HTTP.CORSAllowOrigins),
+			"X-Requested-With",
+			"Authorization",
+			"Sa-Api-Key",
+			"Sa-Api-Signature",
+			"Sa-Api-Timestamp"}),
+		handlers.AllowedOrigins(allowedOrigins),
 		handlers.AllowedMethods([]string{
 			http.MethodGet,
 			http.MethodPost,
@@ -142,7 +294,7 @@ func (a *Router) setupCORS() {
 		handlers.AllowCredentials(),
 	)
 
-	a.handler = cors(a.subRouter)
+	return cors(a.subRouter)
 }
 
 func (a Router) printRoutes() {
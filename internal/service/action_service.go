@@ -1,23 +1,58 @@
 package service
 
 import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"go.uber.org/zap"
+
 	"github.com/qredo/signing-agent/internal/action"
+	"github.com/qredo/signing-agent/internal/api"
+	"github.com/qredo/signing-agent/internal/audit"
+	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub/message"
-	"go.uber.org/zap"
+	"github.com/qredo/signing-agent/internal/journal"
 )
 
+// PendingFilter selects the pending actions returned by Pending. Empty fields match any action
+type PendingFilter struct {
+	Type        string
+	Asset       string
+	WorkspaceID string
+	Destination string
+	Offset      int
+	Limit       int
+}
+
+// ActionService approves or rejects actions on request. The caller is the identity of the requester, if known
 type ActionService interface {
-	Reject(actionID string) error
-	Approve(actionID string) error
+	Reject(actionID, caller string) error
+	Approve(actionID, caller string) error
+	// History returns the decisions recorded in the journal matching the filter
+	History(filter journal.Filter) ([]journal.Entry, error)
+	// Proofs returns the audit inclusion proofs of the action
+	Proofs(actionID string) ([]audit.Proof, error)
+	// Pending returns a page of the cached pending actions matching the filter
+	Pending(filter PendingFilter) *api.PendingActionsResponse
+	// Stop seals and stops the audit log, if provided
+	Stop()
 }
 
-func NewActionService(syncronizer action.ActionSync, log *zap.SugaredLogger, loadBalancingEnabled bool, messageCache message.CacheRemover, signer action.Signer) ActionService {
+// NewActionService returns a new ActionService. The decisions are recorded in the actionJournal, if provided.
+// The inclusion proofs are read from the auditLog, if provided
+func NewActionService(syncronizer action.ActionSync, log *zap.SugaredLogger, loadBalancingEnabled bool, messageCache message.Cacher,
+	signer action.Signer, actionJournal journal.Journal, auditLog audit.Log) ActionService {
 	return &actionSrv{
 		syncronizer:          syncronizer,
 		log:                  log,
 		loadBalancingEnabled: loadBalancingEnabled,
 		messageCache:         messageCache,
 		signer:               signer,
+		journal:              actionJournal,
+		auditLog:             auditLog,
 	}
 }
 
@@ -25,23 +60,121 @@ type actionSrv struct {
 	syncronizer          action.ActionSync
 	log                  *zap.SugaredLogger
 	loadBalancingEnabled bool
-	messageCache         message.CacheRemover
+	messageCache         message.Cacher
 	signer               action.Signer
+	journal              journal.Journal
+	auditLog             audit.Log
 }
 
 // Approve the action for the given actionID
-func (a *actionSrv) Approve(actionID string) error {
-	a.log.Infof("Action Service: approving action `%s`", actionID)
-	return a.act(actionID, true)
+func (a *actionSrv) Approve(actionID, caller string) error {
+	a.log.Infow("Action Service: approving action", "actionID", actionID, "caller", caller)
+	return a.act(actionID, caller, true)
 }
 
 // Reject the action for the given actionID
-func (a *actionSrv) Reject(actionID string) error {
-	a.log.Infof("Action Service: rejecting action `%s`", actionID)
-	return a.act(actionID, false)
+func (a *actionSrv) Reject(actionID, caller string) error {
+	a.log.Infow("Action Service: rejecting action", "actionID", actionID, "caller", caller)
+	return a.act(actionID, caller, false)
 }
 
-func (a *actionSrv) act(actionID string, approve bool) error {
+// Stop stops the audit log, sealing the leaves not yet committed
+func (a *actionSrv) Stop() {
+	if a.auditLog != nil {
+		a.auditLog.Stop()
+	}
+}
+
+// History returns the journal entries matching the filter
+func (a *actionSrv) History(filter journal.Filter) ([]journal.Entry, error) {
+	if a.journal == nil {
+		return nil, defs.ErrNotFound().WithDetail("the approval journal is not enabled")
+	}
+
+	entries, err := a.journal.Query(filter)
+	if err != nil {
+		var apiErr *defs.APIError
+		if errors.As(err, &apiErr) {
+			return nil, err
+		}
+
+		a.log.Errorf("Action Service: failed to query the journal, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to read the approval journal")
+	}
+
+	return entries, nil
+}
+
+// Proofs returns the inclusion proofs of the action in the sealed audit batches
+func (a *actionSrv) Proofs(actionID string) ([]audit.Proof, error) {
+	if a.auditLog == nil {
+		return nil, defs.ErrNotFound().WithDetail("the audit log is not enabled")
+	}
+
+	proofs, err := a.auditLog.Proofs(actionID)
+	if err != nil {
+		a.log.Errorf("Action Service: failed to generate the proofs for action `%s`, err: %v", actionID, err)
+		return nil, defs.ErrInternal().WithDetail("failed to generate the inclusion proofs")
+	}
+
+	if len(proofs) == 0 {
+		return nil, defs.ErrNotFound().WithDetail("action not found in a sealed audit batch")
+	}
+
+	return proofs, nil
+}
+
+// Pending returns the cached actions still pending and not expired, ordered by expiration time.
+// No action is returned when the message cache is not used, i.e. all the actions are approved automatically
+func (a *actionSrv) Pending(filter PendingFilter) *api.PendingActionsResponse {
+	resp := &api.PendingActionsResponse{
+		Offset:  filter.Offset,
+		Limit:   filter.Limit,
+		Actions: []api.PendingAction{},
+	}
+
+	if a.messageCache == nil {
+		return resp
+	}
+
+	matching := []defs.ActionInfo{}
+	for _, message := range a.messageCache.GetMessages() {
+		action := defs.ActionInfo{}
+		if err := json.Unmarshal(message, &action); err != nil {
+			a.log.Debugf("Action Service: failed to unmarshal the cached message, err: %v", err)
+			continue
+		}
+
+		if action.Status == defs.StatusPending && !action.IsExpired() && filter.matches(action) {
+			matching = append(matching, action)
+		}
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		if matching[i].ExpireTime != matching[j].ExpireTime {
+			return matching[i].ExpireTime < matching[j].ExpireTime
+		}
+		return matching[i].ID < matching[j].ID
+	})
+
+	resp.Total = len(matching)
+	for i := filter.Offset; i < len(matching) && len(resp.Actions) < filter.Limit; i++ {
+		resp.Actions = append(resp.Actions, api.PendingAction{
+			ID:          matching[i].ID,
+			Status:      matching[i].Status,
+			ExpireTime:  matching[i].ExpireTime,
+			Type:        matching[i].Type,
+			WorkspaceID: matching[i].WorkspaceID,
+			Asset:       matching[i].Asset,
+			Amount:      matching[i].Amount,
+			Destination: matching[i].Destination,
+		})
+	}
+
+	return resp
+}
+
+func (a *actionSrv) act(actionID, caller string, approve bool) error {
 	if a.loadBalancingEnabled {
 		if !a.syncronizer.ShouldHandleAction(actionID) {
 			a.log.Infof("Action Service: action `%s` was already handled!", actionID)
@@ -59,13 +192,21 @@ func (a *actionSrv) act(actionID string, approve bool) error {
 		}()
 	}
 
-	var err error
+	var (
+		signedMessages [][]byte
+		err            error
+		decision       = journal.DecisionApprove
+		startedAt      = time.Now()
+	)
 	if approve {
-		err = a.signer.ActionApprove(actionID)
+		signedMessages, err = a.signer.ActionApprove(actionID)
 	} else {
-		err = a.signer.ActionReject(actionID)
+		decision = journal.DecisionReject
+		signedMessages, err = a.signer.ActionReject(actionID)
 	}
 
+	a.record(journal.NewEntry(actionID, decision, journal.ModeManual, caller, signedMessages, startedAt, 0, err))
+
 	if err != nil {
 		return err
 	}
@@ -76,3 +217,24 @@ func (a *actionSrv) act(actionID string, approve bool) error {
 
 	return nil
 }
+
+func (a *actionSrv) record(entry journal.Entry) {
+	if a.journal == nil {
+		return
+	}
+
+	if err := a.journal.Record(entry); err != nil {
+		a.log.Errorf("Action Service: failed to record the decision for action `%s` in the journal, err: %v", entry.ActionID, err)
+	}
+}
+
+func (f PendingFilter) matches(action defs.ActionInfo) bool {
+	return matchesField(f.Type, action.Type) &&
+		matchesField(f.Asset, action.Asset) &&
+		matchesField(f.WorkspaceID, action.WorkspaceID) &&
+		matchesField(f.Destination, action.Destination)
+}
+
+func matchesField(expected, value string) bool {
+	return expected == defs.EmptyString || expected == value
+}
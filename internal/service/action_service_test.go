@@ -1,11 +1,16 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/qredo/signing-agent/internal/action"
+	"github.com/qredo/signing-agent/internal/audit"
+	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/hub/message"
+	"github.com/qredo/signing-agent/internal/journal"
 	"github.com/test-go/testify/assert"
 )
 
@@ -16,10 +21,10 @@ func TestActionService_Approve_shouldnt_handle_action(t *testing.T) {
 	}
 	signerMock := &action.MockSigner{}
 
-	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock)
+	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock, nil, nil)
 
 	//Act
-	res := sut.Approve("some test action id")
+	res := sut.Approve("some test action id", "some caller")
 
 	//Assert
 	assert.Nil(t, res)
@@ -35,10 +40,10 @@ func TestActionService_Approve_fails_to_acquire_lock(t *testing.T) {
 		NextLockError:    errors.New("some lock error"),
 	}
 	signerMock := &action.MockSigner{}
-	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock)
+	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock, nil, nil)
 
 	//Act
-	res := sut.Approve("some test action id")
+	res := sut.Approve("some test action id", "some caller")
 
 	//Assert
 	assert.NotNil(t, res)
@@ -54,10 +59,10 @@ func TestActionService_Approve_approves(t *testing.T) {
 		NextReleaseError: errors.New("some unlock error"),
 	}
 	signerMock := &action.MockSigner{}
-	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock)
+	sut := NewActionService(syncronizerMock, testLog, true, nil, signerMock, nil, nil)
 
 	//Act
-	res := sut.Approve("some test action id")
+	res := sut.Approve("some test action id", "some caller")
 
 	//Assert
 	assert.Nil(t, res)
@@ -72,10 +77,10 @@ func TestActionService_Reject_returns_error_doesnt_remove_from_cache(t *testing.
 		NextError: errors.New("some reject error"),
 	}
 	cacheMock := &message.MockCache{}
-	sut := NewActionService(nil, testLog, false, cacheMock, signerMock)
+	sut := NewActionService(nil, testLog, false, cacheMock, signerMock, nil, nil)
 
 	//Act
-	err := sut.Reject("some test action id")
+	err := sut.Reject("some test action id", "some caller")
 
 	//Assert
 	assert.NotNil(t, err)
@@ -89,10 +94,10 @@ func TestActionService_Reject_rejects_removes_from_cache(t *testing.T) {
 	//Arrange
 	signerMock := &action.MockSigner{}
 	cacheMock := &message.MockCache{}
-	sut := NewActionService(nil, testLog, false, cacheMock, signerMock)
+	sut := NewActionService(nil, testLog, false, cacheMock, signerMock, nil, nil)
 
 	//Act
-	err := sut.Reject("some test action id")
+	err := sut.Reject("some test action id", "some caller")
 
 	//Assert
 	assert.Nil(t, err)
@@ -101,3 +106,141 @@ func TestActionService_Reject_rejects_removes_from_cache(t *testing.T) {
 	assert.True(t, cacheMock.RemoveMessageCalled)
 	assert.Equal(t, "some test action id", cacheMock.LastID)
 }
+
+func TestActionService_Approve_records_decision_in_journal(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextMessages: [][]byte{[]byte("some message")},
+	}
+	journalMock := &journal.MockJournal{}
+	sut := NewActionService(nil, testLog, false, nil, signerMock, journalMock, nil)
+
+	//Act
+	err := sut.Approve("some test action id", "some caller")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, journalMock.RecordCalled)
+	assert.Equal(t, "some test action id", journalMock.LastEntry.ActionID)
+	assert.Equal(t, journal.DecisionApprove, journalMock.LastEntry.Decision)
+	assert.Equal(t, journal.ModeManual, journalMock.LastEntry.Mode)
+	assert.Equal(t, "some caller", journalMock.LastEntry.Caller)
+	assert.Equal(t, journal.StatusApproved, journalMock.LastEntry.Status)
+	assert.Equal(t, []string{journal.HashMessage([]byte("some message"))}, journalMock.LastEntry.MessageHashes)
+	assert.Empty(t, journalMock.LastEntry.Error)
+}
+
+func TestActionService_Reject_records_failure_in_journal(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some reject error"),
+	}
+	journalMock := &journal.MockJournal{}
+	sut := NewActionService(nil, testLog, false, nil, signerMock, journalMock, nil)
+
+	//Act
+	err := sut.Reject("some test action id", "some caller")
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.True(t, journalMock.RecordCalled)
+	assert.Equal(t, journal.DecisionReject, journalMock.LastEntry.Decision)
+	assert.Equal(t, journal.StatusFailed, journalMock.LastEntry.Status)
+	assert.Equal(t, "some reject error", journalMock.LastEntry.Error)
+}
+
+func TestActionService_History_journal_not_enabled(t *testing.T) {
+	//Arrange
+	sut := NewActionService(nil, testLog, false, nil, nil, nil, nil)
+
+	//Act
+	entries, err := sut.History(journal.Filter{})
+
+	//Assert
+	assert.Nil(t, entries)
+	assert.NotNil(t, err)
+	assert.Equal(t, "Not Found", err.Error())
+}
+
+func TestActionService_History_queries_journal(t *testing.T) {
+	//Arrange
+	journalMock := &journal.MockJournal{
+		NextEntries: []journal.Entry{{ActionID: "some test action id"}},
+	}
+	sut := NewActionService(nil, testLog, false, nil, nil, journalMock, nil)
+
+	//Act
+	entries, err := sut.History(journal.Filter{Status: journal.StatusApproved})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, journalMock.QueryCalled)
+	assert.Equal(t, journal.StatusApproved, journalMock.LastFilter.Status)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "some test action id", entries[0].ActionID)
+}
+
+func TestActionService_Stop_stops_audit_log(t *testing.T) {
+	//Arrange
+	auditMock := &audit.MockLog{}
+	sut := NewActionService(nil, testLog, false, nil, nil, nil, auditMock)
+
+	//Act
+	sut.Stop()
+
+	//Assert
+	assert.True(t, auditMock.StopCalled)
+}
+
+func TestActionService_Stop_no_audit_log(t *testing.T) {
+	//Arrange
+	sut := NewActionService(nil, testLog, false, nil, nil, nil, nil)
+
+	//Act & Assert
+	assert.NotPanics(t, sut.Stop)
+}
+
+func TestActionService_Pending_no_message_cache(t *testing.T) {
+	//Arrange
+	sut := NewActionService(nil, testLog, false, nil, nil, nil, nil)
+
+	//Act
+	resp := sut.Pending(PendingFilter{Limit: 10})
+
+	//Assert
+	assert.Equal(t, 0, resp.Total)
+	assert.Empty(t, resp.Actions)
+}
+
+func TestActionService_Pending_filters_and_paginates(t *testing.T) {
+	//Arrange
+	expireTime := time.Now().Add(time.Minute).Unix()
+	newMessage := func(action defs.ActionInfo) []byte {
+		data, _ := json.Marshal(action)
+		return data
+	}
+	cacheMock := &message.MockCache{
+		NextMessages: [][]byte{
+			newMessage(defs.ActionInfo{ID: "third", Status: defs.StatusPending, Asset: "ETH", ExpireTime: expireTime + 2}),
+			newMessage(defs.ActionInfo{ID: "first", Status: defs.StatusPending, Asset: "ETH", ExpireTime: expireTime}),
+			newMessage(defs.ActionInfo{ID: "btc", Status: defs.StatusPending, Asset: "BTC", ExpireTime: expireTime}),
+			newMessage(defs.ActionInfo{ID: "expired", Status: defs.StatusPending, Asset: "ETH", ExpireTime: time.Now().Add(-time.Minute).Unix()}),
+			newMessage(defs.ActionInfo{ID: "approved", Status: 3, Asset: "ETH", ExpireTime: expireTime}),
+			newMessage(defs.ActionInfo{ID: "second", Status: defs.StatusPending, Asset: "ETH", Amount: 10, ExpireTime: expireTime + 1}),
+			[]byte("invalid"),
+		},
+	}
+	sut := NewActionService(nil, testLog, false, cacheMock, nil, nil, nil)
+
+	//Act
+	resp := sut.Pending(PendingFilter{Asset: "ETH", Offset: 1, Limit: 1})
+
+	//Assert
+	assert.True(t, cacheMock.GetMessagesCalled)
+	assert.Equal(t, 3, resp.Total)
+	assert.Equal(t, 1, resp.Offset)
+	assert.Equal(t, 1, resp.Limit)
+	assert.Len(t, resp.Actions, 1)
+	assert.Equal(t, "second", resp.Actions[0].ID)
+	assert.Equal(t, int64(10), resp.Actions[0].Amount)
+}
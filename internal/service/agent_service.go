@@ -9,13 +9,16 @@ import (
 	"github.com/qredo/signing-agent/internal/api"
 	"github.com/qredo/signing-agent/internal/auth"
 	"github.com/qredo/signing-agent/internal/autoapprover"
+	"github.com/qredo/signing-agent/internal/backup"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/feed"
 	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/hub/message"
 
 	"github.com/qredo/signing-agent/internal/store"
 	"github.com/qredo/signing-agent/internal/util"
+	"github.com/qredo/signing-agent/internal/webhook"
 	"go.uber.org/zap"
 )
 
@@ -32,23 +35,50 @@ type apiKeyNameResponse struct {
 	Name string `json:"name"`
 }
 
+// apiKeyResponse holds the public keys of the API key
+type apiKeyResponse struct {
+	BlsPublicKey string `json:"blsPublicKey"`
+	EcPublicKey  string `json:"ecPublicKey"`
+}
+
 type AgentService interface {
 	Start() error
 	Stop()
 
 	GetAgentDetails() (*api.GetAgentDetailsResponse, error)
 	RegisterAgent(req *api.AgentRegisterRequest) (*api.AgentRegisterResponse, error)
-	RegisterClientFeed(w http.ResponseWriter, r *http.Request)
+	// RegisterClientFeed upgrades the connection to the websocket feed of the protocol version, the commands received on it are executed by handleCommand
+	RegisterClientFeed(w http.ResponseWriter, r *http.Request, version int, handleCommand feed.CommandHandler)
+	// RegisterClientSSEFeed streams the feed of the protocol version as Server-Sent Events, it returns once the client disconnected or the feed is closed
+	RegisterClientSSEFeed(w http.ResponseWriter, r *http.Request, version int) error
 	GetWebsocketStatus() *api.HealthCheckStatusResponse
+	// Backup returns the registered agent encrypted with the passphrase or to the public key
+	Backup(req *api.BackupRequest) (*backup.Bundle, error)
+	// Restore registers the agent from the backup bundle, the agent must not be already registered
+	Restore(req *api.RestoreRequest) (*api.RestoreResponse, error)
+	// RotateKeys replaces the BLS and EC keys of the registered agent on its API key
+	RotateKeys() (*api.RotateKeysResponse, error)
+	// Deregister stops the service and removes the registered agent, so an agent can register again
+	Deregister() (*api.DeregisterResponse, error)
+	// SetWebsocketConfig changes the timings of the feed clients registered afterwards
+	SetWebsocketConfig(cfg config.WebSocketConfig)
+	// WebhookDeadLetters returns the webhook deliveries given up after the last attempt
+	WebhookDeadLetters() (*api.WebhookDeadLettersResponse, error)
+	// RequeueWebhookDeadLetter moves the webhook dead letter back to the pending deliveries
+	RequeueWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error)
+	// DeleteWebhookDeadLetter removes the webhook dead letter
+	DeleteWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error)
 }
 
-type newClientFeedFunc func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig) feed.ClientFeed
+type newClientFeedFunc func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig,
+	handleCommand feed.CommandHandler) feed.ClientFeed
+type newSSEClientFeedFunc func(w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig) (feed.ClientFeed, error)
 type genKeysFunc func() (string, string, string, string, error)
 
 func NewAgentService(config config.Config, authProvider auth.HeaderProvider, store store.StoreWriter, signer action.Signer,
-	feedHub hub.FeedHub, aa autoapprover.AutoApprover, log *zap.SugaredLogger, upgrader hub.WebsocketUpgrader,
-	agentInfo *store.AgentInfo) AgentService {
-	return &agentSrv{
+	feedHub hub.FeedHub, aa autoapprover.AutoApprover, webhooks webhook.Dispatcher, messageCache message.Cacher,
+	log *zap.SugaredLogger, upgrader hub.WebsocketUpgrader, agentInfo *store.AgentInfo) AgentService {
+	a := &agentSrv{
 		htc:               util.NewHTTPClient(),
 		store:             store,
 		config:            config,
@@ -56,12 +86,17 @@ func NewAgentService(config config.Config, authProvider auth.HeaderProvider, sto
 		signer:            signer,
 		feedHub:           feedHub,
 		autoApprover:      aa,
+		webhooks:          webhooks,
+		messageCache:      messageCache,
 		log:               log,
 		upgrader:          upgrader,
 		newClientFeedFunc: feed.NewClientFeed,
+		newSSEFeedFunc:    feed.NewSSEClientFeed,
 		agentInfo:         agentInfo,
-		genKeysFunc:       defs.GenerateKeys,
 	}
+	a.genKeysFunc = a.generateKeys
+
+	return a
 }
 
 type agentSrv struct {
@@ -74,39 +109,57 @@ type agentSrv struct {
 	log               *zap.SugaredLogger
 	upgrader          hub.WebsocketUpgrader
 	newClientFeedFunc newClientFeedFunc //function used by the feed clients to unregister themselves from the hub and stop receiving data
+	newSSEFeedFunc    newSSEClientFeedFunc
 	autoApprover      autoapprover.AutoApprover
+	webhooks          webhook.Dispatcher
+	messageCache      message.Cacher // the pending actions, cleared once the agent is deregistered
 	agentInfo         *store.AgentInfo
 	genKeysFunc       genKeysFunc
+	configLock        sync.RWMutex
+	rotateLock        sync.Mutex // serializes the key rotation, the backup and the deregistration
 }
 
 // Start is running the feed hub if the agent is registered.
-// It also makes sure the auto approver is registered to the hub and is listening for incoming actions, if enabled in the config
+// It also makes sure the auto approver and the webhooks dispatcher are registered to the hub and are listening
+// for incoming actions, if enabled in the config
 func (a *agentSrv) Start() error {
 	if a.agentInfo == nil {
 		a.log.Warn("Agent Service: agent is not yet configured, auto-approval not started")
 		return nil
 	}
 
+	// the agent signs with the keys Qredo holds
+	a.resolvePendingRotation()
+
+	var wg sync.WaitGroup
 	if a.autoApprover != nil {
-		var wg sync.WaitGroup
 		wg.Add(1)
-
 		go a.autoApprover.Listen(&wg)
-		wg.Wait()
 	}
+	if a.webhooks != nil {
+		wg.Add(1)
+		go a.webhooks.Listen(&wg)
+	}
+	wg.Wait()
 
 	if !a.feedHub.Run() {
 		a.log.Error("Agent Service: failed to start the feed hub")
 		if a.autoApprover != nil {
 			a.autoApprover.Stop()
 		}
+		if a.webhooks != nil {
+			a.webhooks.Stop()
+		}
 		return fmt.Errorf("failed to start the feed hub")
 	}
 
-	//feed hub is running, register the autoApprover if enabled
+	//feed hub is running, register the autoApprover and the webhooks dispatcher if enabled
 	if a.autoApprover != nil {
 		a.feedHub.RegisterClient(a.autoApprover.GetFeedClient())
 	}
+	if a.webhooks != nil {
+		a.feedHub.RegisterClient(a.webhooks.GetFeedClient())
+	}
 
 	return nil
 }
@@ -119,6 +172,22 @@ func (a *agentSrv) Stop() {
 	a.authProvider.Stop()
 }
 
+// generateKeys returns the public and private BLS and EC keys of the agent. The BLS key is generated by the signer,
+// its private key is empty when the signer holds it, so it isn't saved
+func (a *agentSrv) generateKeys() (string, string, string, string, error) {
+	blsKeyPub, blsKeyPriv, err := a.signer.GenerateKey()
+	if err != nil {
+		return defs.EmptyString, defs.EmptyString, defs.EmptyString, defs.EmptyString, err
+	}
+
+	ecKeyPub, ecKeyPriv, err := defs.GenerateECKey()
+	if err != nil {
+		return defs.EmptyString, defs.EmptyString, defs.EmptyString, defs.EmptyString, err
+	}
+
+	return blsKeyPub, blsKeyPriv, ecKeyPub, ecKeyPriv, nil
+}
+
 func (a *agentSrv) RegisterAgent(req *api.AgentRegisterRequest) (*api.AgentRegisterResponse, error) {
 	if a.agentInfo != nil {
 		return nil, defs.ErrBadRequest().WithDetail("signing agent already registered")
@@ -145,7 +214,7 @@ func (a *agentSrv) RegisterAgent(req *api.AgentRegisterRequest) (*api.AgentRegis
 		return nil, defs.ErrInternal().WithDetail("failed to setup signer")
 	}
 
-	if err = a.signer.ActionApprove(actionID); err != nil {
+	if _, err = a.signer.ActionApprove(actionID); err != nil {
 		return nil, err
 	}
 
@@ -177,7 +246,298 @@ func (a *agentSrv) RegisterAgent(req *api.AgentRegisterRequest) (*api.AgentRegis
 	}, nil
 }
 
-func (h agentSrv) GetWebsocketStatus() *api.HealthCheckStatusResponse {
+func (a *agentSrv) Backup(req *api.BackupRequest) (*backup.Bundle, error) {
+	// a rotation in progress replaces the keys, the backup waits for it
+	a.rotateLock.Lock()
+	defer a.rotateLock.Unlock()
+
+	if a.agentInfo == nil {
+		return nil, defs.ErrNotFound().WithDetail("agent not registered")
+	}
+
+	// the remote signer holds the key, the store has none to back up
+	if a.config.Signer.Type == "remote" {
+		return nil, defs.ErrBadRequest().WithDetail("the agent key is held by the remote signer, back it up there")
+	}
+
+	pending, err := a.store.GetPendingRotation(a.agentInfo.APIKeyID)
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to read the pending key rotation, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to read the pending key rotation")
+	}
+
+	bundle, err := backup.NewBundle(a.agentInfo.APIKeyID, a.agentInfo, pending, backup.Key{
+		Passphrase: req.Passphrase,
+		PublicKey:  req.PublicKey,
+	})
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to back up the agent, err: %v", err)
+		return nil, defs.ErrBadRequest().WithDetail(err.Error())
+	}
+
+	a.log.Infof("Agent Service: agent %s backed up, method: %s", bundle.AgentID, bundle.Method)
+	return bundle, nil
+}
+
+func (a *agentSrv) Restore(req *api.RestoreRequest) (*api.RestoreResponse, error) {
+	if a.agentInfo != nil {
+		return nil, defs.ErrBadRequest().WithDetail("signing agent already registered")
+	}
+
+	agentID, agentInfo, err := req.Bundle.Open(backup.Key{
+		Passphrase: req.Passphrase,
+		PrivateKey: req.PrivateKey,
+	})
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to open the backup bundle, err: %v", err)
+		return nil, defs.ErrBadRequest().WithDetail(err.Error())
+	}
+
+	// saved first, the agent is registered in memory only once persisted
+	if err = a.store.SaveAgentInfo(agentID, agentInfo); err != nil {
+		a.log.Errorf("Agent Service: failed to save agent info, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to save agent info")
+	}
+
+	if err := a.authProvider.Initiate(agentInfo.WorkspaceID, agentInfo.APIKeySecret, agentInfo.APIKeyID); err != nil {
+		a.log.Errorf("Agent Service: failed to initiate the auth provider, err: %v", err)
+		a.abortRestore()
+		return nil, defs.ErrInternal().WithDetail("failed to initiate the auth provider")
+	}
+
+	if err := a.signer.SetKey(agentInfo.BLSPrivateKey); err != nil {
+		a.log.Errorf("Agent Service: failed to set signer key, err: %v", err)
+		a.abortRestore()
+		return nil, defs.ErrInternal().WithDetail("failed to setup signer")
+	}
+
+	a.agentInfo = agentInfo
+	a.log.Infof("Agent Service: agent %s restored from backup", agentID)
+	return &api.RestoreResponse{
+		AgentID: agentID,
+		FeedURL: a.getLocalFeed(),
+	}, nil
+}
+
+// abortRestore leaves the agent unregistered in memory, the restored agent stays in the store and is loaded on the next start
+func (a *agentSrv) abortRestore() {
+	a.signer.ClearKey()
+	a.authProvider.Stop()
+}
+
+// RotateKeys generates new keys and submits them on the API key. The resulting action is approved with the current key,
+// the new keys are used only once approved. The new keys are saved as a pending rotation before they are submitted,
+// so they are not lost whatever the outcome of the approval. The pending rotation is promoted once Qredo holds the new
+// public keys and dropped once the approval definitely failed, a rotation left unresolved is resolved on the next start
+func (a *agentSrv) RotateKeys() (*api.RotateKeysResponse, error) {
+	a.rotateLock.Lock()
+	defer a.rotateLock.Unlock()
+
+	if a.agentInfo == nil {
+		return nil, defs.ErrNotFound().WithDetail("agent not registered")
+	}
+
+	blsKeyPub, blsKeyPriv, ecKeyPub, ecKeyPriv, err := a.genKeysFunc()
+	if err != nil {
+		a.log.Errorf("Agent Service: error while generating keys, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to generate keys")
+	}
+
+	pending := &store.PendingRotation{
+		Agent:        *a.agentInfo,
+		BLSPublicKey: blsKeyPub,
+		ECPublicKey:  ecKeyPub,
+	}
+	pending.Agent.BLSPrivateKey = blsKeyPriv
+	pending.Agent.ECPrivateKey = ecKeyPriv
+	agentID := pending.Agent.APIKeyID
+
+	if err = a.store.SavePendingRotation(agentID, pending); err != nil {
+		a.log.Errorf("Agent Service: failed to save the new keys, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("failed to save the new keys")
+	}
+
+	actionID, err := a.updateAPIKey(agentID, pending.Agent.WorkspaceID, blsKeyPub, ecKeyPub)
+	if err != nil {
+		// Qredo holds the new public keys only once the action is approved
+		a.log.Errorf("Agent Service: failed to submit the new keys, err: %v", err)
+		a.dropRotation(agentID)
+		return nil, defs.ErrInternal().WithDetail("failed to submit the new keys")
+	}
+
+	pending.ActionID = actionID
+	if err = a.store.SavePendingRotation(agentID, pending); err != nil {
+		a.log.Warnf("Agent Service: failed to save the key rotation action `%s`, err: %v", actionID, err)
+	}
+
+	// approved with the current key, the agent keeps its keys if the approval fails
+	if _, err = a.signer.ActionApprove(actionID); err != nil {
+		a.log.Errorf("Agent Service: failed to approve the key rotation action `%s`, err: %v", actionID, err)
+
+		// the approval might have reached Qredo
+		applied, resolveErr := a.resolveRotation(pending)
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		if !applied {
+			return nil, err
+		}
+	} else if err = a.promoteRotation(pending); err != nil {
+		return nil, err
+	}
+
+	a.log.Infof("Agent Service: keys of agent %s rotated, action `%s`", agentID, actionID)
+	return &api.RotateKeysResponse{
+		AgentID:  agentID,
+		ActionID: actionID,
+	}, nil
+}
+
+// resolvePendingRotation resolves the key rotation left unresolved by a previous run
+func (a *agentSrv) resolvePendingRotation() {
+	a.rotateLock.Lock()
+	defer a.rotateLock.Unlock()
+
+	pending, err := a.store.GetPendingRotation(a.agentInfo.APIKeyID)
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to read the pending key rotation, err: %v", err)
+		return
+	}
+	if pending == nil {
+		return
+	}
+
+	applied, err := a.resolveRotation(pending)
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to resolve the pending key rotation, action `%s`, err: %v", pending.ActionID, err)
+		return
+	}
+
+	if applied {
+		a.log.Infof("Agent Service: pending key rotation applied, action `%s`", pending.ActionID)
+	} else {
+		a.log.Infof("Agent Service: pending key rotation dropped, action `%s`", pending.ActionID)
+	}
+}
+
+// resolveRotation promotes the pending keys if Qredo holds their public keys. Otherwise the rotation action is rejected,
+// so it can't be approved later, and the pending keys are dropped. It returns true if the keys were promoted
+func (a *agentSrv) resolveRotation(pending *store.PendingRotation) (bool, error) {
+	applied, err := a.rotationApplied(pending)
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to check the key rotation, err: %v", err)
+		return false, defs.ErrInternal().WithDetail("key rotation not confirmed, it's resolved on the next start")
+	}
+
+	if !applied && len(pending.ActionID) > 0 {
+		if _, err = a.signer.ActionReject(pending.ActionID); err != nil {
+			// the action is no longer pending, it was approved meanwhile or it expired
+			if applied, err = a.rotationApplied(pending); err != nil {
+				a.log.Errorf("Agent Service: failed to check the key rotation, err: %v", err)
+				return false, defs.ErrInternal().WithDetail("key rotation not confirmed, it's resolved on the next start")
+			}
+		}
+	}
+
+	if applied {
+		return true, a.promoteRotation(pending)
+	}
+
+	a.dropRotation(pending.Agent.APIKeyID)
+	return false, nil
+}
+
+// rotationApplied returns true if the API key on Qredo holds the public keys of the pending rotation
+func (a *agentSrv) rotationApplied(pending *store.PendingRotation) (bool, error) {
+	resp := &apiKeyResponse{}
+	header := a.authProvider.GetAuthHeader()
+	url := defs.URLAPIKey(a.config.Base.QredoAPI, pending.Agent.WorkspaceID, pending.Agent.APIKeyID)
+
+	if err := a.htc.Request(http.MethodGet, url, nil, resp, header); err != nil {
+		return false, err
+	}
+
+	return resp.BlsPublicKey == pending.BLSPublicKey, nil
+}
+
+// promoteRotation makes the pending keys the agent keys, in memory first as Qredo holds their public keys.
+// The pending rotation is deleted once the agent is saved, otherwise it's promoted again on the next start
+func (a *agentSrv) promoteRotation(pending *store.PendingRotation) error {
+	rotated := pending.Agent
+	a.agentInfo = &rotated
+
+	setKeyErr := a.signer.SetKey(rotated.BLSPrivateKey)
+	if setKeyErr != nil {
+		a.log.Errorf("Agent Service: keys rotated, but failed to set the signer key, err: %v", setKeyErr)
+	}
+
+	if err := a.store.SaveAgentInfo(rotated.APIKeyID, a.agentInfo); err != nil {
+		a.log.Errorf("Agent Service: keys rotated, but failed to save agent info, err: %v", err)
+		return defs.ErrInternal().WithDetail("keys rotated, but failed to save agent info")
+	}
+
+	a.dropRotation(rotated.APIKeyID)
+
+	if setKeyErr != nil {
+		return defs.ErrInternal().WithDetail("keys rotated, but failed to set the signer key. Please restart")
+	}
+
+	return nil
+}
+
+// dropRotation deletes the pending rotation, a stale one is resolved again on the next start
+func (a *agentSrv) dropRotation(agentID string) {
+	if err := a.store.DeletePendingRotation(agentID); err != nil {
+		a.log.Warnf("Agent Service: failed to delete the pending key rotation, err: %v", err)
+	}
+}
+
+// Deregister stops the feed hub, the auto approver, the webhooks dispatcher and the auth provider, then removes the agent and its pending
+// key rotation from the store, its key from the signer and its pending actions from the cache. The API key is left as is on Qredo
+func (a *agentSrv) Deregister() (*api.DeregisterResponse, error) {
+	a.rotateLock.Lock()
+	defer a.rotateLock.Unlock()
+
+	if a.agentInfo == nil {
+		return nil, defs.ErrNotFound().WithDetail("agent not registered")
+	}
+
+	agentID := a.agentInfo.APIKeyID
+
+	a.feedHub.Stop()
+	if a.autoApprover != nil {
+		// the auto approver stops now, and not once the hub cleans up, so it can listen again on the next start
+		a.feedHub.UnregisterClient(a.autoApprover.GetFeedClient())
+	}
+	if a.webhooks != nil {
+		a.feedHub.UnregisterClient(a.webhooks.GetFeedClient())
+	}
+	a.authProvider.Stop()
+
+	// the pending rotation goes first, so the agent is still registered if it can't be deleted
+	if err := a.store.DeletePendingRotation(agentID); err != nil {
+		a.log.Errorf("Agent Service: failed to delete the pending key rotation, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("service stopped, but failed to delete the pending key rotation")
+	}
+
+	if err := a.store.DeleteAgentInfo(agentID); err != nil {
+		a.log.Errorf("Agent Service: failed to delete agent info, err: %v", err)
+		return nil, defs.ErrInternal().WithDetail("service stopped, but failed to delete agent info")
+	}
+
+	a.signer.ClearKey()
+	a.agentInfo = nil
+	if a.messageCache != nil {
+		a.messageCache.Clear()
+	}
+
+	a.log.Infof("Agent Service: agent %s deregistered", agentID)
+	return &api.DeregisterResponse{
+		AgentID: agentID,
+	}, nil
+}
+
+func (h *agentSrv) GetWebsocketStatus() *api.HealthCheckStatusResponse {
 	ws := h.feedHub.GetWebsocketStatus()
 
 	resp := &api.HealthCheckStatusResponse{
@@ -188,7 +548,7 @@ func (h agentSrv) GetWebsocketStatus() *api.HealthCheckStatusResponse {
 	return resp
 }
 
-func (a agentSrv) GetAgentDetails() (*api.GetAgentDetailsResponse, error) {
+func (a *agentSrv) GetAgentDetails() (*api.GetAgentDetailsResponse, error) {
 	if a.agentInfo == nil {
 		return nil, defs.ErrNotFound().WithDetail("agent not registered")
 	}
@@ -206,13 +566,13 @@ func (a agentSrv) GetAgentDetails() (*api.GetAgentDetailsResponse, error) {
 	}, nil
 }
 
-func (a *agentSrv) RegisterClientFeed(w http.ResponseWriter, r *http.Request) {
+func (a *agentSrv) RegisterClientFeed(w http.ResponseWriter, r *http.Request, version int, handleCommand feed.CommandHandler) {
 	if !a.feedHub.IsRunning() {
 		a.log.Errorf("Agent Service: failed to connect feed client, hub not running")
 		return
 	}
 
-	clientFeed := a.newClientFeed(w, r)
+	clientFeed := a.newClientFeed(w, r, version, handleCommand)
 	if clientFeed != nil {
 		var wg sync.WaitGroup
 		wg.Add(2)
@@ -227,7 +587,41 @@ func (a *agentSrv) RegisterClientFeed(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (a agentSrv) updateAPIKey(APIkeyID, workspaceID, blsKey, ecKey string) (string, error) {
+// RegisterClientSSEFeed registers the SSE client to the feed hub, the cached messages are replayed on registration.
+// The stream is served on the request, so it blocks until the client disconnects or the feed is closed
+func (a *agentSrv) RegisterClientSSEFeed(w http.ResponseWriter, r *http.Request, version int) error {
+	if !a.feedHub.IsRunning() {
+		a.log.Errorf("Agent Service: failed to connect SSE feed client, hub not running")
+		return defs.ErrNotFound().WithDetail("feed not available, the agent is not running")
+	}
+
+	a.configLock.RLock()
+	websocketConfig := a.config.Websocket
+	a.configLock.RUnlock()
+
+	clientFeed, err := a.newSSEFeedFunc(w, r, a.log, a.feedHub.UnregisterClient, websocketConfig)
+	if err != nil {
+		a.log.Errorf("Agent Service: failed to set up the SSE feed client, err: %v", err)
+		return defs.ErrInternal().WithDetail("failed to set up the SSE feed")
+	}
+	clientFeed.GetFeedClient().Version = version
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go clientFeed.Listen(&wg)
+	wg.Wait() //wait for the client to start listening
+
+	a.feedHub.RegisterClient(clientFeed.GetFeedClient())
+	a.log.Info("Agent Service: new local SSE feed client connected")
+
+	wg.Add(1)
+	clientFeed.Start(&wg)
+
+	a.log.Info("Agent Service: local SSE feed client disconnected")
+	return nil
+}
+
+func (a *agentSrv) updateAPIKey(APIkeyID, workspaceID, blsKey, ecKey string) (string, error) {
 	req := saveKeyDataRequest{
 		BlsPublicKey: blsKey,
 		EcPublicKey:  ecKey,
@@ -243,7 +637,7 @@ func (a agentSrv) updateAPIKey(APIkeyID, workspaceID, blsKey, ecKey string) (str
 	return resp.ActionID, nil
 }
 
-func (a agentSrv) getAgentName() (string, error) {
+func (a *agentSrv) getAgentName() (string, error) {
 	resp := &apiKeyNameResponse{}
 	header := a.authProvider.GetAuthHeader()
 	url := defs.URLAPIKey(a.config.Base.QredoAPI, a.agentInfo.WorkspaceID, a.agentInfo.APIKeyID)
@@ -255,16 +649,104 @@ func (a agentSrv) getAgentName() (string, error) {
 	return resp.Name, nil
 }
 
-func (a agentSrv) newClientFeed(w http.ResponseWriter, r *http.Request) feed.ClientFeed {
-	conn, err := a.upgrader.Upgrade(w, r, nil)
+// SetWebsocketConfig changes the websocket timings, the registered feed clients keep their timings
+func (a *agentSrv) SetWebsocketConfig(cfg config.WebSocketConfig) {
+	a.configLock.Lock()
+	defer a.configLock.Unlock()
+
+	a.config.Websocket = cfg
+}
+
+// WebhookDeadLetters returns the webhook deliveries given up after the last attempt, oldest first
+func (a *agentSrv) WebhookDeadLetters() (*api.WebhookDeadLettersResponse, error) {
+	if a.webhooks == nil {
+		return nil, defs.ErrNotFound().WithDetail("webhooks are not enabled")
+	}
+
+	resp := &api.WebhookDeadLettersResponse{
+		DeadLetters: []api.WebhookDeadLetter{},
+	}
+	for _, delivery := range a.webhooks.DeadLetters() {
+		resp.DeadLetters = append(resp.DeadLetters, api.WebhookDeadLetter{
+			ID:        delivery.ID,
+			Endpoint:  delivery.Endpoint,
+			ActionID:  delivery.ActionID,
+			Body:      delivery.Body,
+			Attempts:  delivery.Attempts,
+			CreatedAt: delivery.CreatedAt,
+			FailedAt:  delivery.FailedAt,
+			LastError: delivery.LastError,
+		})
+	}
+
+	return resp, nil
+}
+
+// RequeueWebhookDeadLetter moves the webhook dead letter back to the pending deliveries, with its attempts reset
+func (a *agentSrv) RequeueWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error) {
+	if a.webhooks == nil {
+		return nil, defs.ErrNotFound().WithDetail("webhooks are not enabled")
+	}
+
+	if err := a.webhooks.RequeueDeadLetter(id); err != nil {
+		return nil, deadLetterError(err)
+	}
+
+	return &api.WebhookDeadLetterResponse{
+		ID:     id,
+		Status: "requeued",
+	}, nil
+}
+
+// DeleteWebhookDeadLetter removes the webhook dead letter
+func (a *agentSrv) DeleteWebhookDeadLetter(id string) (*api.WebhookDeadLetterResponse, error) {
+	if a.webhooks == nil {
+		return nil, defs.ErrNotFound().WithDetail("webhooks are not enabled")
+	}
+
+	if err := a.webhooks.DeleteDeadLetter(id); err != nil {
+		return nil, deadLetterError(err)
+	}
+
+	return &api.WebhookDeadLetterResponse{
+		ID:     id,
+		Status: "deleted",
+	}, nil
+}
+
+func deadLetterError(err error) error {
+	switch err {
+	case webhook.ErrDeadLetterNotFound:
+		return defs.ErrNotFound().WithDetail(err.Error())
+	case webhook.ErrEndpointNotConfigured:
+		return defs.ErrBadRequest().WithDetail(err.Error())
+	default:
+		return defs.ErrInternal().WithDetail(err.Error())
+	}
+}
+
+// newClientFeed upgrades the connection, answering with the subprotocol of the version when the client requested it
+func (a *agentSrv) newClientFeed(w http.ResponseWriter, r *http.Request, version int, handleCommand feed.CommandHandler) feed.ClientFeed {
+	var responseHeader http.Header
+	if protocol := feed.Subprotocol(r, version); len(protocol) > 0 {
+		responseHeader = http.Header{"Sec-Websocket-Protocol": []string{protocol}}
+	}
+
+	conn, err := a.upgrader.Upgrade(w, r, responseHeader)
 	if err != nil {
 		a.log.Errorf("Agent Service: failed to upgrade connection, err: %v", err)
 		return nil
 	}
 
-	return a.newClientFeedFunc(conn, a.log, a.feedHub.UnregisterClient, a.config.Websocket)
+	a.configLock.RLock()
+	websocketConfig := a.config.Websocket
+	a.configLock.RUnlock()
+
+	clientFeed := a.newClientFeedFunc(conn, a.log, a.feedHub.UnregisterClient, websocketConfig, handleCommand)
+	clientFeed.GetFeedClient().Version = version
+	return clientFeed
 }
 
-func (a agentSrv) getLocalFeed() string {
+func (a *agentSrv) getLocalFeed() string {
 	return defs.URLlocalFeed(a.config.HTTP.Addr)
 }
@@ -14,12 +14,15 @@ import (
 	"github.com/qredo/signing-agent/internal/action"
 	"github.com/qredo/signing-agent/internal/api"
 	"github.com/qredo/signing-agent/internal/auth"
+	"github.com/qredo/signing-agent/internal/backup"
 	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/feed"
 	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/hub/message"
 	"github.com/qredo/signing-agent/internal/store"
 	"github.com/qredo/signing-agent/internal/util"
+	"github.com/qredo/signing-agent/internal/webhook"
 	"github.com/test-go/testify/assert"
 	"go.uber.org/goleak"
 	"go.uber.org/zap"
@@ -31,9 +34,10 @@ var (
 )
 
 type mockAutoApprover struct {
-	StopCalled          bool
-	ListenCalled        bool
-	GetFeedClientCalled bool
+	StopCalled           bool
+	ListenCalled         bool
+	GetFeedClientCalled  bool
+	SetRetryConfigCalled bool
 
 	NextHubFeedClient *hub.HubFeedClient
 }
@@ -59,14 +63,19 @@ type mockFeedHub struct {
 	NextRun                  bool
 	RunCalled                bool
 	RegisterClientCalled     bool
+	UnregisterClientCalled   bool
 	StopCalled               bool
 	IsRunningCalled          bool
 	GetWebsocketStatusCalled bool
 	LastRegisteredClient     *hub.HubFeedClient
+	LastUnregisteredClient   *hub.HubFeedClient
 
 	NextWSstatus api.WebsocketStatus
 }
 
+func (m *mockFeedHub) PublishEvent(eventType string, payload any) {
+}
+
 func (m *mockFeedHub) IsRunning() bool {
 	m.IsRunningCalled = true
 	return m.NextRun
@@ -87,6 +96,11 @@ func (m *mockFeedHub) RegisterClient(client *hub.HubFeedClient) {
 }
 
 func (m *mockFeedHub) UnregisterClient(client *hub.HubFeedClient) {
+	m.UnregisterClientCalled = true
+	m.LastUnregisteredClient = client
+}
+
+func (m *mockFeedHub) SetConfig(cfg config.FeedHubConfig) {
 }
 
 func (m *mockFeedHub) GetWebsocketStatus() api.WebsocketStatus {
@@ -95,11 +109,20 @@ func (m *mockFeedHub) GetWebsocketStatus() api.WebsocketStatus {
 }
 
 type mockStoreWriter struct {
-	SaveAgentInfoCalled bool
-	LastID              string
-	LastAgent           *store.AgentInfo
-
-	NextError error
+	SaveAgentInfoCalled   bool
+	DeleteAgentInfoCalled bool
+	LastID                string
+	LastAgent             *store.AgentInfo
+
+	NextError       error
+	NextDeleteError error
+
+	SavePendingRotationCalled   bool
+	DeletePendingRotationCalled bool
+	LastPendingRotation         *store.PendingRotation
+	NextPendingRotation         *store.PendingRotation
+	NextPendingError            error
+	NextDeletePendingError      error
 }
 
 func (m *mockStoreWriter) SaveAgentInfo(id string, agent *store.AgentInfo) error {
@@ -109,6 +132,28 @@ func (m *mockStoreWriter) SaveAgentInfo(id string, agent *store.AgentInfo) error
 	return m.NextError
 }
 
+func (m *mockStoreWriter) DeleteAgentInfo(id string) error {
+	m.DeleteAgentInfoCalled = true
+	m.LastID = id
+	return m.NextDeleteError
+}
+
+func (m *mockStoreWriter) SavePendingRotation(id string, rotation *store.PendingRotation) error {
+	m.SavePendingRotationCalled = true
+	copied := *rotation
+	m.LastPendingRotation = &copied
+	return m.NextPendingError
+}
+
+func (m *mockStoreWriter) GetPendingRotation(id string) (*store.PendingRotation, error) {
+	return m.NextPendingRotation, nil
+}
+
+func (m *mockStoreWriter) DeletePendingRotation(id string) error {
+	m.DeletePendingRotationCalled = true
+	return m.NextDeletePendingError
+}
+
 type mockClientFeed struct {
 	StartCalled         bool
 	ListenCalled        bool
@@ -146,11 +191,14 @@ func (m *mockAutoApprover) GetFeedClient() *hub.HubFeedClient {
 func (m *mockAutoApprover) Stop() {
 	m.StopCalled = true
 }
+func (m *mockAutoApprover) SetRetryConfig(cfg config.AutoApprove) {
+	m.SetRetryConfigCalled = true
+}
 
 func TestAgentService_Start_agent_not_registered_doesnt_run_hub(t *testing.T) {
 	//Arrange
 	mockFeedHub := &mockFeedHub{}
-	sut := NewAgentService(config.Config{}, nil, nil, nil, mockFeedHub, nil, util.NewTestLogger(),
+	sut := NewAgentService(config.Config{}, nil, nil, nil, mockFeedHub, nil, nil, nil, util.NewTestLogger(),
 		nil, nil)
 
 	//Act
@@ -168,7 +216,7 @@ func TestAgentService_Start_autoapprover_not_enabled_fail_to_run_hub(t *testing.
 	mockFeedHub := &mockFeedHub{
 		NextRun: false,
 	}
-	sut := NewAgentService(config.Config{}, nil, nil, nil, mockFeedHub, nil, util.NewTestLogger(),
+	sut := NewAgentService(config.Config{}, nil, &mockStoreWriter{}, nil, mockFeedHub, nil, nil, nil, util.NewTestLogger(),
 		nil, &store.AgentInfo{})
 
 	//Act
@@ -192,6 +240,7 @@ func TestAgentService_Start_autoapprover_enabled_hub_doesnt_run(t *testing.T) {
 	sut := agentSrv{
 		log:          testLog,
 		agentInfo:    &store.AgentInfo{},
+		store:        &mockStoreWriter{},
 		autoApprover: mockApprover,
 		feedHub:      mockFeedHub,
 	}
@@ -218,6 +267,7 @@ func TestAgentService_Start_run_hub_autoapprove_not_enabled(t *testing.T) {
 	sut := agentSrv{
 		log:       testLog,
 		agentInfo: &store.AgentInfo{},
+		store:     &mockStoreWriter{},
 		feedHub:   mockFeedHub,
 	}
 	//Act
@@ -242,6 +292,7 @@ func TestAgentService_Start_registers_auto_approval(t *testing.T) {
 	sut := agentSrv{
 		log:          testLog,
 		agentInfo:    &store.AgentInfo{},
+		store:        &mockStoreWriter{},
 		feedHub:      mockFeedHub,
 		autoApprover: mockApprover,
 	}
@@ -265,7 +316,7 @@ func TestAgentService_Stop_stops_feedhub_and_authProvider(t *testing.T) {
 
 	sut := NewAgentService(
 		config.Config{}, authMock, nil, nil, mockFeedHub,
-		nil, testLog, nil, nil)
+		nil, nil, nil, testLog, nil, nil)
 
 	//Act
 	sut.Stop()
@@ -284,13 +335,67 @@ func TestAgentService_RegisterClientFeed_hub_not_running(t *testing.T) {
 	}
 
 	// Act
-	sut.RegisterClientFeed(nil, nil)
+	sut.RegisterClientFeed(nil, nil, hub.FeedVersion1, nil)
 
 	// Assert
 	assert.False(t, mockFeedHub.RegisterClientCalled)
 	assert.True(t, mockFeedHub.IsRunningCalled)
 }
 
+func TestAgentService_RegisterClientSSEFeed_hub_not_running(t *testing.T) {
+	//Arrange
+	mockFeedHub := &mockFeedHub{}
+	sut := agentSrv{
+		feedHub: mockFeedHub,
+		log:     testLog,
+	}
+
+	//Act
+	err := sut.RegisterClientSSEFeed(nil, nil, hub.FeedVersion1)
+
+	//Assert
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "feed not available, the agent is not running", detail)
+	assert.False(t, mockFeedHub.RegisterClientCalled)
+}
+
+func TestAgentService_RegisterClientSSEFeed_streams_until_disconnected(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockFeedHub := &mockFeedHub{
+		NextRun: true,
+	}
+	mockClientFeed := &mockClientFeed{
+		NextFeedClient: &hub.HubFeedClient{},
+	}
+	var lastConfig config.WebSocketConfig
+	sut := agentSrv{
+		feedHub: mockFeedHub,
+		log:     testLog,
+		config:  config.Config{Websocket: config.WebSocketConfig{PingPeriod: 5}},
+		newSSEFeedFunc: func(w http.ResponseWriter, r *http.Request, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig) (feed.ClientFeed, error) {
+			lastConfig = config
+			return mockClientFeed, nil
+		},
+	}
+
+	test_req, _ := http.NewRequest("GET", "/path", nil)
+
+	//Act
+	err := sut.RegisterClientSSEFeed(httptest.NewRecorder(), test_req, hub.FeedVersion2)
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, 5, lastConfig.PingPeriod)
+	assert.True(t, mockClientFeed.ListenCalled)
+	assert.True(t, mockClientFeed.StartCalled)
+	assert.True(t, mockFeedHub.RegisterClientCalled)
+	assert.Equal(t, mockClientFeed.NextFeedClient, mockFeedHub.LastRegisteredClient)
+	assert.Equal(t, hub.FeedVersion2, mockFeedHub.LastRegisteredClient.Version)
+}
+
 func TestAgentService_RegisterClientFeed_upgrade_fails(t *testing.T) {
 	//Arrange
 	defer goleak.VerifyNone(t, ignoreOpenCensus)
@@ -301,13 +406,13 @@ func TestAgentService_RegisterClientFeed_upgrade_fails(t *testing.T) {
 	mockUpgrader := &mockWebsocketUpgrader{
 		NextError: errors.New("some upgrade error"),
 	}
-	sut := NewAgentService(config.Config{}, nil, nil, nil, mockFeedHub, nil, testLog, mockUpgrader, nil)
+	sut := NewAgentService(config.Config{}, nil, nil, nil, mockFeedHub, nil, nil, nil, testLog, mockUpgrader, nil)
 
 	test_req, _ := http.NewRequest("GET", "/path", nil)
 	w := httptest.NewRecorder()
 
 	//Act
-	sut.RegisterClientFeed(w, test_req)
+	sut.RegisterClientFeed(w, test_req, hub.FeedVersion1, nil)
 
 	//Assert
 	assert.False(t, mockFeedHub.RegisterClientCalled)
@@ -331,22 +436,29 @@ func TestAgentService_RegisterClientFeed_client_registered(t *testing.T) {
 	mockClientFeed := &mockClientFeed{
 		NextFeedClient: &hub.HubFeedClient{},
 	}
+	var lastCommandHandler feed.CommandHandler
 
 	sut := agentSrv{
 		feedHub:  mockFeedHub,
 		log:      testLog,
 		upgrader: mockUpgrader,
 		config:   config.Config{},
-		newClientFeedFunc: func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig) feed.ClientFeed {
+		newClientFeedFunc: func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig,
+			handleCommand feed.CommandHandler) feed.ClientFeed {
+			lastCommandHandler = handleCommand
 			return mockClientFeed
 		},
 	}
 
 	test_req, _ := http.NewRequest("GET", "/path", nil)
 	w := httptest.NewRecorder()
+	handlerCalled := false
 
 	//Act
-	sut.RegisterClientFeed(w, test_req)
+	sut.RegisterClientFeed(w, test_req, hub.FeedVersion1, func(cmd feed.Command) error {
+		handlerCalled = true
+		return nil
+	})
 
 	//Assert
 	assert.True(t, mockFeedHub.RegisterClientCalled)
@@ -359,6 +471,43 @@ func TestAgentService_RegisterClientFeed_client_registered(t *testing.T) {
 	assert.True(t, mockClientFeed.StartCalled)
 	assert.True(t, mockClientFeed.ListenCalled)
 	assert.True(t, mockClientFeed.GetFeedClientCalled)
+
+	assert.Nil(t, lastCommandHandler(feed.Command{}))
+	assert.True(t, handlerCalled)
+}
+
+func TestAgentService_RegisterClientFeed_answers_the_requested_subprotocol(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockFeedHub := &mockFeedHub{
+		NextRun: true,
+	}
+	mockUpgrader := &mockWebsocketUpgrader{
+		NextWebsocketConnection: &hub.MockWebsocketConnection{},
+	}
+	mockClientFeed := &mockClientFeed{
+		NextFeedClient: &hub.HubFeedClient{},
+	}
+	sut := agentSrv{
+		feedHub:  mockFeedHub,
+		log:      testLog,
+		upgrader: mockUpgrader,
+		newClientFeedFunc: func(conn hub.WebsocketConnection, log *zap.SugaredLogger, unregister feed.UnregisterFunc, config config.WebSocketConfig,
+			handleCommand feed.CommandHandler) feed.ClientFeed {
+			return mockClientFeed
+		},
+	}
+
+	test_req, _ := http.NewRequest("GET", "/path", nil)
+	test_req.Header.Set("Sec-Websocket-Protocol", feed.SubprotocolV2)
+
+	//Act
+	sut.RegisterClientFeed(httptest.NewRecorder(), test_req, hub.FeedVersion2, nil)
+
+	//Assert
+	assert.Equal(t, feed.SubprotocolV2, mockUpgrader.LastResponseHeader.Get("Sec-Websocket-Protocol"))
+	assert.True(t, mockFeedHub.RegisterClientCalled)
+	assert.Equal(t, hub.FeedVersion2, mockFeedHub.LastRegisteredClient.Version)
 }
 
 func TestAgentService_GetWebsocketStatus(t *testing.T) {
@@ -886,3 +1035,879 @@ func TestAgentService_RegisterAgent_returns_agentInfo(t *testing.T) {
 	assert.Equal(t, "ws://localaddress/api/v2/client/feed", res.FeedURL)
 	assert.Equal(t, "someName", res.Name)
 }
+
+func TestAgentService_RegisterAgent_remote_signer_key_not_saved(t *testing.T) {
+	//Arrange
+	var lastBody saveKeyDataRequest
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodPut {
+			_ = json.NewDecoder(r.Body).Decode(&lastBody)
+		}
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"actionID":"testID","name":"someName"}`))),
+		}, nil
+	}
+
+	signerMock := &action.MockSigner{
+		NextPublicKey: "remoteBlsPub",
+	}
+	storeMock := &mockStoreWriter{}
+	sut := &agentSrv{
+		authProvider: &auth.MockHeaderProvider{},
+		htc:          util.NewHTTPMockClient(),
+		log:          util.NewTestLogger(),
+		signer:       signerMock,
+		store:        storeMock,
+	}
+	sut.genKeysFunc = sut.generateKeys
+
+	//Act
+	res, err := sut.RegisterAgent(&api.AgentRegisterRequest{
+		APIKeyID:    "keyID",
+		Secret:      "secret",
+		WorkspaceID: "wkspID",
+	})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.NotNil(t, res)
+	assert.True(t, signerMock.GenerateKeyCalled)
+	assert.Equal(t, "remoteBlsPub", lastBody.BlsPublicKey)
+	assert.NotEmpty(t, lastBody.EcPublicKey)
+	assert.True(t, signerMock.SetKeyCalled)
+	assert.Empty(t, signerMock.LastBlsPrivateKey)
+	assert.Empty(t, storeMock.LastAgent.BLSPrivateKey)
+	assert.NotEmpty(t, storeMock.LastAgent.ECPrivateKey)
+}
+
+func TestAgentService_Backup_agent_not_registered(t *testing.T) {
+	//Arrange
+	sut := agentSrv{}
+
+	//Act
+	res, err := sut.Backup(&api.BackupRequest{Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "agent not registered", detail)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAgentService_Backup_no_key(t *testing.T) {
+	//Arrange
+	sut := agentSrv{
+		log:       testLog,
+		store:     &mockStoreWriter{},
+		agentInfo: &store.AgentInfo{APIKeyID: "keyID", BLSPrivateKey: "some bls key"},
+	}
+
+	//Act
+	res, err := sut.Backup(&api.BackupRequest{})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "a passphrase or a public key must be set", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAgentService_Backup_pending_rotation(t *testing.T) {
+	//Arrange
+	sut := agentSrv{
+		log: testLog,
+		store: &mockStoreWriter{
+			NextPendingRotation: &store.PendingRotation{ActionID: "some action"},
+		},
+		agentInfo: &store.AgentInfo{APIKeyID: "keyID", BLSPrivateKey: "some bls key"},
+	}
+
+	//Act
+	res, err := sut.Backup(&api.BackupRequest{Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "the agent has a pending key rotation, it's resolved on the next start", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAgentService_Backup_remote_signer(t *testing.T) {
+	//Arrange
+	cfg := config.Config{}
+	cfg.Signer.Type = "remote"
+	sut := agentSrv{
+		log:       testLog,
+		config:    cfg,
+		agentInfo: &store.AgentInfo{APIKeyID: "keyID"},
+	}
+
+	//Act
+	res, err := sut.Backup(&api.BackupRequest{Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "the agent key is held by the remote signer, back it up there", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAgentService_Restore_agent_already_registered(t *testing.T) {
+	//Arrange
+	sut := agentSrv{
+		agentInfo: &store.AgentInfo{},
+	}
+
+	//Act
+	res, err := sut.Restore(&api.RestoreRequest{Bundle: &backup.Bundle{}})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "signing agent already registered", detail)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestAgentService_Restore_wrong_passphrase(t *testing.T) {
+	//Arrange
+	agentInfo := &store.AgentInfo{APIKeyID: "keyID", BLSPrivateKey: "some bls key"}
+	bundle, err := backup.NewBundle("keyID", agentInfo, nil, backup.Key{Passphrase: "some passphrase"})
+	assert.Nil(t, err)
+
+	storeMock := &mockStoreWriter{}
+	sut := agentSrv{
+		log:   testLog,
+		store: storeMock,
+	}
+
+	//Act
+	res, err := sut.Restore(&api.RestoreRequest{Bundle: bundle, Passphrase: "other passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, _ := apiErr.APIError()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.False(t, storeMock.SaveAgentInfoCalled)
+	assert.Nil(t, sut.agentInfo)
+}
+
+func TestAgentService_Backup_and_Restore(t *testing.T) {
+	//Arrange
+	agentInfo := &store.AgentInfo{
+		BLSPrivateKey: "some bls key",
+		ECPrivateKey:  "some ec key",
+		WorkspaceID:   "wkspID",
+		APIKeyID:      "keyID",
+		APIKeySecret:  "secret",
+	}
+	registered := agentSrv{
+		log:       testLog,
+		store:     &mockStoreWriter{},
+		agentInfo: agentInfo,
+	}
+	bundle, err := registered.Backup(&api.BackupRequest{Passphrase: "some passphrase"})
+	assert.Nil(t, err)
+
+	authMock := &auth.MockHeaderProvider{}
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{}
+	sut := agentSrv{
+		log:          testLog,
+		authProvider: authMock,
+		signer:       signerMock,
+		store:        storeMock,
+		config: config.Config{
+			HTTP: config.HttpSettings{
+				Addr: "localaddress",
+			},
+		},
+	}
+
+	//Act
+	res, err := sut.Restore(&api.RestoreRequest{Bundle: bundle, Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, "keyID", res.AgentID)
+	assert.Equal(t, "ws://localaddress/api/v2/client/feed", res.FeedURL)
+
+	assert.Equal(t, "wkspID", authMock.LastWorkspaceID)
+	assert.Equal(t, "secret", authMock.LastApiKeySecret)
+	assert.Equal(t, "keyID", authMock.LastApiKeyID)
+	assert.Equal(t, "some bls key", signerMock.LastBlsPrivateKey)
+	assert.Equal(t, "keyID", storeMock.LastID)
+	assert.Equal(t, agentInfo, storeMock.LastAgent)
+	assert.Equal(t, agentInfo, sut.agentInfo)
+}
+
+func TestAgentService_Restore_save_fails(t *testing.T) {
+	//Arrange
+	bundle, err := backup.NewBundle("keyID", &store.AgentInfo{APIKeyID: "keyID", BLSPrivateKey: "some bls key"}, nil, backup.Key{Passphrase: "some passphrase"})
+	assert.Nil(t, err)
+
+	authMock := &auth.MockHeaderProvider{}
+	signerMock := &action.MockSigner{}
+	sut := agentSrv{
+		log:          testLog,
+		authProvider: authMock,
+		signer:       signerMock,
+		store:        &mockStoreWriter{NextError: errors.New("some store error")},
+	}
+
+	//Act
+	res, err := sut.Restore(&api.RestoreRequest{Bundle: bundle, Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	code, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.Equal(t, "failed to save agent info", detail)
+	assert.False(t, authMock.InitiateCalled)
+	assert.False(t, signerMock.SetKeyCalled)
+	assert.Nil(t, sut.agentInfo)
+}
+
+func TestAgentService_Restore_set_key_fails(t *testing.T) {
+	//Arrange
+	bundle, err := backup.NewBundle("keyID", &store.AgentInfo{APIKeyID: "keyID", BLSPrivateKey: "some bls key"}, nil, backup.Key{Passphrase: "some passphrase"})
+	assert.Nil(t, err)
+
+	authMock := &auth.MockHeaderProvider{}
+	signerMock := &action.MockSigner{NextSetKeyError: errors.New("some key error")}
+	storeMock := &mockStoreWriter{}
+	sut := agentSrv{
+		log:          testLog,
+		authProvider: authMock,
+		signer:       signerMock,
+		store:        storeMock,
+	}
+
+	//Act
+	res, err := sut.Restore(&api.RestoreRequest{Bundle: bundle, Passphrase: "some passphrase"})
+
+	//Assert
+	assert.Nil(t, res)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "failed to setup signer", detail)
+	assert.True(t, storeMock.SaveAgentInfoCalled)
+	assert.True(t, signerMock.ClearKeyCalled)
+	assert.True(t, authMock.StopCalled)
+	assert.Nil(t, sut.agentInfo)
+}
+
+func newRotateTestAgentSrv(signer action.Signer, storeWriter store.StoreWriter) *agentSrv {
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"actionID":"rotateID"}`))),
+		}, nil
+	}
+
+	return &agentSrv{
+		authProvider: &auth.MockHeaderProvider{},
+		htc:          util.NewHTTPMockClient(),
+		log:          util.NewTestLogger(),
+		signer:       signer,
+		store:        storeWriter,
+		agentInfo: &store.AgentInfo{
+			BLSPrivateKey: "oldBls",
+			ECPrivateKey:  "oldEc",
+			WorkspaceID:   "wkspID",
+			APIKeyID:      "keyID",
+			APIKeySecret:  "secret",
+		},
+		genKeysFunc: func() (string, string, string, string, error) {
+			return "newBlsPub", "newBls", "newEcPub", "newEc", nil
+		},
+	}
+}
+
+func TestAgentService_RotateKeys_agent_not_registered(t *testing.T) {
+	//Arrange
+	sut := agentSrv{}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "agent not registered", detail)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAgentService_RotateKeys_fails_to_approve_keeps_keys(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some approval error"),
+	}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	assert.Equal(t, "some approval error", err.Error())
+	assert.Equal(t, "rotateID", signerMock.LastActionId)
+	assert.False(t, signerMock.SetKeyCalled)
+	assert.False(t, storeMock.SaveAgentInfoCalled)
+	assert.Equal(t, "oldBls", sut.agentInfo.BLSPrivateKey)
+	assert.Equal(t, "oldEc", sut.agentInfo.ECPrivateKey)
+	assert.True(t, signerMock.ActionRejectCalled)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_RotateKeys_fails_to_set_signer_key_saves_keys(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextSetKeyError: errors.New("some signer error"),
+	}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "keys rotated, but failed to set the signer key. Please restart", detail)
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	assert.True(t, storeMock.SaveAgentInfoCalled)
+	assert.Equal(t, "newBls", storeMock.LastAgent.BLSPrivateKey)
+	assert.Equal(t, "newEc", storeMock.LastAgent.ECPrivateKey)
+}
+
+func TestAgentService_RotateKeys_fails_to_save_agent_info(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextError: errors.New("some db error"),
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "keys rotated, but failed to save agent info", detail)
+	assert.Equal(t, http.StatusInternalServerError, code)
+
+	assert.Equal(t, "newBls", signerMock.LastBlsPrivateKey)
+	assert.Equal(t, "newBls", sut.agentInfo.BLSPrivateKey)
+	assert.False(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_RotateKeys_rotates_keys(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+
+	var lastBody saveKeyDataRequest
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		_ = json.NewDecoder(r.Body).Decode(&lastBody)
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"actionID":"rotateID"}`))),
+		}, nil
+	}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, &api.RotateKeysResponse{AgentID: "keyID", ActionID: "rotateID"}, res)
+	assert.Equal(t, saveKeyDataRequest{BlsPublicKey: "newBlsPub", EcPublicKey: "newEcPub"}, lastBody)
+
+	assert.True(t, signerMock.ActionApproveCalled)
+	assert.Equal(t, "rotateID", signerMock.LastActionId)
+	assert.Equal(t, "newBls", signerMock.LastBlsPrivateKey)
+
+	assert.Equal(t, "keyID", storeMock.LastID)
+	assert.Equal(t, &store.AgentInfo{
+		BLSPrivateKey: "newBls",
+		ECPrivateKey:  "newEc",
+		WorkspaceID:   "wkspID",
+		APIKeyID:      "keyID",
+		APIKeySecret:  "secret",
+	}, storeMock.LastAgent)
+	assert.Equal(t, storeMock.LastAgent, sut.agentInfo)
+	assert.Equal(t, &store.PendingRotation{
+		Agent:        *storeMock.LastAgent,
+		BLSPublicKey: "newBlsPub",
+		ECPublicKey:  "newEcPub",
+		ActionID:     "rotateID",
+	}, storeMock.LastPendingRotation)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_RotateKeys_fails_to_save_pending_rotation(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextPendingError: errors.New("some db error"),
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	requested := false
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		requested = true
+		return nil, errors.New("unexpected request")
+	}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "failed to save the new keys", detail)
+	assert.False(t, requested)
+	assert.False(t, signerMock.ActionApproveCalled)
+	assert.Equal(t, "oldBls", sut.agentInfo.BLSPrivateKey)
+}
+
+func TestAgentService_RotateKeys_fails_to_submit_drops_pending_rotation(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return nil, errors.New("some http error")
+	}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "failed to submit the new keys", detail)
+	assert.True(t, storeMock.SavePendingRotationCalled)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+	assert.False(t, signerMock.ActionApproveCalled)
+	assert.Equal(t, "oldBls", sut.agentInfo.BLSPrivateKey)
+}
+
+func TestAgentService_RotateKeys_approval_error_but_keys_applied(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some approval error"),
+	}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		body := `{"actionID":"rotateID"}`
+		if r.Method == http.MethodGet {
+			body = `{"blsPublicKey":"newBlsPub","ecPublicKey":"newEcPub"}`
+		}
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(body))),
+		}, nil
+	}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, &api.RotateKeysResponse{AgentID: "keyID", ActionID: "rotateID"}, res)
+	assert.False(t, signerMock.ActionRejectCalled)
+	assert.Equal(t, "newBls", signerMock.LastBlsPrivateKey)
+	assert.Equal(t, "newBls", storeMock.LastAgent.BLSPrivateKey)
+	assert.Equal(t, "newBls", sut.agentInfo.BLSPrivateKey)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_RotateKeys_approval_error_rotation_not_confirmed(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{
+		NextError: errors.New("some approval error"),
+	}
+	storeMock := &mockStoreWriter{}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		if r.Method == http.MethodGet {
+			return nil, errors.New("some http error")
+		}
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"actionID":"rotateID"}`))),
+		}, nil
+	}
+
+	//Act
+	res, err := sut.RotateKeys()
+
+	//Assert
+	assert.Nil(t, res)
+	_, detail := err.(*defs.APIError).APIError()
+	assert.Equal(t, "key rotation not confirmed, it's resolved on the next start", detail)
+	assert.False(t, storeMock.DeletePendingRotationCalled)
+	assert.False(t, signerMock.SetKeyCalled)
+	assert.Equal(t, "oldBls", sut.agentInfo.BLSPrivateKey)
+}
+
+func TestAgentService_Start_promotes_applied_pending_rotation(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextPendingRotation: &store.PendingRotation{
+			Agent:        store.AgentInfo{BLSPrivateKey: "newBls", APIKeyID: "keyID"},
+			BLSPublicKey: "newBlsPub",
+			ActionID:     "rotateID",
+		},
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	sut.feedHub = &mockFeedHub{NextRun: true}
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"blsPublicKey":"newBlsPub"}`))),
+		}, nil
+	}
+
+	//Act
+	err := sut.Start()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.False(t, signerMock.ActionRejectCalled)
+	assert.Equal(t, "newBls", signerMock.LastBlsPrivateKey)
+	assert.Equal(t, "newBls", storeMock.LastAgent.BLSPrivateKey)
+	assert.Equal(t, "newBls", sut.agentInfo.BLSPrivateKey)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_Start_drops_pending_rotation_not_applied(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextPendingRotation: &store.PendingRotation{
+			Agent:        store.AgentInfo{BLSPrivateKey: "newBls", APIKeyID: "keyID"},
+			BLSPublicKey: "newBlsPub",
+			ActionID:     "rotateID",
+		},
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	sut.feedHub = &mockFeedHub{NextRun: true}
+	util.GetDoMockHTTPClientFunc = func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			Status:     "200 OK",
+			StatusCode: 200,
+			Body:       io.NopCloser(bytes.NewReader([]byte(`{"blsPublicKey":"oldBlsPub"}`))),
+		}, nil
+	}
+
+	//Act
+	err := sut.Start()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, signerMock.ActionRejectCalled)
+	assert.Equal(t, "rotateID", signerMock.LastActionId)
+	assert.False(t, signerMock.SetKeyCalled)
+	assert.False(t, storeMock.SaveAgentInfoCalled)
+	assert.Equal(t, "oldBls", sut.agentInfo.BLSPrivateKey)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+}
+
+func TestAgentService_Start_registers_webhooks(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockFeedHub := &mockFeedHub{
+		NextRun: true,
+	}
+	feedClient := hub.NewHubFeedClient(true)
+	mockWebhooks := &webhook.MockDispatcher{
+		NextHubFeedClient: &feedClient,
+	}
+	sut := agentSrv{
+		log:       testLog,
+		agentInfo: &store.AgentInfo{},
+		store:     &mockStoreWriter{},
+		feedHub:   mockFeedHub,
+		webhooks:  mockWebhooks,
+	}
+
+	//Act
+	err := sut.Start()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, mockWebhooks.ListenCalled)
+	assert.True(t, mockFeedHub.RegisterClientCalled)
+	assert.Equal(t, &feedClient, mockFeedHub.LastRegisteredClient)
+}
+
+func TestAgentService_Start_stops_webhooks_hub_doesnt_run(t *testing.T) {
+	//Arrange
+	defer goleak.VerifyNone(t, ignoreOpenCensus)
+	mockFeedHub := &mockFeedHub{}
+	mockWebhooks := &webhook.MockDispatcher{}
+	sut := agentSrv{
+		log:       testLog,
+		agentInfo: &store.AgentInfo{},
+		store:     &mockStoreWriter{},
+		feedHub:   mockFeedHub,
+		webhooks:  mockWebhooks,
+	}
+
+	//Act
+	err := sut.Start()
+
+	//Assert
+	assert.NotNil(t, err)
+	assert.True(t, mockWebhooks.ListenCalled)
+	assert.True(t, mockWebhooks.StopCalled)
+	assert.False(t, mockFeedHub.RegisterClientCalled)
+}
+
+func TestAgentService_WebhookDeadLetters_not_enabled(t *testing.T) {
+	//Arrange
+	sut := agentSrv{
+		log: testLog,
+	}
+
+	//Act
+	res, err := sut.WebhookDeadLetters()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "webhooks are not enabled", detail)
+}
+
+func TestAgentService_WebhookDeadLetters(t *testing.T) {
+	//Arrange
+	failedAt := time.Now().UTC()
+	mockWebhooks := &webhook.MockDispatcher{
+		NextDeadLetters: []webhook.Delivery{{
+			ID:            "delivery",
+			Endpoint:      "approvals",
+			ActionID:      "action",
+			Body:          []byte(`{"id":"action"}`),
+			Attempts:      10,
+			NextAttemptAt: failedAt,
+			FailedAt:      failedAt,
+			LastError:     "endpoint responded 503",
+		}},
+	}
+	sut := agentSrv{
+		log:      testLog,
+		webhooks: mockWebhooks,
+	}
+
+	//Act
+	res, err := sut.WebhookDeadLetters()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, mockWebhooks.DeadLettersCalled)
+	assert.Equal(t, []api.WebhookDeadLetter{{
+		ID:        "delivery",
+		Endpoint:  "approvals",
+		ActionID:  "action",
+		Body:      []byte(`{"id":"action"}`),
+		Attempts:  10,
+		FailedAt:  failedAt,
+		LastError: "endpoint responded 503",
+	}}, res.DeadLetters)
+}
+
+func TestAgentService_RequeueWebhookDeadLetter(t *testing.T) {
+	//Arrange
+	mockWebhooks := &webhook.MockDispatcher{}
+	sut := agentSrv{
+		log:      testLog,
+		webhooks: mockWebhooks,
+	}
+
+	//Act
+	res, err := sut.RequeueWebhookDeadLetter("delivery")
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, mockWebhooks.RequeueCalled)
+	assert.Equal(t, "delivery", mockWebhooks.LastID)
+	assert.Equal(t, &api.WebhookDeadLetterResponse{ID: "delivery", Status: "requeued"}, res)
+}
+
+func TestAgentService_RequeueWebhookDeadLetter_endpoint_not_configured(t *testing.T) {
+	//Arrange
+	sut := agentSrv{
+		log:      testLog,
+		webhooks: &webhook.MockDispatcher{NextError: webhook.ErrEndpointNotConfigured},
+	}
+
+	//Act
+	res, err := sut.RequeueWebhookDeadLetter("delivery")
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, "webhook endpoint no longer configured", detail)
+}
+
+func TestAgentService_DeleteWebhookDeadLetter_not_found(t *testing.T) {
+	//Arrange
+	mockWebhooks := &webhook.MockDispatcher{NextError: webhook.ErrDeadLetterNotFound}
+	sut := agentSrv{
+		log:      testLog,
+		webhooks: mockWebhooks,
+	}
+
+	//Act
+	res, err := sut.DeleteWebhookDeadLetter("delivery")
+
+	//Assert
+	assert.Nil(t, res)
+	assert.True(t, mockWebhooks.DeleteCalled)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "webhook dead letter not found", detail)
+}
+
+func TestAgentService_Deregister_agent_not_registered(t *testing.T) {
+	//Arrange
+	sut := agentSrv{}
+
+	//Act
+	res, err := sut.Deregister()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "agent not registered", detail)
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestAgentService_Deregister_fails_to_delete_agent_info(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextDeleteError: errors.New("some store error"),
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	sut.feedHub = &mockFeedHub{}
+
+	//Act
+	res, err := sut.Deregister()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "service stopped, but failed to delete agent info", detail)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.False(t, signerMock.ClearKeyCalled)
+	assert.NotNil(t, sut.agentInfo)
+}
+
+func TestAgentService_Deregister_fails_to_delete_pending_rotation(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{
+		NextDeletePendingError: errors.New("some store error"),
+	}
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	sut.feedHub = &mockFeedHub{}
+
+	//Act
+	res, err := sut.Deregister()
+
+	//Assert
+	assert.Nil(t, res)
+	apiErr := err.(*defs.APIError)
+	code, detail := apiErr.APIError()
+	assert.Equal(t, "service stopped, but failed to delete the pending key rotation", detail)
+	assert.Equal(t, http.StatusInternalServerError, code)
+	assert.False(t, storeMock.DeleteAgentInfoCalled)
+	assert.False(t, signerMock.ClearKeyCalled)
+	assert.NotNil(t, sut.agentInfo)
+}
+
+func TestAgentService_Deregister_stops_and_removes_agent(t *testing.T) {
+	//Arrange
+	signerMock := &action.MockSigner{}
+	storeMock := &mockStoreWriter{}
+	feedHubMock := &mockFeedHub{}
+	authMock := &auth.MockHeaderProvider{}
+	feedClient := hub.NewHubFeedClient(true)
+	sut := newRotateTestAgentSrv(signerMock, storeMock)
+	sut.feedHub = feedHubMock
+	sut.authProvider = authMock
+	sut.autoApprover = &mockAutoApprover{
+		NextHubFeedClient: &feedClient,
+	}
+	cacheMock := &message.MockCache{}
+	sut.messageCache = cacheMock
+
+	//Act
+	res, err := sut.Deregister()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.Equal(t, &api.DeregisterResponse{AgentID: "keyID"}, res)
+	assert.True(t, feedHubMock.StopCalled)
+	assert.True(t, feedHubMock.UnregisterClientCalled)
+	assert.Equal(t, &feedClient, feedHubMock.LastUnregisteredClient)
+	assert.True(t, authMock.StopCalled)
+	assert.True(t, storeMock.DeletePendingRotationCalled)
+	assert.True(t, storeMock.DeleteAgentInfoCalled)
+	assert.Equal(t, "keyID", storeMock.LastID)
+	assert.True(t, signerMock.ClearKeyCalled)
+	assert.True(t, cacheMock.ClearCalled)
+	assert.Nil(t, sut.agentInfo)
+}
+
+func TestAgentService_Deregister_unregisters_webhooks(t *testing.T) {
+	//Arrange
+	feedHubMock := &mockFeedHub{}
+	feedClient := hub.NewHubFeedClient(true)
+	sut := newRotateTestAgentSrv(&action.MockSigner{}, &mockStoreWriter{})
+	sut.feedHub = feedHubMock
+	sut.authProvider = &auth.MockHeaderProvider{}
+	sut.webhooks = &webhook.MockDispatcher{
+		NextHubFeedClient: &feedClient,
+	}
+
+	//Act
+	_, err := sut.Deregister()
+
+	//Assert
+	assert.Nil(t, err)
+	assert.True(t, feedHubMock.UnregisterClientCalled)
+	assert.Equal(t, &feedClient, feedHubMock.LastUnregisteredClient)
+}
@@ -12,6 +12,9 @@ import (
 
 const agentIDString string = "AgentID_V2"
 
+// pendingRotationPrefix is the prefix of the key of the pending rotation record of an agent
+const pendingRotationPrefix = "PendingRotation_"
+
 type AgentInfo struct {
 	BLSPrivateKey string `json:"blsPrivateKey"`
 	ECPrivateKey  string `json:"ecPrivateKey"`
@@ -20,6 +23,15 @@ type AgentInfo struct {
 	APIKeySecret  string `json:"APIKeySecret"`
 }
 
+// PendingRotation holds the agent with the keys of a key rotation not yet confirmed by Qredo, along with the public keys
+// submitted and the ID of the action approving them. It's kept until Qredo holds the new public keys or the rotation failed
+type PendingRotation struct {
+	Agent        AgentInfo `json:"agent"`
+	BLSPublicKey string    `json:"blsPublicKey"`
+	ECPublicKey  string    `json:"ecPublicKey"`
+	ActionID     string    `json:"actionID"`
+}
+
 type AgentStore interface {
 	StoreWriter
 	GetAgentInfo() (*AgentInfo, error)
@@ -27,6 +39,12 @@ type AgentStore interface {
 
 type StoreWriter interface {
 	SaveAgentInfo(id string, agent *AgentInfo) error
+	DeleteAgentInfo(id string) error
+	// SavePendingRotation saves the keys of the key rotation of the agent until it's resolved
+	SavePendingRotation(id string, rotation *PendingRotation) error
+	// GetPendingRotation returns the pending key rotation of the agent, nil if there is none
+	GetPendingRotation(id string) (*PendingRotation, error)
+	DeletePendingRotation(id string) error
 }
 
 type storage struct {
@@ -64,6 +82,95 @@ func (s *storage) SaveAgentInfo(id string, agent *AgentInfo) error {
 	return nil
 }
 
+// DeleteAgentInfo removes the agent from the store. The agentID is deleted first, so the agent is no longer
+// registered even if the record can't be deleted. The record is overwritten with an empty agent info before
+// its deletion, and purged from the stores keeping the previous versions of their data, so the keys don't stay in them
+func (s *storage) DeleteAgentInfo(id string) error {
+	if len(id) == 0 {
+		return errors.New("invalid agentID")
+	}
+
+	if err := s.kv.Del(agentIDString); err != nil && err != defs.ErrKVNotFound {
+		return fmt.Errorf("failed to delete agentID, err: %v", err)
+	}
+
+	data, err := json.Marshal(&AgentInfo{})
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent info, err: %v", err)
+	}
+
+	if err = s.kv.Set(id, data); err != nil {
+		return fmt.Errorf("failed to overwrite agent info, err: %v", err)
+	}
+
+	if err = util.Purge(s.kv, id); err != nil {
+		return fmt.Errorf("failed to delete agent info, err: %v", err)
+	}
+
+	return nil
+}
+
+func (s *storage) SavePendingRotation(id string, rotation *PendingRotation) error {
+	if len(id) == 0 {
+		return errors.New("invalid agentID")
+	}
+
+	data, err := json.Marshal(rotation)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending rotation, err: %v", err)
+	}
+
+	if err = s.kv.Set(pendingRotationPrefix+id, data); err != nil {
+		return fmt.Errorf("failed to save pending rotation, err: %v", err)
+	}
+
+	return nil
+}
+
+func (s *storage) GetPendingRotation(id string) (*PendingRotation, error) {
+	data, err := s.kv.Get(pendingRotationPrefix + id)
+	if err == defs.ErrKVNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve pending rotation, err: %v", err)
+	}
+
+	rotation := &PendingRotation{}
+	if err = json.Unmarshal(data, rotation); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal pending rotation, err: %v", err)
+	}
+
+	return rotation, nil
+}
+
+// DeletePendingRotation removes the pending rotation of the agent, if any. Like the agent info, the record is
+// overwritten with an empty rotation before its deletion, so the new keys don't stay in the stores keeping the deleted data
+func (s *storage) DeletePendingRotation(id string) error {
+	key := pendingRotationPrefix + id
+	if _, err := s.kv.Get(key); err != nil {
+		if err == defs.ErrKVNotFound {
+			return nil
+		}
+		return fmt.Errorf("failed to retrieve pending rotation, err: %v", err)
+	}
+
+	data, err := json.Marshal(&PendingRotation{})
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending rotation, err: %v", err)
+	}
+
+	if err = s.kv.Set(key, data); err != nil {
+		return fmt.Errorf("failed to overwrite pending rotation, err: %v", err)
+	}
+
+	if err = s.kv.Del(key); err != nil && err != defs.ErrKVNotFound {
+		return fmt.Errorf("failed to delete pending rotation, err: %v", err)
+	}
+
+	return nil
+}
+
 // GetAgentInfo returns the agent info if agent registered, otherwise null. If retrieving from store fails it returns error
 func (s storage) GetAgentInfo() (*AgentInfo, error) {
 	id, err := s.getSystemAgentID()
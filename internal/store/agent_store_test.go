@@ -6,6 +6,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 
+	"github.com/qredo/signing-agent/internal/defs"
 	"github.com/qredo/signing-agent/internal/util"
 )
 
@@ -69,4 +70,111 @@ func TestStorage(t *testing.T) {
 			assert.Nil(t, err)
 			assert.Equal(t, *agentInfo, *newAgentInfo)
 		})
+
+	t.Run(
+		"deregister agent - invalid id",
+		func(t *testing.T) {
+			err = store.DeleteAgentInfo("")
+
+			assert.NotNil(t, err)
+			assert.Equal(t, "invalid agentID", err.Error())
+		})
+
+	t.Run(
+		"deregister agent - deletes agent info",
+		func(t *testing.T) {
+			agentID := "5zPWqLZaPqAaNenjyzWy5rcaGm4PuT1bfP74GgrzFUJn"
+			err = store.DeleteAgentInfo(agentID)
+			assert.Nil(t, err)
+
+			agentInfo, err := store.GetAgentInfo()
+			assert.Nil(t, err)
+			assert.Nil(t, agentInfo)
+
+			_, err = kv.Get(agentID)
+			assert.Equal(t, defs.ErrKVNotFound, err)
+		})
+
+	t.Run(
+		"pending rotation - not set",
+		func(t *testing.T) {
+			rotation, err := store.GetPendingRotation("api key id")
+			assert.Nil(t, rotation)
+			assert.Nil(t, err)
+		})
+
+	t.Run(
+		"pending rotation - invalid id",
+		func(t *testing.T) {
+			err = store.SavePendingRotation("", &PendingRotation{})
+
+			assert.NotNil(t, err)
+			assert.Equal(t, "invalid agentID", err.Error())
+		})
+
+	t.Run(
+		"pending rotation - saves and deletes the rotation",
+		func(t *testing.T) {
+			rotation := &PendingRotation{
+				Agent: AgentInfo{
+					BLSPrivateKey: "new bls priv key",
+					ECPrivateKey:  "new ec priv key",
+					APIKeyID:      "api key id",
+				},
+				BLSPublicKey: "new bls pub key",
+				ECPublicKey:  "new ec pub key",
+				ActionID:     "action id",
+			}
+			err = store.SavePendingRotation("api key id", rotation)
+			assert.Nil(t, err)
+
+			saved, err := store.GetPendingRotation("api key id")
+			assert.Nil(t, err)
+			assert.Equal(t, *rotation, *saved)
+
+			err = store.DeletePendingRotation("api key id")
+			assert.Nil(t, err)
+
+			saved, err = store.GetPendingRotation("api key id")
+			assert.Nil(t, err)
+			assert.Nil(t, saved)
+
+			err = store.DeletePendingRotation("api key id")
+			assert.Nil(t, err)
+		})
+
+	t.Run(
+		"pending rotation - overwrites the rotation before deleting it",
+		func(t *testing.T) {
+			recorder := &recordingStore{KVStore: kv}
+			sut := NewAgentStore(recorder)
+			err = sut.SavePendingRotation("api key id", &PendingRotation{
+				Agent:    AgentInfo{BLSPrivateKey: "new bls priv key"},
+				ActionID: "action id",
+			})
+			assert.Nil(t, err)
+
+			err = sut.DeletePendingRotation("api key id")
+			assert.Nil(t, err)
+
+			assert.Equal(t, pendingRotationPrefix+"api key id", recorder.lastDeleted)
+			assert.JSONEq(t, `{"agent":{"blsPrivateKey":"","ecPrivateKey":"","workspaceID":"","APIKeyID":"","APIKeySecret":""},"blsPublicKey":"","ecPublicKey":"","actionID":""}`, string(recorder.lastSet))
+		})
+}
+
+// recordingStore keeps the last data set and the last key deleted
+type recordingStore struct {
+	util.KVStore
+	lastSet     []byte
+	lastDeleted string
+}
+
+func (r *recordingStore) Set(key string, data []byte) error {
+	r.lastSet = data
+	return r.KVStore.Set(key, data)
+}
+
+func (r *recordingStore) Del(key string) error {
+	r.lastDeleted = key
+	return r.KVStore.Del(key)
 }
@@ -0,0 +1,98 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+// Migrate copies the registered agent from the source store to the destination store and verifies the copy
+// by reading it back. The agent record and its pending key rotation, if any, are copied as is, so the agent keeps its keys
+// and the rotation is resolved on the next start. The source store is wiped only once the copy is verified.
+// It returns the ID of the migrated agent
+func Migrate(from, to util.KVStore, wipeSource bool) (string, error) {
+	id, err := from.Get(agentIDString)
+	if err != nil {
+		if err == defs.ErrKVNotFound {
+			return defs.EmptyString, fmt.Errorf("no agent registered in the source store")
+		}
+		return defs.EmptyString, fmt.Errorf("failed to read the agentID from the source store, err: %v", err)
+	}
+
+	agentID := string(id)
+	record, err := from.Get(agentID)
+	if err != nil {
+		return defs.EmptyString, fmt.Errorf("failed to read the agent info from the source store, err: %v", err)
+	}
+
+	rotationKey := pendingRotationPrefix + agentID
+	rotation, err := from.Get(rotationKey)
+	if err != nil && err != defs.ErrKVNotFound {
+		return defs.EmptyString, fmt.Errorf("failed to read the pending key rotation from the source store, err: %v", err)
+	}
+	hasRotation := err == nil
+
+	existing, err := to.Get(agentIDString)
+	if err != nil && err != defs.ErrKVNotFound {
+		return defs.EmptyString, fmt.Errorf("failed to read the agentID from the destination store, err: %v", err)
+	}
+	if err == nil && string(existing) != agentID {
+		return defs.EmptyString, fmt.Errorf("the destination store already holds the agent %s", string(existing))
+	}
+
+	// the record is written first, so the agentID never points to a missing record
+	if err := to.Set(agentID, record); err != nil {
+		return defs.EmptyString, fmt.Errorf("failed to save the agent info in the destination store, err: %v", err)
+	}
+	if hasRotation {
+		if err := to.Set(rotationKey, rotation); err != nil {
+			return defs.EmptyString, fmt.Errorf("failed to save the pending key rotation in the destination store, err: %v", err)
+		}
+	}
+	if err := to.Set(agentIDString, id); err != nil {
+		return defs.EmptyString, fmt.Errorf("failed to set the agentID in the destination store, err: %v", err)
+	}
+
+	if err := verifyCopy(to, agentIDString, id); err != nil {
+		return defs.EmptyString, err
+	}
+	if err := verifyCopy(to, agentID, record); err != nil {
+		return defs.EmptyString, err
+	}
+	if hasRotation {
+		if err := verifyCopy(to, rotationKey, rotation); err != nil {
+			return defs.EmptyString, err
+		}
+	}
+
+	if wipeSource {
+		if err := from.Del(agentIDString); err != nil {
+			return agentID, fmt.Errorf("agent copied, but failed to delete the agentID from the source store, err: %v", err)
+		}
+		if err := from.Del(agentID); err != nil {
+			return agentID, fmt.Errorf("agent copied, but failed to delete the agent info from the source store, err: %v", err)
+		}
+		if hasRotation {
+			if err := from.Del(rotationKey); err != nil {
+				return agentID, fmt.Errorf("agent copied, but failed to delete the pending key rotation from the source store, err: %v", err)
+			}
+		}
+	}
+
+	return agentID, nil
+}
+
+func verifyCopy(kv util.KVStore, key string, expected []byte) error {
+	copied, err := kv.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to read back `%s` from the destination store, err: %v", key, err)
+	}
+
+	if !bytes.Equal(copied, expected) {
+		return fmt.Errorf("the copy of `%s` in the destination store differs from the source", key)
+	}
+
+	return nil
+}
@@ -0,0 +1,170 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+const testMigrateAgentID = "5zPWqLZaPqAaNenjyzWy5rcaGm4PuT1bfP74GgrzFUJn"
+
+func newTestKVStore(t *testing.T) util.KVStore {
+	kv := util.NewFileStore(filepath.Join(t.TempDir(), "ccstore.db"))
+	require.NoError(t, kv.Init())
+	return kv
+}
+
+func newRegisteredKVStore(t *testing.T) (util.KVStore, *AgentInfo) {
+	kv := newTestKVStore(t)
+	agent := &AgentInfo{
+		BLSPrivateKey: "some bls key",
+		ECPrivateKey:  "some ec key",
+		WorkspaceID:   "some workspace",
+		APIKeyID:      "some api key",
+		APIKeySecret:  "some secret",
+	}
+	require.NoError(t, NewAgentStore(kv).SaveAgentInfo(testMigrateAgentID, agent))
+	return kv, agent
+}
+
+// corruptingStore stores a different value than the one set
+type corruptingStore struct {
+	util.KVStore
+}
+
+func (c *corruptingStore) Set(key string, data []byte) error {
+	return c.KVStore.Set(key, append(data, '!'))
+}
+
+func TestMigrate_copies_agent(t *testing.T) {
+	//Arrange
+	from, agent := newRegisteredKVStore(t)
+	to := newTestKVStore(t)
+
+	//Act
+	agentID, err := Migrate(from, to, false)
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, testMigrateAgentID, agentID)
+
+	migrated, err := NewAgentStore(to).GetAgentInfo()
+	require.NoError(t, err)
+	assert.Equal(t, agent, migrated)
+
+	source, err := NewAgentStore(from).GetAgentInfo()
+	require.NoError(t, err)
+	assert.Equal(t, agent, source)
+}
+
+func TestMigrate_wipes_source(t *testing.T) {
+	//Arrange
+	from, agent := newRegisteredKVStore(t)
+	to := newTestKVStore(t)
+
+	//Act
+	_, err := Migrate(from, to, true)
+
+	//Assert
+	require.NoError(t, err)
+
+	migrated, err := NewAgentStore(to).GetAgentInfo()
+	require.NoError(t, err)
+	assert.Equal(t, agent, migrated)
+
+	_, err = from.Get(agentIDString)
+	assert.Equal(t, defs.ErrKVNotFound, err)
+	_, err = from.Get(testMigrateAgentID)
+	assert.Equal(t, defs.ErrKVNotFound, err)
+}
+
+func TestMigrate_copies_and_wipes_pending_rotation(t *testing.T) {
+	//Arrange
+	from, _ := newRegisteredKVStore(t)
+	rotation := &PendingRotation{
+		Agent:        AgentInfo{BLSPrivateKey: "new bls key", APIKeyID: "some api key"},
+		BLSPublicKey: "new bls pub key",
+		ECPublicKey:  "new ec pub key",
+		ActionID:     "some action",
+	}
+	require.NoError(t, NewAgentStore(from).SavePendingRotation(testMigrateAgentID, rotation))
+	to := newTestKVStore(t)
+
+	//Act
+	_, err := Migrate(from, to, true)
+
+	//Assert
+	require.NoError(t, err)
+
+	migrated, err := NewAgentStore(to).GetPendingRotation(testMigrateAgentID)
+	require.NoError(t, err)
+	assert.Equal(t, rotation, migrated)
+
+	_, err = from.Get(pendingRotationPrefix + testMigrateAgentID)
+	assert.Equal(t, defs.ErrKVNotFound, err)
+}
+
+func TestMigrate_same_agent_already_in_destination(t *testing.T) {
+	//Arrange
+	from, _ := newRegisteredKVStore(t)
+	to := newTestKVStore(t)
+	_, err := Migrate(from, to, false)
+	require.NoError(t, err)
+
+	//Act
+	agentID, err := Migrate(from, to, false)
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, testMigrateAgentID, agentID)
+}
+
+func TestMigrate_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		stores func(t *testing.T) (util.KVStore, util.KVStore)
+		errMsg string
+	}{
+		{
+			name: "no agent in source",
+			stores: func(t *testing.T) (util.KVStore, util.KVStore) {
+				return newTestKVStore(t), newTestKVStore(t)
+			},
+			errMsg: "no agent registered in the source store",
+		},
+		{
+			name: "other agent in destination",
+			stores: func(t *testing.T) (util.KVStore, util.KVStore) {
+				from, _ := newRegisteredKVStore(t)
+				to := newTestKVStore(t)
+				require.NoError(t, NewAgentStore(to).SaveAgentInfo("other agent", &AgentInfo{}))
+				return from, to
+			},
+			errMsg: "the destination store already holds the agent other agent",
+		},
+		{
+			name: "copy differs",
+			stores: func(t *testing.T) (util.KVStore, util.KVStore) {
+				from, _ := newRegisteredKVStore(t)
+				return from, &corruptingStore{newTestKVStore(t)}
+			},
+			errMsg: "the copy of `AgentID_V2` in the destination store differs from the source",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			from, to := tc.stores(t)
+
+			//Act
+			_, err := Migrate(from, to, true)
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
@@ -1,19 +1,135 @@
 package util
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"crypto/rand"
 	"encoding/json"
 	"io"
 
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
 
 	"github.com/qredo/signing-agent/crypto"
 )
 
 const (
 	AMCLRandomSeedSize = 48
+	// AESKeySize is the size of the AES-256 keys
+	AESKeySize = 32
+
+	kdfArgon2id = "argon2id"
+	kdfSaltSize = 16
+
+	// the bounds of the key derivation parameters read from the stored data, so crafted parameters can't
+	// make argon2 panic or exhaust the memory and the CPU
+	kdfMaxSaltSize = 64
+	kdfMaxTime     = 16
+	kdfMinMemory   = 1024
+	kdfMaxMemory   = 1024 * 1024
 )
 
+// DefaultKDFParams are the argon2id parameters of the keys derived from a passphrase
+var DefaultKDFParams = KDFParams{
+	Algorithm: kdfArgon2id,
+	Time:      3,
+	Memory:    64 * 1024,
+	Threads:   4,
+}
+
+// KDFParams are the parameters of the key derivation from a passphrase, stored with the encrypted data
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memoryKiB"`
+	Threads   uint8  `json:"threads"`
+}
+
+// NewKDFParams returns the default key derivation parameters with a random salt
+func NewKDFParams() (*KDFParams, error) {
+	salt, err := RandomBytes(kdfSaltSize)
+	if err != nil {
+		return nil, err
+	}
+
+	params := DefaultKDFParams
+	params.Salt = salt
+	return &params, nil
+}
+
+// DeriveKey returns the AES-256 key derived from the passphrase
+func (p *KDFParams) DeriveKey(passphrase string) ([]byte, error) {
+	if p.Algorithm != kdfArgon2id {
+		return nil, errors.Errorf("unsupported key derivation `%s`", p.Algorithm)
+	}
+
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, AESKeySize), nil
+}
+
+// validate checks the parameters are within the bounds argon2 accepts and the agent can afford
+func (p *KDFParams) validate() error {
+	if len(p.Salt) < kdfSaltSize || len(p.Salt) > kdfMaxSaltSize {
+		return errors.Errorf("invalid key derivation salt size %d, expected between %d and %d", len(p.Salt), kdfSaltSize, kdfMaxSaltSize)
+	}
+
+	if p.Time < 1 || p.Time > kdfMaxTime {
+		return errors.Errorf("invalid key derivation time %d, expected between 1 and %d", p.Time, kdfMaxTime)
+	}
+
+	if p.Memory < kdfMinMemory || p.Memory > kdfMaxMemory {
+		return errors.Errorf("invalid key derivation memory %d KiB, expected between %d and %d", p.Memory, kdfMinMemory, kdfMaxMemory)
+	}
+
+	if p.Threads < 1 {
+		return errors.New("invalid key derivation threads 0, expected between 1 and 255")
+	}
+
+	return nil
+}
+
+// EncryptGCM encrypts with AES-256-GCM, the random nonce is prepended to the ciphertext
+func EncryptGCM(key, plain, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := RandomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plain, aad), nil
+}
+
+// DecryptGCM decrypts the ciphertext returned by EncryptGCM
+func DecryptGCM(key, encrypted, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(encrypted) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	return gcm.Open(nil, encrypted[:gcm.NonceSize()], encrypted[gcm.NonceSize():], aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
 func RandomBytes(size int) ([]byte, error) {
 	b := make([]byte, size)
 	n, err := io.ReadAtLeast(rand.Reader, b, size)
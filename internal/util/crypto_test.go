@@ -0,0 +1,76 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKDFParams_DeriveKey_invalid_params(t *testing.T) {
+	tests := []struct {
+		name   string
+		update func(p *KDFParams)
+		errMsg string
+	}{
+		{
+			name:   "unsupported algorithm",
+			update: func(p *KDFParams) { p.Algorithm = "scrypt" },
+			errMsg: "unsupported key derivation `scrypt`",
+		},
+		{
+			name:   "short salt",
+			update: func(p *KDFParams) { p.Salt = p.Salt[:8] },
+			errMsg: "invalid key derivation salt size 8, expected between 16 and 64",
+		},
+		{
+			name:   "no time",
+			update: func(p *KDFParams) { p.Time = 0 },
+			errMsg: "invalid key derivation time 0, expected between 1 and 16",
+		},
+		{
+			name:   "too much memory",
+			update: func(p *KDFParams) { p.Memory = 4 * 1024 * 1024 },
+			errMsg: "invalid key derivation memory 4194304 KiB, expected between 1024 and 1048576",
+		},
+		{
+			name:   "too little memory",
+			update: func(p *KDFParams) { p.Memory = 8 },
+			errMsg: "invalid key derivation memory 8 KiB, expected between 1024 and 1048576",
+		},
+		{
+			name:   "no threads",
+			update: func(p *KDFParams) { p.Threads = 0 },
+			errMsg: "invalid key derivation threads 0, expected between 1 and 255",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//Arrange
+			params, err := NewKDFParams()
+			require.NoError(t, err)
+			tt.update(params)
+
+			//Act
+			key, err := params.DeriveKey("some passphrase")
+
+			//Assert
+			assert.Nil(t, key)
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
+
+func TestKDFParams_DeriveKey(t *testing.T) {
+	//Arrange
+	params, err := NewKDFParams()
+	require.NoError(t, err)
+
+	//Act
+	key, err := params.DeriveKey("some passphrase")
+
+	//Assert
+	require.NoError(t, err)
+	assert.Len(t, key, AESKeySize)
+}
@@ -3,8 +3,12 @@ package util
 import (
 	"encoding/json"
 	"os"
+	"path/filepath"
 	"sync"
 
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/config"
 	"github.com/qredo/signing-agent/internal/defs"
 )
 
@@ -17,10 +21,22 @@ func NewFileStore(fileName string) KVStore {
 	return fs
 }
 
+// NewEncryptedFileStore returns a file store encrypted at rest. A plaintext store file is encrypted on Init
+func NewEncryptedFileStore(fileName string, cfg config.FileEncryption) KVStore {
+	fs := &FileStore{
+		fileName: fileName,
+		data:     map[string][]byte{},
+		cipher:   newStoreCipher(cfg),
+	}
+
+	return fs
+}
+
 type FileStore struct {
 	sync.RWMutex
 	fileName string
 	data     map[string][]byte
+	cipher   *storeCipher
 }
 
 func (s *FileStore) Init() error {
@@ -30,27 +46,110 @@ func (s *FileStore) Init() error {
 	b, err := os.ReadFile(s.fileName)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if s.cipher != nil {
+				if err := s.cipher.create(); err != nil {
+					return err
+				}
+			}
 			return s.save()
 		}
 		return err
 	}
 
+	if isEncryptedFile(b) {
+		if s.cipher == nil {
+			return errors.New("the store file is encrypted, the file store encryption must be enabled")
+		}
+
+		data, err := s.cipher.open(b)
+		if err != nil {
+			return err
+		}
+		s.data = data
+
+		return nil
+	}
+
 	if err := json.Unmarshal(b, &s.data); err != nil {
 		return err
 	}
 
+	if s.cipher != nil {
+		// migrate the plaintext store
+		if err := s.cipher.create(); err != nil {
+			return err
+		}
+		return s.save()
+	}
+
 	return nil
 }
 
-// caller must handle concurrency
+// caller must handle concurrency.
+// The store is written to a temporary file renamed over the store file, so a crash leaves either store whole,
+// the plaintext one included while it's migrated
 func (s *FileStore) save() error {
 
-	b, err := json.Marshal(s.data)
+	var b []byte
+	var err error
+	if s.cipher != nil {
+		b, err = s.cipher.seal(s.data)
+	} else {
+		b, err = json.Marshal(s.data)
+	}
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.fileName)
+	file, err := os.CreateTemp(dir, filepath.Base(s.fileName)+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "write store file")
+	}
+	tmpName := file.Name()
+
+	if err := writeSynced(file, b); err != nil {
+		_ = os.Remove(tmpName)
+		return errors.Wrap(err, "write store file")
+	}
+
+	if err := os.Rename(tmpName, s.fileName); err != nil {
+		_ = os.Remove(tmpName)
+		return errors.Wrap(err, "write store file")
+	}
+
+	return errors.Wrap(syncDir(dir), "write store file")
+}
+
+// writeSynced writes the data to the file and flushes it to disk before closing it
+func writeSynced(file *os.File, data []byte) error {
+	if err := file.Chmod(0600); err != nil {
+		file.Close()
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// syncDir flushes the directory entries to disk, so the rename survives a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
 	if err != nil {
 		return err
 	}
+	defer d.Close()
 
-	return os.WriteFile(s.fileName, b, 0600)
+	return d.Sync()
 }
 
 func (s *FileStore) Get(key string) ([]byte, error) {
@@ -0,0 +1,188 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/config"
+)
+
+const (
+	// encryptedStoreVersion is the version of the encrypted file store format
+	encryptedStoreVersion = 1
+)
+
+// storeAAD binds the ciphertexts to the encrypted file store format
+var storeAAD = []byte("signing-agent/filestore/v1")
+
+// ErrWrongStoreKey is returned when the data key can't be decrypted with the configured key
+var ErrWrongStoreKey = errors.New("wrong encryption key for the file store, or the store file is corrupted")
+
+// encryptedFile is the format of the encrypted file store. The data is encrypted with a random data key,
+// itself encrypted with the key encryption key given in the config or derived from the passphrase
+type encryptedFile struct {
+	Version    int        `json:"version"`
+	KDF        *KDFParams `json:"kdf,omitempty"`
+	WrappedKey []byte     `json:"wrappedKey"`
+	Data       []byte     `json:"data"`
+}
+
+// storeCipher encrypts the content of the file store
+type storeCipher struct {
+	cfg        config.FileEncryption
+	kdf        *KDFParams
+	wrappedKey []byte
+	dataKey    []byte
+}
+
+func newStoreCipher(cfg config.FileEncryption) *storeCipher {
+	return &storeCipher{
+		cfg: cfg,
+	}
+}
+
+// isEncryptedFile returns true if the content is in the encrypted file store format
+func isEncryptedFile(b []byte) bool {
+	fields := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return false
+	}
+
+	_, ok := fields["wrappedKey"]
+	return ok
+}
+
+// create generates the data key of a new encrypted store
+func (c *storeCipher) create() error {
+	var kdf *KDFParams
+	if len(c.cfg.Passphrase) > 0 {
+		var err error
+		if kdf, err = NewKDFParams(); err != nil {
+			return err
+		}
+	}
+
+	kek, err := c.keyEncryptionKey(kdf)
+	if err != nil {
+		return err
+	}
+
+	dataKey, err := RandomBytes(AESKeySize)
+	if err != nil {
+		return err
+	}
+
+	wrappedKey, err := EncryptGCM(kek, dataKey, storeAAD)
+	if err != nil {
+		return errors.Wrap(err, "encrypt data key")
+	}
+
+	c.kdf = kdf
+	c.wrappedKey = wrappedKey
+	c.dataKey = dataKey
+	return nil
+}
+
+// open decrypts the data key and the content of the encrypted store
+func (c *storeCipher) open(b []byte) (map[string][]byte, error) {
+	file := encryptedFile{}
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, errors.Wrap(err, "parse encrypted store file")
+	}
+
+	if file.Version != encryptedStoreVersion {
+		return nil, errors.Errorf("unsupported encrypted store version %d", file.Version)
+	}
+
+	kek, err := c.keyEncryptionKey(file.KDF)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := DecryptGCM(kek, file.WrappedKey, storeAAD)
+	if err != nil {
+		return nil, ErrWrongStoreKey
+	}
+
+	plain, err := DecryptGCM(dataKey, file.Data, storeAAD)
+	if err != nil {
+		return nil, errors.Wrap(err, "decrypt store data")
+	}
+
+	data := map[string][]byte{}
+	if err := json.Unmarshal(plain, &data); err != nil {
+		return nil, errors.Wrap(err, "parse store data")
+	}
+
+	c.kdf = file.KDF
+	c.wrappedKey = file.WrappedKey
+	c.dataKey = dataKey
+	return data, nil
+}
+
+// seal returns the encrypted store file content
+func (c *storeCipher) seal(data map[string][]byte) ([]byte, error) {
+	plain, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, err := EncryptGCM(c.dataKey, plain, storeAAD)
+	if err != nil {
+		return nil, errors.Wrap(err, "encrypt store data")
+	}
+
+	return json.Marshal(encryptedFile{
+		Version:    encryptedStoreVersion,
+		KDF:        c.kdf,
+		WrappedKey: c.wrappedKey,
+		Data:       encrypted,
+	})
+}
+
+// keyEncryptionKey returns the key derived from the passphrase with the kdf params, or the configured key
+func (c *storeCipher) keyEncryptionKey(kdf *KDFParams) ([]byte, error) {
+	set := 0
+	for _, v := range []string{c.cfg.Passphrase, c.cfg.Key, c.cfg.KeyFile} {
+		if len(v) > 0 {
+			set++
+		}
+	}
+	if set != 1 {
+		return nil, errors.New("exactly one of the passphrase, the key or the key file must be set for the file store encryption")
+	}
+
+	if len(c.cfg.Passphrase) > 0 {
+		if kdf == nil {
+			return nil, errors.New("the store file is encrypted with a key, not a passphrase")
+		}
+		return kdf.DeriveKey(c.cfg.Passphrase)
+	}
+
+	if kdf != nil {
+		return nil, errors.New("the store file is encrypted with a passphrase, not a key")
+	}
+
+	encoded := c.cfg.Key
+	if len(c.cfg.KeyFile) > 0 {
+		b, err := os.ReadFile(c.cfg.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read store key file")
+		}
+		encoded = string(b)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, errors.Wrap(err, "decode store key")
+	}
+	if len(key) != AESKeySize {
+		return nil, errors.Errorf("the store key must be %d bytes, got %d", AESKeySize, len(key))
+	}
+
+	return key, nil
+}
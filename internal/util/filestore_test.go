@@ -0,0 +1,252 @@
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/config"
+)
+
+func init() {
+	// keep the key derivation cheap in the tests
+	DefaultKDFParams.Time = 1
+	DefaultKDFParams.Memory = 1024
+	DefaultKDFParams.Threads = 1
+}
+
+func newTestStoreKey(t *testing.T) string {
+	key, err := RandomBytes(AESKeySize)
+	require.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestEncryptedFileStore_round_trip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  func(t *testing.T, dir string) config.FileEncryption
+	}{
+		{
+			name: "passphrase",
+			cfg: func(t *testing.T, dir string) config.FileEncryption {
+				return config.FileEncryption{Enabled: true, Passphrase: "some passphrase"}
+			},
+		},
+		{
+			name: "key",
+			cfg: func(t *testing.T, dir string) config.FileEncryption {
+				return config.FileEncryption{Enabled: true, Key: newTestStoreKey(t)}
+			},
+		},
+		{
+			name: "key file",
+			cfg: func(t *testing.T, dir string) config.FileEncryption {
+				keyFile := filepath.Join(dir, "store.key")
+				require.NoError(t, os.WriteFile(keyFile, []byte(newTestStoreKey(t)+"\n"), 0600))
+				return config.FileEncryption{Enabled: true, KeyFile: keyFile}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			dir := t.TempDir()
+			fileName := filepath.Join(dir, "ccstore.db")
+			cfg := tc.cfg(t, dir)
+
+			sut := NewEncryptedFileStore(fileName, cfg)
+			require.NoError(t, sut.Init())
+
+			//Act
+			require.NoError(t, sut.Set("some key", []byte("some secret value")))
+
+			reopened := NewEncryptedFileStore(fileName, cfg)
+			err := reopened.Init()
+
+			//Assert
+			require.NoError(t, err)
+			value, err := reopened.Get("some key")
+			require.NoError(t, err)
+			assert.Equal(t, []byte("some secret value"), value)
+
+			raw, _ := os.ReadFile(fileName)
+			assert.NotContains(t, string(raw), base64.StdEncoding.EncodeToString([]byte("some secret value")))
+			assert.True(t, isEncryptedFile(raw))
+		})
+	}
+}
+
+func TestEncryptedFileStore_Init_wrong_key(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "ccstore.db")
+	store := NewEncryptedFileStore(fileName, config.FileEncryption{Enabled: true, Passphrase: "some passphrase"})
+	require.NoError(t, store.Init())
+	require.NoError(t, store.Set("some key", []byte("some value")))
+
+	sut := NewEncryptedFileStore(fileName, config.FileEncryption{Enabled: true, Passphrase: "other passphrase"})
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	assert.ErrorIs(t, err, ErrWrongStoreKey)
+}
+
+func TestEncryptedFileStore_Init_migrates_plaintext_store(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "ccstore.db")
+	plain := NewFileStore(fileName)
+	require.NoError(t, plain.Init())
+	require.NoError(t, plain.Set("some key", []byte("some value")))
+
+	cfg := config.FileEncryption{Enabled: true, Key: newTestStoreKey(t)}
+	sut := NewEncryptedFileStore(fileName, cfg)
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	require.NoError(t, err)
+	raw, _ := os.ReadFile(fileName)
+	assert.True(t, isEncryptedFile(raw))
+
+	reopened := NewEncryptedFileStore(fileName, cfg)
+	require.NoError(t, reopened.Init())
+	value, err := reopened.Get("some key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("some value"), value)
+}
+
+func TestFileStore_Init_encrypted_file_without_encryption(t *testing.T) {
+	//Arrange
+	fileName := filepath.Join(t.TempDir(), "ccstore.db")
+	require.NoError(t, NewEncryptedFileStore(fileName, config.FileEncryption{Enabled: true, Key: newTestStoreKey(t)}).Init())
+
+	sut := NewFileStore(fileName)
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	assert.ErrorContains(t, err, "the store file is encrypted")
+}
+
+func TestEncryptedFileStore_Init_fails(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		cfg    config.FileEncryption
+		file   func(t *testing.T) []byte
+		errMsg string
+	}{
+		{
+			name:   "no key",
+			cfg:    config.FileEncryption{Enabled: true},
+			errMsg: "exactly one of the passphrase, the key or the key file must be set",
+		},
+		{
+			name:   "passphrase and key",
+			cfg:    config.FileEncryption{Enabled: true, Passphrase: "some passphrase", Key: "some key"},
+			errMsg: "exactly one of the passphrase, the key or the key file must be set",
+		},
+		{
+			name:   "short key",
+			cfg:    config.FileEncryption{Enabled: true, Key: base64.StdEncoding.EncodeToString([]byte("short"))},
+			errMsg: "the store key must be 32 bytes, got 5",
+		},
+		{
+			name:   "missing key file",
+			cfg:    config.FileEncryption{Enabled: true, KeyFile: "missing.key"},
+			errMsg: "read store key file",
+		},
+		{
+			name: "unsupported version",
+			cfg:  config.FileEncryption{Enabled: true, Passphrase: "some passphrase"},
+			file: func(t *testing.T) []byte {
+				b, _ := json.Marshal(encryptedFile{Version: 2, WrappedKey: []byte("some wrapped key")})
+				return b
+			},
+			errMsg: "unsupported encrypted store version 2",
+		},
+		{
+			name: "passphrase for a key encrypted store",
+			cfg:  config.FileEncryption{Enabled: true, Passphrase: "some passphrase"},
+			file: func(t *testing.T) []byte {
+				b, _ := json.Marshal(encryptedFile{Version: encryptedStoreVersion, WrappedKey: []byte("some wrapped key")})
+				return b
+			},
+			errMsg: "the store file is encrypted with a key, not a passphrase",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			fileName := filepath.Join(t.TempDir(), "ccstore.db")
+			if tc.file != nil {
+				require.NoError(t, os.WriteFile(fileName, tc.file(t), 0600))
+			}
+			sut := NewEncryptedFileStore(fileName, tc.cfg)
+
+			//Act
+			err := sut.Init()
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
+
+func TestFileStore_Set_replaces_the_file_atomically(t *testing.T) {
+	//Arrange
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "ccstore.db")
+	sut := NewFileStore(fileName)
+	require.NoError(t, sut.Init())
+
+	//Act
+	err := sut.Set("some key", []byte("some value"))
+
+	//Assert
+	require.NoError(t, err)
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "ccstore.db", entries[0].Name())
+
+	info, err := os.Stat(fileName)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	data := map[string][]byte{}
+	raw, _ := os.ReadFile(fileName)
+	require.NoError(t, json.Unmarshal(raw, &data))
+	assert.Equal(t, []byte("some value"), data["some key"])
+}
+
+func TestFileStore_Set_keeps_the_file_when_the_write_fails(t *testing.T) {
+	//Arrange
+	dir := t.TempDir()
+	fileName := filepath.Join(dir, "ccstore.db")
+	sut := NewFileStore(fileName)
+	require.NoError(t, sut.Init())
+	require.NoError(t, sut.Set("some key", []byte("some value")))
+	before, _ := os.ReadFile(fileName)
+
+	require.NoError(t, os.Chmod(dir, 0500))
+	t.Cleanup(func() { _ = os.Chmod(dir, 0700) })
+	if f, err := os.CreateTemp(dir, "probe"); err == nil {
+		// running as root, the directory permissions aren't enforced
+		f.Close()
+		t.Skip("the directory is writable")
+	}
+
+	//Act
+	err := sut.Set("other key", []byte("other value"))
+
+	//Assert
+	assert.ErrorContains(t, err, "write store file")
+	after, _ := os.ReadFile(fileName)
+	assert.Equal(t, before, after)
+}
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/metrics"
+)
+
+// NewInstrumentedStore returns a KVStore observing the latency of the kv operations, labelled with the backend name
+func NewInstrumentedStore(kv KVStore, backend string) KVStore {
+	return &InstrumentedStore{
+		kv:      kv,
+		backend: backend,
+	}
+}
+
+type InstrumentedStore struct {
+	kv      KVStore
+	backend string
+}
+
+func (s *InstrumentedStore) Init() error {
+	return s.kv.Init()
+}
+
+func (s *InstrumentedStore) Get(key string) ([]byte, error) {
+	start := time.Now()
+	data, err := s.kv.Get(key)
+
+	// a missing key is an expected result, not a failure of the backend
+	outcome := metrics.Outcome(err)
+	if errors.Is(err, defs.ErrKVNotFound) {
+		outcome = metrics.OutcomeSuccess
+	}
+	s.observe("get", outcome, start)
+
+	return data, err
+}
+
+func (s *InstrumentedStore) Set(key string, data []byte) error {
+	start := time.Now()
+	err := s.kv.Set(key, data)
+	s.observe("set", metrics.Outcome(err), start)
+
+	return err
+}
+
+func (s *InstrumentedStore) Del(key string) error {
+	start := time.Now()
+	err := s.kv.Del(key)
+	s.observe("del", metrics.Outcome(err), start)
+
+	return err
+}
+
+func (s *InstrumentedStore) Purge(key string) error {
+	start := time.Now()
+	err := Purge(s.kv, key)
+	s.observe("purge", metrics.Outcome(err), start)
+
+	return err
+}
+
+func (s *InstrumentedStore) observe(operation, outcome string, start time.Time) {
+	metrics.StoreDuration.WithLabelValues(s.backend, operation, outcome).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/metrics"
+)
+
+type mockKVStore struct {
+	GetCalled bool
+	SetCalled bool
+	DelCalled bool
+	NextData  []byte
+	NextError error
+}
+
+func (m *mockKVStore) Get(key string) ([]byte, error) {
+	m.GetCalled = true
+	return m.NextData, m.NextError
+}
+
+func (m *mockKVStore) Set(key string, data []byte) error {
+	m.SetCalled = true
+	return m.NextError
+}
+
+func (m *mockKVStore) Del(key string) error {
+	m.DelCalled = true
+	return m.NextError
+}
+
+func (m *mockKVStore) Init() error {
+	return nil
+}
+
+func TestInstrumentedStore_Get_observes_not_found_as_success(t *testing.T) {
+	// Arrange
+	kv := &mockKVStore{NextError: defs.ErrKVNotFound}
+	sut := NewInstrumentedStore(kv, "test-get")
+
+	// Act
+	data, err := sut.Get("key")
+
+	// Assert
+	assert.True(t, kv.GetCalled)
+	assert.Nil(t, data)
+	assert.Equal(t, defs.ErrKVNotFound, err)
+	assert.Equal(t, uint64(1), sampleCount(t, "test-get", "get", metrics.OutcomeSuccess))
+}
+
+func TestInstrumentedStore_Set_observes_failure(t *testing.T) {
+	// Arrange
+	kv := &mockKVStore{NextError: errors.New("some error")}
+	sut := NewInstrumentedStore(kv, "test-set")
+
+	// Act
+	err := sut.Set("key", []byte("data"))
+
+	// Assert
+	assert.True(t, kv.SetCalled)
+	assert.Equal(t, "some error", err.Error())
+	assert.Equal(t, uint64(1), sampleCount(t, "test-set", "set", metrics.OutcomeFailure))
+}
+
+func TestInstrumentedStore_Purge_deletes_without_versions(t *testing.T) {
+	// Arrange
+	kv := &mockKVStore{}
+	sut := NewInstrumentedStore(kv, "test-purge").(*InstrumentedStore)
+
+	// Act
+	err := sut.Purge("key")
+
+	// Assert
+	assert.Nil(t, err)
+	assert.True(t, kv.DelCalled)
+	assert.Equal(t, uint64(1), sampleCount(t, "test-purge", "purge", metrics.OutcomeSuccess))
+}
+
+func sampleCount(t *testing.T, labels ...string) uint64 {
+	m := &dto.Metric{}
+	if err := metrics.StoreDuration.WithLabelValues(labels...).(prometheus.Metric).Write(m); err != nil {
+		t.Fatalf("failed to read the metric: %v", err)
+	}
+
+	return m.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,327 @@
+package util
+
+/*
+The k8s store keeps the signing-agent data in a Secret of the agent's namespace, one Secret key per store key.
+It uses the in-cluster service account credentials, the service account needs a Role like:
+
+  rules:
+    - apiGroups: [""]
+      resources: ["secrets"]
+      verbs: ["create"]
+    - apiGroups: [""]
+      resources: ["secrets"]
+      resourceNames: ["signing-agent"]
+      verbs: ["get", "patch"]
+
+The Secret is created on start if it doesn't exist. The updates are JSON merge patches of the changed data keys,
+so the Secret metadata, as its labels, annotations and owner references, is left as is. They carry the resourceVersion
+read, so concurrent changes are never overwritten.
+*/
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+const (
+	k8sServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	// k8sConflictRetries is the number of times an update is retried when the Secret was changed concurrently
+	k8sConflictRetries = 3
+)
+
+// errK8sConflict is returned when the Secret resourceVersion changed since it was read
+var errK8sConflict = errors.New("kubernetes secret changed concurrently")
+
+// k8sKeyPattern is the format of the Secret data keys
+var k8sKeyPattern = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+
+type K8sStore struct {
+	cfg               config.K8sConfig
+	serviceAccountDir string
+	apiURL            string
+	namespace         string
+	httpClient        *http.Client
+}
+
+type k8sSecret struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Metadata   k8sObjectMeta     `json:"metadata"`
+	Type       string            `json:"type,omitempty"`
+	Data       map[string][]byte `json:"data"`
+}
+
+type k8sObjectMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace,omitempty"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+// k8sDataPatch is the JSON merge patch of the Secret data, a nil value deletes the key.
+// The resourceVersion makes the patch fail with a conflict if the Secret changed since it was read
+type k8sDataPatch struct {
+	Metadata k8sPatchMeta      `json:"metadata"`
+	Data     map[string][]byte `json:"data"`
+}
+
+type k8sPatchMeta struct {
+	ResourceVersion string `json:"resourceVersion"`
+}
+
+type k8sStatus struct {
+	Message string `json:"message"`
+	Reason  string `json:"reason"`
+}
+
+// NewK8sStore creates and returns the Kubernetes Secret KVStore.
+func NewK8sStore(cfg config.K8sConfig) KVStore {
+	s := &K8sStore{
+		cfg:               cfg,
+		serviceAccountDir: k8sServiceAccountDir,
+	}
+
+	return s
+}
+
+// Get returns the value of the named key, or error if not found.
+func (s *K8sStore) Get(key string) ([]byte, error) {
+	secret, err := s.getSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := secret.Data[key]; ok {
+		return val, nil
+	}
+
+	return nil, defs.ErrKVNotFound
+}
+
+// Set adds/updates the named key with value in data.
+func (s *K8sStore) Set(key string, data []byte) error {
+	if !k8sKeyPattern.MatchString(key) {
+		return errors.Errorf("invalid key `%s` for a kubernetes secret", key)
+	}
+
+	if data == nil {
+		// a nil value would delete the key
+		data = []byte{}
+	}
+
+	return s.update(func(secret *k8sSecret) (map[string][]byte, bool) {
+		return map[string][]byte{key: data}, true
+	})
+}
+
+// Del deletes the named key.
+func (s *K8sStore) Del(key string) error {
+	return s.update(func(secret *k8sSecret) (map[string][]byte, bool) {
+		if _, ok := secret.Data[key]; !ok {
+			return nil, false
+		}
+		return map[string][]byte{key: nil}, true
+	})
+}
+
+// Init loads the in-cluster configuration and checks the Secret can be read, the Secret is created if it doesn't exist.
+func (s *K8sStore) Init() error {
+	if len(s.cfg.SecretName) == 0 {
+		return errors.New("cannot initialise k8s store: no secret name set")
+	}
+
+	if len(s.apiURL) == 0 {
+		if err := s.loadInClusterConfig(); err != nil {
+			return errors.Wrap(err, "cannot initialise k8s store")
+		}
+	}
+
+	s.namespace = s.cfg.Namespace
+	if len(s.namespace) == 0 {
+		b, err := os.ReadFile(filepath.Join(s.serviceAccountDir, "namespace"))
+		if err != nil {
+			return errors.Wrap(err, "cannot initialise k8s store: read service account namespace")
+		}
+		s.namespace = strings.TrimSpace(string(b))
+	}
+
+	status, err := s.request(http.MethodGet, s.secretURL(), nil, nil)
+	if err == nil {
+		return nil
+	}
+	if status != http.StatusNotFound {
+		return errors.Wrap(err, "cannot initialise k8s store")
+	}
+
+	secret := s.newSecret()
+	status, err = s.request(http.MethodPost, s.secretsURL(), secret, nil)
+	if err != nil && status != http.StatusConflict {
+		return errors.Wrap(err, "cannot initialise k8s store: create secret")
+	}
+
+	return nil
+}
+
+// loadInClusterConfig sets the API server address and the TLS config from the pod environment
+func (s *K8sStore) loadInClusterConfig() error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 || len(port) == 0 {
+		return errors.New("not running in a kubernetes cluster, KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT not set")
+	}
+
+	pem, err := os.ReadFile(filepath.Join(s.serviceAccountDir, "ca.crt"))
+	if err != nil {
+		return errors.Wrap(err, "read service account CA")
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return errors.New("no valid certificates in the service account CA")
+	}
+
+	s.apiURL = "https://" + net.JoinHostPort(host, port)
+	s.httpClient = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				MinVersion: tls.VersionTLS12,
+				RootCAs:    roots,
+			},
+		},
+	}
+
+	return nil
+}
+
+// update patches the Secret with the data change to the Secret read, re-reading it on a conflict
+func (s *K8sStore) update(change func(secret *k8sSecret) (map[string][]byte, bool)) error {
+	for i := 0; i < k8sConflictRetries; i++ {
+		secret, err := s.getSecret()
+		if err != nil {
+			return err
+		}
+
+		data, changed := change(secret)
+		if !changed {
+			return nil
+		}
+
+		patch := k8sDataPatch{
+			Metadata: k8sPatchMeta{ResourceVersion: secret.Metadata.ResourceVersion},
+			Data:     data,
+		}
+		status, err := s.request(http.MethodPatch, s.secretURL(), patch, nil)
+		if err == nil {
+			return nil
+		}
+		if status != http.StatusConflict {
+			return errors.Wrap(err, "update kubernetes secret")
+		}
+	}
+
+	return errors.Wrapf(errK8sConflict, "secret not updated after %d attempts", k8sConflictRetries)
+}
+
+func (s *K8sStore) getSecret() (*k8sSecret, error) {
+	secret := &k8sSecret{}
+	if _, err := s.request(http.MethodGet, s.secretURL(), nil, secret); err != nil {
+		return nil, errors.Wrap(err, "read kubernetes secret")
+	}
+
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+
+	return secret, nil
+}
+
+func (s *K8sStore) newSecret() *k8sSecret {
+	return &k8sSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata: k8sObjectMeta{
+			Name:      s.cfg.SecretName,
+			Namespace: s.namespace,
+		},
+		Type: "Opaque",
+		Data: map[string][]byte{},
+	}
+}
+
+func (s *K8sStore) secretsURL() string {
+	return fmt.Sprintf("%s/api/v1/namespaces/%s/secrets", strings.TrimRight(s.apiURL, "/"), s.namespace)
+}
+
+func (s *K8sStore) secretURL() string {
+	return s.secretsURL() + "/" + s.cfg.SecretName
+}
+
+// request sends the request with the service account token and decodes the response, the status code is returned with the error
+func (s *K8sStore) request(method, url string, reqData, respData interface{}) (int, error) {
+	// the projected service account token is rotated, so it's read on every request
+	token, err := os.ReadFile(filepath.Join(s.serviceAccountDir, "token"))
+	if err != nil {
+		return 0, errors.Wrap(err, "read service account token")
+	}
+
+	var body io.Reader
+	if reqData != nil {
+		b, err := json.Marshal(reqData)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if method == http.MethodPatch {
+		req.Header.Set("Content-Type", "application/merge-patch+json")
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		status := k8sStatus{}
+		_ = json.Unmarshal(b, &status)
+		return resp.StatusCode, errors.Errorf("kubernetes API responded %d %s: %s", resp.StatusCode, status.Reason, status.Message)
+	}
+
+	if respData != nil {
+		if err := json.Unmarshal(b, respData); err != nil {
+			return resp.StatusCode, errors.Wrap(err, "decode kubernetes API response")
+		}
+	}
+
+	return resp.StatusCode, nil
+}
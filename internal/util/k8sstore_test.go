@@ -0,0 +1,299 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+const (
+	testK8sToken      = "some service account token"
+	testK8sSecretsURL = "/api/v1/namespaces/signing/secrets"
+	testK8sSecretURL  = testK8sSecretsURL + "/signing-agent"
+)
+
+// fakeK8sAPI serves the Secret endpoints of the Kubernetes API server
+type fakeK8sAPI struct {
+	lock            sync.Mutex
+	secret          *k8sSecret
+	resourceVersion int
+	updates         int
+	conflicts       int
+	lastPatch       map[string]map[string]json.RawMessage
+	lastPatchType   string
+}
+
+func (f *fakeK8sAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer "+testK8sToken {
+		writeK8sStatus(w, http.StatusUnauthorized, "Unauthorized", "Unauthorized")
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == testK8sSecretURL:
+		if f.secret == nil {
+			writeK8sStatus(w, http.StatusNotFound, "NotFound", `secrets "signing-agent" not found`)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.secret)
+	case r.Method == http.MethodPost && r.URL.Path == testK8sSecretsURL:
+		if f.secret != nil {
+			writeK8sStatus(w, http.StatusConflict, "AlreadyExists", `secrets "signing-agent" already exists`)
+			return
+		}
+		f.store(w, r)
+	case r.Method == http.MethodPatch && r.URL.Path == testK8sSecretURL:
+		patch := map[string]map[string]json.RawMessage{}
+		_ = json.NewDecoder(r.Body).Decode(&patch)
+		f.lastPatch = patch
+		f.lastPatchType = r.Header.Get("Content-Type")
+
+		resourceVersion := ""
+		_ = json.Unmarshal(patch["metadata"]["resourceVersion"], &resourceVersion)
+		if f.conflicts > 0 || resourceVersion != f.secret.Metadata.ResourceVersion {
+			f.conflicts--
+			writeK8sStatus(w, http.StatusConflict, "Conflict", "the object has been modified; please apply your changes to the latest version and try again")
+			return
+		}
+
+		secret := *f.secret
+		secret.Data = map[string][]byte{}
+		for key, value := range f.secret.Data {
+			secret.Data[key] = value
+		}
+		for key, value := range patch["data"] {
+			if string(value) == "null" {
+				delete(secret.Data, key)
+				continue
+			}
+			data := []byte{}
+			_ = json.Unmarshal(value, &data)
+			secret.Data[key] = data
+		}
+		f.updates++
+		f.storeSecret(w, secret)
+	default:
+		writeK8sStatus(w, http.StatusNotFound, "NotFound", "the server could not find the requested resource")
+	}
+}
+
+func (f *fakeK8sAPI) store(w http.ResponseWriter, r *http.Request) {
+	secret := k8sSecret{}
+	_ = json.NewDecoder(r.Body).Decode(&secret)
+	f.storeSecret(w, secret)
+}
+
+func (f *fakeK8sAPI) storeSecret(w http.ResponseWriter, secret k8sSecret) {
+	f.resourceVersion++
+	secret.Metadata.ResourceVersion = strconv.Itoa(f.resourceVersion)
+	f.secret = &secret
+	_ = json.NewEncoder(w).Encode(f.secret)
+}
+
+func writeK8sStatus(w http.ResponseWriter, code int, reason, message string) {
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(k8sStatus{Reason: reason, Message: message})
+}
+
+func newTestK8sStore(t *testing.T, server *httptest.Server) *K8sStore {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "token"), []byte(testK8sToken+"\n"), 0600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "namespace"), []byte("signing"), 0600))
+
+	return &K8sStore{
+		cfg:               config.K8sConfig{SecretName: "signing-agent"},
+		serviceAccountDir: dir,
+		apiURL:            server.URL,
+		httpClient:        server.Client(),
+	}
+}
+
+func TestK8sStore_Init_creates_secret(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	require.NoError(t, err)
+	require.NotNil(t, api.secret)
+	assert.Equal(t, "signing-agent", api.secret.Metadata.Name)
+	assert.Equal(t, "signing", api.secret.Metadata.Namespace)
+	assert.Equal(t, "Opaque", api.secret.Type)
+}
+
+func TestK8sStore_Init_existing_secret(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{
+		secret: &k8sSecret{
+			Metadata: k8sObjectMeta{Name: "signing-agent", ResourceVersion: "7"},
+			Data:     map[string][]byte{"some key": []byte("some value")},
+		},
+	}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, "7", api.secret.Metadata.ResourceVersion)
+	value, err := sut.Get("some key")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("some value"), value)
+}
+
+func TestK8sStore_Set_Get_Del(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, sut.Init())
+
+	//Act
+	setErr := sut.Set("AgentID_V2", []byte("some agent id"))
+	value, getErr := sut.Get("AgentID_V2")
+	delErr := sut.Del("AgentID_V2")
+	_, getDeletedErr := sut.Get("AgentID_V2")
+
+	//Assert
+	require.NoError(t, setErr)
+	require.NoError(t, getErr)
+	require.NoError(t, delErr)
+	assert.Equal(t, []byte("some agent id"), value)
+	assert.Equal(t, defs.ErrKVNotFound, getDeletedErr)
+	assert.Equal(t, 2, api.updates)
+}
+
+func TestK8sStore_Set_invalid_key(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, sut.Init())
+
+	//Act
+	err := sut.Set("some/key", []byte("some value"))
+
+	//Assert
+	assert.ErrorContains(t, err, "invalid key `some/key` for a kubernetes secret")
+	assert.Equal(t, 0, api.updates)
+}
+
+func TestK8sStore_Set_retries_on_conflict(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, sut.Init())
+	api.conflicts = 1
+
+	//Act
+	err := sut.Set("some-key", []byte("some value"))
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, []byte("some value"), api.secret.Data["some-key"])
+}
+
+func TestK8sStore_Set_gives_up_on_conflict(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, sut.Init())
+	api.conflicts = k8sConflictRetries
+
+	//Act
+	err := sut.Set("some-key", []byte("some value"))
+
+	//Assert
+	assert.ErrorIs(t, err, errK8sConflict)
+	assert.NotContains(t, api.secret.Data, "some-key")
+}
+
+func TestK8sStore_Init_fails(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, os.WriteFile(filepath.Join(sut.serviceAccountDir, "token"), []byte("other token"), 0600))
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	assert.ErrorContains(t, err, "kubernetes API responded 401 Unauthorized")
+}
+
+func TestK8sStore_Init_outside_cluster(t *testing.T) {
+	//Arrange
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	sut := NewK8sStore(config.K8sConfig{SecretName: "signing-agent"})
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	assert.ErrorContains(t, err, "not running in a kubernetes cluster")
+}
+
+func TestK8sStore_Set_patches_only_the_data(t *testing.T) {
+	//Arrange
+	api := &fakeK8sAPI{
+		secret: &k8sSecret{
+			Metadata: k8sObjectMeta{Name: "signing-agent", ResourceVersion: "7"},
+			Data:     map[string][]byte{"some key": []byte("some value")},
+		},
+	}
+	server := httptest.NewTLSServer(api)
+	defer server.Close()
+
+	sut := newTestK8sStore(t, server)
+	require.NoError(t, sut.Init())
+
+	//Act
+	err := sut.Set("other-key", []byte("other value"))
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, "application/merge-patch+json", api.lastPatchType)
+	assert.Equal(t, map[string]json.RawMessage{"resourceVersion": json.RawMessage(`"7"`)}, api.lastPatch["metadata"])
+	assert.Len(t, api.lastPatch["data"], 1)
+	assert.Equal(t, map[string][]byte{
+		"some key":  []byte("some value"),
+		"other-key": []byte("other value"),
+	}, api.secret.Data)
+}
@@ -8,3 +8,18 @@ type KVStore interface {
 	Del(key string) error
 	Init() error
 }
+
+// KVPurger is implemented by the stores keeping the previous versions of their data, a deleted value stays in them until purged
+type KVPurger interface {
+	// Purge deletes the named key and destroys the previous versions of the data still holding it
+	Purge(key string) error
+}
+
+// Purge deletes the named key, the previous versions of the data are destroyed with the stores keeping them
+func Purge(kv KVStore, key string) error {
+	if purger, ok := kv.(KVPurger); ok {
+		return purger.Purge(key)
+	}
+
+	return kv.Del(key)
+}
@@ -2,11 +2,18 @@ package util
 
 import (
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 
 	"github.com/qredo/signing-agent/internal/config"
 )
 
 func NewLogger(cfg *config.Logging) *zap.SugaredLogger {
+	log, _ := NewLeveledLogger(cfg)
+	return log
+}
+
+// NewLeveledLogger returns the logger and its level, which can be changed while the logger is in use
+func NewLeveledLogger(cfg *config.Logging) (*zap.SugaredLogger, zap.AtomicLevel) {
 	logConfig := zap.NewProductionConfig()
 
 	switch cfg.Format {
@@ -18,21 +25,25 @@ func NewLogger(cfg *config.Logging) *zap.SugaredLogger {
 		logConfig = zap.NewProductionConfig()
 	}
 
-	switch cfg.Level {
+	logConfig.Level = zap.NewAtomicLevelAt(LogLevel(cfg.Level))
+	logConfig.DisableStacktrace = true
+	l, _ := logConfig.Build()
+
+	return l.Sugar(), logConfig.Level
+}
+
+// LogLevel returns the zap level for the configured level, debug if unknown
+func LogLevel(level string) zapcore.Level {
+	switch level {
 	case "info":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.InfoLevel)
+		return zap.InfoLevel
 	case "warn":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+		return zap.WarnLevel
 	case "error":
-		logConfig.Level = zap.NewAtomicLevelAt(zap.ErrorLevel)
+		return zap.ErrorLevel
 	default:
-		logConfig.Level = zap.NewAtomicLevelAt(zap.DebugLevel)
+		return zap.DebugLevel
 	}
-
-	logConfig.DisableStacktrace = true
-	l, _ := logConfig.Build()
-
-	return l.Sugar()
 }
 
 func NewTestLogger() *zap.SugaredLogger {
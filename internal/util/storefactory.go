@@ -7,6 +7,9 @@ import (
 func CreateStore(cfg config.Config) KVStore {
 	switch cfg.Store.Type {
 	case "file":
+		if cfg.Store.FileEncryption.Enabled {
+			return NewEncryptedFileStore(cfg.Store.FileConfig, cfg.Store.FileEncryption)
+		}
 		return NewFileStore(cfg.Store.FileConfig)
 	case "oci":
 		return NewOciStore(cfg.Store.OciConfig)
@@ -14,6 +17,10 @@ func CreateStore(cfg config.Config) KVStore {
 		return NewAWSStore(cfg.Store.AwsConfig)
 	case "gcp":
 		return NewGCPStore(cfg.Store.GcpConfig)
+	case "vault":
+		return NewVaultStore(cfg.Store.VaultConfig)
+	case "k8s":
+		return NewK8sStore(cfg.Store.K8sConfig)
 	default:
 		return nil
 	}
@@ -0,0 +1,376 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+const (
+	VaultAuthToken   = "token"
+	VaultAuthAppRole = "approle"
+
+	vaultTokenHeader     = "X-Vault-Token"
+	vaultNamespaceHeader = "X-Vault-Namespace"
+	// vaultCASRetries is the number of times a write is retried when the secret was changed concurrently
+	vaultCASRetries = 3
+	// vaultTokenRenewMargin is the time before the expiry of an AppRole token when a new one is requested
+	vaultTokenRenewMargin = 30 * time.Second
+)
+
+// errVaultCASMismatch is returned when the secret version changed since it was read
+var errVaultCASMismatch = errors.New("vault secret changed concurrently")
+
+// VaultStore keeps the store in a single secret of a Vault KV v2 secrets engine.
+// The writes are check-and-set on the version read, so concurrent changes are never overwritten.
+// A purge destroys the previous versions of the secret, which still hold the deleted value,
+// so the Vault policy needs the update capability on the destroy path of the secret as well
+type VaultStore struct {
+	lock        sync.Mutex
+	cfg         config.VaultConfig
+	httpClient  *http.Client
+	token       string
+	tokenExpiry time.Time
+}
+
+type vaultSecretResponse struct {
+	Data struct {
+		Data     map[string][]byte `json:"data"`
+		Metadata struct {
+			Version int `json:"version"`
+		} `json:"metadata"`
+	} `json:"data"`
+}
+
+type vaultWriteResponse struct {
+	Data struct {
+		Version int `json:"version"`
+	} `json:"data"`
+}
+
+type vaultWriteRequest struct {
+	Options struct {
+		CAS int `json:"cas"`
+	} `json:"options"`
+	Data map[string][]byte `json:"data"`
+}
+
+type vaultDestroyRequest struct {
+	Versions []int `json:"versions"`
+}
+
+type vaultLoginResponse struct {
+	Auth struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+	} `json:"auth"`
+}
+
+type vaultErrorResponse struct {
+	Errors []string `json:"errors"`
+}
+
+// NewVaultStore creates and returns the Vault KVStore.
+func NewVaultStore(cfg config.VaultConfig) KVStore {
+	s := &VaultStore{
+		cfg: cfg,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+
+	return s
+}
+
+// Get returns the value of the named key, or error if not found.
+func (s *VaultStore) Get(key string) ([]byte, error) {
+	data, _, err := s.readSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	if val, ok := data[key]; ok {
+		return val, nil
+	}
+
+	return nil, defs.ErrKVNotFound
+}
+
+// Set adds/updates the named key with value in data.
+func (s *VaultStore) Set(key string, data []byte) error {
+	_, err := s.update(func(secret map[string][]byte) bool {
+		secret[key] = data
+		return true
+	})
+
+	return err
+}
+
+// Del deletes the named key, the previous versions of the secret still hold it.
+func (s *VaultStore) Del(key string) error {
+	_, err := s.delete(key)
+	return err
+}
+
+// Purge deletes the named key and destroys the previous versions of the secret.
+func (s *VaultStore) Purge(key string) error {
+	version, err := s.delete(key)
+	if err != nil {
+		return err
+	}
+
+	if version == 0 {
+		// the key is already deleted, the previous versions may still hold it
+		if _, version, err = s.readSecret(); err != nil {
+			return err
+		}
+	}
+
+	return s.destroyPreviousVersions(version)
+}
+
+// delete removes the key from the secret, it returns the version written, 0 if the key is not set
+func (s *VaultStore) delete(key string) (int, error) {
+	return s.update(func(secret map[string][]byte) bool {
+		if _, ok := secret[key]; !ok {
+			return false
+		}
+		delete(secret, key)
+		return true
+	})
+}
+
+// Init authenticates with Vault and checks the secret can be read, the secret is created if it doesn't exist.
+func (s *VaultStore) Init() error {
+	if len(s.cfg.Address) == 0 || len(s.cfg.MountPath) == 0 || len(s.cfg.SecretPath) == 0 {
+		return errors.New("cannot initialise Vault store: the address, the mount path and the secret path must be set")
+	}
+
+	switch s.cfg.AuthMethod {
+	case VaultAuthToken:
+		if len(s.cfg.Token) == 0 {
+			return errors.New("cannot initialise Vault store: no token set")
+		}
+	case VaultAuthAppRole:
+		if len(s.cfg.RoleID) == 0 || len(s.cfg.SecretID) == 0 {
+			return errors.New("cannot initialise Vault store: the role ID and the secret ID must be set")
+		}
+	default:
+		return errors.Errorf("cannot initialise Vault store: unsupported auth method `%s`", s.cfg.AuthMethod)
+	}
+
+	_, version, err := s.readSecret()
+	if err != nil {
+		return errors.Wrap(err, "cannot initialise Vault store")
+	}
+
+	if version == 0 {
+		if _, err := s.writeSecret(map[string][]byte{}, version); err != nil && err != errVaultCASMismatch {
+			return errors.Wrap(err, "cannot initialise Vault store")
+		}
+	}
+
+	return nil
+}
+
+// update applies the change to the secret and writes it with check-and-set, re-reading the secret on a conflict.
+// It returns the version written, 0 if the secret is unchanged
+func (s *VaultStore) update(change func(secret map[string][]byte) bool) (int, error) {
+	for i := 0; i < vaultCASRetries; i++ {
+		secret, version, err := s.readSecret()
+		if err != nil {
+			return 0, err
+		}
+
+		if !change(secret) {
+			return 0, nil
+		}
+
+		written, err := s.writeSecret(secret, version)
+		if err == nil {
+			return written, nil
+		}
+		if err != errVaultCASMismatch {
+			return 0, err
+		}
+	}
+
+	return 0, errors.Wrapf(errVaultCASMismatch, "secret not updated after %d attempts", vaultCASRetries)
+}
+
+// destroyPreviousVersions permanently removes the versions of the secret before the given one
+func (s *VaultStore) destroyPreviousVersions(version int) error {
+	if version <= 1 {
+		return nil
+	}
+
+	req := vaultDestroyRequest{
+		Versions: make([]int, 0, version-1),
+	}
+	for v := 1; v < version; v++ {
+		req.Versions = append(req.Versions, v)
+	}
+
+	if _, err := s.request(http.MethodPost, s.destroyURL(), req, nil); err != nil {
+		return errors.Wrap(err, "destroy the previous Vault secret versions")
+	}
+
+	return nil
+}
+
+// readSecret returns the data and the version of the secret, the version is 0 if the secret was never written
+func (s *VaultStore) readSecret() (map[string][]byte, int, error) {
+	resp := vaultSecretResponse{}
+	status, err := s.request(http.MethodGet, s.secretURL(), nil, &resp)
+	if err != nil {
+		if status == http.StatusNotFound {
+			return map[string][]byte{}, resp.Data.Metadata.Version, nil
+		}
+		return nil, 0, errors.Wrap(err, "read Vault secret")
+	}
+
+	data := resp.Data.Data
+	if data == nil {
+		data = map[string][]byte{}
+	}
+
+	return data, resp.Data.Metadata.Version, nil
+}
+
+// writeSecret writes the secret if its current version is the given one, it returns the version written
+func (s *VaultStore) writeSecret(data map[string][]byte, version int) (int, error) {
+	req := vaultWriteRequest{
+		Data: data,
+	}
+	req.Options.CAS = version
+
+	resp := vaultWriteResponse{}
+	status, err := s.request(http.MethodPost, s.secretURL(), req, &resp)
+	if err != nil {
+		if status == http.StatusBadRequest && strings.Contains(err.Error(), "check-and-set") {
+			return 0, errVaultCASMismatch
+		}
+		return 0, errors.Wrap(err, "write Vault secret")
+	}
+
+	return resp.Data.Version, nil
+}
+
+func (s *VaultStore) secretURL() string {
+	return s.engineURL("data")
+}
+
+func (s *VaultStore) destroyURL() string {
+	return s.engineURL("destroy")
+}
+
+// engineURL returns the URL of the secret on the endpoint of the KV v2 secrets engine
+func (s *VaultStore) engineURL(endpoint string) string {
+	return fmt.Sprintf("%s/v1/%s/%s/%s", strings.TrimRight(s.cfg.Address, "/"), strings.Trim(s.cfg.MountPath, "/"), endpoint, strings.Trim(s.cfg.SecretPath, "/"))
+}
+
+// request sends the authenticated request and decodes the response, the status code is returned with the error
+func (s *VaultStore) request(method, url string, reqData, respData interface{}) (int, error) {
+	token, err := s.getToken()
+	if err != nil {
+		return 0, err
+	}
+
+	return s.send(method, url, token, reqData, respData)
+}
+
+func (s *VaultStore) send(method, url, token string, reqData, respData interface{}) (int, error) {
+	var body io.Reader
+	if reqData != nil {
+		b, err := json.Marshal(reqData)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set(vaultTokenHeader, token)
+	}
+	if len(s.cfg.Namespace) > 0 {
+		req.Header.Set(vaultNamespaceHeader, s.cfg.Namespace)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		vaultErr := vaultErrorResponse{}
+		_ = json.Unmarshal(b, &vaultErr)
+		if respData != nil {
+			// a deleted secret is not found, but its metadata is still returned
+			_ = json.Unmarshal(b, respData)
+		}
+		return resp.StatusCode, errors.Errorf("vault responded %d: %s", resp.StatusCode, strings.Join(vaultErr.Errors, ", "))
+	}
+
+	if respData != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, respData); err != nil {
+			return resp.StatusCode, errors.Wrap(err, "decode Vault response")
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// getToken returns the configured token, or the AppRole token, logging in again when it's about to expire
+func (s *VaultStore) getToken() (string, error) {
+	if s.cfg.AuthMethod != VaultAuthAppRole {
+		return s.cfg.Token, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.token) > 0 && (s.tokenExpiry.IsZero() || time.Now().Add(vaultTokenRenewMargin).Before(s.tokenExpiry)) {
+		return s.token, nil
+	}
+
+	login := map[string]string{
+		"role_id":   s.cfg.RoleID,
+		"secret_id": s.cfg.SecretID,
+	}
+	url := fmt.Sprintf("%s/v1/auth/%s/login", strings.TrimRight(s.cfg.Address, "/"), strings.Trim(s.cfg.AppRolePath, "/"))
+
+	resp := vaultLoginResponse{}
+	if _, err := s.send(http.MethodPost, url, "", login, &resp); err != nil {
+		return "", errors.Wrap(err, "Vault AppRole login")
+	}
+
+	s.token = resp.Auth.ClientToken
+	s.tokenExpiry = time.Time{}
+	if resp.Auth.LeaseDuration > 0 {
+		s.tokenExpiry = time.Now().Add(time.Duration(resp.Auth.LeaseDuration) * time.Second)
+	}
+
+	return s.token, nil
+}
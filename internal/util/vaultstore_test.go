@@ -0,0 +1,356 @@
+package util
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+)
+
+const (
+	testVaultToken      = "some token"
+	testVaultNamespace  = "some/namespace"
+	testVaultSecretURL  = "/v1/kv/data/signing-agent"
+	testVaultDestroyURL = "/v1/kv/destroy/signing-agent"
+)
+
+// fakeVault serves the KV v2 secret and the AppRole login of the Vault HTTP API
+type fakeVault struct {
+	lock       sync.Mutex
+	data       map[string][]byte
+	version    int
+	logins     int
+	writes     int
+	conflicts  int
+	destroyed  []int
+	lastHeader http.Header
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.lastHeader = r.Header
+	if r.Header.Get(vaultNamespaceHeader) != testVaultNamespace {
+		writeVaultError(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+
+	if r.URL.Path == "/v1/auth/approle/login" {
+		login := map[string]string{}
+		_ = json.NewDecoder(r.Body).Decode(&login)
+		if login["role_id"] != "some role" || login["secret_id"] != "some secret" {
+			writeVaultError(w, http.StatusBadRequest, "invalid role or secret ID")
+			return
+		}
+		f.logins++
+		_, _ = w.Write([]byte(`{"auth":{"client_token":"` + testVaultToken + `","lease_duration":3600}}`))
+		return
+	}
+
+	if r.Header.Get(vaultTokenHeader) != testVaultToken {
+		writeVaultError(w, http.StatusForbidden, "permission denied")
+		return
+	}
+
+	if r.URL.Path == testVaultDestroyURL && r.Method == http.MethodPost {
+		req := vaultDestroyRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		f.destroyed = append(f.destroyed, req.Versions...)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if r.URL.Path != testVaultSecretURL {
+		writeVaultError(w, http.StatusNotFound, "no handler for route")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if f.version == 0 {
+			writeVaultError(w, http.StatusNotFound)
+			return
+		}
+		resp := vaultSecretResponse{}
+		resp.Data.Data = f.data
+		resp.Data.Metadata.Version = f.version
+		_ = json.NewEncoder(w).Encode(resp)
+	case http.MethodPost:
+		req := vaultWriteRequest{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		if f.conflicts > 0 || req.Options.CAS != f.version {
+			f.conflicts--
+			writeVaultError(w, http.StatusBadRequest, "check-and-set parameter did not match the current version")
+			return
+		}
+		f.data = req.Data
+		f.version++
+		f.writes++
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]int{"version": f.version}})
+	}
+}
+
+func writeVaultError(w http.ResponseWriter, status int, errs ...string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(vaultErrorResponse{Errors: errs})
+}
+
+func newTestVaultConfig(address string) config.VaultConfig {
+	return config.VaultConfig{
+		Address:     address,
+		Namespace:   testVaultNamespace,
+		MountPath:   "kv",
+		SecretPath:  "signing-agent",
+		AuthMethod:  VaultAuthToken,
+		Token:       testVaultToken,
+		AppRolePath: "approle",
+	}
+}
+
+func TestVaultStore_Init_creates_secret(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+
+	//Act
+	err := sut.Init()
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, vault.version)
+	assert.Empty(t, vault.data)
+}
+
+func TestVaultStore_Purge(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL)).(*VaultStore)
+	require.NoError(t, sut.Init())
+	require.NoError(t, sut.Set("some key", []byte("some value")))
+	require.NoError(t, sut.Set("other key", []byte("other value")))
+
+	//Act
+	err := sut.Purge("some key")
+
+	//Assert
+	require.NoError(t, err)
+	_, getErr := sut.Get("some key")
+	assert.Equal(t, defs.ErrKVNotFound, getErr)
+	assert.Equal(t, 4, vault.version)
+	assert.Equal(t, []int{1, 2, 3}, vault.destroyed)
+}
+
+func TestVaultStore_Purge_missing_key_destroys_previous_versions(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL)).(*VaultStore)
+	require.NoError(t, sut.Init())
+	require.NoError(t, sut.Set("some key", []byte("some value")))
+	require.NoError(t, sut.Del("some key"))
+
+	//Act
+	err := sut.Purge("some key")
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, 3, vault.writes)
+	assert.Equal(t, []int{1, 2}, vault.destroyed)
+}
+
+func TestVaultStore_Set_Get_Del(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+	require.NoError(t, sut.Init())
+
+	//Act
+	setErr := sut.Set("some key", []byte("some value"))
+	value, getErr := sut.Get("some key")
+	delErr := sut.Del("some key")
+	_, getDeletedErr := sut.Get("some key")
+
+	//Assert
+	require.NoError(t, setErr)
+	require.NoError(t, getErr)
+	require.NoError(t, delErr)
+	assert.Equal(t, []byte("some value"), value)
+	assert.Equal(t, defs.ErrKVNotFound, getDeletedErr)
+	assert.Equal(t, 3, vault.version)
+	assert.Empty(t, vault.destroyed)
+}
+
+func TestVaultStore_Del_missing_key_doesnt_write(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+	require.NoError(t, sut.Init())
+
+	//Act
+	err := sut.Del("some key")
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, 1, vault.writes)
+	assert.Empty(t, vault.destroyed)
+}
+
+func TestVaultStore_Set_keeps_concurrent_changes(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+	other := NewVaultStore(newTestVaultConfig(server.URL))
+	require.NoError(t, sut.Init())
+
+	//Act
+	var wg sync.WaitGroup
+	for i, store := range []KVStore{sut, other} {
+		wg.Add(1)
+		go func(key string, store KVStore) {
+			defer wg.Done()
+			assert.NoError(t, store.Set(key, []byte("some value")))
+		}(string(rune('a'+i)), store)
+	}
+	wg.Wait()
+
+	//Assert
+	_, errA := sut.Get("a")
+	_, errB := sut.Get("b")
+	assert.NoError(t, errA)
+	assert.NoError(t, errB)
+}
+
+func TestVaultStore_Set_retries_on_cas_mismatch(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+	require.NoError(t, sut.Init())
+	vault.conflicts = 1
+
+	//Act
+	err := sut.Set("some key", []byte("some value"))
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, []byte("some value"), vault.data["some key"])
+}
+
+func TestVaultStore_Set_gives_up_on_cas_mismatch(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	sut := NewVaultStore(newTestVaultConfig(server.URL))
+	require.NoError(t, sut.Init())
+	vault.conflicts = vaultCASRetries
+
+	//Act
+	err := sut.Set("some key", []byte("some value"))
+
+	//Assert
+	assert.ErrorIs(t, err, errVaultCASMismatch)
+	assert.NotContains(t, vault.data, "some key")
+}
+
+func TestVaultStore_AppRole_login(t *testing.T) {
+	//Arrange
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	cfg := newTestVaultConfig(server.URL)
+	cfg.AuthMethod = VaultAuthAppRole
+	cfg.Token = ""
+	cfg.RoleID = "some role"
+	cfg.SecretID = "some secret"
+	sut := NewVaultStore(cfg)
+
+	//Act
+	err := sut.Init()
+	_, getErr := sut.Get("some key")
+
+	//Assert
+	require.NoError(t, err)
+	assert.Equal(t, defs.ErrKVNotFound, getErr)
+	assert.Equal(t, 1, vault.logins)
+	assert.Equal(t, testVaultToken, vault.lastHeader.Get(vaultTokenHeader))
+}
+
+func TestVaultStore_Init_fails(t *testing.T) {
+	vault := &fakeVault{}
+	server := httptest.NewServer(vault)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name   string
+		cfg    func(cfg *config.VaultConfig)
+		errMsg string
+	}{
+		{
+			name:   "no address",
+			cfg:    func(cfg *config.VaultConfig) { cfg.Address = "" },
+			errMsg: "the address, the mount path and the secret path must be set",
+		},
+		{
+			name:   "unsupported auth method",
+			cfg:    func(cfg *config.VaultConfig) { cfg.AuthMethod = "ldap" },
+			errMsg: "unsupported auth method `ldap`",
+		},
+		{
+			name:   "wrong token",
+			cfg:    func(cfg *config.VaultConfig) { cfg.Token = "other token" },
+			errMsg: "vault responded 403: permission denied",
+		},
+		{
+			name: "wrong secret ID",
+			cfg: func(cfg *config.VaultConfig) {
+				cfg.AuthMethod = VaultAuthAppRole
+				cfg.RoleID = "some role"
+				cfg.SecretID = "other secret"
+			},
+			errMsg: "Vault AppRole login: vault responded 400: invalid role or secret ID",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			//Arrange
+			cfg := newTestVaultConfig(server.URL)
+			tc.cfg(&cfg)
+			sut := NewVaultStore(cfg)
+
+			//Act
+			err := sut.Init()
+
+			//Assert
+			assert.ErrorContains(t, err, tc.errMsg)
+		})
+	}
+}
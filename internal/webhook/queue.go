@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// queueState is the content of the queue file
+type queueState struct {
+	Pending     []Delivery `json:"pending"`
+	DeadLetters []Delivery `json:"deadLetters"`
+}
+
+// queueFile keeps the pending deliveries and the dead letters, so the retries survive restarts.
+// The file is rewritten on every change, through a temporary file renamed over it
+type queueFile struct {
+	fileName string
+}
+
+// load returns the saved state, an empty one if the file doesn't exist yet
+func (q queueFile) load() (queueState, error) {
+	state := queueState{}
+
+	data, err := os.ReadFile(q.fileName)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, errors.Wrap(err, "read webhook queue file")
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		return state, errors.Wrap(err, "parse webhook queue file")
+	}
+
+	return state, nil
+}
+
+func (q queueFile) save(state queueState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "marshal webhook queue")
+	}
+
+	tmpName := q.fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "write webhook queue file")
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return errors.Wrap(err, "write webhook queue file")
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return errors.Wrap(err, "write webhook queue file")
+	}
+
+	if err := file.Close(); err != nil {
+		return errors.Wrap(err, "write webhook queue file")
+	}
+
+	return errors.Wrap(os.Rename(tmpName, q.fileName), "write webhook queue file")
+}
@@ -0,0 +1,569 @@
+// Package webhook delivers the actions received on the feed to the configured HTTP endpoints.
+// The dispatcher is an internal feed client of the hub. Each action matching the event filter of an endpoint
+// is posted to it, the body signed with the endpoint secret. The failed deliveries are retried with exponential
+// backoff and kept in the queue file, so they survive restarts. The deliveries still failing after the last
+// attempt are moved to the dead letters, the oldest evicted past the configured cap. The dead letters can be
+// requeued for delivery or deleted
+package webhook
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/hub"
+	"github.com/qredo/signing-agent/internal/metrics"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+// The headers of the webhook requests. The signature is the base64 url encoded defs.HmacSum of the timestamp,
+// the POST method, the endpoint URL and the body, with the endpoint secret
+const (
+	idHeader        = "sa-webhook-id"
+	timestampHeader = "sa-webhook-timestamp"
+	signatureHeader = "sa-webhook-signature"
+)
+
+// maxResponseSize is the size of the endpoint response read before the connection is released
+const maxResponseSize = 4096
+
+// idleWait is how long the dispatcher waits when no delivery is pending, it's woken up by the new deliveries anyway
+const idleWait = time.Hour
+
+var (
+	// ErrDeadLetterNotFound is returned when no dead letter has the given ID
+	ErrDeadLetterNotFound = errors.New("webhook dead letter not found")
+	// ErrEndpointNotConfigured is returned when requeuing a dead letter of an endpoint no longer configured
+	ErrEndpointNotConfigured = errors.New("webhook endpoint no longer configured")
+)
+
+// Delivery is an action to post to an endpoint. The ID is the same for the same body to the same endpoint,
+// so the receivers can drop the duplicates
+type Delivery struct {
+	ID            string          `json:"id"`
+	Endpoint      string          `json:"endpoint"`
+	ActionID      string          `json:"actionID"`
+	Body          json.RawMessage `json:"body"`
+	Attempts      int             `json:"attempts"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	NextAttemptAt time.Time       `json:"nextAttemptAt"`
+	LastError     string          `json:"lastError,omitempty"`
+	FailedAt      time.Time       `json:"failedAt,omitempty"`
+}
+
+// Dispatcher posts the actions received on its feed to the webhook endpoints
+type Dispatcher interface {
+	// Listen receives the actions on the Feed channel and delivers them, until the channel is closed
+	Listen(wg *sync.WaitGroup)
+	GetFeedClient() *hub.HubFeedClient
+	Stop()
+	// DeadLetters returns the deliveries given up after the last attempt, oldest first
+	DeadLetters() []Delivery
+	// RequeueDeadLetter moves the dead letter back to the pending deliveries, with its attempts reset
+	RequeueDeadLetter(id string) error
+	// DeleteDeadLetter removes the dead letter
+	DeleteDeadLetter(id string) error
+}
+
+type endpoint struct {
+	config.WebhookEndpoint
+	secret []byte
+}
+
+type dispatcher struct {
+	hub.HubFeedClient
+	feedLock sync.Mutex
+	listened bool
+	log      *zap.SugaredLogger
+
+	endpoints        []endpoint
+	httpClient       util.HTTPClient
+	retryInterval    time.Duration
+	retryIntervalMax time.Duration
+	maxAttempts      int
+	maxDeadLetters   int
+	maxConcurrent    int
+
+	lock     sync.Mutex
+	queue    queueFile
+	state    queueState
+	inflight map[string]bool
+	active   map[string]int
+	wake     chan struct{}
+}
+
+// NewDispatcher returns a Dispatcher for the configured endpoints, with the deliveries pending in the queue file.
+// The pending deliveries to the endpoints no longer configured are moved to the dead letters.
+// The Dispatcher has an internal FeedClient, the pending deliveries are retried only while it listens
+func NewDispatcher(cfg config.Webhooks, log *zap.SugaredLogger) (Dispatcher, error) {
+	if cfg.TimeoutSec <= 0 || cfg.RetryInterval <= 0 || cfg.RetryIntervalMax < cfg.RetryInterval || cfg.MaxAttempts <= 0 {
+		return nil, errors.New("webhooks timeoutSec, retry intervals and maxAttempts must be positive, with retryIntervalMaxSec not lower than retryIntervalSec")
+	}
+
+	if cfg.MaxDeadLetters <= 0 || cfg.MaxConcurrent <= 0 {
+		return nil, errors.New("webhooks maxDeadLetters and maxConcurrent must be positive")
+	}
+
+	d := &dispatcher{
+		HubFeedClient:    hub.NewHubFeedClient(true),
+		log:              log,
+		httpClient:       &http.Client{Timeout: time.Duration(cfg.TimeoutSec) * time.Second},
+		retryInterval:    time.Duration(cfg.RetryInterval) * time.Second,
+		retryIntervalMax: time.Duration(cfg.RetryIntervalMax) * time.Second,
+		maxAttempts:      cfg.MaxAttempts,
+		maxDeadLetters:   cfg.MaxDeadLetters,
+		maxConcurrent:    cfg.MaxConcurrent,
+		queue:            queueFile{fileName: cfg.QueueFile},
+		inflight:         make(map[string]bool),
+		active:           make(map[string]int),
+		wake:             make(chan struct{}, 1),
+	}
+
+	names := make(map[string]bool, len(cfg.Endpoints))
+	for _, e := range cfg.Endpoints {
+		if e.Name == defs.EmptyString || names[e.Name] {
+			return nil, fmt.Errorf("webhook endpoint names must be set and unique, got `%s`", e.Name)
+		}
+		names[e.Name] = true
+
+		if u, err := url.Parse(e.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == defs.EmptyString {
+			return nil, fmt.Errorf("invalid url for webhook endpoint `%s`", e.Name)
+		}
+
+		secret, err := base64.RawURLEncoding.DecodeString(e.Secret)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("invalid secret for webhook endpoint `%s`", e.Name)
+		}
+
+		d.endpoints = append(d.endpoints, endpoint{WebhookEndpoint: e, secret: secret})
+	}
+
+	if len(d.endpoints) == 0 {
+		return nil, errors.New("webhooks enabled but no endpoints configured")
+	}
+
+	state, err := d.queue.load()
+	if err != nil {
+		return nil, err
+	}
+
+	d.state = queueState{DeadLetters: state.DeadLetters}
+	for _, delivery := range state.Pending {
+		if names[delivery.Endpoint] {
+			d.state.Pending = append(d.state.Pending, delivery)
+		} else {
+			d.deadLetter(delivery, "endpoint no longer configured")
+		}
+	}
+	d.evictDeadLetters()
+
+	if len(d.state.Pending) != len(state.Pending) || len(d.state.DeadLetters) != len(state.DeadLetters) {
+		if err := d.queue.save(d.state); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+// Listen is constantly listening for messages on the Feed channel and retries the pending deliveries meanwhile.
+// The Feed channel is always closed by the sender, the Dispatcher stops once the attempts in progress are done.
+// Listening again after a stop is done on a new Feed channel, that must be registered to the hub again
+func (d *dispatcher) Listen(wg *sync.WaitGroup) {
+	feed := d.renewFeed()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go d.run(stop, stopped)
+
+	d.log.Debug("Webhooks: listening")
+	wg.Done()
+
+	for message := range feed {
+		d.enqueue(message)
+	}
+
+	close(stop)
+	<-stopped
+	d.log.Info("Webhooks: stopped")
+}
+
+// renewFeed returns the channel to listen on, a new one if the Dispatcher listened before,
+// as the previous channel was closed when it stopped
+func (d *dispatcher) renewFeed() chan []byte {
+	d.feedLock.Lock()
+	defer d.feedLock.Unlock()
+
+	if d.listened {
+		d.HubFeedClient = hub.NewHubFeedClient(true)
+	}
+	d.listened = true
+
+	return d.Feed
+}
+
+func (d *dispatcher) Stop() {
+	d.log.Debug("Webhooks: stopping")
+	close(d.Feed)
+}
+
+func (d *dispatcher) GetFeedClient() *hub.HubFeedClient {
+	return &d.HubFeedClient
+}
+
+func (d *dispatcher) DeadLetters() []Delivery {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	deadLetters := make([]Delivery, len(d.state.DeadLetters))
+	copy(deadLetters, d.state.DeadLetters)
+	return deadLetters
+}
+
+func (d *dispatcher) RequeueDeadLetter(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	i := d.deadLetterIndex(id)
+	if i < 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	delivery := d.state.DeadLetters[i]
+	if d.endpoint(delivery.Endpoint) == nil {
+		return ErrEndpointNotConfigured
+	}
+
+	d.state.DeadLetters = append(d.state.DeadLetters[:i], d.state.DeadLetters[i+1:]...)
+	if d.pendingIndex(id) < 0 {
+		delivery.Attempts = 0
+		delivery.LastError = defs.EmptyString
+		delivery.FailedAt = time.Time{}
+		delivery.NextAttemptAt = time.Now().UTC()
+		d.state.Pending = append(d.state.Pending, delivery)
+	}
+
+	d.log.Infof("Webhooks: dead letter of action `%s` to `%s` requeued", delivery.ActionID, delivery.Endpoint)
+	d.save()
+	d.wakeUp()
+	return nil
+}
+
+func (d *dispatcher) DeleteDeadLetter(id string) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	i := d.deadLetterIndex(id)
+	if i < 0 {
+		return ErrDeadLetterNotFound
+	}
+
+	delivery := d.state.DeadLetters[i]
+	d.state.DeadLetters = append(d.state.DeadLetters[:i], d.state.DeadLetters[i+1:]...)
+
+	d.log.Infof("Webhooks: dead letter of action `%s` to `%s` deleted", delivery.ActionID, delivery.Endpoint)
+	d.save()
+	return nil
+}
+
+// enqueue adds the deliveries of the action to the endpoints it matches the filter of.
+// A delivery already pending isn't added again, e.g. when the hub replays the cached actions
+func (d *dispatcher) enqueue(message []byte) {
+	action := defs.ActionInfo{}
+	if err := json.Unmarshal(message, &action); err != nil {
+		d.log.Errorf("Webhooks: fail to unmarshal the message `%v`, err: %v", string(message), err)
+		return
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now().UTC()
+	added := false
+	for _, e := range d.endpoints {
+		if !e.matches(action) {
+			continue
+		}
+
+		id := deliveryID(e.Name, message)
+		if d.pendingIndex(id) >= 0 {
+			continue
+		}
+
+		d.state.Pending = append(d.state.Pending, Delivery{
+			ID:            id,
+			Endpoint:      e.Name,
+			ActionID:      action.ID,
+			Body:          append(json.RawMessage{}, message...),
+			CreatedAt:     now,
+			NextAttemptAt: now,
+		})
+		added = true
+	}
+
+	if added {
+		d.save()
+		d.wakeUp()
+	}
+}
+
+// run sends the deliveries as they are due, until stop is closed. It waits for the attempts in progress before returning
+func (d *dispatcher) run(stop <-chan struct{}, stopped chan<- struct{}) {
+	var attempts sync.WaitGroup
+	defer func() {
+		attempts.Wait()
+		close(stopped)
+	}()
+
+	for {
+		timer := time.NewTimer(d.sendDue(&attempts))
+
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+
+		timer.Stop()
+	}
+}
+
+// sendDue starts the attempts of the deliveries due, up to maxConcurrent in flight per endpoint,
+// and returns how long until the next one is due. The deliveries held back are sent once an attempt is done
+func (d *dispatcher) sendDue(attempts *sync.WaitGroup) time.Duration {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	wait := idleWait
+	for _, delivery := range d.state.Pending {
+		if d.inflight[delivery.ID] {
+			continue
+		}
+
+		if until := delivery.NextAttemptAt.Sub(now); until > 0 {
+			if until < wait {
+				wait = until
+			}
+			continue
+		}
+
+		if d.active[delivery.Endpoint] >= d.maxConcurrent {
+			continue
+		}
+
+		d.inflight[delivery.ID] = true
+		d.active[delivery.Endpoint]++
+		attempts.Add(1)
+		go func(delivery Delivery) {
+			defer attempts.Done()
+			d.attempt(delivery)
+		}(delivery)
+	}
+
+	return wait
+}
+
+// attempt posts the delivery and updates it with the outcome: removed once delivered, scheduled for a retry
+// or moved to the dead letters after the last attempt
+func (d *dispatcher) attempt(delivery Delivery) {
+	err := d.post(delivery)
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	defer d.wakeUp()
+
+	delete(d.inflight, delivery.ID)
+	if d.active[delivery.Endpoint]--; d.active[delivery.Endpoint] <= 0 {
+		delete(d.active, delivery.Endpoint)
+	}
+
+	i := d.pendingIndex(delivery.ID)
+	if i < 0 {
+		return
+	}
+
+	pending := &d.state.Pending[i]
+	pending.Attempts++
+
+	switch {
+	case err == nil:
+		d.log.Debugf("Webhooks: action `%s` delivered to `%s`", delivery.ActionID, delivery.Endpoint)
+		metrics.WebhookDeliveries.WithLabelValues(delivery.Endpoint, "delivered").Inc()
+		d.state.Pending = append(d.state.Pending[:i], d.state.Pending[i+1:]...)
+	case pending.Attempts >= d.maxAttempts:
+		d.log.Warnf("Webhooks: giving up delivering action `%s` to `%s` after %d attempts, err: %v", delivery.ActionID, delivery.Endpoint, pending.Attempts, err)
+		metrics.WebhookDeliveries.WithLabelValues(delivery.Endpoint, "deadLetter").Inc()
+		failed := *pending
+		d.state.Pending = append(d.state.Pending[:i], d.state.Pending[i+1:]...)
+		d.deadLetter(failed, err.Error())
+	default:
+		d.log.Errorf("Webhooks: delivering action `%s` to `%s` failed, attempt %d, err: %v", delivery.ActionID, delivery.Endpoint, pending.Attempts, err)
+		metrics.WebhookDeliveries.WithLabelValues(delivery.Endpoint, "retry").Inc()
+		pending.LastError = err.Error()
+		pending.NextAttemptAt = time.Now().UTC().Add(d.backoff(pending.Attempts))
+	}
+
+	d.save()
+}
+
+// backoff returns the wait before the next attempt, doubled after every failed attempt up to the max interval
+func (d *dispatcher) backoff(attempts int) time.Duration {
+	wait := d.retryInterval
+	for i := 1; i < attempts && wait < d.retryIntervalMax; i++ {
+		wait *= 2
+	}
+
+	if wait > d.retryIntervalMax {
+		return d.retryIntervalMax
+	}
+
+	return wait
+}
+
+func (d *dispatcher) post(delivery Delivery) error {
+	e := d.endpoint(delivery.Endpoint)
+	if e == nil {
+		return errors.New("endpoint no longer configured")
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sig, err := defs.HmacSum(timestamp, http.MethodPost, e.URL, e.secret, delivery.Body)
+	if err != nil {
+		return errors.Wrap(err, "sign webhook body")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return errors.Wrap(err, "create webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(idHeader, delivery.ID)
+	req.Header.Set(timestampHeader, timestamp)
+	req.Header.Set(signatureHeader, base64.RawURLEncoding.EncodeToString(sig))
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "post webhook")
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (d *dispatcher) endpoint(name string) *endpoint {
+	for i := range d.endpoints {
+		if d.endpoints[i].Name == name {
+			return &d.endpoints[i]
+		}
+	}
+
+	return nil
+}
+
+func (d *dispatcher) pendingIndex(id string) int {
+	for i, delivery := range d.state.Pending {
+		if delivery.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (d *dispatcher) deadLetterIndex(id string) int {
+	for i, delivery := range d.state.DeadLetters {
+		if delivery.ID == id {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (d *dispatcher) deadLetter(delivery Delivery, reason string) {
+	delivery.LastError = reason
+	delivery.FailedAt = time.Now().UTC()
+	d.state.DeadLetters = append(d.state.DeadLetters, delivery)
+	d.evictDeadLetters()
+}
+
+// evictDeadLetters drops the oldest dead letters past maxDeadLetters
+func (d *dispatcher) evictDeadLetters() {
+	evicted := len(d.state.DeadLetters) - d.maxDeadLetters
+	if evicted <= 0 {
+		return
+	}
+
+	d.log.Warnf("Webhooks: %d dead letters evicted, the oldest past the max of %d", evicted, d.maxDeadLetters)
+	d.state.DeadLetters = append([]Delivery{}, d.state.DeadLetters[evicted:]...)
+}
+
+// save writes the queue file, the deliveries are kept in memory if it fails
+func (d *dispatcher) save() {
+	if err := d.queue.save(d.state); err != nil {
+		d.log.Errorf("Webhooks: failed to save the delivery queue, err: %v", err)
+	}
+}
+
+func (d *dispatcher) wakeUp() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// matches returns true if the action passes the event filter of the endpoint. An empty list matches any value
+func (e endpoint) matches(action defs.ActionInfo) bool {
+	return matchesType(e.Types, action.Type) && matchesStatus(e.Statuses, action.Status)
+}
+
+func matchesType(types []string, actionType string) bool {
+	for _, t := range types {
+		if t == actionType {
+			return true
+		}
+	}
+
+	return len(types) == 0
+}
+
+func matchesStatus(statuses []int, status int) bool {
+	for _, s := range statuses {
+		if s == status {
+			return true
+		}
+	}
+
+	return len(statuses) == 0
+}
+
+// deliveryID returns the hex encoded SHA-256 of the endpoint name and the body, truncated to 128 bits
+func deliveryID(endpointName string, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(endpointName))
+	hash.Write([]byte{0})
+	hash.Write(body)
+
+	return hex.EncodeToString(hash.Sum(nil)[:16])
+}
@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"sync"
+
+	"github.com/qredo/signing-agent/internal/hub"
+)
+
+type MockDispatcher struct {
+	ListenCalled        bool
+	GetFeedClientCalled bool
+	StopCalled          bool
+	DeadLettersCalled   bool
+	RequeueCalled       bool
+	DeleteCalled        bool
+
+	LastID            string
+	NextHubFeedClient *hub.HubFeedClient
+	NextDeadLetters   []Delivery
+	NextError         error
+}
+
+func (m *MockDispatcher) Listen(wg *sync.WaitGroup) {
+	m.ListenCalled = true
+	wg.Done()
+}
+
+func (m *MockDispatcher) GetFeedClient() *hub.HubFeedClient {
+	m.GetFeedClientCalled = true
+	return m.NextHubFeedClient
+}
+
+func (m *MockDispatcher) Stop() {
+	m.StopCalled = true
+}
+
+func (m *MockDispatcher) DeadLetters() []Delivery {
+	m.DeadLettersCalled = true
+	return m.NextDeadLetters
+}
+
+func (m *MockDispatcher) RequeueDeadLetter(id string) error {
+	m.RequeueCalled = true
+	m.LastID = id
+	return m.NextError
+}
+
+func (m *MockDispatcher) DeleteDeadLetter(id string) error {
+	m.DeleteCalled = true
+	m.LastID = id
+	return m.NextError
+}
@@ -0,0 +1,494 @@
+package webhook
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/qredo/signing-agent/internal/config"
+	"github.com/qredo/signing-agent/internal/defs"
+	"github.com/qredo/signing-agent/internal/util"
+)
+
+const (
+	testSecret       = "c2VjcmV0" // "secret"
+	testActionPaid   = `{"id":"action1","status":1,"type":"transfer"}`
+	testActionOther  = `{"id":"action2","status":3,"type":"withdraw"}`
+	testWaitDuration = 5 * time.Second
+	testTick         = 5 * time.Millisecond
+)
+
+type receivedRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// testEndpoint is a webhook endpoint responding the status, it records the requests received
+type testEndpoint struct {
+	*httptest.Server
+	lock     sync.Mutex
+	status   int
+	received []receivedRequest
+}
+
+func newTestEndpoint(status int) *testEndpoint {
+	e := &testEndpoint{status: status}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		e.lock.Lock()
+		defer e.lock.Unlock()
+		e.received = append(e.received, receivedRequest{header: r.Header.Clone(), body: body})
+		w.WriteHeader(e.status)
+	}))
+
+	return e
+}
+
+func (e *testEndpoint) requests() []receivedRequest {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return append([]receivedRequest{}, e.received...)
+}
+
+func (e *testEndpoint) setStatus(status int) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.status = status
+}
+
+func newTestConfig(t *testing.T, endpoints ...config.WebhookEndpoint) config.Webhooks {
+	return config.Webhooks{
+		Enabled:          true,
+		Endpoints:        endpoints,
+		QueueFile:        filepath.Join(t.TempDir(), "webhooks.json"),
+		TimeoutSec:       5,
+		RetryInterval:    1,
+		RetryIntervalMax: 1,
+		MaxAttempts:      3,
+		MaxDeadLetters:   10,
+		MaxConcurrent:    4,
+	}
+}
+
+func newTestDispatcher(t *testing.T, cfg config.Webhooks) *dispatcher {
+	d, err := NewDispatcher(cfg, util.NewTestLogger())
+	require.Nil(t, err)
+
+	sut := d.(*dispatcher)
+	sut.retryInterval = 10 * time.Millisecond
+	sut.retryIntervalMax = 20 * time.Millisecond
+	return sut
+}
+
+// listen starts the dispatcher, the returned function stops it and waits for Listen to return
+func listen(sut *dispatcher) func() {
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	done := make(chan struct{})
+	go func() {
+		sut.Listen(&wg)
+		close(done)
+	}()
+	wg.Wait()
+
+	feed := sut.GetFeedClient().Feed
+	return func() {
+		close(feed)
+		<-done
+	}
+}
+
+func TestNewDispatcher_invalid_config(t *testing.T) {
+	valid := config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret}
+
+	tests := []struct {
+		name      string
+		update    func(cfg *config.Webhooks)
+		wantError string
+	}{
+		{"no endpoints", func(cfg *config.Webhooks) { cfg.Endpoints = nil }, "webhooks enabled but no endpoints configured"},
+		{"duplicate name", func(cfg *config.Webhooks) { cfg.Endpoints = append(cfg.Endpoints, valid) }, "webhook endpoint names must be set and unique, got `approvals`"},
+		{"invalid url", func(cfg *config.Webhooks) { cfg.Endpoints[0].URL = "approvals.local/hook" }, "invalid url for webhook endpoint `approvals`"},
+		{"invalid secret", func(cfg *config.Webhooks) { cfg.Endpoints[0].Secret = "not base64!" }, "invalid secret for webhook endpoint `approvals`"},
+		{"no max attempts", func(cfg *config.Webhooks) { cfg.MaxAttempts = 0 }, "webhooks timeoutSec, retry intervals and maxAttempts must be positive, with retryIntervalMaxSec not lower than retryIntervalSec"},
+		{"no max dead letters", func(cfg *config.Webhooks) { cfg.MaxDeadLetters = 0 }, "webhooks maxDeadLetters and maxConcurrent must be positive"},
+		{"no max concurrent", func(cfg *config.Webhooks) { cfg.MaxConcurrent = 0 }, "webhooks maxDeadLetters and maxConcurrent must be positive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Arrange
+			cfg := newTestConfig(t, valid)
+			tt.update(&cfg)
+
+			// Act
+			res, err := NewDispatcher(cfg, util.NewTestLogger())
+
+			// Assert
+			assert.Nil(t, res)
+			assert.EqualError(t, err, tt.wantError)
+		})
+	}
+}
+
+func TestDispatcher_delivers_the_signed_actions_matching_the_filter(t *testing.T) {
+	// Arrange
+	all := newTestEndpoint(http.StatusOK)
+	defer all.Close()
+	pending := newTestEndpoint(http.StatusNoContent)
+	defer pending.Close()
+
+	cfg := newTestConfig(t,
+		config.WebhookEndpoint{Name: "all", URL: all.URL + "/hook", Secret: testSecret},
+		config.WebhookEndpoint{Name: "pending", URL: pending.URL, Secret: testSecret, Types: []string{"transfer"}, Statuses: []int{defs.StatusPending}})
+	sut := newTestDispatcher(t, cfg)
+	stop := listen(sut)
+
+	// Act
+	sut.GetFeedClient().Feed <- []byte(testActionPaid)
+	sut.GetFeedClient().Feed <- []byte(testActionOther)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		return len(all.requests()) == 2 && len(pending.requests()) == 1
+	}, testWaitDuration, testTick)
+	require.Eventually(t, func() bool {
+		state, _ := sut.queue.load()
+		return len(state.Pending) == 0
+	}, testWaitDuration, testTick)
+	stop()
+
+	req := pending.requests()[0]
+	assert.Equal(t, testActionPaid, string(req.body))
+	assert.Equal(t, "application/json", req.header.Get("Content-Type"))
+	assert.Equal(t, deliveryID("pending", []byte(testActionPaid)), req.header.Get(idHeader))
+
+	expected, _ := defs.HmacSum(req.header.Get(timestampHeader), http.MethodPost, pending.URL, []byte("secret"), req.body)
+	assert.Equal(t, base64.RawURLEncoding.EncodeToString(expected), req.header.Get(signatureHeader))
+	assert.Empty(t, sut.DeadLetters())
+}
+
+func TestDispatcher_moves_to_the_dead_letters_after_the_last_attempt(t *testing.T) {
+	// Arrange
+	failing := newTestEndpoint(http.StatusServiceUnavailable)
+	defer failing.Close()
+
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "failing", URL: failing.URL, Secret: testSecret})
+	sut := newTestDispatcher(t, cfg)
+	stop := listen(sut)
+
+	// Act
+	sut.GetFeedClient().Feed <- []byte(testActionPaid)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		return len(sut.DeadLetters()) == 1
+	}, testWaitDuration, testTick)
+	stop()
+
+	assert.Len(t, failing.requests(), 3)
+
+	deadLetter := sut.DeadLetters()[0]
+	assert.Equal(t, "failing", deadLetter.Endpoint)
+	assert.Equal(t, "action1", deadLetter.ActionID)
+	assert.Equal(t, testActionPaid, string(deadLetter.Body))
+	assert.Equal(t, 3, deadLetter.Attempts)
+	assert.Equal(t, "endpoint responded 503", deadLetter.LastError)
+	assert.False(t, deadLetter.FailedAt.IsZero())
+
+	reloaded := newTestDispatcher(t, cfg)
+	assert.Equal(t, sut.DeadLetters(), reloaded.DeadLetters())
+	assert.Empty(t, reloaded.state.Pending)
+}
+
+func TestDispatcher_retries_the_pending_deliveries_after_a_restart(t *testing.T) {
+	// Arrange
+	endpoint := newTestEndpoint(http.StatusInternalServerError)
+	defer endpoint.Close()
+
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: endpoint.URL, Secret: testSecret})
+	first := newTestDispatcher(t, cfg)
+	first.retryInterval = time.Hour
+	first.retryIntervalMax = time.Hour
+	stop := listen(first)
+
+	first.GetFeedClient().Feed <- []byte(testActionPaid)
+	require.Eventually(t, func() bool {
+		return len(endpoint.requests()) == 1
+	}, testWaitDuration, testTick)
+	stop()
+
+	endpoint.setStatus(http.StatusOK)
+	sut := newTestDispatcher(t, cfg)
+
+	// Act
+	require.Len(t, sut.state.Pending, 1)
+	sut.state.Pending[0].NextAttemptAt = time.Now()
+	stop = listen(sut)
+
+	// Assert
+	require.Eventually(t, func() bool {
+		state, _ := sut.queue.load()
+		return len(state.Pending) == 0
+	}, testWaitDuration, testTick)
+	stop()
+
+	requests := endpoint.requests()
+	assert.Len(t, requests, 2)
+	assert.Equal(t, requests[0].header.Get(idHeader), requests[1].header.Get(idHeader))
+	assert.Empty(t, sut.DeadLetters())
+}
+
+func TestDispatcher_listens_again_on_a_new_feed(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	sut := newTestDispatcher(t, cfg)
+	stop := listen(sut)
+	firstFeed := sut.GetFeedClient().Feed
+	stop()
+
+	// Act
+	stop = listen(sut)
+	defer stop()
+
+	// Assert
+	assert.NotEqual(t, firstFeed, sut.GetFeedClient().Feed)
+	assert.True(t, sut.GetFeedClient().IsInternal)
+}
+
+func TestNewDispatcher_dead_letters_the_deliveries_of_removed_endpoints(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	queue := queueFile{fileName: cfg.QueueFile}
+	require.Nil(t, queue.save(queueState{
+		Pending: []Delivery{
+			{ID: "kept", Endpoint: "approvals", ActionID: "action1"},
+			{ID: "removed", Endpoint: "removed", ActionID: "action2"},
+		},
+	}))
+
+	// Act
+	sut := newTestDispatcher(t, cfg)
+
+	// Assert
+	require.Len(t, sut.state.Pending, 1)
+	assert.Equal(t, "kept", sut.state.Pending[0].ID)
+
+	deadLetters := sut.DeadLetters()
+	require.Len(t, deadLetters, 1)
+	assert.Equal(t, "removed", deadLetters[0].ID)
+	assert.Equal(t, "endpoint no longer configured", deadLetters[0].LastError)
+
+	saved, err := queue.load()
+	require.Nil(t, err)
+	assert.Equal(t, sut.state, saved)
+}
+
+func TestDispatcher_enqueue_skips_the_pending_duplicates(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	sut := newTestDispatcher(t, cfg)
+
+	// Act
+	sut.enqueue([]byte(testActionPaid))
+	sut.enqueue([]byte(testActionPaid))
+	sut.enqueue([]byte("not an action"))
+
+	// Assert
+	require.Len(t, sut.state.Pending, 1)
+	assert.Equal(t, "action1", sut.state.Pending[0].ActionID)
+}
+
+func TestDispatcher_backoff(t *testing.T) {
+	// Arrange
+	sut := &dispatcher{
+		retryInterval:    5 * time.Second,
+		retryIntervalMax: time.Minute,
+	}
+
+	// Act & Assert
+	assert.Equal(t, 5*time.Second, sut.backoff(1))
+	assert.Equal(t, 10*time.Second, sut.backoff(2))
+	assert.Equal(t, 40*time.Second, sut.backoff(4))
+	assert.Equal(t, time.Minute, sut.backoff(5))
+	assert.Equal(t, time.Minute, sut.backoff(100))
+}
+
+func TestNewDispatcher_evicts_the_oldest_dead_letters_past_the_max(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	cfg.MaxDeadLetters = 2
+	queue := queueFile{fileName: cfg.QueueFile}
+	require.Nil(t, queue.save(queueState{
+		Pending: []Delivery{{ID: "removed", Endpoint: "removed", ActionID: "action3"}},
+		DeadLetters: []Delivery{
+			{ID: "oldest", Endpoint: "approvals", ActionID: "action1"},
+			{ID: "older", Endpoint: "approvals", ActionID: "action2"},
+		},
+	}))
+
+	// Act
+	sut := newTestDispatcher(t, cfg)
+
+	// Assert
+	deadLetters := sut.DeadLetters()
+	require.Len(t, deadLetters, 2)
+	assert.Equal(t, "older", deadLetters[0].ID)
+	assert.Equal(t, "removed", deadLetters[1].ID)
+
+	saved, err := queue.load()
+	require.Nil(t, err)
+	assert.Equal(t, sut.state, saved)
+}
+
+func TestDispatcher_RequeueDeadLetter_delivers_it_again(t *testing.T) {
+	// Arrange
+	endpoint := newTestEndpoint(http.StatusOK)
+	defer endpoint.Close()
+
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: endpoint.URL, Secret: testSecret})
+	queue := queueFile{fileName: cfg.QueueFile}
+	require.Nil(t, queue.save(queueState{
+		DeadLetters: []Delivery{{
+			ID:        deliveryID("approvals", []byte(testActionPaid)),
+			Endpoint:  "approvals",
+			ActionID:  "action1",
+			Body:      []byte(testActionPaid),
+			Attempts:  3,
+			LastError: "endpoint responded 503",
+			FailedAt:  time.Now().UTC(),
+		}},
+	}))
+	sut := newTestDispatcher(t, cfg)
+	stop := listen(sut)
+
+	// Act
+	err := sut.RequeueDeadLetter(deliveryID("approvals", []byte(testActionPaid)))
+
+	// Assert
+	require.Nil(t, err)
+	require.Eventually(t, func() bool {
+		state, _ := sut.queue.load()
+		return len(state.Pending) == 0 && len(endpoint.requests()) == 1
+	}, testWaitDuration, testTick)
+	stop()
+
+	assert.Equal(t, testActionPaid, string(endpoint.requests()[0].body))
+	assert.Empty(t, sut.DeadLetters())
+}
+
+func TestDispatcher_RequeueDeadLetter_errors(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	queue := queueFile{fileName: cfg.QueueFile}
+	require.Nil(t, queue.save(queueState{
+		DeadLetters: []Delivery{{ID: "removed", Endpoint: "removed", ActionID: "action1"}},
+	}))
+	sut := newTestDispatcher(t, cfg)
+
+	// Act
+	errNotFound := sut.RequeueDeadLetter("unknown")
+	errRemoved := sut.RequeueDeadLetter("removed")
+
+	// Assert
+	assert.Equal(t, ErrDeadLetterNotFound, errNotFound)
+	assert.Equal(t, ErrEndpointNotConfigured, errRemoved)
+	assert.Len(t, sut.DeadLetters(), 1)
+	assert.Empty(t, sut.state.Pending)
+}
+
+func TestDispatcher_DeleteDeadLetter(t *testing.T) {
+	// Arrange
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: "https://approvals.local/hook", Secret: testSecret})
+	queue := queueFile{fileName: cfg.QueueFile}
+	require.Nil(t, queue.save(queueState{
+		DeadLetters: []Delivery{
+			{ID: "deleted", Endpoint: "approvals", ActionID: "action1"},
+			{ID: "kept", Endpoint: "approvals", ActionID: "action2"},
+		},
+	}))
+	sut := newTestDispatcher(t, cfg)
+
+	// Act
+	err := sut.DeleteDeadLetter("deleted")
+	errNotFound := sut.DeleteDeadLetter("deleted")
+
+	// Assert
+	assert.Nil(t, err)
+	assert.Equal(t, ErrDeadLetterNotFound, errNotFound)
+
+	saved, err := queue.load()
+	require.Nil(t, err)
+	require.Len(t, saved.DeadLetters, 1)
+	assert.Equal(t, "kept", saved.DeadLetters[0].ID)
+}
+
+func TestDispatcher_limits_the_attempts_in_flight_per_endpoint(t *testing.T) {
+	// Arrange
+	release := make(chan struct{})
+	var lock sync.Mutex
+	inflight, maxInflight, received := 0, 0, 0
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		inflight++
+		received++
+		if inflight > maxInflight {
+			maxInflight = inflight
+		}
+		lock.Unlock()
+
+		<-release
+
+		lock.Lock()
+		inflight--
+		lock.Unlock()
+	}))
+	defer endpoint.Close()
+
+	cfg := newTestConfig(t, config.WebhookEndpoint{Name: "approvals", URL: endpoint.URL, Secret: testSecret})
+	cfg.MaxConcurrent = 2
+	sut := newTestDispatcher(t, cfg)
+	stop := listen(sut)
+
+	// Act
+	for i := 0; i < 5; i++ {
+		sut.GetFeedClient().Feed <- []byte(fmt.Sprintf(`{"id":"action%d","status":1,"type":"transfer"}`, i))
+	}
+
+	// Assert
+	require.Eventually(t, func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return received == 2
+	}, testWaitDuration, testTick)
+	time.Sleep(50 * time.Millisecond)
+
+	lock.Lock()
+	assert.Equal(t, 2, received)
+	lock.Unlock()
+
+	close(release)
+	require.Eventually(t, func() bool {
+		state, _ := sut.queue.load()
+		return len(state.Pending) == 0
+	}, testWaitDuration, testTick)
+	stop()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 2, maxInflight)
+	assert.Equal(t, 5, received)
+}